@@ -2,6 +2,7 @@ package v1alpha1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -123,6 +124,130 @@ type CodeModulesSpec struct {
 
 	// Optional: use OneAgent binaries from volume
 	Volume corev1.VolumeSource `json:"volume,omitempty"`
+
+	// Optional: inject into all namespaces matching the selector, in addition to the namespaces labeled with
+	// oneagent.dynatrace.com/instance
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Optional: only inject into pods matching the selector
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// Optional: containers matching any of the patterns are not injected, e.g. sidecars like istio-proxy
+	// Can be overridden per pod with the oneagent.dynatrace.com/include-containers annotation
+	ExcludeContainers []ContainerPattern `json:"excludeContainers,omitempty"`
+
+	// Optional: selects the flavor of the OneAgent package by the images of the injected containers, the first matching
+	// rule is used. Pods with containers requiring different flavors, or not matched by any rule, use the multidistro flavor.
+	// Can be overridden per pod with the oneagent.dynatrace.com/flavor annotation
+	FlavorRules []FlavorRule `json:"flavorRules,omitempty"`
+
+	// Optional: pins the version of the OneAgent package mounted by the CSI driver, defaults to the latest version.
+	// Can be overridden per namespace or pod with the oneagent.dynatrace.com/version annotation, the CSI driver keeps
+	// every version which is still requested.
+	// Example: {major.minor.release} - 1.200.0
+	// +kubebuilder:validation:Pattern=`^[0-9]+\.[0-9]+\.[0-9]+(\.[0-9]+(-[0-9]+)?)?$`
+	Version string `json:"version,omitempty"`
+
+	// Optional: limits the size of what each pod writes to the volume mounted by the CSI driver, e.g. the logs of the
+	// agent. The volume is made read-only once the limit is exceeded and an Event is recorded on the pod.
+	// Can be overridden per pod with the oneagent.dynatrace.com/volume-quota annotation
+	// Example: 100Mi
+	VolumeQuota *resource.Quantity `json:"volumeQuota,omitempty"`
+
+	// Optional: namespaces whose pods may select this DynaKube with the dynakube attribute of volumes of the CSI
+	// driver, in addition to the namespaces it injects into and its own namespace. Volumes of pods in other namespaces
+	// selecting this DynaKube are rejected, as they would get the OneAgent package and connection config of its tenant.
+	VolumeNamespaces []string `json:"volumeNamespaces,omitempty"`
+
+	// Optional: if set, pods downloading the OneAgent package themselves, i.e. using an emptyDir volume, get a token with
+	// only the InstallerDownload scope, which expires after the given lifetime and is renewed by the Operator, instead of
+	// the PaaS token. Requires the apiTokens.write scope for the API token.
+	// Pods using the CSI driver never get a token. The lifetime must be at least one hour, e.g. 24h or 1h30m.
+	// +kubebuilder:validation:Pattern=`^[1-9][0-9]*h([0-9]+m)?([0-9]+s)?$`
+	InstallerTokenLifetime *metav1.Duration `json:"installerTokenLifetime,omitempty"`
+
+	// Optional: deploys a cache inside the cluster, which downloads each OneAgent package once and serves it to pods
+	// using an emptyDir volume. Pods don't get a token for the tenant then.
+	DownloadCache DownloadCacheSpec `json:"downloadCache,omitempty"`
+
+	// Optional: restarts Deployments, StatefulSets and DaemonSets whose pods don't match the desired injection state
+	// anymore, e.g. because code modules have been disabled or the namespace is assigned to another DynaKube.
+	// Outdated workloads are listed in the status in any case.
+	RestartWorkloads RestartWorkloadsSpec `json:"restartWorkloads,omitempty"`
+
+	// Optional: how the Kubernetes API server handles failures of the webhook for namespaces of this DynaKube
+	Webhook WebhookSpec `json:"webhook,omitempty"`
+
+	// Optional: propagates metadata of the pods, their workloads and namespaces to the injected processes
+	MetadataEnrichment MetadataEnrichmentSpec `json:"metadataEnrichment,omitempty"`
+}
+
+type MetadataEnrichmentSpec struct {
+	// Enables the enrichment, the kind and name of the workload owning the pod are always propagated then
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Optional: keys of the pod labels propagated as tags
+	PodLabels []string `json:"podLabels,omitempty"`
+
+	// Optional: keys of the pod annotations propagated as custom properties
+	PodAnnotations []string `json:"podAnnotations,omitempty"`
+
+	// Optional: keys of the namespace labels propagated as tags
+	NamespaceLabels []string `json:"namespaceLabels,omitempty"`
+}
+
+type WebhookSpec struct {
+	// Optional: Fail rejects pods the webhook fails to inject, Ignore creates them without injection, defaults to Fail
+	// +kubebuilder:validation:Enum=Fail;Ignore
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+
+	// Optional: seconds the Kubernetes API server waits for the webhook, between 1 and 30, defaults to 10
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=30
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+}
+
+type RestartWorkloadsSpec struct {
+	// Enables the restart of outdated workloads
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Optional: maximum amount of workloads restarted at once, defaults to 1
+	// +kubebuilder:validation:Minimum=1
+	BatchSize *int32 `json:"batchSize,omitempty"`
+
+	// Optional: minimum time between two batches of restarts, defaults to 5m
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+type DownloadCacheSpec struct {
+	// Enables the download cache
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Optional: the image of the download cache, defaults to the image of the Operator
+	Image string `json:"image,omitempty"`
+
+	// Optional: amount of replicas of the download cache, defaults to 1
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Optional: define resources requests and limits for the download cache
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+type FlavorRule struct {
+	// Pattern for the image of the container, '*' matches any sequence of characters
+	Image string `json:"image"`
+
+	// Flavor of the OneAgent package used for matching containers
+	// +kubebuilder:validation:Enum=default;musl;multidistro
+	Flavor string `json:"flavor"`
+}
+
+type ContainerPattern struct {
+	// Optional: pattern for the name of the container, '*' matches any sequence of characters
+	Name string `json:"name,omitempty"`
+
+	// Optional: pattern for the image of the container, '*' matches any sequence of characters
+	Image string `json:"image,omitempty"`
 }
 
 type FullStackSpec struct {
@@ -214,6 +339,10 @@ type CapabilityProperties struct {
 	// If referenced from a secret, make sure the key is called 'customProperties'
 	CustomProperties *DynaKubeValueSource `json:"customProperties,omitempty"`
 
+	// Optional: Add custom properties as structured sections of key/value pairs
+	// They are rendered into the custom properties file and merged with customProperties, taking precedence over it
+	StructuredCustomProperties *ActiveGateCustomProperties `json:"structuredCustomProperties,omitempty"`
+
 	// Optional: define resources requests and limits for single ActiveGate pods
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Resource Requirements",order=34,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:com.tectonic.ui:resourceRequirements"}
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
@@ -244,6 +373,130 @@ type CapabilityProperties struct {
 	// Optional: set custom Service Account Name used with ActiveGate pods
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Service Account name",order=40,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:io.kubernetes:ServiceAccount"}
 	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Optional: persistent storage for the ActiveGate buffers.
+	// If not specified the ActiveGate uses the ephemeral storage of the pod.
+	// Changing the storage class or access mode, or removing the storage, recreates the StatefulSet and deletes the
+	// existing volume claims with their buffered data. The identity of the ActiveGate is not stored in the volume,
+	// it is derived from the ID seeds and the name of the pod, which are kept across restarts anyway.
+	Storage *ActiveGateStorageSpec `json:"storage,omitempty"`
+
+	// Optional: overrides which are merged onto the generated ActiveGate pod template
+	PodOverrides *ActiveGatePodOverrides `json:"podOverrides,omitempty"`
+
+	// Optional: configures how the Service of the ActiveGate is exposed
+	// Only applies to capabilities which create a Service, like routing and data ingest
+	Service *ActiveGateServiceSpec `json:"service,omitempty"`
+}
+
+type ActiveGateServiceSpec struct {
+	// Optional: type of the Service - defaults to ClusterIP
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Service type",order=48,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:com.tectonic.ui:select:ClusterIP","urn:alm:descriptor:com.tectonic.ui:select:NodePort","urn:alm:descriptor:com.tectonic.ui:select:LoadBalancer"}
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// Optional: Adds additional annotations to the Service, e.g. to configure cloud load balancers
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Service annotations",order=49,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:com.tectonic.ui:text"}
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Optional: external traffic policy of NodePort and LoadBalancer Services
+	// +kubebuilder:validation:Enum=Cluster;Local
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="External traffic policy",order=50,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:com.tectonic.ui:text"}
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+}
+
+type ActiveGatePodOverrides struct {
+	// Optional: replaces the default readiness probe of the ActiveGate container
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	ReadinessProbe *corev1.Probe `json:"readinessProbe,omitempty"`
+
+	// Optional: adds a liveness probe to the ActiveGate container
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	LivenessProbe *corev1.Probe `json:"livenessProbe,omitempty"`
+
+	// Optional: adds a startup probe to the ActiveGate container
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	StartupProbe *corev1.Probe `json:"startupProbe,omitempty"`
+
+	// Optional: image pull policy of the ActiveGate containers - defaults to Always
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Image pull policy",order=44,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:com.tectonic.ui:imagePullPolicy"}
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// Optional: security context applied to all ActiveGate containers, including init containers
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// Optional: security context of the ActiveGate pods
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	// Optional: If specified, indicates the pod's priority. Name must be defined by creating a PriorityClass object with that
+	// name.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Priority Class name",order=45,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:io.kubernetes:PriorityClass"}
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// Optional: Adds additional annotations to the ActiveGate pods
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Annotations",order=46,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:com.tectonic.ui:text"}
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Optional: Sets DNS Policy for the ActiveGate pods
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="DNS Policy",order=47,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:com.tectonic.ui:text"}
+	DNSPolicy corev1.DNSPolicy `json:"dnsPolicy,omitempty"`
+
+	// Optional: topology spread constraints of the ActiveGate pods
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+}
+
+type ActiveGateCustomProperties struct {
+	// Optional: settings of the [connectivity] section
+	Connectivity *ActiveGateConnectivityProperties `json:"connectivity,omitempty"`
+
+	// Optional: settings of the [collector] section
+	Collector *ActiveGateCollectorProperties `json:"collector,omitempty"`
+
+	// Optional: additional sections, each containing its key/value pairs
+	// Keys which are also set by the typed connectivity or collector properties are rejected
+	Sections map[string]map[string]string `json:"sections,omitempty"`
+}
+
+type ActiveGateConnectivityProperties struct {
+	// Optional: network zone of the ActiveGate
+	NetworkZone string `json:"networkZone,omitempty"`
+
+	// Optional: comma separated list of addresses under which the ActiveGate is reachable by OneAgents
+	DNSEntryPoint string `json:"dnsEntryPoint,omitempty"`
+}
+
+type ActiveGateCollectorProperties struct {
+	// Optional: group of the ActiveGate
+	Group string `json:"group,omitempty"`
+}
+
+type ActiveGateStorageSpec struct {
+	// Optional: name of the StorageClass used for the volume claims. Defaults to the cluster's default StorageClass
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Storage class",order=41,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:io.kubernetes:StorageClass"}
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Optional: size of the volume claimed by every ActiveGate pod - defaults to 1Gi
+	// Increasing the size expands the existing volume claims, if the StorageClass allows volume expansion
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Storage size",order=42,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:com.tectonic.ui:text"}
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// Optional: access mode of the volume claims - defaults to ReadWriteOnce
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Access mode",order=43,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced","urn:alm:descriptor:com.tectonic.ui:text"}
+	AccessMode corev1.PersistentVolumeAccessMode `json:"accessMode,omitempty"`
 }
 
 type DynaKubeValueSource struct {
@@ -307,6 +560,50 @@ type DynaKubeStatus struct {
 	ActiveGate ActiveGateStatus `json:"activeGate,omitempty"`
 
 	OneAgent OneAgentStatus `json:"oneAgent,omitempty"`
+
+	CodeModules CodeModulesStatus `json:"codeModules,omitempty"`
+}
+
+type CodeModulesStatus struct {
+	// Workloads whose pods don't match the desired injection state of the DynaKube, they are only injected or
+	// un-injected once their pods are recreated
+	OutdatedWorkloads []OutdatedWorkload `json:"outdatedWorkloads,omitempty"`
+
+	// LastWorkloadRestart is the time of the last batch of restarts of outdated workloads
+	LastWorkloadRestart *metav1.Time `json:"lastWorkloadRestart,omitempty"`
+
+	// InjectionFailures is the amount of pods the webhook failed to inject
+	InjectionFailures int64 `json:"injectionFailures,omitempty"`
+
+	// LastInjectionFailure describes the last pod the webhook failed to inject
+	LastInjectionFailure *InjectionFailure `json:"lastInjectionFailure,omitempty"`
+
+	// FailedInstallations is the amount of existing pods whose install container failed to download or configure the
+	// OneAgent, as reported by the termination message of the install container
+	FailedInstallations int32 `json:"failedInstallations,omitempty"`
+}
+
+type InjectionFailure struct {
+	Namespace string `json:"namespace"`
+
+	// Pod is the name of the pod, or its generateName if the name hasn't been set yet
+	Pod string `json:"pod"`
+
+	Message string `json:"message"`
+
+	Time metav1.Time `json:"time"`
+}
+
+type OutdatedWorkload struct {
+	// Kind of the workload, i.e. Deployment, StatefulSet or DaemonSet
+	Kind string `json:"kind"`
+
+	Namespace string `json:"namespace"`
+
+	Name string `json:"name"`
+
+	// Reason why the pods of the workload are outdated
+	Reason string `json:"reason"`
 }
 
 type ConnectionInfoStatus struct {
@@ -333,6 +630,49 @@ type VersionStatus struct {
 
 type ActiveGateStatus struct {
 	VersionStatus `json:",inline"`
+
+	// ServiceEndpoints contains the externally reachable communication endpoints of the ActiveGate Services per capability
+	ServiceEndpoints map[string]string `json:"serviceEndpoints,omitempty"`
+
+	// CustomPropertiesHashes contains the hash of the rendered custom properties per capability
+	CustomPropertiesHashes map[string]string `json:"customPropertiesHashes,omitempty"`
+
+	// Replicas contains the connection state of the ActiveGate pods as reported by the Dynatrace tenant
+	Replicas []ActiveGateReplicaStatus `json:"replicas,omitempty"`
+}
+
+type ActiveGateConnectionState string
+
+const (
+	ActiveGateConnected     ActiveGateConnectionState = "Connected"
+	ActiveGateDisconnected  ActiveGateConnectionState = "Disconnected"
+	ActiveGateNotRegistered ActiveGateConnectionState = "NotRegistered"
+)
+
+type ActiveGateReplicaStatus struct {
+	// PodName is the name of the ActiveGate pod
+	PodName string `json:"podName"`
+
+	// Capability is the name of the capability the pod has been deployed for
+	Capability string `json:"capability"`
+
+	// ID is the id of the ActiveGate registered at the tenant
+	ID string `json:"id,omitempty"`
+
+	// ConnectionState is either Connected, Disconnected or NotRegistered
+	ConnectionState ActiveGateConnectionState `json:"connectionState"`
+
+	// Version is the version reported by the registered ActiveGate
+	Version string `json:"version,omitempty"`
+
+	// NetworkZone is the network zone the ActiveGate registered in
+	NetworkZone string `json:"networkZone,omitempty"`
+
+	// Group is the group the ActiveGate registered in
+	Group string `json:"group,omitempty"`
+
+	// MissingModules lists the ActiveGate modules required by the capability which are not enabled or misconfigured
+	MissingModules []string `json:"missingModules,omitempty"`
 }
 
 type OneAgentStatus struct {
@@ -381,6 +721,9 @@ const (
 
 	// PaaSTokenConditionType identifies the PaaS Token validity condition
 	PaaSTokenConditionType string = "PaaSToken"
+
+	// InjectionConflictConditionType identifies the condition which reports namespaces matched by several DynaKubes
+	InjectionConflictConditionType string = "InjectionConflict"
 )
 
 // Possible reasons for ApiToken and PaaSToken conditions
@@ -404,6 +747,27 @@ const (
 	ReasonTokenError string = "TokenError"
 )
 
+// Possible reasons for the InjectionConflict condition
+const (
+	// ReasonNoConflict is set when no namespace is matched by another DynaKube
+	ReasonNoConflict string = "NoConflict"
+
+	// ReasonNamespacesConflicting is set when namespaces are matched by another DynaKube as well
+	ReasonNamespacesConflicting string = "NamespacesConflicting"
+)
+
+// Possible reasons for outdated workloads
+const (
+	// ReasonNotInjected is set when pods aren't injected, but the DynaKube injects into them now
+	ReasonNotInjected string = "NotInjected"
+
+	// ReasonInjectionDisabled is set when pods are injected, but the injection has been disabled for them in the meantime
+	ReasonInjectionDisabled string = "InjectionDisabled"
+
+	// ReasonDynaKubeChanged is set when pods have been injected by another DynaKube
+	ReasonDynaKubeChanged string = "DynaKubeChanged"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // DynaKube is the Schema for the DynaKube API
@@ -26,6 +26,12 @@ import (
 const (
 	// PullSecretSuffix is the suffix appended to the DynaKube name to n.
 	PullSecretSuffix = "-pull-secret"
+
+	// InstallerTokenSuffix is the suffix appended to the DynaKube name for the Secret holding the short-lived installer token.
+	InstallerTokenSuffix = "-installer-token"
+
+	// DownloadCacheSuffix is the suffix appended to the DynaKube name for the objects of the download cache.
+	DownloadCacheSuffix = "-download-cache"
 )
 
 // NeedsActiveGate returns true when a feature requires ActiveGate instances.
@@ -98,6 +104,47 @@ func buildImageRegistry(apiURL string) string {
 	return registry
 }
 
+// NeedsInstallerDownload returns true if injected pods download the OneAgent package themselves and therefore need a
+// token for the tenant.
+func (dk *DynaKube) NeedsInstallerDownload() bool {
+	return dk.Spec.CodeModules.Enabled && dk.Spec.CodeModules.Volume.EmptyDir != nil
+}
+
+// UsesShortLivedInstallerToken returns true if injected pods get a short-lived installer token instead of the PaaS token.
+func (dk *DynaKube) UsesShortLivedInstallerToken() bool {
+	return dk.NeedsInstallerDownload() && !dk.NeedsDownloadCache() && dk.Spec.CodeModules.InstallerTokenLifetime != nil
+}
+
+// NeedsDownloadCache returns true if injected pods download the OneAgent package from the download cache instead of the
+// tenant.
+func (dk *DynaKube) NeedsDownloadCache() bool {
+	return dk.NeedsInstallerDownload() && dk.Spec.CodeModules.DownloadCache.Enabled
+}
+
+// DownloadCacheName returns the name of the Deployment, Service and Secret of the download cache.
+func (dk *DynaKube) DownloadCacheName() string {
+	return dk.Name + DownloadCacheSuffix
+}
+
+// InstallerTokenSecret returns the name of the Secret holding the short-lived installer token.
+func (dk *DynaKube) InstallerTokenSecret() string {
+	return dk.Name + InstallerTokenSuffix
+}
+
+// AllowsVolumeNamespace returns true if pods in the namespace may select the DynaKube by the dynakube volume attribute
+// of the CSI driver without being injected by it, i.e. for its own namespace and the allowed volume namespaces.
+func (dk *DynaKube) AllowsVolumeNamespace(namespace string) bool {
+	if namespace == dk.Namespace {
+		return true
+	}
+	for _, ns := range dk.Spec.CodeModules.VolumeNamespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
 // Tokens returns the name of the Secret to be used for tokens.
 func (dk *DynaKube) Tokens() string {
 	if tkns := dk.Spec.Tokens; tkns != "" {
@@ -24,6 +24,132 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveGateCollectorProperties) DeepCopyInto(out *ActiveGateCollectorProperties) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveGateCollectorProperties.
+func (in *ActiveGateCollectorProperties) DeepCopy() *ActiveGateCollectorProperties {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveGateCollectorProperties)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveGateConnectivityProperties) DeepCopyInto(out *ActiveGateConnectivityProperties) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveGateConnectivityProperties.
+func (in *ActiveGateConnectivityProperties) DeepCopy() *ActiveGateConnectivityProperties {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveGateConnectivityProperties)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveGateCustomProperties) DeepCopyInto(out *ActiveGateCustomProperties) {
+	*out = *in
+	if in.Connectivity != nil {
+		in, out := &in.Connectivity, &out.Connectivity
+		*out = new(ActiveGateConnectivityProperties)
+		**out = **in
+	}
+	if in.Collector != nil {
+		in, out := &in.Collector, &out.Collector
+		*out = new(ActiveGateCollectorProperties)
+		**out = **in
+	}
+	if in.Sections != nil {
+		in, out := &in.Sections, &out.Sections
+		*out = make(map[string]map[string]string, len(*in))
+		for key, val := range *in {
+			var outVal map[string]string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make(map[string]string, len(*in))
+				for key, val := range *in {
+					(*out)[key] = val
+				}
+			}
+			(*out)[key] = outVal
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveGateCustomProperties.
+func (in *ActiveGateCustomProperties) DeepCopy() *ActiveGateCustomProperties {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveGateCustomProperties)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveGatePodOverrides) DeepCopyInto(out *ActiveGatePodOverrides) {
+	*out = *in
+	if in.ReadinessProbe != nil {
+		in, out := &in.ReadinessProbe, &out.ReadinessProbe
+		*out = new(v1.Probe)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LivenessProbe != nil {
+		in, out := &in.LivenessProbe, &out.LivenessProbe
+		*out = new(v1.Probe)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.StartupProbe != nil {
+		in, out := &in.StartupProbe, &out.StartupProbe
+		*out = new(v1.Probe)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecurityContext != nil {
+		in, out := &in.SecurityContext, &out.SecurityContext
+		*out = new(v1.SecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodSecurityContext != nil {
+		in, out := &in.PodSecurityContext, &out.PodSecurityContext
+		*out = new(v1.PodSecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]v1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveGatePodOverrides.
+func (in *ActiveGatePodOverrides) DeepCopy() *ActiveGatePodOverrides {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveGatePodOverrides)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ActiveGateSpec) DeepCopyInto(out *ActiveGateSpec) {
 	*out = *in
@@ -44,10 +170,73 @@ func (in *ActiveGateSpec) DeepCopy() *ActiveGateSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveGateReplicaStatus) DeepCopyInto(out *ActiveGateReplicaStatus) {
+	*out = *in
+	if in.MissingModules != nil {
+		in, out := &in.MissingModules, &out.MissingModules
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveGateReplicaStatus.
+func (in *ActiveGateReplicaStatus) DeepCopy() *ActiveGateReplicaStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveGateReplicaStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveGateServiceSpec) DeepCopyInto(out *ActiveGateServiceSpec) {
+	*out = *in
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveGateServiceSpec.
+func (in *ActiveGateServiceSpec) DeepCopy() *ActiveGateServiceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveGateServiceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ActiveGateStatus) DeepCopyInto(out *ActiveGateStatus) {
 	*out = *in
 	in.VersionStatus.DeepCopyInto(&out.VersionStatus)
+	if in.ServiceEndpoints != nil {
+		in, out := &in.ServiceEndpoints, &out.ServiceEndpoints
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.CustomPropertiesHashes != nil {
+		in, out := &in.CustomPropertiesHashes, &out.CustomPropertiesHashes
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = make([]ActiveGateReplicaStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveGateStatus.
@@ -60,6 +249,31 @@ func (in *ActiveGateStatus) DeepCopy() *ActiveGateStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveGateStorageSpec) DeepCopyInto(out *ActiveGateStorageSpec) {
+	*out = *in
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveGateStorageSpec.
+func (in *ActiveGateStorageSpec) DeepCopy() *ActiveGateStorageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveGateStorageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CapabilityProperties) DeepCopyInto(out *CapabilityProperties) {
 	*out = *in
@@ -73,6 +287,11 @@ func (in *CapabilityProperties) DeepCopyInto(out *CapabilityProperties) {
 		*out = new(DynaKubeValueSource)
 		**out = **in
 	}
+	if in.StructuredCustomProperties != nil {
+		in, out := &in.StructuredCustomProperties, &out.StructuredCustomProperties
+		*out = new(ActiveGateCustomProperties)
+		(*in).DeepCopyInto(*out)
+	}
 	in.Resources.DeepCopyInto(&out.Resources)
 	if in.NodeSelector != nil {
 		in, out := &in.NodeSelector, &out.NodeSelector
@@ -107,6 +326,21 @@ func (in *CapabilityProperties) DeepCopyInto(out *CapabilityProperties) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Storage != nil {
+		in, out := &in.Storage, &out.Storage
+		*out = new(ActiveGateStorageSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodOverrides != nil {
+		in, out := &in.PodOverrides, &out.PodOverrides
+		*out = new(ActiveGatePodOverrides)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Service != nil {
+		in, out := &in.Service, &out.Service
+		*out = new(ActiveGateServiceSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CapabilityProperties.
@@ -124,6 +358,45 @@ func (in *CodeModulesSpec) DeepCopyInto(out *CodeModulesSpec) {
 	*out = *in
 	in.Resources.DeepCopyInto(&out.Resources)
 	in.Volume.DeepCopyInto(&out.Volume)
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExcludeContainers != nil {
+		in, out := &in.ExcludeContainers, &out.ExcludeContainers
+		*out = make([]ContainerPattern, len(*in))
+		copy(*out, *in)
+	}
+	if in.FlavorRules != nil {
+		in, out := &in.FlavorRules, &out.FlavorRules
+		*out = make([]FlavorRule, len(*in))
+		copy(*out, *in)
+	}
+	if in.VolumeQuota != nil {
+		in, out := &in.VolumeQuota, &out.VolumeQuota
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.VolumeNamespaces != nil {
+		in, out := &in.VolumeNamespaces, &out.VolumeNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.InstallerTokenLifetime != nil {
+		in, out := &in.InstallerTokenLifetime, &out.InstallerTokenLifetime
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	in.DownloadCache.DeepCopyInto(&out.DownloadCache)
+	in.RestartWorkloads.DeepCopyInto(&out.RestartWorkloads)
+	in.Webhook.DeepCopyInto(&out.Webhook)
+	in.MetadataEnrichment.DeepCopyInto(&out.MetadataEnrichment)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CodeModulesSpec.
@@ -152,6 +425,35 @@ func (in *DataIngestSpec) DeepCopy() *DataIngestSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CodeModulesStatus) DeepCopyInto(out *CodeModulesStatus) {
+	*out = *in
+	if in.OutdatedWorkloads != nil {
+		in, out := &in.OutdatedWorkloads, &out.OutdatedWorkloads
+		*out = make([]OutdatedWorkload, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastWorkloadRestart != nil {
+		in, out := &in.LastWorkloadRestart, &out.LastWorkloadRestart
+		*out = (*in).DeepCopy()
+	}
+	if in.LastInjectionFailure != nil {
+		in, out := &in.LastInjectionFailure, &out.LastInjectionFailure
+		*out = new(InjectionFailure)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CodeModulesStatus.
+func (in *CodeModulesStatus) DeepCopy() *CodeModulesStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CodeModulesStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CommunicationHostStatus) DeepCopyInto(out *CommunicationHostStatus) {
 	*out = *in
@@ -187,6 +489,42 @@ func (in *ConnectionInfoStatus) DeepCopy() *ConnectionInfoStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerPattern) DeepCopyInto(out *ContainerPattern) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerPattern.
+func (in *ContainerPattern) DeepCopy() *ContainerPattern {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerPattern)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DownloadCacheSpec) DeepCopyInto(out *DownloadCacheSpec) {
+	*out = *in
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DownloadCacheSpec.
+func (in *DownloadCacheSpec) DeepCopy() *DownloadCacheSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DownloadCacheSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DynaKube) DeepCopyInto(out *DynaKube) {
 	*out = *in
@@ -316,6 +654,7 @@ func (in *DynaKubeStatus) DeepCopyInto(out *DynaKubeStatus) {
 	}
 	in.ActiveGate.DeepCopyInto(&out.ActiveGate)
 	in.OneAgent.DeepCopyInto(&out.OneAgent)
+	in.CodeModules.DeepCopyInto(&out.CodeModules)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynaKubeStatus.
@@ -343,6 +682,21 @@ func (in *DynaKubeValueSource) DeepCopy() *DynaKubeValueSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FlavorRule) DeepCopyInto(out *FlavorRule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FlavorRule.
+func (in *FlavorRule) DeepCopy() *FlavorRule {
+	if in == nil {
+		return nil
+	}
+	out := new(FlavorRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FullStackSpec) DeepCopyInto(out *FullStackSpec) {
 	*out = *in
@@ -402,6 +756,22 @@ func (in *FullStackSpec) DeepCopy() *FullStackSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InjectionFailure) DeepCopyInto(out *InjectionFailure) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InjectionFailure.
+func (in *InjectionFailure) DeepCopy() *InjectionFailure {
+	if in == nil {
+		return nil
+	}
+	out := new(InjectionFailure)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KubernetesMonitoringSpec) DeepCopyInto(out *KubernetesMonitoringSpec) {
 	*out = *in
@@ -418,6 +788,36 @@ func (in *KubernetesMonitoringSpec) DeepCopy() *KubernetesMonitoringSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetadataEnrichmentSpec) DeepCopyInto(out *MetadataEnrichmentSpec) {
+	*out = *in
+	if in.PodLabels != nil {
+		in, out := &in.PodLabels, &out.PodLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PodAnnotations != nil {
+		in, out := &in.PodAnnotations, &out.PodAnnotations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespaceLabels != nil {
+		in, out := &in.NamespaceLabels, &out.NamespaceLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetadataEnrichmentSpec.
+func (in *MetadataEnrichmentSpec) DeepCopy() *MetadataEnrichmentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MetadataEnrichmentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OneAgentInstance) DeepCopyInto(out *OneAgentInstance) {
 	*out = *in
@@ -480,6 +880,46 @@ func (in *OneAgentStatus) DeepCopy() *OneAgentStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OutdatedWorkload) DeepCopyInto(out *OutdatedWorkload) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OutdatedWorkload.
+func (in *OutdatedWorkload) DeepCopy() *OutdatedWorkload {
+	if in == nil {
+		return nil
+	}
+	out := new(OutdatedWorkload)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestartWorkloadsSpec) DeepCopyInto(out *RestartWorkloadsSpec) {
+	*out = *in
+	if in.BatchSize != nil {
+		in, out := &in.BatchSize, &out.BatchSize
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestartWorkloadsSpec.
+func (in *RestartWorkloadsSpec) DeepCopy() *RestartWorkloadsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RestartWorkloadsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RoutingSpec) DeepCopyInto(out *RoutingSpec) {
 	*out = *in
@@ -514,3 +954,23 @@ func (in *VersionStatus) DeepCopy() *VersionStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebhookSpec) DeepCopyInto(out *WebhookSpec) {
+	*out = *in
+	if in.TimeoutSeconds != nil {
+		in, out := &in.TimeoutSeconds, &out.TimeoutSeconds
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebhookSpec.
+func (in *WebhookSpec) DeepCopy() *WebhookSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WebhookSpec)
+	in.DeepCopyInto(out)
+	return out
+}
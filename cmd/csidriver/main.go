@@ -18,20 +18,25 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"time"
 
 	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/distribution"
 	csidriver "github.com/Dynatrace/dynatrace-operator/controllers/csi/driver"
 	csigc "github.com/Dynatrace/dynatrace-operator/controllers/csi/gc"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
 	csiprovisioner "github.com/Dynatrace/dynatrace-operator/controllers/csi/provisioner"
 	"github.com/Dynatrace/dynatrace-operator/logger"
 	"github.com/Dynatrace/dynatrace-operator/scheme"
 	"github.com/Dynatrace/dynatrace-operator/version"
 	"github.com/spf13/afero"
 	"golang.org/x/sys/unix"
+	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -42,9 +47,20 @@ var (
 	endpoint  = flag.String("endpoint", "unix:///tmp/csi.sock", "CSI endpoint")
 	probeAddr = flag.String("health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 
+	publishTimeout      = flag.Duration("publish-timeout", time.Minute, "How long volumes wait for the OneAgent to be provisioned.")
+	provisioningTimeout = flag.Duration("provisioning-timeout", 0,
+		"How long the driver waits for the OneAgent to be provisioned after its start before it reports readiness, disabled if 0.")
+
+	requireChecksums = flag.Bool("require-checksums", false,
+		"Reject OneAgent packages the deployment API doesn't provide a SHA-256 checksum for, instead of only verifying the checksums of the archive.")
+
+	distributionPort = flag.Int("distribution-port", 10081, "The port OneAgent packages are served at if distributed in the cluster.")
+
 	log = logger.NewDTLogger().WithName("server")
 )
 
+const defaultMaxLogAge = 14 * 24 * time.Hour
+
 func main() {
 	flag.Parse()
 	ctrl.SetLogger(log)
@@ -58,6 +74,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	gcPolicy, err := parseGCPolicy()
+	if err != nil {
+		log.Error(err, "unable to parse garbage collection policy")
+		os.Exit(1)
+	}
+
+	distributionEnabled := false
+	if value := os.Getenv("AGENT_DISTRIBUTION_ENABLED"); value != "" {
+		if distributionEnabled, err = strconv.ParseBool(value); err != nil {
+			log.Error(err, "unable to convert AGENT_DISTRIBUTION_ENABLED to bool")
+			os.Exit(1)
+		}
+	}
+
 	defaultUmask := unix.Umask(0000)
 	defer unix.Umask(defaultUmask)
 
@@ -78,6 +108,17 @@ func main() {
 		Endpoint:   *endpoint,
 		RootDir:    dtcsi.DataPath,
 		GCInterval: time.Duration(gcInterval) * time.Minute,
+		GCPolicy:   gcPolicy,
+
+		PublishTimeout:      *publishTimeout,
+		ProvisioningTimeout: *provisioningTimeout,
+		RequireChecksums:    *requireChecksums,
+		Distribution: dtcsi.DistributionOptions{
+			Enabled:        distributionEnabled,
+			Address:        net.JoinHostPort(os.Getenv("POD_IP"), strconv.Itoa(*distributionPort)),
+			Namespace:      namespace,
+			ServiceAccount: os.Getenv("SERVICE_ACCOUNT_NAME"),
+		},
 	}
 
 	fs := afero.NewOsFs()
@@ -87,27 +128,48 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := fs.MkdirAll(filepath.Join(csiOpts.RootDir, dtcsi.GarbageCollectionPath), 0770); err != nil {
-		log.Error(err, "unable to create garbage collector directory for CSI Driver")
+	db, err := metadata.NewAccess(filepath.Join(csiOpts.RootDir, metadata.FileName))
+	if err != nil {
+		log.Error(err, "unable to open metadata database for CSI Driver")
 		os.Exit(1)
 	}
+	defer func() { _ = db.Close() }()
 
-	if err := csidriver.NewServer(mgr.GetClient(), csiOpts).SetupWithManager(mgr); err != nil {
-		log.Error(err, "unable to create CSI Driver server")
+	provisioner := csiprovisioner.NewReconciler(mgr, csiOpts, db)
+	if err := provisioner.SetupWithManager(mgr); err != nil {
+		log.Error(err, "unable to create CSI Provisioner")
 		os.Exit(1)
 	}
 
-	if err := csiprovisioner.NewReconciler(mgr, csiOpts).SetupWithManager(mgr); err != nil {
-		log.Error(err, "unable to create CSI Provisioner")
+	if err := csidriver.NewServer(mgr.GetClient(), csiOpts, db, provisioner).SetupWithManager(mgr); err != nil {
+		log.Error(err, "unable to create CSI Driver server")
 		os.Exit(1)
 	}
 
+	if csiOpts.Distribution.Enabled {
+		clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			log.Error(err, "unable to create Kubernetes client for OneAgent distribution")
+			os.Exit(1)
+		}
+
+		if err := mgr.Add(distribution.NewServer(csiOpts, clientset.CoordinationV1(), clientset.AuthenticationV1(), provisioner.GetDistributionSource)); err != nil {
+			log.Error(err, "unable to create OneAgent distribution server")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		log.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
 
-	if err := csigc.NewReconciler(mgr.GetClient(), csiOpts).SetupWithManager(mgr); err != nil {
+	if err := mgr.AddReadyzCheck("provisioned", provisioner.ReadyzCheck); err != nil {
+		log.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	if err := csigc.NewReconciler(mgr.GetClient(), csiOpts, db).SetupWithManager(mgr); err != nil {
 		log.Error(err, "unable to create CSI Garbage Collector")
 		os.Exit(1)
 	}
@@ -118,3 +180,37 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// parseGCPolicy reads the policy of the garbage collector from the environment, unset variables keep the defaults
+func parseGCPolicy() (dtcsi.GCPolicy, error) {
+	policy := dtcsi.GCPolicy{MaxLogAge: defaultMaxLogAge}
+	var err error
+
+	if value := os.Getenv("GC_KEEP_VERSIONS"); value != "" {
+		if policy.KeepVersions, err = strconv.Atoi(value); err != nil {
+			return policy, fmt.Errorf("unable to convert GC_KEEP_VERSIONS to int: %w", err)
+		}
+	}
+	if value := os.Getenv("GC_MAX_VERSION_AGE"); value != "" {
+		if policy.MaxVersionAge, err = time.ParseDuration(value); err != nil {
+			return policy, fmt.Errorf("unable to parse GC_MAX_VERSION_AGE: %w", err)
+		}
+	}
+	if value := os.Getenv("GC_MAX_LOG_AGE"); value != "" {
+		if policy.MaxLogAge, err = time.ParseDuration(value); err != nil {
+			return policy, fmt.Errorf("unable to parse GC_MAX_LOG_AGE: %w", err)
+		}
+	}
+	if value := os.Getenv("GC_DISK_HIGH_WATERMARK_PERCENT"); value != "" {
+		if policy.DiskHighWatermark, err = strconv.Atoi(value); err != nil {
+			return policy, fmt.Errorf("unable to convert GC_DISK_HIGH_WATERMARK_PERCENT to int: %w", err)
+		}
+	}
+	if value := os.Getenv("GC_DRY_RUN"); value != "" {
+		if policy.DryRun, err = strconv.ParseBool(value); err != nil {
+			return policy, fmt.Errorf("unable to convert GC_DRY_RUN to bool: %w", err)
+		}
+	}
+
+	return policy, nil
+}
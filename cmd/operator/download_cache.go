@@ -0,0 +1,56 @@
+/*
+Copyright 2021 Dynatrace LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Dynatrace/dynatrace-operator/downloadcache"
+	"github.com/Dynatrace/dynatrace-operator/installer"
+	"github.com/spf13/afero"
+)
+
+const downloadCacheSubcmd = "download-cache"
+
+// runDownloadCache is run by the download cache Deployment of a DynaKube, it only needs the install configuration to
+// connect to the tenant and doesn't need access to the cluster
+func runDownloadCache() int {
+	logger := log.WithName(downloadCacheSubcmd)
+	fs := afero.NewOsFs()
+
+	config, trustedCAs, err := installer.ReadConfig(fs, installer.ConfigDir)
+	if err != nil {
+		logger.Error(err, "failed to read configuration")
+		return 1
+	}
+
+	dtc, err := installer.BuildDynatraceClient(config, trustedCAs)
+	if err != nil {
+		logger.Error(err, "failed to create Dynatrace client")
+		return 1
+	}
+
+	cache := downloadcache.NewCache(fs, dtc, downloadcache.CacheDir, logger)
+
+	logger.Info("starting download cache", "port", downloadcache.Port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", downloadcache.Port), cache.Handler()); err != nil {
+		logger.Error(err, "download cache failed")
+		return 1
+	}
+	return 0
+}
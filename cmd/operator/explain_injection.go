@@ -0,0 +1,80 @@
+/*
+Copyright 2021 Dynatrace LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Dynatrace/dynatrace-operator/scheme"
+	"github.com/Dynatrace/dynatrace-operator/webhook/server"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+const explainInjectionSubcmd = "explain-injection"
+
+var (
+	explainPodFile           string
+	explainPodNamespace      string
+	explainOperatorNamespace string
+)
+
+// runExplainInjection prints the injection decision of the webhook for a pod manifest, it only reads from the cluster.
+// The logger of controller-runtime isn't set up for this subcommand, so only the explanation is written to stdout.
+func runExplainInjection() int {
+	var manifest []byte
+	var err error
+	if explainPodFile == "-" {
+		manifest, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		manifest, err = ioutil.ReadFile(explainPodFile)
+	}
+	if err != nil {
+		log.Error(err, "failed to read pod manifest", "file", explainPodFile)
+		return 1
+	}
+
+	cfg, err := config.GetConfig()
+	if err != nil {
+		log.Error(err, "failed to get cluster configuration")
+		return 1
+	}
+
+	clt, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		log.Error(err, "failed to create client")
+		return 1
+	}
+
+	explanation, err := server.ExplainInjection(context.TODO(), cfg, clt, explainOperatorNamespace, manifest, explainPodNamespace)
+	if err != nil {
+		log.Error(err, "failed to explain injection")
+		return 1
+	}
+
+	output, err := json.MarshalIndent(explanation, "", "  ")
+	if err != nil {
+		log.Error(err, "failed to format explanation")
+		return 1
+	}
+	fmt.Println(string(output))
+	return 0
+}
@@ -0,0 +1,32 @@
+/*
+Copyright 2021 Dynatrace LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/Dynatrace/dynatrace-operator/installer"
+)
+
+const installOneAgentSubcmd = "install-oneagent"
+
+// runInstallOneAgent is run by the install container injected by the webhook, it doesn't need access to the cluster
+func runInstallOneAgent() int {
+	if err := installer.NewInstaller(log.WithName(installOneAgentSubcmd)).Run(); err != nil {
+		log.Error(err, "failed to install OneAgent")
+		return 1
+	}
+	return 0
+}
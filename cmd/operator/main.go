@@ -37,7 +37,7 @@ var subcmdCallbacks = map[string]func(ns string, cfg *rest.Config) (manager.Mana
 	"webhook-server": startWebhookServer,
 }
 
-var errBadSubcmd = errors.New("subcommand must be operator, or webhook-server")
+var errBadSubcmd = errors.New("subcommand must be operator, webhook-server, install-oneagent, download-cache or explain-injection")
 
 var (
 	certsDir string
@@ -51,16 +51,35 @@ func main() {
 	webhookServerFlags.StringVar(&certFile, "cert", "tls.crt", "File name for the public certificate.")
 	webhookServerFlags.StringVar(&keyFile, "cert-key", "tls.key", "File name for the private key.")
 
+	explainInjectionFlags := pflag.NewFlagSet(explainInjectionSubcmd, pflag.ExitOnError)
+	explainInjectionFlags.StringVarP(&explainPodFile, "filename", "f", "-", "Pod manifest to explain, - reads it from stdin.")
+	explainInjectionFlags.StringVar(&explainPodNamespace, "pod-namespace", "", "Namespace of the pod, defaults to the namespace of the manifest.")
+	explainInjectionFlags.StringVar(&explainOperatorNamespace, "operator-namespace", "dynatrace", "Namespace of the Operator and its DynaKubes.")
+
 	pflag.CommandLine.AddFlagSet(webhookServerFlags)
+	pflag.CommandLine.AddFlagSet(explainInjectionFlags)
 	pflag.Parse()
 
+	subcmd := "operator"
+	if args := pflag.Args(); len(args) > 0 {
+		subcmd = args[0]
+	}
+
+	// The explanation is the only output of the subcommand, so it runs before the loggers are set up
+	if subcmd == explainInjectionSubcmd {
+		os.Exit(runExplainInjection())
+	}
+
 	ctrl.SetLogger(logger.NewDTLogger())
 
 	version.LogVersion()
 
-	subcmd := "operator"
-	if args := pflag.Args(); len(args) > 0 {
-		subcmd = args[0]
+	if subcmd == installOneAgentSubcmd {
+		os.Exit(runInstallOneAgent())
+	}
+
+	if subcmd == downloadCacheSubcmd {
+		os.Exit(runDownloadCache())
 	}
 
 	subcmdFn := subcmdCallbacks[subcmd]
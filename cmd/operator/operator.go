@@ -20,6 +20,7 @@ import (
 	"os"
 
 	"github.com/Dynatrace/dynatrace-operator/controllers/dynakube"
+	"github.com/Dynatrace/dynatrace-operator/controllers/injection"
 	"github.com/Dynatrace/dynatrace-operator/controllers/namespace"
 	"github.com/Dynatrace/dynatrace-operator/controllers/nodes"
 	"github.com/Dynatrace/dynatrace-operator/controllers/webhookcerts"
@@ -60,6 +61,7 @@ func startOperator(ns string, cfg *rest.Config) (manager.Manager, error) {
 
 	funcs := []func(manager.Manager, string) error{
 		dynakube.Add,
+		injection.Add,
 		namespace.Add,
 		nodes.Add,
 	}
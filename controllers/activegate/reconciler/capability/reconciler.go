@@ -2,7 +2,11 @@ package capability
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
 
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	"github.com/Dynatrace/dynatrace-operator/controllers/activegate/capability"
@@ -93,7 +97,7 @@ func buildDNSEntryPoint(instance *dynatracev1alpha1.DynaKube, moduleName string)
 
 func (r *Reconciler) Reconcile() (update bool, err error) {
 	if r.GetConfiguration().CreateService {
-		update, err = r.createServiceIfNotExists()
+		update, err = r.createOrUpdateService()
 		if update || err != nil {
 			return update, errors.WithStack(err)
 		}
@@ -103,19 +107,118 @@ func (r *Reconciler) Reconcile() (update bool, err error) {
 	return update, errors.WithStack(err)
 }
 
-func (r *Reconciler) createServiceIfNotExists() (bool, error) {
-	service := createService(r.Instance, r.GetModuleName())
+func (r *Reconciler) createOrUpdateService() (bool, error) {
+	desiredService := createService(r.Instance, r.GetModuleName(), r.GetProperties().Service)
+	if err := controllerutil.SetControllerReference(r.Instance, desiredService, r.Scheme()); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	hash, err := generateServiceHash(desiredService)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	desiredService.Annotations[sts.AnnotationTemplateHash] = hash
 
-	err := r.Get(context.TODO(), client.ObjectKey{Name: service.Name, Namespace: service.Namespace}, service)
+	var currentService corev1.Service
+	err = r.Get(context.TODO(), client.ObjectKey{Name: desiredService.Name, Namespace: desiredService.Namespace}, &currentService)
 	if err != nil && k8serrors.IsNotFound(err) {
 		r.log.Info("creating service", "module", r.GetModuleName())
+		err = r.Create(context.TODO(), desiredService)
+		return true, errors.WithStack(err)
+	} else if err != nil {
+		return false, errors.WithStack(err)
+	}
 
-		if err := controllerutil.SetControllerReference(r.Instance, service, r.Scheme()); err != nil {
-			return false, errors.WithStack(err)
+	if sts.GetTemplateHash(&currentService) != hash {
+		r.log.Info("updating service", "module", r.GetModuleName())
+		// Keep the values assigned by Kubernetes, they must not be changed on updates
+		desiredService.ResourceVersion = currentService.ResourceVersion
+		desiredService.Spec.ClusterIP = currentService.Spec.ClusterIP
+		desiredService.Annotations = mergeServiceAnnotations(currentService.Annotations, desiredService.Annotations)
+		if desiredService.Spec.Type != corev1.ServiceTypeClusterIP && currentService.Spec.Type != corev1.ServiceTypeClusterIP {
+			preserveNodePorts(desiredService, &currentService)
 		}
 
-		err = r.Create(context.TODO(), service)
+		err = r.Update(context.TODO(), desiredService)
 		return true, errors.WithStack(err)
 	}
-	return false, errors.WithStack(err)
+
+	endpoint, err := r.getExternalEndpoint(&currentService)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return r.updateServiceEndpoint(endpoint), nil
+}
+
+// getExternalEndpoint returns the communication endpoint of the Service reachable from outside the cluster, i.e. the
+// ingress of a LoadBalancer Service or the node port on the node of the first running ActiveGate pod. ClusterIP
+// Services are not reachable from outside the cluster.
+func (r *Reconciler) getExternalEndpoint(service *corev1.Service) (string, error) {
+	if service.Spec.Type != corev1.ServiceTypeNodePort {
+		return buildExternalEndpoint(service), nil
+	}
+
+	var pods corev1.PodList
+	err := r.List(context.TODO(), &pods, client.InNamespace(service.Namespace), client.MatchingLabels(service.Spec.Selector))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Name < pods.Items[j].Name })
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.Spec.NodeName == "" {
+			continue
+		}
+
+		var node corev1.Node
+		if err := r.Get(context.TODO(), client.ObjectKey{Name: pod.Spec.NodeName}, &node); err != nil {
+			return "", errors.WithStack(err)
+		}
+		return buildNodePortEndpoint(service, &node), nil
+	}
+	return "", nil
+}
+
+func (r *Reconciler) updateServiceEndpoint(endpoint string) bool {
+	endpoints := &r.Instance.Status.ActiveGate.ServiceEndpoints
+
+	if (*endpoints)[r.GetModuleName()] == endpoint {
+		return false
+	}
+
+	if endpoint == "" {
+		delete(*endpoints, r.GetModuleName())
+		return true
+	}
+
+	if *endpoints == nil {
+		*endpoints = map[string]string{}
+	}
+	(*endpoints)[r.GetModuleName()] = endpoint
+	r.log.Info("service endpoint changed", "module", r.GetModuleName(), "endpoint", endpoint)
+	return true
+}
+
+func preserveNodePorts(desiredService *corev1.Service, currentService *corev1.Service) {
+	for i := range desiredService.Spec.Ports {
+		for _, currentPort := range currentService.Spec.Ports {
+			if desiredService.Spec.Ports[i].Port == currentPort.Port {
+				desiredService.Spec.Ports[i].NodePort = currentPort.NodePort
+			}
+		}
+	}
+}
+
+func generateServiceHash(service *corev1.Service) (string, error) {
+	data, err := json.Marshal(service)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	hasher := fnv.New32()
+	if _, err = hasher.Write(data); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return strconv.FormatUint(uint64(hasher.Sum32()), 10), nil
 }
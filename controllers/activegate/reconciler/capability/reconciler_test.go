@@ -172,6 +172,109 @@ func TestReconcile(t *testing.T) {
 	})
 }
 
+func TestCreateOrUpdateService(t *testing.T) {
+	t.Run(`service is updated on spec changes`, func(t *testing.T) {
+		r := createDefaultReconciler(t)
+		r.GetProperties().Service = nil
+
+		update, err := r.createOrUpdateService()
+		assert.NoError(t, err)
+		assert.True(t, update)
+
+		update, err = r.createOrUpdateService()
+		assert.NoError(t, err)
+		assert.False(t, update)
+
+		r.GetProperties().Service = &v1alpha1.ActiveGateServiceSpec{Type: corev1.ServiceTypeNodePort}
+		update, err = r.createOrUpdateService()
+		assert.NoError(t, err)
+		assert.True(t, update)
+
+		svc := &corev1.Service{}
+		err = r.Get(context.TODO(), client.ObjectKey{Name: BuildServiceName(r.Instance.Name, r.GetModuleName()), Namespace: r.Instance.Namespace}, svc)
+		require.NoError(t, err)
+		assert.Equal(t, corev1.ServiceTypeNodePort, svc.Spec.Type)
+	})
+	t.Run(`load balancer endpoint is written into status`, func(t *testing.T) {
+		r := createDefaultReconciler(t)
+		r.GetProperties().Service = &v1alpha1.ActiveGateServiceSpec{Type: corev1.ServiceTypeLoadBalancer}
+		defer func() { r.GetProperties().Service = nil }()
+
+		update, err := r.createOrUpdateService()
+		require.NoError(t, err)
+		require.True(t, update)
+
+		svc := &corev1.Service{}
+		err = r.Get(context.TODO(), client.ObjectKey{Name: BuildServiceName(r.Instance.Name, r.GetModuleName()), Namespace: r.Instance.Namespace}, svc)
+		require.NoError(t, err)
+
+		svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+		require.NoError(t, r.Status().Update(context.TODO(), svc))
+
+		update, err = r.createOrUpdateService()
+		assert.NoError(t, err)
+		assert.True(t, update)
+		assert.Equal(t, "https://10.0.0.1:443/communication", r.Instance.Status.ActiveGate.ServiceEndpoints[r.GetModuleName()])
+
+		update, err = r.createOrUpdateService()
+		assert.NoError(t, err)
+		assert.False(t, update)
+	})
+	t.Run(`node port endpoint is written into status`, func(t *testing.T) {
+		r := createDefaultReconciler(t)
+		r.GetProperties().Service = &v1alpha1.ActiveGateServiceSpec{Type: corev1.ServiceTypeNodePort}
+		defer func() { r.GetProperties().Service = nil }()
+
+		update, err := r.createOrUpdateService()
+		require.NoError(t, err)
+		require.True(t, update)
+
+		svc := &corev1.Service{}
+		err = r.Get(context.TODO(), client.ObjectKey{Name: BuildServiceName(r.Instance.Name, r.GetModuleName()), Namespace: r.Instance.Namespace}, svc)
+		require.NoError(t, err)
+		svc.Spec.Ports[0].NodePort = 30443
+		require.NoError(t, r.Update(context.TODO(), svc))
+
+		require.NoError(t, r.Create(context.TODO(), &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node"},
+			Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.2"}}},
+		}))
+		require.NoError(t, r.Create(context.TODO(), &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "activegate-0", Namespace: svc.Namespace, Labels: svc.Spec.Selector},
+			Spec:       corev1.PodSpec{NodeName: "node"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}))
+
+		update, err = r.createOrUpdateService()
+		assert.NoError(t, err)
+		assert.True(t, update)
+		assert.Equal(t, "https://10.0.0.2:30443/communication", r.Instance.Status.ActiveGate.ServiceEndpoints[r.GetModuleName()])
+	})
+	t.Run(`annotations of others are kept on updates`, func(t *testing.T) {
+		r := createDefaultReconciler(t)
+		r.GetProperties().Service = nil
+
+		_, err := r.createOrUpdateService()
+		require.NoError(t, err)
+
+		svc := &corev1.Service{}
+		key := client.ObjectKey{Name: BuildServiceName(r.Instance.Name, r.GetModuleName()), Namespace: r.Instance.Namespace}
+		require.NoError(t, r.Get(context.TODO(), key, svc))
+		svc.Annotations["added-by-user"] = testValue
+		require.NoError(t, r.Update(context.TODO(), svc))
+
+		r.GetProperties().Service = &v1alpha1.ActiveGateServiceSpec{Annotations: map[string]string{testName: testValue}}
+		defer func() { r.GetProperties().Service = nil }()
+		update, err := r.createOrUpdateService()
+		require.NoError(t, err)
+		require.True(t, update)
+
+		require.NoError(t, r.Get(context.TODO(), key, svc))
+		assert.Equal(t, testValue, svc.Annotations["added-by-user"])
+		assert.Equal(t, testValue, svc.Annotations[testName])
+	})
+}
+
 func TestSetReadinessProbePort(t *testing.T) {
 	r := createDefaultReconciler(t)
 	stsProps := rsfs.NewStatefulSetProperties(r.Instance, metricsCapability.GetProperties(), "", "", "", "", "", nil, nil, nil)
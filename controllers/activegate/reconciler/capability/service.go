@@ -2,6 +2,9 @@ package capability
 
 import (
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
@@ -12,12 +15,17 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
-func createService(instance *v1alpha1.DynaKube, feature string) *corev1.Service {
-	return &corev1.Service{
+// annotationServiceAnnotations lists the annotations of the Service configured in the DynaKube, so they can be
+// removed again without touching the ones added by others
+const annotationServiceAnnotations = "internal.operator.dynatrace.com/service-annotations"
+
+func createService(instance *v1alpha1.DynaKube, feature string, serviceSpec *v1alpha1.ActiveGateServiceSpec) *corev1.Service {
+	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      BuildServiceName(instance.Name, feature),
-			Namespace: instance.Namespace,
-			Labels:    statefulset.BuildLabelsFromInstance(instance, feature),
+			Name:        BuildServiceName(instance.Name, feature),
+			Namespace:   instance.Namespace,
+			Labels:      statefulset.BuildLabelsFromInstance(instance, feature),
+			Annotations: map[string]string{},
 		},
 		Spec: corev1.ServiceSpec{
 			Type:     corev1.ServiceTypeClusterIP,
@@ -31,6 +39,89 @@ func createService(instance *v1alpha1.DynaKube, feature string) *corev1.Service
 			},
 		},
 	}
+
+	if serviceSpec != nil {
+		if serviceSpec.Type != "" {
+			service.Spec.Type = serviceSpec.Type
+		}
+		if service.Spec.Type != corev1.ServiceTypeClusterIP {
+			service.Spec.ExternalTrafficPolicy = serviceSpec.ExternalTrafficPolicy
+		}
+
+		keys := make([]string, 0, len(serviceSpec.Annotations))
+		for key, value := range serviceSpec.Annotations {
+			service.Annotations[key] = value
+			keys = append(keys, key)
+		}
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			service.Annotations[annotationServiceAnnotations] = strings.Join(keys, ",")
+		}
+	}
+
+	return service
+}
+
+// mergeServiceAnnotations returns the annotations of the desired Service merged onto the ones of the current Service,
+// so annotations added by cloud controllers or users are kept. Only annotations previously configured in the DynaKube
+// are removed once they are not configured anymore.
+func mergeServiceAnnotations(current map[string]string, desired map[string]string) map[string]string {
+	merged := map[string]string{}
+	for key, value := range current {
+		merged[key] = value
+	}
+	if managed := current[annotationServiceAnnotations]; managed != "" {
+		for _, key := range strings.Split(managed, ",") {
+			delete(merged, key)
+		}
+	}
+	delete(merged, annotationServiceAnnotations)
+
+	for key, value := range desired {
+		merged[key] = value
+	}
+	return merged
+}
+
+// buildExternalEndpoint returns the communication endpoint of a LoadBalancer Service, once its ingress is assigned.
+func buildExternalEndpoint(service *corev1.Service) string {
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return ""
+	}
+
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		host := ingress.Hostname
+		if host == "" {
+			host = ingress.IP
+		}
+		if host != "" {
+			return fmt.Sprintf("https://%s:%d/communication", host, consts.ServicePort)
+		}
+	}
+	return ""
+}
+
+// buildNodePortEndpoint returns the communication endpoint of a NodePort Service on the given node, preferring its
+// external address. Returns an empty string if the node port has not been assigned yet.
+func buildNodePortEndpoint(service *corev1.Service, node *corev1.Node) string {
+	var nodePort int32
+	for _, port := range service.Spec.Ports {
+		if port.Port == consts.ServicePort {
+			nodePort = port.NodePort
+		}
+	}
+	if nodePort == 0 {
+		return ""
+	}
+
+	for _, addressType := range []corev1.NodeAddressType{corev1.NodeExternalIP, corev1.NodeExternalDNS, corev1.NodeInternalIP} {
+		for _, address := range node.Status.Addresses {
+			if address.Type == addressType && address.Address != "" {
+				return fmt.Sprintf("https://%s/communication", net.JoinHostPort(address.Address, strconv.Itoa(int(nodePort))))
+			}
+		}
+	}
+	return ""
 }
 
 func BuildServiceName(instanceName string, module string) string {
@@ -21,7 +21,7 @@ func TestCreateService(t *testing.T) {
 	instance := &v1alpha1.DynaKube{
 		ObjectMeta: v1.ObjectMeta{Namespace: testNamespace, Name: testName},
 	}
-	service := createService(instance, testFeature)
+	service := createService(instance, testFeature, nil)
 
 	assert.NotNil(t, service)
 	assert.Equal(t, instance.Name+"-"+testFeature, service.Name)
@@ -43,6 +43,85 @@ func TestCreateService(t *testing.T) {
 	})
 }
 
+func TestCreateService_WithServiceSpec(t *testing.T) {
+	instance := &v1alpha1.DynaKube{
+		ObjectMeta: v1.ObjectMeta{Namespace: testNamespace, Name: testName},
+	}
+
+	t.Run(`load balancer`, func(t *testing.T) {
+		service := createService(instance, testFeature, &v1alpha1.ActiveGateServiceSpec{
+			Type:                  corev1.ServiceTypeLoadBalancer,
+			Annotations:           map[string]string{testName: testValue},
+			ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+		})
+
+		assert.Equal(t, corev1.ServiceTypeLoadBalancer, service.Spec.Type)
+		assert.Equal(t, corev1.ServiceExternalTrafficPolicyTypeLocal, service.Spec.ExternalTrafficPolicy)
+		assert.Equal(t, testValue, service.Annotations[testName])
+	})
+	t.Run(`external traffic policy is ignored for cluster ip`, func(t *testing.T) {
+		service := createService(instance, testFeature, &v1alpha1.ActiveGateServiceSpec{
+			ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+		})
+
+		assert.Equal(t, corev1.ServiceTypeClusterIP, service.Spec.Type)
+		assert.Empty(t, service.Spec.ExternalTrafficPolicy)
+	})
+}
+
+func TestBuildExternalEndpoint(t *testing.T) {
+	service := &corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP}}
+	assert.Empty(t, buildExternalEndpoint(service))
+
+	service.Spec.Type = corev1.ServiceTypeLoadBalancer
+	assert.Empty(t, buildExternalEndpoint(service))
+
+	service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+	assert.Equal(t, "https://10.0.0.1:443/communication", buildExternalEndpoint(service))
+
+	service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1", Hostname: "ag.example.com"}}
+	assert.Equal(t, "https://ag.example.com:443/communication", buildExternalEndpoint(service))
+}
+
+func TestBuildNodePortEndpoint(t *testing.T) {
+	service := &corev1.Service{Spec: corev1.ServiceSpec{
+		Type:  corev1.ServiceTypeNodePort,
+		Ports: []corev1.ServicePort{{Port: consts.ServicePort}},
+	}}
+	node := &corev1.Node{Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+	}}}
+	assert.Empty(t, buildNodePortEndpoint(service, node))
+
+	service.Spec.Ports[0].NodePort = 30443
+	assert.Equal(t, "https://10.0.0.1:30443/communication", buildNodePortEndpoint(service, node))
+
+	node.Status.Addresses = append(node.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "1.2.3.4"})
+	assert.Equal(t, "https://1.2.3.4:30443/communication", buildNodePortEndpoint(service, node))
+}
+
+func TestMergeServiceAnnotations(t *testing.T) {
+	instance := &v1alpha1.DynaKube{
+		ObjectMeta: v1.ObjectMeta{Namespace: testNamespace, Name: testName},
+	}
+	current := createService(instance, testFeature, &v1alpha1.ActiveGateServiceSpec{
+		Annotations: map[string]string{"removed": testValue, "changed": testValue},
+	}).Annotations
+	current["added-by-cloud-controller"] = testValue
+
+	desired := createService(instance, testFeature, &v1alpha1.ActiveGateServiceSpec{
+		Annotations: map[string]string{"changed": "new"},
+	}).Annotations
+
+	merged := mergeServiceAnnotations(current, desired)
+
+	assert.Equal(t, map[string]string{
+		"added-by-cloud-controller":  testValue,
+		"changed":                    "new",
+		annotationServiceAnnotations: "changed",
+	}, merged)
+}
+
 func TestBuildServiceNameForDNSEntryPoint(t *testing.T) {
 	actual := buildServiceHostName(testName, testFeature)
 	assert.NotEmpty(t, actual)
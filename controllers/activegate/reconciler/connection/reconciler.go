@@ -0,0 +1,185 @@
+package connection
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/controllers/activegate/capability"
+	sts "github.com/Dynatrace/dynatrace-operator/controllers/activegate/reconciler/statefulset"
+	"github.com/Dynatrace/dynatrace-operator/controllers/customproperties"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// requiredModules maps the capabilities to the ActiveGate modules they need to be enabled at the tenant
+var requiredModules = map[string][]string{
+	"kubernetes_monitoring": {dtclient.ActiveGateModuleKubernetes},
+	"MSGrouter":             {dtclient.ActiveGateModuleOneAgentRouting},
+	"metrics_ingest":        {dtclient.ActiveGateModuleMetricAPI},
+}
+
+// Reconciler matches the ActiveGate pods of the enabled capabilities to the ActiveGates registered at the tenant
+// and reports their connection state in the status of the DynaKube
+type Reconciler struct {
+	client.Client
+	dtc          dtclient.Client
+	log          logr.Logger
+	instance     *dynatracev1alpha1.DynaKube
+	capabilities []capability.Capability
+}
+
+func NewReconciler(clt client.Client, dtc dtclient.Client, log logr.Logger, instance *dynatracev1alpha1.DynaKube,
+	capabilities []capability.Capability) *Reconciler {
+	return &Reconciler{
+		Client:       clt,
+		dtc:          dtc,
+		log:          log,
+		instance:     instance,
+		capabilities: capabilities,
+	}
+}
+
+func (r *Reconciler) Reconcile() (bool, error) {
+	var replicas []dynatracev1alpha1.ActiveGateReplicaStatus
+	activeGatesByZone := map[string][]dtclient.ActiveGate{}
+
+	for _, c := range r.capabilities {
+		if !c.GetProperties().Enabled {
+			continue
+		}
+
+		pods, err := r.getPods(c)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		zone := r.getNetworkZone(c)
+		for i := range pods {
+			pod := &pods[i]
+			activeGates, ok := activeGatesByZone[zone]
+			if !ok {
+				if activeGates, err = r.dtc.GetActiveGates(dtclient.ActiveGateQuery{NetworkZone: zone}); err != nil {
+					return false, errors.WithStack(err)
+				}
+				activeGatesByZone[zone] = activeGates
+			}
+
+			replicas = append(replicas, buildReplicaStatus(pod, c, r.findActiveGate(pod, activeGates)))
+		}
+	}
+
+	sort.Slice(replicas, func(i, j int) bool {
+		return replicas[i].PodName < replicas[j].PodName
+	})
+
+	if reflect.DeepEqual(replicas, r.instance.Status.ActiveGate.Replicas) {
+		return false, nil
+	}
+
+	r.log.Info("ActiveGate connection status changed")
+	r.instance.Status.ActiveGate.Replicas = replicas
+	return true, nil
+}
+
+// getNetworkZone returns the network zone the ActiveGates of the capability connect to, which can be overridden per
+// capability by the structured custom properties
+func (r *Reconciler) getNetworkZone(c capability.Capability) string {
+	if structured := c.GetProperties().StructuredCustomProperties; structured != nil {
+		if structured.Connectivity != nil && structured.Connectivity.NetworkZone != "" {
+			return structured.Connectivity.NetworkZone
+		}
+		if zone := structured.Sections[customproperties.SectionConnectivity][customproperties.KeyNetworkZone]; zone != "" {
+			return zone
+		}
+	}
+	return r.instance.Spec.NetworkZone
+}
+
+func (r *Reconciler) getPods(c capability.Capability) ([]corev1.Pod, error) {
+	var pods corev1.PodList
+	err := r.List(context.TODO(), &pods,
+		client.InNamespace(r.instance.Namespace),
+		client.MatchingLabels(sts.BuildLabelsFromInstance(r.instance, c.GetModuleName())))
+	return pods.Items, errors.WithStack(err)
+}
+
+// findActiveGate returns the ActiveGate registered for the pod, which uses the pod name as its hostname.
+// Other clusters connected to the same tenant use the same pod names, so if the tenant reports the id seeds of an
+// ActiveGate, they have to match the DT_ID_SEED_* environment of the pod. ActiveGates with matching id seeds are
+// preferred over the ones without, connected ones over disconnected ones.
+func (r *Reconciler) findActiveGate(pod *corev1.Pod, activeGates []dtclient.ActiveGate) *dtclient.ActiveGate {
+	seed := getIDSeed(pod)
+
+	var match *dtclient.ActiveGate
+	for i := range activeGates {
+		activeGate := &activeGates[i]
+		if !activeGate.Containerized || activeGate.Hostname != pod.Name || (activeGate.IDSeed != nil && *activeGate.IDSeed != seed) {
+			continue
+		}
+		if match == nil || rank(activeGate) > rank(match) {
+			match = activeGate
+		}
+	}
+	return match
+}
+
+func rank(activeGate *dtclient.ActiveGate) int {
+	rank := 0
+	if activeGate.IDSeed != nil {
+		rank += 2
+	}
+	if activeGate.IsConnected() {
+		rank++
+	}
+	return rank
+}
+
+// getIDSeed returns the id seeds passed to the ActiveGate container of the pod
+func getIDSeed(pod *corev1.Pod) dtclient.ActiveGateIDSeed {
+	var seed dtclient.ActiveGateIDSeed
+	for _, container := range pod.Spec.Containers {
+		for _, env := range container.Env {
+			switch env.Name {
+			case sts.DTIdSeedNamespace:
+				seed.Namespace = env.Value
+			case sts.DTIdSeedClusterId:
+				seed.KubernetesCluster = env.Value
+			}
+		}
+	}
+	return seed
+}
+
+func buildReplicaStatus(pod *corev1.Pod, c capability.Capability, activeGate *dtclient.ActiveGate) dynatracev1alpha1.ActiveGateReplicaStatus {
+	status := dynatracev1alpha1.ActiveGateReplicaStatus{
+		PodName:         pod.Name,
+		Capability:      c.GetCapabilityName(),
+		ConnectionState: dynatracev1alpha1.ActiveGateNotRegistered,
+	}
+
+	if activeGate == nil {
+		return status
+	}
+
+	status.ID = activeGate.ID
+	status.Version = activeGate.Version
+	status.NetworkZone = activeGate.NetworkZone
+	status.Group = activeGate.Group
+	status.ConnectionState = dynatracev1alpha1.ActiveGateDisconnected
+	if activeGate.IsConnected() {
+		status.ConnectionState = dynatracev1alpha1.ActiveGateConnected
+	}
+
+	for _, module := range requiredModules[c.GetCapabilityName()] {
+		if !activeGate.HasModule(module) {
+			status.MissingModules = append(status.MissingModules, module)
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,177 @@
+package connection
+
+import (
+	"fmt"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/controllers/activegate/capability"
+	sts "github.com/Dynatrace/dynatrace-operator/controllers/activegate/reconciler/statefulset"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	testName      = "test-name"
+	testNamespace = "test-namespace"
+	testUID       = "test-uid"
+	testVersion   = "1.213.0.20210324-052430"
+	testZone      = "test-zone"
+	testGroup     = "test-group"
+)
+
+func buildTestInstance() *dynatracev1alpha1.DynaKube {
+	instance := &dynatracev1alpha1.DynaKube{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testName,
+			Namespace: testNamespace,
+		},
+	}
+	instance.Spec.RoutingSpec.Enabled = true
+	instance.Status.KubeSystemUUID = testUID
+	return instance
+}
+
+func buildTestPod(instance *dynatracev1alpha1.DynaKube, name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+			Labels:    sts.BuildLabelsFromInstance(instance, "routing"),
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Env: []corev1.EnvVar{
+					{Name: sts.DTIdSeedNamespace, Value: testNamespace},
+					{Name: sts.DTIdSeedClusterId, Value: testUID},
+				},
+			}},
+		},
+	}
+}
+
+func buildTestReconciler(instance *dynatracev1alpha1.DynaKube, dtc dtclient.Client, objs ...client.Object) *Reconciler {
+	capabilities := []capability.Capability{
+		capability.NewKubeMonCapability(&instance.Spec.KubernetesMonitoringSpec.CapabilityProperties),
+		capability.NewRoutingCapability(&instance.Spec.RoutingSpec.CapabilityProperties),
+	}
+	return NewReconciler(fake.NewClient(objs...), dtc, logf.Log, instance, capabilities)
+}
+
+func TestReconciler_Reconcile(t *testing.T) {
+	t.Run(`without pods the tenant is not queried`, func(t *testing.T) {
+		instance := buildTestInstance()
+		dtc := &dtclient.MockDynatraceClient{}
+
+		update, err := buildTestReconciler(instance, dtc).Reconcile()
+		require.NoError(t, err)
+		assert.False(t, update)
+		assert.Nil(t, instance.Status.ActiveGate.Replicas)
+		dtc.AssertNotCalled(t, "GetActiveGates", dtclient.ActiveGateQuery{})
+	})
+	t.Run(`matches pods to registered ActiveGates`, func(t *testing.T) {
+		instance := buildTestInstance()
+		instance.Spec.NetworkZone = testZone
+		seed := &dtclient.ActiveGateIDSeed{Namespace: testNamespace, KubernetesCluster: testUID}
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("GetActiveGates", dtclient.ActiveGateQuery{NetworkZone: testZone}).Return([]dtclient.ActiveGate{
+			{ID: "offline", Hostname: "routing-0", Containerized: true, OfflineSince: 1, IDSeed: seed},
+			{
+				ID: "online", Hostname: "routing-0", Containerized: true, Version: testVersion, NetworkZone: testZone, Group: testGroup,
+				Modules: []dtclient.ActiveGateModule{{Type: dtclient.ActiveGateModuleOneAgentRouting, Enabled: true}},
+				IDSeed:  seed,
+			},
+			{ID: "disconnected", Hostname: "routing-1", Containerized: true, OfflineSince: 1, IDSeed: seed},
+			{
+				ID: "other-cluster", Hostname: "routing-1", Containerized: true,
+				IDSeed: &dtclient.ActiveGateIDSeed{Namespace: testNamespace, KubernetesCluster: "other-uid"},
+			},
+			{
+				ID: "other-namespace", Hostname: "routing-2", Containerized: true,
+				IDSeed: &dtclient.ActiveGateIDSeed{Namespace: "other-namespace", KubernetesCluster: testUID},
+			},
+			{ID: "host", Hostname: "routing-2"},
+		}, nil)
+
+		r := buildTestReconciler(instance, dtc,
+			buildTestPod(instance, "routing-0"),
+			buildTestPod(instance, "routing-1"),
+			buildTestPod(instance, "routing-2"))
+		update, err := r.Reconcile()
+		require.NoError(t, err)
+		assert.True(t, update)
+
+		assert.Equal(t, []dynatracev1alpha1.ActiveGateReplicaStatus{
+			{
+				PodName: "routing-0", Capability: "MSGrouter", ID: "online", ConnectionState: dynatracev1alpha1.ActiveGateConnected,
+				Version: testVersion, NetworkZone: testZone, Group: testGroup,
+			},
+			{
+				PodName: "routing-1", Capability: "MSGrouter", ID: "disconnected", ConnectionState: dynatracev1alpha1.ActiveGateDisconnected,
+				MissingModules: []string{dtclient.ActiveGateModuleOneAgentRouting},
+			},
+			{
+				PodName: "routing-2", Capability: "MSGrouter", ConnectionState: dynatracev1alpha1.ActiveGateNotRegistered,
+			},
+		}, instance.Status.ActiveGate.Replicas)
+
+		update, err = r.Reconcile()
+		require.NoError(t, err)
+		assert.False(t, update)
+		dtc.AssertNumberOfCalls(t, "GetActiveGates", 2)
+	})
+	t.Run(`matches by hostname if the tenant doesn't report id seeds`, func(t *testing.T) {
+		instance := buildTestInstance()
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("GetActiveGates", dtclient.ActiveGateQuery{}).Return([]dtclient.ActiveGate{
+			{ID: "disconnected", Hostname: "routing-0", Containerized: true, OfflineSince: 1},
+			{ID: "connected", Hostname: "routing-0", Containerized: true},
+		}, nil)
+
+		_, err := buildTestReconciler(instance, dtc, buildTestPod(instance, "routing-0")).Reconcile()
+		require.NoError(t, err)
+		require.Len(t, instance.Status.ActiveGate.Replicas, 1)
+		assert.Equal(t, "connected", instance.Status.ActiveGate.Replicas[0].ID)
+	})
+	t.Run(`queries the network zone of the capability`, func(t *testing.T) {
+		instance := buildTestInstance()
+		instance.Spec.NetworkZone = testZone
+		instance.Spec.RoutingSpec.StructuredCustomProperties = &dynatracev1alpha1.ActiveGateCustomProperties{
+			Connectivity: &dynatracev1alpha1.ActiveGateConnectivityProperties{NetworkZone: "routing-zone"},
+		}
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("GetActiveGates", dtclient.ActiveGateQuery{NetworkZone: "routing-zone"}).Return([]dtclient.ActiveGate{
+			{ID: "connected", Hostname: "routing-0", Containerized: true, NetworkZone: "routing-zone"},
+		}, nil)
+
+		_, err := buildTestReconciler(instance, dtc, buildTestPod(instance, "routing-0")).Reconcile()
+		require.NoError(t, err)
+		require.Len(t, instance.Status.ActiveGate.Replicas, 1)
+		assert.Equal(t, "routing-zone", instance.Status.ActiveGate.Replicas[0].NetworkZone)
+	})
+	t.Run(`disabled capabilities are removed from the status`, func(t *testing.T) {
+		instance := buildTestInstance()
+		instance.Status.ActiveGate.Replicas = []dynatracev1alpha1.ActiveGateReplicaStatus{{PodName: "routing-0"}}
+		instance.Spec.RoutingSpec.Enabled = false
+
+		update, err := buildTestReconciler(instance, &dtclient.MockDynatraceClient{}, buildTestPod(instance, "routing-0")).Reconcile()
+		require.NoError(t, err)
+		assert.True(t, update)
+		assert.Nil(t, instance.Status.ActiveGate.Replicas)
+	})
+	t.Run(`api errors are returned`, func(t *testing.T) {
+		instance := buildTestInstance()
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("GetActiveGates", dtclient.ActiveGateQuery{}).Return([]dtclient.ActiveGate(nil), fmt.Errorf("missing scope"))
+
+		update, err := buildTestReconciler(instance, dtc, buildTestPod(instance, "routing-0")).Reconcile()
+		assert.Error(t, err)
+		assert.False(t, update)
+	})
+}
@@ -0,0 +1,66 @@
+package statefulset
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// applyPodOverrides merges the pod overrides of the capability onto the generated pod template.
+// It has to run after all other modifications of the StatefulSet, so user defined values take precedence.
+func applyPodOverrides(stsProperties *statefulSetProperties, sts *appsv1.StatefulSet) {
+	overrides := stsProperties.PodOverrides
+	if overrides == nil {
+		return
+	}
+
+	template := &sts.Spec.Template
+	for key, value := range overrides.Annotations {
+		if _, isInternal := template.Annotations[key]; !isInternal {
+			template.Annotations[key] = value
+		}
+	}
+
+	podSpec := &template.Spec
+	if overrides.PodSecurityContext != nil {
+		podSpec.SecurityContext = overrides.PodSecurityContext.DeepCopy()
+	}
+	if overrides.PriorityClassName != "" {
+		podSpec.PriorityClassName = overrides.PriorityClassName
+	}
+	if overrides.DNSPolicy != "" {
+		podSpec.DNSPolicy = overrides.DNSPolicy
+	}
+	if len(overrides.TopologySpreadConstraints) > 0 {
+		podSpec.TopologySpreadConstraints = append([]corev1.TopologySpreadConstraint{}, overrides.TopologySpreadConstraints...)
+	}
+
+	for idx := range podSpec.InitContainers {
+		applyContainerOverrides(stsProperties, &podSpec.InitContainers[idx])
+	}
+
+	for idx := range podSpec.Containers {
+		container := &podSpec.Containers[idx]
+		applyContainerOverrides(stsProperties, container)
+
+		if overrides.ReadinessProbe != nil {
+			container.ReadinessProbe = overrides.ReadinessProbe.DeepCopy()
+		}
+		if overrides.LivenessProbe != nil {
+			container.LivenessProbe = overrides.LivenessProbe.DeepCopy()
+		}
+		if overrides.StartupProbe != nil {
+			container.StartupProbe = overrides.StartupProbe.DeepCopy()
+		}
+	}
+}
+
+func applyContainerOverrides(stsProperties *statefulSetProperties, container *corev1.Container) {
+	overrides := stsProperties.PodOverrides
+
+	if overrides.ImagePullPolicy != "" {
+		container.ImagePullPolicy = overrides.ImagePullPolicy
+	}
+	if overrides.SecurityContext != nil {
+		container.SecurityContext = overrides.SecurityContext.DeepCopy()
+	}
+}
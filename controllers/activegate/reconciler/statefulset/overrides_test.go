@@ -0,0 +1,89 @@
+package statefulset
+
+import (
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestStatefulSet_PodOverrides(t *testing.T) {
+	t.Run(`without overrides`, func(t *testing.T) {
+		instance := buildTestInstance()
+		sts, err := CreateStatefulSet(NewStatefulSetProperties(instance, &instance.Spec.RoutingSpec.CapabilityProperties,
+			"", "", testFeature, "", "", nil, nil, nil))
+		require.NoError(t, err)
+
+		container := sts.Spec.Template.Spec.Containers[0]
+		assert.Equal(t, corev1.PullAlways, container.ImagePullPolicy)
+		assert.NotNil(t, container.ReadinessProbe)
+		assert.Nil(t, container.LivenessProbe)
+		assert.Nil(t, container.StartupProbe)
+		assert.Nil(t, container.SecurityContext)
+		assert.Nil(t, sts.Spec.Template.Spec.SecurityContext)
+		assert.Empty(t, sts.Spec.Template.Spec.PriorityClassName)
+	})
+	t.Run(`with overrides`, func(t *testing.T) {
+		instance := buildTestInstance()
+		runAsNonRoot := true
+		probe := &corev1.Probe{
+			Handler: corev1.Handler{
+				TCPSocket: &corev1.TCPSocketAction{},
+			},
+			InitialDelaySeconds: 10,
+		}
+		instance.Spec.RoutingSpec.PodOverrides = &dynatracev1alpha1.ActiveGatePodOverrides{
+			ReadinessProbe:     probe,
+			LivenessProbe:      probe,
+			StartupProbe:       probe,
+			ImagePullPolicy:    corev1.PullIfNotPresent,
+			SecurityContext:    &corev1.SecurityContext{RunAsNonRoot: &runAsNonRoot},
+			PodSecurityContext: &corev1.PodSecurityContext{RunAsNonRoot: &runAsNonRoot},
+			PriorityClassName:  testName,
+			Annotations: map[string]string{
+				testKey:           testValue,
+				AnnotationVersion: testValue,
+			},
+			DNSPolicy: corev1.DNSClusterFirstWithHostNet,
+			TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+				{MaxSkew: 1, TopologyKey: testKey, WhenUnsatisfiable: corev1.ScheduleAnyway},
+			},
+		}
+		initContainers := []corev1.Container{{Name: testName}}
+		sts, err := CreateStatefulSet(NewStatefulSetProperties(instance, &instance.Spec.RoutingSpec.CapabilityProperties,
+			"", "", testFeature, "", "", initContainers, nil, nil))
+		require.NoError(t, err)
+
+		podSpec := sts.Spec.Template.Spec
+		container := podSpec.Containers[0]
+		assert.Equal(t, corev1.PullIfNotPresent, container.ImagePullPolicy)
+		assert.Equal(t, probe, container.ReadinessProbe)
+		assert.Equal(t, probe, container.LivenessProbe)
+		assert.Equal(t, probe, container.StartupProbe)
+		assert.Equal(t, &runAsNonRoot, container.SecurityContext.RunAsNonRoot)
+		assert.Equal(t, &runAsNonRoot, podSpec.InitContainers[0].SecurityContext.RunAsNonRoot)
+		assert.Equal(t, corev1.PullIfNotPresent, podSpec.InitContainers[0].ImagePullPolicy)
+		assert.Equal(t, &runAsNonRoot, podSpec.SecurityContext.RunAsNonRoot)
+		assert.Equal(t, testName, podSpec.PriorityClassName)
+		assert.Equal(t, corev1.DNSClusterFirstWithHostNet, podSpec.DNSPolicy)
+		assert.Len(t, podSpec.TopologySpreadConstraints, 1)
+		assert.Equal(t, testValue, sts.Spec.Template.Annotations[testKey])
+		assert.Equal(t, instance.Status.ActiveGate.Version, sts.Spec.Template.Annotations[AnnotationVersion])
+	})
+	t.Run(`overrides are covered by the template hash`, func(t *testing.T) {
+		instance := buildTestInstance()
+		stsProperties := NewStatefulSetProperties(instance, &instance.Spec.RoutingSpec.CapabilityProperties,
+			"", "", testFeature, "", "", nil, nil, nil)
+
+		sts, err := CreateStatefulSet(stsProperties)
+		require.NoError(t, err)
+
+		instance.Spec.RoutingSpec.PodOverrides = &dynatracev1alpha1.ActiveGatePodOverrides{PriorityClassName: testName}
+		overriddenSts, err := CreateStatefulSet(stsProperties)
+		require.NoError(t, err)
+
+		assert.True(t, HasStatefulSetChanged(sts, overriddenSts))
+	})
+}
@@ -2,9 +2,8 @@ package statefulset
 
 import (
 	"context"
-	"hash/fnv"
 	"reflect"
-	"strconv"
+	"strings"
 
 	"github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	"github.com/Dynatrace/dynatrace-operator/controllers/activegate/capability"
@@ -70,9 +69,14 @@ func (r *Reconciler) AddOnAfterStatefulSetCreateListener(event events.StatefulSe
 }
 
 func (r *Reconciler) Reconcile() (update bool, err error) {
-	if r.capability.CustomProperties != nil {
+	if r.capability.CustomProperties != nil || r.capability.StructuredCustomProperties != nil {
+		customPropertiesSource := v1alpha1.DynaKubeValueSource{}
+		if r.capability.CustomProperties != nil {
+			customPropertiesSource = *r.capability.CustomProperties
+		}
+
 		err = customproperties.
-			NewReconciler(r, r.Instance, r.log, r.serviceAccountOwner, *r.capability.CustomProperties, r.scheme).
+			NewReconciler(r, r.Instance, r.log, r.serviceAccountOwner, customPropertiesSource, r.capability.StructuredCustomProperties, r.scheme).
 			Reconcile()
 		if err != nil {
 			r.log.Error(err, "could not reconcile custom properties")
@@ -80,12 +84,13 @@ func (r *Reconciler) Reconcile() (update bool, err error) {
 		}
 	}
 
+	previousCustomPropertiesHash := r.Instance.Status.ActiveGate.CustomPropertiesHashes[r.feature]
 	if update, err = r.manageStatefulSet(); err != nil {
 		r.log.Error(err, "could not reconcile stateful set")
 		return false, errors.WithStack(err)
 	}
 
-	return update, nil
+	return update || previousCustomPropertiesHash != r.Instance.Status.ActiveGate.CustomPropertiesHashes[r.feature], nil
 }
 
 func (r *Reconciler) manageStatefulSet() (bool, error) {
@@ -108,12 +113,22 @@ func (r *Reconciler) manageStatefulSet() (bool, error) {
 		return deleted, errors.WithStack(err)
 	}
 
+	deleted, err = r.deleteStatefulSetIfVolumeClaimTemplatesChanged(desiredSts)
+	if deleted || err != nil {
+		return deleted, errors.WithStack(err)
+	}
+
+	expanded, err := r.expandVolumeClaimsIfResized(desiredSts)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
 	updated, err := r.updateStatefulSetIfOutdated(desiredSts)
 	if updated || err != nil {
 		return updated, errors.WithStack(err)
 	}
 
-	return false, nil
+	return expanded, nil
 }
 
 func (r *Reconciler) buildDesiredStatefulSet() (*appsv1.StatefulSet, error) {
@@ -147,6 +162,18 @@ func (r *Reconciler) getStatefulSet(desiredSts *appsv1.StatefulSet) (*appsv1.Sta
 func (r *Reconciler) createStatefulSetIfNotExists(desiredSts *appsv1.StatefulSet) (bool, error) {
 	_, err := r.getStatefulSet(desiredSts)
 	if err != nil && k8serrors.IsNotFound(errors.Cause(err)) {
+		// The StatefulSet would bind the deleted claims again, which would keep its pods pending
+		claims, err := r.getVolumeClaims(desiredSts)
+		if err != nil {
+			return false, err
+		}
+		for _, claim := range claims {
+			if claim.DeletionTimestamp != nil {
+				r.log.Info("waiting for persistent volume claim to be deleted", "name", claim.Name)
+				return true, nil
+			}
+		}
+
 		r.log.Info("creating new stateful set for " + r.feature)
 		return true, r.Create(context.TODO(), desiredSts)
 	}
@@ -162,6 +189,9 @@ func (r *Reconciler) updateStatefulSetIfOutdated(desiredSts *appsv1.StatefulSet)
 		return false, nil
 	}
 
+	// Volume claim templates are immutable, changed sizes are applied by expanding the existing claims
+	desiredSts.Spec.VolumeClaimTemplates = currentSts.Spec.VolumeClaimTemplates
+
 	r.log.Info("updating existing stateful set")
 	if err = r.Update(context.TODO(), desiredSts); err != nil {
 		return false, err
@@ -186,39 +216,135 @@ func (r *Reconciler) deleteStatefulSetIfOldLabelsAreUsed(desiredSts *appsv1.Stat
 	return false, nil
 }
 
+func (r *Reconciler) deleteStatefulSetIfVolumeClaimTemplatesChanged(desiredSts *appsv1.StatefulSet) (bool, error) {
+	currentSts, err := r.getStatefulSet(desiredSts)
+	if err != nil {
+		return false, err
+	}
+
+	if HasVolumeClaimTemplatesChanged(currentSts, desiredSts) {
+		r.log.Info("Deleting existing stateful set since its volume claim templates changed")
+		if err = r.Delete(context.TODO(), desiredSts); err != nil {
+			return false, err
+		}
+
+		// The claims are only created once per pod, the recreated StatefulSet would bind the existing ones again and
+		// removed templates would leave them orphaned
+		claims, err := r.getVolumeClaims(currentSts)
+		if err != nil {
+			return true, err
+		}
+		for i := range claims {
+			r.log.Info("deleting persistent volume claim since its template changed", "name", claims[i].Name)
+			if err = r.Delete(context.TODO(), &claims[i]); err != nil && !k8serrors.IsNotFound(err) {
+				return true, errors.WithStack(err)
+			}
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// getVolumeClaims returns the persistent volume claims created for the volume claim templates of the StatefulSet
+func (r *Reconciler) getVolumeClaims(sts *appsv1.StatefulSet) ([]corev1.PersistentVolumeClaim, error) {
+	if len(sts.Spec.VolumeClaimTemplates) == 0 {
+		return nil, nil
+	}
+
+	var claims corev1.PersistentVolumeClaimList
+	err := r.List(context.TODO(), &claims,
+		client.InNamespace(sts.Namespace),
+		client.MatchingLabels(sts.Spec.Selector.MatchLabels))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var result []corev1.PersistentVolumeClaim
+	for _, claim := range claims.Items {
+		for _, template := range sts.Spec.VolumeClaimTemplates {
+			// Claims created by the StatefulSet controller are named <template>-<statefulset>-<ordinal>
+			if strings.HasPrefix(claim.Name, template.Name+"-"+sts.Name+"-") {
+				result = append(result, claim)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func (r *Reconciler) expandVolumeClaimsIfResized(desiredSts *appsv1.StatefulSet) (bool, error) {
+	if len(desiredSts.Spec.VolumeClaimTemplates) == 0 {
+		return false, nil
+	}
+
+	var claims corev1.PersistentVolumeClaimList
+	err := r.List(context.TODO(), &claims,
+		client.InNamespace(desiredSts.Namespace),
+		client.MatchingLabels(desiredSts.Spec.Selector.MatchLabels))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	expanded := false
+	for _, template := range desiredSts.Spec.VolumeClaimTemplates {
+		desiredSize := template.Spec.Resources.Requests[corev1.ResourceStorage]
+		// Claims created by the StatefulSet controller are named <template>-<statefulset>-<ordinal>
+		prefix := template.Name + "-" + desiredSts.Name + "-"
+
+		for i := range claims.Items {
+			claim := &claims.Items[i]
+			currentSize := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+			if !strings.HasPrefix(claim.Name, prefix) || desiredSize.Cmp(currentSize) <= 0 {
+				continue
+			}
+
+			r.log.Info("expanding persistent volume claim", "name", claim.Name, "from", currentSize.String(), "to", desiredSize.String())
+			if claim.Spec.Resources.Requests == nil {
+				claim.Spec.Resources.Requests = corev1.ResourceList{}
+			}
+			claim.Spec.Resources.Requests[corev1.ResourceStorage] = desiredSize
+			if err = r.Update(context.TODO(), claim); err != nil {
+				return expanded, errors.WithStack(err)
+			}
+			expanded = true
+		}
+	}
+
+	return expanded, nil
+}
+
 func (r *Reconciler) calculateCustomPropertyHash() (string, error) {
-	customProperties := r.capability.CustomProperties
-	if customProperties == nil || (customProperties.Value == "" && customProperties.ValueFrom == "") {
+	if isCustomPropertiesNilOrEmpty(r.capability.CustomProperties, r.capability.StructuredCustomProperties) {
+		r.setCustomPropertiesHashStatus("")
 		return "", nil
 	}
 
-	data, err := r.getDataFromCustomProperty(customProperties)
+	data, err := customproperties.GetData(r, r.Instance.Namespace, r.capability.CustomProperties, r.capability.StructuredCustomProperties)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
 
-	hash := fnv.New32()
-	if _, err = hash.Write([]byte(data)); err != nil {
+	hash, err := customproperties.Hash(data)
+	if err != nil {
 		return "", errors.WithStack(err)
 	}
 
-	return strconv.FormatUint(uint64(hash.Sum32()), 10), nil
+	r.setCustomPropertiesHashStatus(hash)
+	return hash, nil
 }
 
-func (r *Reconciler) getDataFromCustomProperty(customProperties *v1alpha1.DynaKubeValueSource) (string, error) {
-	if customProperties.ValueFrom != "" {
-		namespace := r.Instance.Namespace
-		var secret corev1.Secret
-		err := r.Get(context.TODO(), client.ObjectKey{Name: customProperties.ValueFrom, Namespace: namespace}, &secret)
-		if err != nil {
-			return "", errors.WithStack(err)
-		}
+// setCustomPropertiesHashStatus exposes the hash of the rendered custom properties, so configuration drift is visible
+func (r *Reconciler) setCustomPropertiesHashStatus(hash string) {
+	hashes := r.Instance.Status.ActiveGate.CustomPropertiesHashes
+	if hash == "" {
+		delete(hashes, r.feature)
+		return
+	}
 
-		dataBytes, ok := secret.Data[customproperties.DataKey]
-		if !ok {
-			return "", errors.Errorf("no custom properties found on secret '%s' on namespace '%s'", customProperties.ValueFrom, namespace)
-		}
-		return string(dataBytes), nil
+	if hashes == nil {
+		hashes = map[string]string{}
+		r.Instance.Status.ActiveGate.CustomPropertiesHashes = hashes
 	}
-	return customProperties.Value, nil
+	hashes[r.feature] = hash
 }
@@ -17,6 +17,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -254,3 +255,157 @@ func TestReconcile_GetCustomPropertyHash(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, hash)
 }
+
+func TestReconcile_GetCustomPropertyHash_Structured(t *testing.T) {
+	r := createDefaultReconciler(t)
+	r.Instance.Spec.RoutingSpec.CustomProperties = &dynatracev1alpha1.DynaKubeValueSource{Value: "[collector]\ngroup=old\n"}
+	rawHash, err := r.calculateCustomPropertyHash()
+	require.NoError(t, err)
+	assert.Equal(t, rawHash, r.Instance.Status.ActiveGate.CustomPropertiesHashes[r.feature])
+
+	r.Instance.Spec.RoutingSpec.StructuredCustomProperties = &dynatracev1alpha1.ActiveGateCustomProperties{
+		Collector: &dynatracev1alpha1.ActiveGateCollectorProperties{Group: testValue},
+	}
+	structuredHash, err := r.calculateCustomPropertyHash()
+	require.NoError(t, err)
+	assert.NotEmpty(t, structuredHash)
+	assert.NotEqual(t, rawHash, structuredHash)
+	assert.Equal(t, structuredHash, r.Instance.Status.ActiveGate.CustomPropertiesHashes[r.feature])
+
+	r.Instance.Spec.RoutingSpec.StructuredCustomProperties.Sections = map[string]map[string]string{
+		"invalid]": {testKey: testValue},
+	}
+	_, err = r.calculateCustomPropertyHash()
+	assert.Error(t, err)
+
+	r.Instance.Spec.RoutingSpec.CustomProperties = nil
+	r.Instance.Spec.RoutingSpec.StructuredCustomProperties = nil
+	hash, err := r.calculateCustomPropertyHash()
+	require.NoError(t, err)
+	assert.Empty(t, hash)
+	assert.NotContains(t, r.Instance.Status.ActiveGate.CustomPropertiesHashes, r.feature)
+}
+
+func TestReconcile_ExpandVolumeClaimsIfResized(t *testing.T) {
+	r := createDefaultReconciler(t)
+	r.Instance.Spec.RoutingSpec.Storage = &dynatracev1alpha1.ActiveGateStorageSpec{}
+
+	desiredSts, err := r.buildDesiredStatefulSet()
+	require.NoError(t, err)
+
+	created, err := r.createStatefulSetIfNotExists(desiredSts)
+	require.True(t, created)
+	require.NoError(t, err)
+
+	claimName := StorageVolumeName + "-" + desiredSts.Name + "-0"
+	err = r.Create(context.TODO(), &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claimName,
+			Namespace: desiredSts.Namespace,
+			Labels:    desiredSts.Spec.Selector.MatchLabels,
+		},
+		Spec: desiredSts.Spec.VolumeClaimTemplates[0].Spec,
+	})
+	require.NoError(t, err)
+
+	expanded, err := r.expandVolumeClaimsIfResized(desiredSts)
+	assert.NoError(t, err)
+	assert.False(t, expanded)
+
+	size := resource.MustParse("10Gi")
+	r.Instance.Spec.RoutingSpec.Storage.Size = &size
+	desiredSts, err = r.buildDesiredStatefulSet()
+	require.NoError(t, err)
+
+	expanded, err = r.expandVolumeClaimsIfResized(desiredSts)
+	assert.NoError(t, err)
+	assert.True(t, expanded)
+
+	var claim corev1.PersistentVolumeClaim
+	err = r.Get(context.TODO(), client.ObjectKey{Name: claimName, Namespace: desiredSts.Namespace}, &claim)
+	require.NoError(t, err)
+
+	claimSize := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+	assert.True(t, size.Equal(claimSize))
+
+	updated, err := r.updateStatefulSetIfOutdated(desiredSts)
+	assert.NoError(t, err)
+	assert.True(t, updated)
+
+	var sts appsv1.StatefulSet
+	err = r.Get(context.TODO(), client.ObjectKey{Name: desiredSts.Name, Namespace: desiredSts.Namespace}, &sts)
+	require.NoError(t, err)
+
+	templateSize := sts.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[corev1.ResourceStorage]
+	assert.True(t, resource.MustParse(defaultStorageSize).Equal(templateSize))
+}
+
+func TestReconcile_DeleteStatefulSetIfVolumeClaimTemplatesChanged(t *testing.T) {
+	r := createDefaultReconciler(t)
+	desiredSts, err := r.buildDesiredStatefulSet()
+	require.NoError(t, err)
+
+	created, err := r.createStatefulSetIfNotExists(desiredSts)
+	require.True(t, created)
+	require.NoError(t, err)
+
+	deleted, err := r.deleteStatefulSetIfVolumeClaimTemplatesChanged(desiredSts)
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+
+	r.Instance.Spec.RoutingSpec.Storage = &dynatracev1alpha1.ActiveGateStorageSpec{}
+	desiredSts, err = r.buildDesiredStatefulSet()
+	require.NoError(t, err)
+
+	deleted, err = r.deleteStatefulSetIfVolumeClaimTemplatesChanged(desiredSts)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+}
+
+func TestReconcile_DeleteVolumeClaimsIfTemplatesChanged(t *testing.T) {
+	r := createDefaultReconciler(t)
+	r.Instance.Spec.RoutingSpec.Storage = &dynatracev1alpha1.ActiveGateStorageSpec{}
+	desiredSts, err := r.buildDesiredStatefulSet()
+	require.NoError(t, err)
+
+	created, err := r.createStatefulSetIfNotExists(desiredSts)
+	require.True(t, created)
+	require.NoError(t, err)
+
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      StorageVolumeName + "-" + desiredSts.Name + "-0",
+			Namespace: desiredSts.Namespace,
+			Labels:    desiredSts.Spec.Selector.MatchLabels,
+		},
+		Spec: desiredSts.Spec.VolumeClaimTemplates[0].Spec,
+	}
+	require.NoError(t, r.Create(context.TODO(), claim.DeepCopy()))
+
+	storageClass := testName
+	r.Instance.Spec.RoutingSpec.Storage.StorageClassName = &storageClass
+	desiredSts, err = r.buildDesiredStatefulSet()
+	require.NoError(t, err)
+
+	deleted, err := r.deleteStatefulSetIfVolumeClaimTemplatesChanged(desiredSts)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+
+	err = r.Get(context.TODO(), client.ObjectKey{Name: claim.Name, Namespace: claim.Namespace}, &corev1.PersistentVolumeClaim{})
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	t.Run(`waits for deleted claims before recreating the stateful set`, func(t *testing.T) {
+		now := metav1.Now()
+		terminating := claim.DeepCopy()
+		terminating.DeletionTimestamp = &now
+		terminating.Finalizers = []string{"kubernetes.io/pvc-protection"}
+		require.NoError(t, r.Create(context.TODO(), terminating))
+
+		created, err := r.createStatefulSetIfNotExists(desiredSts)
+		assert.NoError(t, err)
+		assert.True(t, created)
+
+		_, err = r.getStatefulSet(desiredSts)
+		assert.True(t, k8serrors.IsNotFound(errors.Cause(err)))
+	})
+}
@@ -103,12 +103,15 @@ func CreateStatefulSet(stsProperties *statefulSetProperties) (*appsv1.StatefulSe
 				},
 				Spec: buildTemplateSpec(stsProperties),
 			},
+			VolumeClaimTemplates: buildVolumeClaimTemplates(stsProperties),
 		}}
 
 	for _, onAfterCreateListener := range stsProperties.OnAfterCreateListener {
 		onAfterCreateListener(sts)
 	}
 
+	applyPodOverrides(stsProperties, sts)
+
 	hash, err := generateStatefulSetHash(sts)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -176,7 +179,7 @@ func buildContainer(stsProperties *statefulSetProperties) corev1.Container {
 func buildVolumes(stsProperties *statefulSetProperties) []corev1.Volume {
 	var volumes []corev1.Volume
 
-	if !isCustomPropertiesNilOrEmpty(stsProperties.CustomProperties) {
+	if !isCustomPropertiesNilOrEmpty(stsProperties.CustomProperties, stsProperties.StructuredCustomProperties) {
 		valueFrom := determineCustomPropertiesSource(stsProperties)
 		volumes = append(volumes, corev1.Volume{
 			Name: customproperties.VolumeName,
@@ -195,7 +198,9 @@ func buildVolumes(stsProperties *statefulSetProperties) []corev1.Volume {
 }
 
 func determineCustomPropertiesSource(stsProperties *statefulSetProperties) string {
-	if stsProperties.CustomProperties.ValueFrom == "" {
+	// Structured properties are merged into the secret managed by the operator
+	if stsProperties.CustomProperties == nil || stsProperties.CustomProperties.ValueFrom == "" ||
+		stsProperties.StructuredCustomProperties != nil {
 		return fmt.Sprintf("%s-%s-%s", stsProperties.Name, stsProperties.serviceAccountOwner, customproperties.Suffix)
 	}
 	return stsProperties.CustomProperties.ValueFrom
@@ -204,7 +209,7 @@ func determineCustomPropertiesSource(stsProperties *statefulSetProperties) strin
 func buildVolumeMounts(stsProperties *statefulSetProperties) []corev1.VolumeMount {
 	var volumeMounts []corev1.VolumeMount
 
-	if !isCustomPropertiesNilOrEmpty(stsProperties.CustomProperties) {
+	if !isCustomPropertiesNilOrEmpty(stsProperties.CustomProperties, stsProperties.StructuredCustomProperties) {
 		volumeMounts = append(volumeMounts, corev1.VolumeMount{
 			ReadOnly:  true,
 			Name:      customproperties.VolumeName,
@@ -214,6 +219,7 @@ func buildVolumeMounts(stsProperties *statefulSetProperties) []corev1.VolumeMoun
 	}
 
 	volumeMounts = append(volumeMounts, stsProperties.containerVolumeMounts...)
+	volumeMounts = append(volumeMounts, buildStorageVolumeMounts(stsProperties)...)
 
 	return volumeMounts
 }
@@ -283,10 +289,11 @@ func buildKubernetesExpression(archKey string, osKey string) []corev1.NodeSelect
 	}
 }
 
-func isCustomPropertiesNilOrEmpty(customProperties *dynatracev1alpha1.DynaKubeValueSource) bool {
-	return customProperties == nil ||
-		(customProperties.Value == "" &&
-			customProperties.ValueFrom == "")
+func isCustomPropertiesNilOrEmpty(customProperties *dynatracev1alpha1.DynaKubeValueSource, structuredProperties *dynatracev1alpha1.ActiveGateCustomProperties) bool {
+	return structuredProperties == nil &&
+		(customProperties == nil ||
+			(customProperties.Value == "" &&
+				customProperties.ValueFrom == ""))
 }
 
 func isProxyNilOrEmpty(proxy *dynatracev1alpha1.DynaKubeProxy) bool {
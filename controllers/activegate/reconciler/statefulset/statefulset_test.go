@@ -190,6 +190,28 @@ func TestStatefulSet_Volumes(t *testing.T) {
 			{Key: customproperties.DataKey, Path: customproperties.DataPath},
 		}, customPropertiesVolume.Secret.Items)
 	})
+	t.Run(`structured custom properties use the operator managed secret`, func(t *testing.T) {
+		capabilityProperties.CustomProperties = &dynatracev1alpha1.DynaKubeValueSource{
+			ValueFrom: testKey,
+		}
+		capabilityProperties.StructuredCustomProperties = &dynatracev1alpha1.ActiveGateCustomProperties{
+			Connectivity: &dynatracev1alpha1.ActiveGateConnectivityProperties{NetworkZone: testValue},
+		}
+		volumes := buildVolumes(NewStatefulSetProperties(instance, capabilityProperties,
+			"", "", testFeature, "", "", nil, nil, nil))
+		expectedSecretName := instance.Name + "-router-" + customproperties.Suffix
+
+		require.NotEmpty(t, volumes)
+		assert.Equal(t, expectedSecretName, volumes[0].Secret.SecretName)
+
+		capabilityProperties.CustomProperties = nil
+		volumes = buildVolumes(NewStatefulSetProperties(instance, capabilityProperties,
+			"", "", testFeature, "", "", nil, nil, nil))
+
+		require.NotEmpty(t, volumes)
+		assert.Equal(t, expectedSecretName, volumes[0].Secret.SecretName)
+		capabilityProperties.StructuredCustomProperties = nil
+	})
 }
 
 func TestStatefulSet_Env(t *testing.T) {
@@ -0,0 +1,92 @@
+package statefulset
+
+import (
+	"reflect"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	StorageVolumeName = "activegate-storage"
+
+	gatewayTempPath = "/var/lib/dynatrace/gateway/temp"
+	storageTempDir  = "temp"
+
+	defaultStorageSize = "1Gi"
+)
+
+func buildVolumeClaimTemplates(stsProperties *statefulSetProperties) []corev1.PersistentVolumeClaim {
+	storage := stsProperties.Storage
+	if storage == nil {
+		return nil
+	}
+
+	accessMode := storage.AccessMode
+	if accessMode == "" {
+		accessMode = corev1.ReadWriteOnce
+	}
+
+	return []corev1.PersistentVolumeClaim{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   StorageVolumeName,
+				Labels: BuildLabelsFromInstance(stsProperties.DynaKube, stsProperties.feature),
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      []corev1.PersistentVolumeAccessMode{accessMode},
+				StorageClassName: storage.StorageClassName,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceStorage: determineStorageSize(storage),
+					},
+				},
+			},
+		},
+	}
+}
+
+func determineStorageSize(storage *dynatracev1alpha1.ActiveGateStorageSpec) resource.Quantity {
+	if storage.Size == nil || storage.Size.IsZero() {
+		return resource.MustParse(defaultStorageSize)
+	}
+	return *storage.Size
+}
+
+func buildStorageVolumeMounts(stsProperties *statefulSetProperties) []corev1.VolumeMount {
+	if stsProperties.Storage == nil {
+		return nil
+	}
+
+	return []corev1.VolumeMount{
+		{
+			Name:      StorageVolumeName,
+			MountPath: gatewayTempPath,
+			SubPath:   storageTempDir,
+		},
+	}
+}
+
+// HasVolumeClaimTemplatesChanged checks if the immutable parts of the volume claim templates differ.
+// Storage sizes are ignored, since they are handled by expanding the existing claims.
+func HasVolumeClaimTemplatesChanged(a *appsv1.StatefulSet, b *appsv1.StatefulSet) bool {
+	if len(a.Spec.VolumeClaimTemplates) != len(b.Spec.VolumeClaimTemplates) {
+		return true
+	}
+
+	for i := range a.Spec.VolumeClaimTemplates {
+		templateA := a.Spec.VolumeClaimTemplates[i]
+		templateB := b.Spec.VolumeClaimTemplates[i]
+
+		if templateA.Name != templateB.Name ||
+			!reflect.DeepEqual(templateA.Spec.AccessModes, templateB.Spec.AccessModes) ||
+			!reflect.DeepEqual(templateA.Spec.StorageClassName, templateB.Spec.StorageClassName) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,107 @@
+package statefulset
+
+import (
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestStatefulSet_VolumeClaimTemplates(t *testing.T) {
+	t.Run(`without storage`, func(t *testing.T) {
+		instance := buildTestInstance()
+		sts, err := CreateStatefulSet(NewStatefulSetProperties(instance, &instance.Spec.RoutingSpec.CapabilityProperties,
+			"", "", testFeature, "", "", nil, nil, nil))
+
+		require.NoError(t, err)
+		assert.Empty(t, sts.Spec.VolumeClaimTemplates)
+		assert.Empty(t, sts.Spec.Template.Spec.Containers[0].VolumeMounts)
+	})
+	t.Run(`with default storage`, func(t *testing.T) {
+		instance := buildTestInstance()
+		instance.Spec.RoutingSpec.Storage = &dynatracev1alpha1.ActiveGateStorageSpec{}
+		sts, err := CreateStatefulSet(NewStatefulSetProperties(instance, &instance.Spec.RoutingSpec.CapabilityProperties,
+			"", "", testFeature, "", "", nil, nil, nil))
+
+		require.NoError(t, err)
+		require.Len(t, sts.Spec.VolumeClaimTemplates, 1)
+
+		template := sts.Spec.VolumeClaimTemplates[0]
+		assert.Equal(t, StorageVolumeName, template.Name)
+		assert.Equal(t, BuildLabelsFromInstance(instance, testFeature), template.Labels)
+		assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, template.Spec.AccessModes)
+		assert.Nil(t, template.Spec.StorageClassName)
+
+		size := template.Spec.Resources.Requests[corev1.ResourceStorage]
+		assert.True(t, resource.MustParse(defaultStorageSize).Equal(size))
+
+		assert.Contains(t, sts.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      StorageVolumeName,
+			MountPath: gatewayTempPath,
+			SubPath:   storageTempDir,
+		})
+		for _, mount := range sts.Spec.Template.Spec.Containers[0].VolumeMounts {
+			assert.False(t, mount.Name == StorageVolumeName && mount.MountPath != gatewayTempPath,
+				"storage must not hide the configuration of the image at %s", mount.MountPath)
+		}
+	})
+	t.Run(`with custom storage`, func(t *testing.T) {
+		instance := buildTestInstance()
+		storageClass := testName
+		size := resource.MustParse("5Gi")
+		instance.Spec.RoutingSpec.Storage = &dynatracev1alpha1.ActiveGateStorageSpec{
+			StorageClassName: &storageClass,
+			Size:             &size,
+			AccessMode:       corev1.ReadWriteMany,
+		}
+		sts, err := CreateStatefulSet(NewStatefulSetProperties(instance, &instance.Spec.RoutingSpec.CapabilityProperties,
+			"", "", testFeature, "", "", nil, nil, nil))
+
+		require.NoError(t, err)
+		require.Len(t, sts.Spec.VolumeClaimTemplates, 1)
+
+		template := sts.Spec.VolumeClaimTemplates[0]
+		assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, template.Spec.AccessModes)
+		assert.Equal(t, &storageClass, template.Spec.StorageClassName)
+
+		requestedSize := template.Spec.Resources.Requests[corev1.ResourceStorage]
+		assert.True(t, size.Equal(requestedSize))
+	})
+}
+
+func TestHasVolumeClaimTemplatesChanged(t *testing.T) {
+	instance := buildTestInstance()
+	instance.Spec.RoutingSpec.Storage = &dynatracev1alpha1.ActiveGateStorageSpec{}
+	stsProperties := NewStatefulSetProperties(instance, &instance.Spec.RoutingSpec.CapabilityProperties,
+		"", "", testFeature, "", "", nil, nil, nil)
+
+	current, err := CreateStatefulSet(stsProperties)
+	require.NoError(t, err)
+
+	t.Run(`size changes are ignored`, func(t *testing.T) {
+		size := resource.MustParse("10Gi")
+		instance.Spec.RoutingSpec.Storage.Size = &size
+		desired, err := CreateStatefulSet(stsProperties)
+		require.NoError(t, err)
+
+		assert.False(t, HasVolumeClaimTemplatesChanged(current, desired))
+	})
+	t.Run(`storage class changes are detected`, func(t *testing.T) {
+		storageClass := testName
+		instance.Spec.RoutingSpec.Storage.StorageClassName = &storageClass
+		desired, err := CreateStatefulSet(stsProperties)
+		require.NoError(t, err)
+
+		assert.True(t, HasVolumeClaimTemplatesChanged(current, desired))
+	})
+	t.Run(`removed storage is detected`, func(t *testing.T) {
+		instance.Spec.RoutingSpec.Storage = nil
+		desired, err := CreateStatefulSet(stsProperties)
+		require.NoError(t, err)
+
+		assert.True(t, HasVolumeClaimTemplatesChanged(current, desired))
+	})
+}
@@ -16,18 +16,147 @@ limitations under the License.
 
 package dtcsi
 
-import "time"
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+)
 
 const (
-	DataPath              = "/data"
-	DriverName            = "csi.oneagent.dynatrace.com"
-	GarbageCollectionPath = "gc"
-	VersionDir            = "version"
+	DataPath   = "/data"
+	DriverName = "csi.oneagent.dynatrace.com"
+	StagingDir = "staging"
+
+	// TopologyKeyNode is the topology key of the node the CSI driver is running on
+	TopologyKeyNode = "topology." + DriverName + "/node"
+
+	// NodeNotReadyTaintKey is the key of the taint which keeps workloads off a node until the OneAgent has been
+	// provisioned on it for all DynaKubes using the CSI driver. Nodes have to be registered with the taint, e.g. by the
+	// kubelet flag --register-with-taints=csi.oneagent.dynatrace.com/not-ready:NoSchedule, the driver only removes it.
+	NodeNotReadyTaintKey = DriverName + "/not-ready"
+
+	// FlavorVolumeAttribute is the volume attribute selecting the flavor of the mounted OneAgent package
+	FlavorVolumeAttribute = "flavor"
+	// VersionVolumeAttribute is the volume attribute selecting the version of the mounted OneAgent package, the version
+	// provisioned for the tenant is mounted if not set
+	VersionVolumeAttribute = "version"
+	// QuotaVolumeAttribute is the volume attribute limiting the size of what the pod writes to the volume, e.g. 100Mi.
+	// The volume is made read-only once the limit is exceeded. Unlimited if not set.
+	QuotaVolumeAttribute = "quota"
+	// TypeVolumeAttribute is the volume attribute selecting the artifact mounted into the volume, one of the volume types
+	TypeVolumeAttribute = "type"
+	// DynaKubeVolumeAttribute is the volume attribute selecting the DynaKube of the volume by name, instead of the
+	// DynaKube assigned to the namespace of the pod
+	DynaKubeVolumeAttribute = "dynakube"
+
+	// CodeModulesVolumeType mounts the OneAgent package for code modules, which is the default
+	CodeModulesVolumeType = "code-modules"
+	// HostAgentVolumeType mounts the installer of the full-stack host agent read-only, e.g. for OneAgent DaemonSet pods
+	HostAgentVolumeType = "host-agent"
+
+	// HostAgentInstaller is the file name of the installer of the full-stack host agent in its directory
+	HostAgentInstaller = "oneagent.sh"
+
+	hostAgentSuffix = "host"
 )
 
+// versionPattern matches OneAgent versions, the version of codeModules in the DynaKube CRD is validated with the same
+// pattern
+var versionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+(\.[0-9]+(-[0-9]+)?)?$`)
+
 type CSIOptions struct {
 	NodeID     string
 	Endpoint   string
 	RootDir    string
 	GCInterval time.Duration
+	GCPolicy   GCPolicy
+	// PublishTimeout is how long a volume is retried while the OneAgent is still being provisioned for its DynaKube
+	PublishTimeout time.Duration
+	// ProvisioningTimeout is how long the driver waits for the OneAgent to be provisioned for all DynaKubes after its
+	// start, before it reports readiness and removes the not-ready taint from its node anyway. Disabled if not set.
+	ProvisioningTimeout time.Duration
+	// RequireChecksums rejects OneAgent packages the deployment API doesn't provide a SHA-256 checksum for, instead of
+	// only verifying them by the checksums of the archive
+	RequireChecksums bool
+	Distribution     DistributionOptions
+}
+
+// DistributionOptions configures the in-cluster distribution of OneAgent packages. The CSI driver pods elect one of
+// them, which downloads and verifies each package once and serves it to the others.
+type DistributionOptions struct {
+	Enabled bool
+	// Address is the address of this pod, where it serves the packages if it has been elected
+	Address string
+	// Namespace is the namespace of the CSI driver and its DynaKubes, where the distributing pod is elected
+	Namespace string
+	// ServiceAccount is the ServiceAccount of the CSI driver pods, the distributing pod only serves requests
+	// authenticated with a token of it
+	ServiceAccount string
+}
+
+// GCPolicy configures which unused OneAgent versions and logs are removed by the garbage collector
+type GCPolicy struct {
+	// KeepVersions is the number of most recent versions which are kept even if unused
+	KeepVersions int
+	// MaxVersionAge is the age after which unused versions are removed, they are removed immediately if not set
+	MaxVersionAge time.Duration
+	// MaxLogAge is the age after which the logs of unpublished volumes are removed
+	MaxLogAge time.Duration
+	// DiskHighWatermark is the disk usage of the data directory in percent which triggers an immediate collection of
+	// unused versions and logs, oldest first, until the usage is below it again. Disabled if not set.
+	DiskHighWatermark int
+	// DryRun only logs what would be removed
+	DryRun bool
+}
+
+// AgentBinaryDir returns the directory of the OneAgent package for the given version and flavor.
+// The multidistro flavor is kept in the version directory, other flavors get a directory with the flavor as suffix.
+func AgentBinaryDir(envDir string, version string, flavor string) string {
+	if flavor == "" || flavor == dtclient.FlavorMultidistro {
+		return filepath.Join(envDir, "bin", version)
+	}
+	return filepath.Join(envDir, "bin", version+"-"+flavor)
+}
+
+// HostAgentDir returns the directory of the installer of the full-stack host agent for the given version, which is kept
+// next to the OneAgent packages, so it's covered by the garbage collection
+func HostAgentDir(envDir string, version string) string {
+	return filepath.Join(envDir, "bin", version+"-"+hostAgentSuffix)
+}
+
+// IsValidVolumeType checks if the type is one of the volume types, an empty type selects code modules
+func IsValidVolumeType(volumeType string) bool {
+	switch volumeType {
+	case "", CodeModulesVolumeType, HostAgentVolumeType:
+		return true
+	}
+	return false
+}
+
+// IsValidFlavor checks if the flavor is one of the flavors of the OneAgent package
+func IsValidFlavor(flavor string) bool {
+	switch flavor {
+	case dtclient.FlavorDefault, dtclient.FlavorMUSL, dtclient.FlavorMultidistro:
+		return true
+	}
+	return false
+}
+
+// IsValidVersion checks if the version is a OneAgent version, e.g. 1.203.0 or 1.203.0.20201029-151112
+func IsValidVersion(version string) bool {
+	return versionPattern.MatchString(version)
+}
+
+// ParseAgentBinaryDir returns the version and flavor of a directory created by AgentBinaryDir, the flavor of a directory
+// created by HostAgentDir is "host"
+func ParseAgentBinaryDir(name string) (string, string) {
+	for _, flavor := range []string{dtclient.FlavorDefault, dtclient.FlavorMUSL, hostAgentSuffix} {
+		if strings.HasSuffix(name, "-"+flavor) {
+			return strings.TrimSuffix(name, "-"+flavor), flavor
+		}
+	}
+	return name, dtclient.FlavorMultidistro
 }
@@ -0,0 +1,26 @@
+package dtcsi
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAgentBinaryDir(t *testing.T) {
+	version, flavor := ParseAgentBinaryDir("1.203.0.20201029-151112")
+	assert.Equal(t, "1.203.0.20201029-151112", version)
+	assert.Equal(t, "multidistro", flavor)
+
+	version, flavor = ParseAgentBinaryDir("1.203.0.20201029-151112-musl")
+	assert.Equal(t, "1.203.0.20201029-151112", version)
+	assert.Equal(t, "musl", flavor)
+
+	version, flavor = ParseAgentBinaryDir("1.203.0-default")
+	assert.Equal(t, "1.203.0", version)
+	assert.Equal(t, "default", flavor)
+
+	version, flavor = ParseAgentBinaryDir(filepath.Base(HostAgentDir("/data/tenant", "1.203.0")))
+	assert.Equal(t, "1.203.0", version)
+	assert.Equal(t, "host", flavor)
+}
@@ -0,0 +1,97 @@
+package distribution
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const downloadTimeout = 10 * time.Minute
+
+// Client fetches OneAgent packages from the CSI driver pod elected for distributing them
+type Client struct {
+	apiReader  client.Reader
+	namespace  string
+	httpClient *http.Client
+	// tokenPath is the path of the ServiceAccount token the client authenticates with, it's read for every request
+	// since the kubelet rotates it
+	tokenPath string
+}
+
+// NewClient returns a new Client
+func NewClient(apiReader client.Reader, namespace string) *Client {
+	return &Client{
+		apiReader:  apiReader,
+		namespace:  namespace,
+		httpClient: &http.Client{Timeout: downloadTimeout},
+		tokenPath:  TokenPath,
+	}
+}
+
+// GetAgent writes the OneAgent package of the tenant of the DynaKube to the writer and returns the SHA-256 checksum
+// the distributing pod verified it with. The package is served over plain HTTP, callers have to verify it with the
+// checksum of the tenant themselves. Requests are authenticated with the token of the TokenAudience.
+func (c *Client) GetAgent(ctx context.Context, dynakube, flavor, arch, version string, writer io.Writer) (string, error) {
+	address, err := c.getDistributor(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := ioutil.ReadFile(c.tokenPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read distribution token: %w", err)
+	}
+
+	key := packageKey{dynakube: dynakube, flavor: flavor, arch: arch, version: version}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+key.path(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request OneAgent package from %s: %w", address, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("distributing pod %s responded with %s: %s", address, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	checksum := resp.Header.Get(ChecksumHeader)
+	if checksum == "" {
+		return "", fmt.Errorf("distributing pod %s responded without checksum", address)
+	}
+
+	if _, err := io.Copy(writer, resp.Body); err != nil {
+		return "", fmt.Errorf("failed to download OneAgent package from %s: %w", address, err)
+	}
+	return checksum, nil
+}
+
+// getDistributor returns the address of the elected pod, which is the holder of the lease as long as it renews it
+func (c *Client) getDistributor(ctx context.Context) (string, error) {
+	var lease coordinationv1.Lease
+	if err := c.apiReader.Get(ctx, client.ObjectKey{Name: LeaseName, Namespace: c.namespace}, &lease); err != nil {
+		return "", fmt.Errorf("failed to query distribution lease: %w", err)
+	}
+
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" {
+		return "", fmt.Errorf("no pod has been elected for distributing OneAgent packages")
+	}
+	if spec.RenewTime != nil && spec.LeaseDurationSeconds != nil &&
+		time.Since(spec.RenewTime.Time) > time.Duration(*spec.LeaseDurationSeconds)*time.Second {
+		return "", fmt.Errorf("distribution lease of %s has expired", *spec.HolderIdentity)
+	}
+	return *spec.HolderIdentity, nil
+}
@@ -0,0 +1,83 @@
+package distribution
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const namespace = "dynatrace"
+
+func newLease(holder string, renewTime time.Time) *coordinationv1.Lease {
+	duration := int32(leaseDuration.Seconds())
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: LeaseName, Namespace: namespace},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			RenewTime:            &metav1.MicroTime{Time: renewTime},
+		},
+	}
+}
+
+func TestClient_GetAgent(t *testing.T) {
+	dtc := &dtclient.MockDynatraceClient{}
+	mockPackage(dtc, testChecksum())
+	srv := newTestServer(dtc)
+	httpServer := httptest.NewServer(srv)
+	defer httpServer.Close()
+	address := strings.TrimPrefix(httpServer.URL, "http://")
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(tokenPath, []byte(testToken+"\n"), 0600))
+
+	getAgent := func(objs ...client.Object) (string, string, error) {
+		var buffer bytes.Buffer
+		c := NewClient(fake.NewClient(objs...), namespace)
+		c.tokenPath = tokenPath
+		checksum, err := c.GetAgent(context.TODO(), testDynakube, dtclient.FlavorMultidistro, dtclient.ArchX86, testVersion, &buffer)
+		return buffer.String(), checksum, err
+	}
+
+	t.Run(`downloads from elected pod`, func(t *testing.T) {
+		content, checksum, err := getAgent(newLease(address, time.Now()))
+
+		require.NoError(t, err)
+		assert.Equal(t, testContent, content)
+		assert.Equal(t, testChecksum(), checksum)
+	})
+	t.Run(`fails without lease`, func(t *testing.T) {
+		_, _, err := getAgent()
+
+		assert.Error(t, err)
+	})
+	t.Run(`fails with expired lease`, func(t *testing.T) {
+		_, _, err := getAgent(newLease(address, time.Now().Add(-time.Hour)))
+
+		assert.EqualError(t, err, "distribution lease of "+address+" has expired")
+	})
+	t.Run(`fails if elected pod does not serve`, func(t *testing.T) {
+		atomic.StoreInt32(&srv.leading, 0)
+		defer atomic.StoreInt32(&srv.leading, 1)
+
+		_, _, err := getAgent(newLease(address, time.Now()))
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), http.StatusText(http.StatusServiceUnavailable))
+	})
+}
@@ -0,0 +1,66 @@
+package distribution
+
+import (
+	"fmt"
+	"strings"
+
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// LeaseName is the name of the lease electing the CSI driver pod which distributes the OneAgent packages, its
+	// holder identity is the address the packages are served at
+	LeaseName = dtcsi.DriverName + "-distribution"
+
+	// ChecksumHeader holds the SHA-256 checksum of a served OneAgent package
+	ChecksumHeader = "X-Dynatrace-Checksum-Sha256"
+
+	// TokenAudience is the audience of the ServiceAccount tokens the CSI driver pods authenticate with at the
+	// distributing pod. Tokens of this audience are rejected by the Kubernetes API server, so they are of no use to
+	// others even though the packages are served over plain HTTP.
+	TokenAudience = "oneagent-distribution.dynatrace.com"
+	// TokenPath is the path of the projected ServiceAccount token of the TokenAudience
+	TokenPath = "/var/run/secrets/dynatrace.com/distribution/token"
+
+	packagesPath = "/v1/agents/"
+)
+
+// packageKey identifies a OneAgent package of the tenant of a DynaKube
+type packageKey struct {
+	dynakube string
+	flavor   string
+	arch     string
+	version  string
+}
+
+func (key packageKey) path() string {
+	return packagesPath + strings.Join([]string{key.dynakube, key.flavor, key.arch, key.version}, "/")
+}
+
+func (key packageKey) fileName() string {
+	return fmt.Sprintf("%s-%s-%s.zip", key.version, key.flavor, key.arch)
+}
+
+// parsePackagePath returns the package of a path built by packageKey.path, all parts are validated as they are used
+// to build file paths
+func parsePackagePath(path string) (packageKey, error) {
+	parts := strings.Split(strings.TrimPrefix(path, packagesPath), "/")
+	if !strings.HasPrefix(path, packagesPath) || len(parts) != 4 {
+		return packageKey{}, fmt.Errorf("invalid package path %s", path)
+	}
+
+	key := packageKey{dynakube: parts[0], flavor: parts[1], arch: parts[2], version: parts[3]}
+	switch {
+	case len(validation.IsDNS1123Subdomain(key.dynakube)) > 0:
+		return packageKey{}, fmt.Errorf("invalid DynaKube name %s", key.dynakube)
+	case !dtcsi.IsValidFlavor(key.flavor):
+		return packageKey{}, fmt.Errorf("invalid flavor %s", key.flavor)
+	case key.arch != dtclient.ArchX86 && key.arch != dtclient.ArchARM:
+		return packageKey{}, fmt.Errorf("invalid architecture %s", key.arch)
+	case !dtcsi.IsValidVersion(key.version):
+		return packageKey{}, fmt.Errorf("invalid version %s", key.version)
+	}
+	return key, nil
+}
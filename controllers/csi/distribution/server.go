@@ -0,0 +1,321 @@
+package distribution
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/Dynatrace/dynatrace-operator/logger"
+	"github.com/spf13/afero"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authenticationv1client "k8s.io/client-go/kubernetes/typed/authentication/v1"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// CacheDir is the directory below the data directory where the distributing pod keeps the downloaded packages
+	CacheDir = "distribution"
+
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+
+	cacheMaxAge     = 24 * time.Hour
+	cleanupInterval = time.Hour
+)
+
+var log = logger.NewDTLogger().WithName("distribution")
+
+// DynatraceClientFunc returns the Dynatrace client and the tenant UUID of the DynaKube with the given name
+type DynatraceClientFunc func(ctx context.Context, dynakube string) (dtclient.Client, string, error)
+
+// Server takes part in the election of the CSI driver pod distributing the OneAgent packages. Once elected, it
+// downloads and verifies each requested package once and serves it to the other CSI driver pods.
+type Server struct {
+	opts    dtcsi.CSIOptions
+	leases  coordinationv1client.LeasesGetter
+	reviews authenticationv1client.TokenReviewsGetter
+	dtcFunc DynatraceClientFunc
+	fs      afero.Fs
+
+	leading int32
+	mu      sync.Mutex
+	locks   map[packageKey]*sync.Mutex
+}
+
+// NewServer returns a new Server
+func NewServer(opts dtcsi.CSIOptions, leases coordinationv1client.LeasesGetter, reviews authenticationv1client.TokenReviewsGetter, dtcFunc DynatraceClientFunc) *Server {
+	return &Server{
+		opts:    opts,
+		leases:  leases,
+		reviews: reviews,
+		dtcFunc: dtcFunc,
+		fs:      afero.NewOsFs(),
+		locks:   map[packageKey]*sync.Mutex{},
+	}
+}
+
+// Start serves the packages and takes part in the election until the context is done
+func (srv *Server) Start(ctx context.Context) error {
+	_, port, err := net.SplitHostPort(srv.opts.Distribution.Address)
+	if err != nil {
+		return fmt.Errorf("invalid distribution address %s: %w", srv.opts.Distribution.Address, err)
+	}
+
+	httpServer := &http.Server{Addr: ":" + port, Handler: srv}
+	go func() {
+		<-ctx.Done()
+		_ = httpServer.Close()
+	}()
+	go srv.elect(ctx)
+	go srv.cleanupPeriodically(ctx)
+
+	log.Info("serving OneAgent packages", "address", srv.opts.Distribution.Address)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (srv *Server) elect(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: LeaseName, Namespace: srv.opts.Distribution.Namespace},
+		Client:     srv.leases,
+		LockConfig: resourcelock.ResourceLockConfig{Identity: srv.opts.Distribution.Address},
+	}
+
+	// The election ends whenever the lease is lost, the pod keeps applying as long as it runs
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(context.Context) {
+					log.Info("elected for distributing OneAgent packages")
+					atomic.StoreInt32(&srv.leading, 1)
+				},
+				OnStoppedLeading: func() {
+					log.Info("no longer distributing OneAgent packages")
+					atomic.StoreInt32(&srv.leading, 0)
+				},
+			},
+		})
+	}
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if code, err := srv.authenticate(req); err != nil {
+		log.Info("rejected request for OneAgent package", "remote", req.RemoteAddr, "error", err.Error())
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if atomic.LoadInt32(&srv.leading) == 0 {
+		http.Error(w, "not elected for distributing OneAgent packages", http.StatusServiceUnavailable)
+		return
+	}
+
+	key, err := parsePackagePath(req.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	path, checksum, err := srv.getPackage(req.Context(), key)
+	if err != nil {
+		log.Info("failed to provide OneAgent package", "path", req.URL.Path, "error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	file, err := srv.fs.Open(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Packages are removed from the cache once they have not been requested for a while
+	now := time.Now()
+	_ = srv.fs.Chtimes(path, now, now)
+
+	w.Header().Set(ChecksumHeader, checksum)
+	http.ServeContent(w, req, key.fileName(), fileInfo.ModTime(), file)
+}
+
+// authenticate reviews the bearer token of the request, which has to be a token of the TokenAudience of the
+// ServiceAccount of the CSI driver pods. Returns the HTTP status code to respond with if not.
+func (srv *Server) authenticate(req *http.Request) (int, error) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		return http.StatusUnauthorized, fmt.Errorf("bearer token is missing")
+	}
+
+	review, err := srv.reviews.TokenReviews().Create(req.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: []string{TokenAudience}},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to review token: %w", err)
+	}
+	if !review.Status.Authenticated || !containsAudience(review.Status.Audiences) {
+		return http.StatusUnauthorized, fmt.Errorf("bearer token is invalid")
+	}
+
+	expected := fmt.Sprintf("system:serviceaccount:%s:%s", srv.opts.Distribution.Namespace, srv.opts.Distribution.ServiceAccount)
+	if review.Status.User.Username != expected {
+		return http.StatusForbidden, fmt.Errorf("user %s is not allowed to fetch OneAgent packages", review.Status.User.Username)
+	}
+	return http.StatusOK, nil
+}
+
+func containsAudience(audiences []string) bool {
+	for _, audience := range audiences {
+		if audience == TokenAudience {
+			return true
+		}
+	}
+	return false
+}
+
+// getPackage returns the path and the SHA-256 checksum of the cached package, which is downloaded from the tenant and
+// verified first if not cached yet. Concurrent requests for the same package wait for a single download.
+func (srv *Server) getPackage(ctx context.Context, key packageKey) (string, string, error) {
+	lock := srv.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	dtc, tenantUUID, err := srv.dtcFunc(ctx, key.dynakube)
+	if err != nil {
+		return "", "", err
+	}
+
+	cacheDir := filepath.Join(srv.opts.RootDir, CacheDir, tenantUUID)
+	path := filepath.Join(cacheDir, key.fileName())
+	if exists, _ := afero.Exists(srv.fs, path); exists {
+		if checksum, err := afero.ReadFile(srv.fs, path+".sha256"); err == nil {
+			return path, string(checksum), nil
+		}
+	}
+
+	checksum, err := srv.download(dtc, key, cacheDir, path)
+	if err != nil {
+		return "", "", err
+	}
+	return path, checksum, nil
+}
+
+func (srv *Server) download(dtc dtclient.Client, key packageKey, cacheDir string, path string) (string, error) {
+	if err := srv.fs.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	tmpFile, err := afero.TempFile(srv.fs, cacheDir, "download")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file for download: %w", err)
+	}
+	moved := false
+	defer func() {
+		_ = tmpFile.Close()
+		if !moved {
+			_ = srv.fs.Remove(tmpFile.Name())
+		}
+	}()
+
+	log.Info("downloading OneAgent package for distribution", "version", key.version, "flavor", key.flavor, "architecture", key.arch)
+	hash := sha256.New()
+	if err := dtc.GetAgent(dtclient.OsUnix, dtclient.InstallerTypePaaS, key.flavor, key.arch, key.version, io.MultiWriter(tmpFile, hash)); err != nil {
+		return "", fmt.Errorf("failed to fetch OneAgent version %s: %w", key.version, err)
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	expected, err := dtc.GetAgentChecksum(dtclient.OsUnix, dtclient.InstallerTypePaaS, key.flavor, key.arch, key.version)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch checksum: %w", err)
+	} else if expected == "" && srv.opts.RequireChecksums {
+		return "", fmt.Errorf("no checksum provided by the deployment API, but checksums are required")
+	} else if expected != "" && !strings.EqualFold(checksum, expected) {
+		return "", fmt.Errorf("checksum mismatch: expected %s, got %s", expected, checksum)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return "", err
+	}
+	if err := srv.fs.Rename(tmpFile.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to move OneAgent package to %s: %w", path, err)
+	}
+	moved = true
+	if err := afero.WriteFile(srv.fs, path+".sha256", []byte(checksum), 0644); err != nil {
+		_ = srv.fs.Remove(path)
+		return "", fmt.Errorf("failed to store checksum: %w", err)
+	}
+	return checksum, nil
+}
+
+func (srv *Server) lock(key packageKey) *sync.Mutex {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if _, ok := srv.locks[key]; !ok {
+		srv.locks[key] = &sync.Mutex{}
+	}
+	return srv.locks[key]
+}
+
+func (srv *Server) cleanupPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			srv.cleanup()
+		}
+	}
+}
+
+// cleanup removes the cached packages which have not been requested for a day, including those cached while the pod
+// was elected before
+func (srv *Server) cleanup() {
+	cacheRoot := filepath.Join(srv.opts.RootDir, CacheDir)
+	_ = afero.Walk(srv.fs, cacheRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".zip" || time.Since(info.ModTime()) < cacheMaxAge {
+			return nil
+		}
+
+		log.Info("removing cached OneAgent package", "path", path)
+		_ = srv.fs.Remove(path + ".sha256")
+		if err := srv.fs.Remove(path); err != nil {
+			log.Info("failed to remove cached OneAgent package", "path", path, "error", err.Error())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,193 @@
+package distribution
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	testDynakube = "dynakube"
+	testTenant   = "abc12345"
+	testVersion  = "1.203.0.20201029-151112"
+	testContent  = "OneAgent package"
+	rootDir      = "/data"
+	testToken    = "token"
+	testAccount  = "dynatrace-oneagent-csi-driver"
+)
+
+var testKey = packageKey{dynakube: testDynakube, flavor: dtclient.FlavorMultidistro, arch: dtclient.ArchX86, version: testVersion}
+
+func testChecksum() string {
+	hash := sha256.Sum256([]byte(testContent))
+	return hex.EncodeToString(hash[:])
+}
+
+// newTokenReviews authenticates testToken as the given user with the TokenAudience
+func newTokenReviews(username string) *kubefake.Clientset {
+	clientset := kubefake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == testToken {
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: username},
+				Audiences:     review.Spec.Audiences,
+			}
+		}
+		return true, review, nil
+	})
+	return clientset
+}
+
+func newTestServer(dtc dtclient.Client) *Server {
+	return &Server{
+		opts: dtcsi.CSIOptions{
+			RootDir:      rootDir,
+			Distribution: dtcsi.DistributionOptions{Namespace: "dynatrace", ServiceAccount: testAccount},
+		},
+		reviews: newTokenReviews("system:serviceaccount:dynatrace:" + testAccount).AuthenticationV1(),
+		dtcFunc: func(_ context.Context, dynakube string) (dtclient.Client, string, error) {
+			if dynakube != testDynakube {
+				return nil, "", fmt.Errorf("DynaKube %s not found", dynakube)
+			}
+			return dtc, testTenant, nil
+		},
+		fs:      afero.NewMemMapFs(),
+		leading: 1,
+		locks:   map[packageKey]*sync.Mutex{},
+	}
+}
+
+func mockPackage(dtc *dtclient.MockDynatraceClient, checksum string) {
+	dtc.On("GetAgent", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro, dtclient.ArchX86, testVersion, mock.Anything).
+		Run(func(args mock.Arguments) {
+			_, _ = io.WriteString(args.Get(5).(io.Writer), testContent)
+		}).
+		Return(nil)
+	dtc.On("GetAgentChecksum", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro, dtclient.ArchX86, testVersion).
+		Return(checksum, nil)
+}
+
+func serve(srv *Server, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestServer_ServeHTTP(t *testing.T) {
+	t.Run(`downloads and caches package`, func(t *testing.T) {
+		dtc := &dtclient.MockDynatraceClient{}
+		mockPackage(dtc, testChecksum())
+		srv := newTestServer(dtc)
+
+		for i := 0; i < 2; i++ {
+			recorder := serve(srv, testKey.path())
+
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, testContent, recorder.Body.String())
+			assert.Equal(t, testChecksum(), recorder.Header().Get(ChecksumHeader))
+		}
+		dtc.AssertNumberOfCalls(t, "GetAgent", 1)
+
+		exists, _ := afero.Exists(srv.fs, filepath.Join(rootDir, CacheDir, testTenant, testKey.fileName()))
+		assert.True(t, exists)
+	})
+	t.Run(`does not cache package with checksum mismatch`, func(t *testing.T) {
+		dtc := &dtclient.MockDynatraceClient{}
+		mockPackage(dtc, "invalid")
+		srv := newTestServer(dtc)
+
+		recorder := serve(srv, testKey.path())
+
+		assert.Equal(t, http.StatusBadGateway, recorder.Code)
+		exists, _ := afero.Exists(srv.fs, filepath.Join(rootDir, CacheDir, testTenant, testKey.fileName()))
+		assert.False(t, exists)
+	})
+	t.Run(`computes checksum if tenant provides none`, func(t *testing.T) {
+		dtc := &dtclient.MockDynatraceClient{}
+		mockPackage(dtc, "")
+		srv := newTestServer(dtc)
+
+		recorder := serve(srv, testKey.path())
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, testChecksum(), recorder.Header().Get(ChecksumHeader))
+	})
+	t.Run(`unavailable if not elected`, func(t *testing.T) {
+		srv := newTestServer(&dtclient.MockDynatraceClient{})
+		srv.leading = 0
+
+		assert.Equal(t, http.StatusServiceUnavailable, serve(srv, testKey.path()).Code)
+	})
+	t.Run(`rejects invalid paths`, func(t *testing.T) {
+		srv := newTestServer(&dtclient.MockDynatraceClient{})
+
+		assert.Equal(t, http.StatusBadRequest, serve(srv, "/v1/agents/dynakube/multidistro/x86/../../etc").Code)
+		assert.Equal(t, http.StatusBadRequest, serve(srv, "/v1/agents/dynakube/unknown/x86/"+testVersion).Code)
+		assert.Equal(t, http.StatusBadRequest, serve(srv, "/v1/agents/Dyna_Kube/multidistro/x86/"+testVersion).Code)
+		assert.Equal(t, http.StatusBadRequest, serve(srv, "/v1/agents/dynakube/multidistro/s390/"+testVersion).Code)
+	})
+	t.Run(`fails for unknown DynaKube`, func(t *testing.T) {
+		srv := newTestServer(&dtclient.MockDynatraceClient{})
+
+		assert.Equal(t, http.StatusBadGateway, serve(srv, "/v1/agents/other/multidistro/x86/"+testVersion).Code)
+	})
+	t.Run(`rejects requests without valid token`, func(t *testing.T) {
+		srv := newTestServer(&dtclient.MockDynatraceClient{})
+
+		for _, header := range []string{"", testToken, "Bearer other"} {
+			req := httptest.NewRequest(http.MethodGet, testKey.path(), nil)
+			req.Header.Set("Authorization", header)
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, req)
+
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		}
+	})
+	t.Run(`rejects tokens of other ServiceAccounts`, func(t *testing.T) {
+		srv := newTestServer(&dtclient.MockDynatraceClient{})
+		srv.reviews = newTokenReviews("system:serviceaccount:dynatrace:default").AuthenticationV1()
+
+		assert.Equal(t, http.StatusForbidden, serve(srv, testKey.path()).Code)
+	})
+}
+
+func TestServer_Cleanup(t *testing.T) {
+	srv := newTestServer(&dtclient.MockDynatraceClient{})
+	cacheDir := filepath.Join(rootDir, CacheDir, testTenant)
+	for _, name := range []string{"old", "recent"} {
+		require.NoError(t, afero.WriteFile(srv.fs, filepath.Join(cacheDir, name+".zip"), []byte(testContent), 0644))
+		require.NoError(t, afero.WriteFile(srv.fs, filepath.Join(cacheDir, name+".zip.sha256"), []byte(testChecksum()), 0644))
+	}
+	old := time.Now().Add(-2 * cacheMaxAge)
+	require.NoError(t, srv.fs.Chtimes(filepath.Join(cacheDir, "old.zip"), old, old))
+
+	srv.cleanup()
+
+	for path, expected := range map[string]bool{"old.zip": false, "old.zip.sha256": false, "recent.zip": true, "recent.zip.sha256": true} {
+		exists, _ := afero.Exists(srv.fs, filepath.Join(cacheDir, path))
+		assert.Equal(t, expected, exists, path)
+	}
+}
@@ -3,59 +3,218 @@ package csidriver
 import (
 	"context"
 	"fmt"
-	"os"
 	"path/filepath"
+	"time"
 
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
 	"github.com/Dynatrace/dynatrace-operator/webhook"
 	"github.com/spf13/afero"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const publishRetryInterval = time.Second
+
+// EventReasonFlavorUnavailable is the reason of the Event recorded on a pod which got the multidistro flavor mounted
+// instead of the requested one
+const EventReasonFlavorUnavailable = "FlavorUnavailable"
+
 type bindConfig struct {
-	agentDir                    string
-	envDir                      string
-	version                     string
-	volumeToVersionReferenceDir string
+	agentDir   string
+	envDir     string
+	tenantUUID string
+	version    string
 }
 
 func newBindConfig(ctx context.Context, svr *CSIDriverServer, volumeCfg *volumeConfig, fs afero.Afero) (*bindConfig, error) {
+	dkName, err := svr.getDynaKubeName(ctx, volumeCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := svr.db.GetTenant(dkName)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to extract tenant for DynaKube %s: %s", dkName, err.Error()))
+	} else if tenant == nil {
+		return nil, status.Error(codes.Unavailable, fmt.Sprintf("tenant for DynaKube %s has not been provisioned yet", dkName))
+	}
+	envDir := filepath.Join(svr.opts.RootDir, tenant.TenantUUID)
+
+	if volumeCfg.volumeType == dtcsi.HostAgentVolumeType {
+		return newHostAgentBindConfig(tenant, envDir, volumeCfg, fs)
+	}
+
+	version := volumeCfg.version
+	if version == "" {
+		if tenant.LatestVersion == "" {
+			return nil, status.Error(codes.Unavailable, fmt.Sprintf("no version has been provisioned yet for DynaKube %s", dkName))
+		}
+		version = tenant.LatestVersion
+	} else if exists, _ := fs.DirExists(dtcsi.AgentBinaryDir(envDir, version, "")); !exists {
+		// The provisioner installs versions requested by pods on the node once triggered by waitForBindConfig, kubelet retries
+		return nil, status.Error(codes.Unavailable, fmt.Sprintf("version %s has not been provisioned yet for DynaKube %s", version, dkName))
+	}
+
+	agentDir := dtcsi.AgentBinaryDir(envDir, version, volumeCfg.flavor)
+	if exists, _ := fs.DirExists(agentDir); !exists && agentDir != dtcsi.AgentBinaryDir(envDir, version, "") {
+		// The provisioner installs flavors requested by pods on the node once triggered by waitForBindConfig, kubelet retries
+		return nil, status.Error(codes.Unavailable, fmt.Sprintf("flavor %s of version %s has not been provisioned yet for DynaKube %s", volumeCfg.flavor, version, dkName))
+	}
+
+	return &bindConfig{
+		agentDir:   agentDir,
+		envDir:     envDir,
+		tenantUUID: tenant.TenantUUID,
+		version:    version,
+	}, nil
+}
+
+// getDynaKubeName returns the DynaKube selected by the volume attribute, or the one assigned to the namespace of the pod.
+// The volume attribute is only accepted for namespaces the DynaKube injects into or allows volumes of.
+func (svr *CSIDriverServer) getDynaKubeName(ctx context.Context, volumeCfg *volumeConfig) (string, error) {
 	var ns corev1.Namespace
 	if err := svr.client.Get(ctx, client.ObjectKey{Name: volumeCfg.namespace}, &ns); err != nil {
-		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("failed to query namespace %s: %s", volumeCfg.namespace, err.Error()))
+		return "", status.Error(codes.FailedPrecondition, fmt.Sprintf("failed to query namespace %s: %s", volumeCfg.namespace, err.Error()))
+	}
+
+	if volumeCfg.dynakube != "" {
+		return volumeCfg.dynakube, svr.checkVolumeNamespace(ctx, &ns, volumeCfg.dynakube)
 	}
 
 	dkName := ns.Labels[webhook.LabelInstance]
 	if dkName == "" {
-		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("namespace '%s' doesn't have DynaKube assigned", volumeCfg.namespace))
+		return "", status.Error(codes.FailedPrecondition, fmt.Sprintf("namespace '%s' doesn't have DynaKube assigned", volumeCfg.namespace))
 	}
+	return dkName, nil
+}
 
-	tenantUUID, err := fs.ReadFile(filepath.Join(svr.opts.RootDir, fmt.Sprintf("tenant-%s", dkName)))
-	if err != nil {
-		return nil, status.Error(codes.Unavailable, fmt.Sprintf("failed to extract tenant for DynaKube %s: %s", dkName, err.Error()))
+// checkVolumeNamespace returns PermissionDenied unless pods in the namespace may select the DynaKube by the volume
+// attribute, otherwise any pod could mount the OneAgent package and connection config of any tenant
+func (svr *CSIDriverServer) checkVolumeNamespace(ctx context.Context, ns *corev1.Namespace, dkName string) error {
+	var dynakubes dynatracev1alpha1.DynaKubeList
+	if err := svr.client.List(ctx, &dynakubes); err != nil {
+		return status.Error(codes.Internal, fmt.Sprintf("failed to query DynaKubes: %s", err.Error()))
 	}
-	envDir := filepath.Join(svr.opts.RootDir, string(tenantUUID))
 
-	versionBytes, err := fs.ReadFile(filepath.Join(envDir, "version"))
-	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("Failed to query agent directory for DynaKube %s: %s", dkName, err.Error()))
+	var dk *dynatracev1alpha1.DynaKube
+	for i := range dynakubes.Items {
+		if dynakubes.Items[i].Name == dkName {
+			dk = &dynakubes.Items[i]
+		}
 	}
+	if dk == nil {
+		return status.Error(codes.FailedPrecondition, fmt.Sprintf("DynaKube %s doesn't exist", dkName))
+	} else if dk.AllowsVolumeNamespace(ns.Name) {
+		return nil
+	}
+
+	var candidates []dynatracev1alpha1.DynaKube
+	for _, item := range dynakubes.Items {
+		if item.Namespace == dk.Namespace {
+			candidates = append(candidates, item)
+		}
+	}
+	if match, err := webhook.FindDynaKube(ns, candidates); err == nil && match != nil && match.Name == dkName {
+		return nil
+	}
+	return status.Error(codes.PermissionDenied, fmt.Sprintf("namespace '%s' is not allowed to use DynaKube %s", ns.Name, dkName))
+}
 
-	version := string(versionBytes)
-	agentDir := filepath.Join(envDir, "bin", version)
+// newHostAgentBindConfig binds the directory of the installer of the full-stack host agent, the provisioner installs
+// it once a pod on the node requests it
+func newHostAgentBindConfig(tenant *metadata.Tenant, envDir string, volumeCfg *volumeConfig, fs afero.Afero) (*bindConfig, error) {
+	version := volumeCfg.version
+	if version == "" {
+		if len(tenant.HostAgentVersions) == 0 {
+			return nil, status.Error(codes.Unavailable, fmt.Sprintf("no host agent has been provisioned yet for DynaKube %s", tenant.DynakubeName))
+		}
+		version = tenant.HostAgentVersions[0]
+	}
 
-	volumeToVersionReferenceDir := filepath.Join(envDir, dtcsi.GarbageCollectionPath, string(versionBytes))
-	if err := svr.fs.MkdirAll(volumeToVersionReferenceDir, os.ModePerm); err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("Failed to create pod to version reference directory: %s", err))
+	agentDir := dtcsi.HostAgentDir(envDir, version)
+	if exists, _ := fs.DirExists(agentDir); !exists {
+		return nil, status.Error(codes.Unavailable, fmt.Sprintf("host agent version %s has not been provisioned yet for DynaKube %s", version, tenant.DynakubeName))
 	}
 
 	return &bindConfig{
-		agentDir:                    agentDir,
-		envDir:                      envDir,
-		version:                     version,
-		volumeToVersionReferenceDir: volumeToVersionReferenceDir,
+		agentDir:   agentDir,
+		envDir:     envDir,
+		tenantUUID: tenant.TenantUUID,
+		version:    version,
 	}, nil
 }
+
+// waitForBindConfig retries the bind config as long as the OneAgent is still being provisioned for the DynaKube of the
+// volume, at most for the publish timeout, so pods scheduled to a freshly started node don't fail to start
+func (svr *CSIDriverServer) waitForBindConfig(ctx context.Context, volumeCfg *volumeConfig) (*bindConfig, error) {
+	timeout := time.NewTimer(svr.opts.PublishTimeout)
+	defer timeout.Stop()
+	retry := time.NewTicker(publishRetryInterval)
+	defer retry.Stop()
+
+	triggered := false
+	for {
+		bindCfg, err := newBindConfig(ctx, svr, volumeCfg, svr.fs)
+		if status.Code(err) != codes.Unavailable {
+			return bindCfg, err
+		}
+
+		// The provisioner would only install the requested version or flavor with its next periodic reconciliation,
+		// which may be after the publish timeout
+		if !triggered {
+			svr.triggerProvisioning(ctx, volumeCfg)
+			triggered = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-timeout.C:
+			return svr.fallBackToMultidistro(ctx, volumeCfg, err)
+		case <-retry.C:
+			svr.log.Info("waiting for OneAgent to be provisioned", "volumeID", volumeCfg.volumeId, "reason", status.Convert(err).Message())
+		}
+	}
+}
+
+func (svr *CSIDriverServer) triggerProvisioning(ctx context.Context, volumeCfg *volumeConfig) {
+	if svr.trigger == nil {
+		return
+	}
+	if dkName, err := svr.getDynaKubeName(ctx, volumeCfg); err == nil {
+		svr.trigger.TriggerProvisioning(dkName)
+	}
+}
+
+// fallBackToMultidistro binds the multidistro flavor if the flavor requested by the volume has not been provisioned in
+// time, e.g. because the tenant doesn't provide it, and records a warning Event on the pod. Returns the given error if
+// the multidistro flavor is not available either.
+func (svr *CSIDriverServer) fallBackToMultidistro(ctx context.Context, volumeCfg *volumeConfig, err error) (*bindConfig, error) {
+	if volumeCfg.flavor == "" || volumeCfg.flavor == dtclient.FlavorMultidistro {
+		return nil, err
+	}
+
+	multidistroCfg := *volumeCfg
+	multidistroCfg.flavor = ""
+	bindCfg, multidistroErr := newBindConfig(ctx, svr, &multidistroCfg, svr.fs)
+	if multidistroErr != nil {
+		return nil, err
+	}
+
+	svr.log.Info("flavor has not been provisioned in time, falling back to multidistro",
+		"flavor", volumeCfg.flavor, "version", bindCfg.version, "pod", volumeCfg.podName, "namespace", volumeCfg.namespace)
+	if svr.recorder != nil && volumeCfg.podName != "" {
+		svr.recorder.Eventf(&metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Name: volumeCfg.podName, Namespace: volumeCfg.namespace},
+		}, corev1.EventTypeWarning, EventReasonFlavorUnavailable,
+			"OneAgent flavor %s has not been provisioned in time, mounted multidistro flavor instead", volumeCfg.flavor)
+	}
+	return bindCfg, nil
+}
@@ -5,15 +5,23 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
 	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
 	"github.com/Dynatrace/dynatrace-operator/webhook"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
@@ -52,12 +60,13 @@ func TestCSIDriverServer_NewBindConfig(t *testing.T) {
 		assert.Error(t, err)
 		assert.Nil(t, bindCfg)
 	})
-	t.Run(`failed to extract tenant from file`, func(t *testing.T) {
+	t.Run(`tenant not provisioned`, func(t *testing.T) {
 		clt := fake.NewClient(
 			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{webhook.LabelInstance: dkName}}})
 		srv := &CSIDriverServer{
 			client: clt,
 			fs:     afero.Afero{Fs: afero.NewMemMapFs()},
+			db:     newTestDB(t),
 		}
 		volumeCfg := &volumeConfig{
 			namespace: namespace,
@@ -65,68 +74,267 @@ func TestCSIDriverServer_NewBindConfig(t *testing.T) {
 
 		bindCfg, err := newBindConfig(context.TODO(), srv, volumeCfg, srv.fs)
 
-		assert.Error(t, err)
+		assert.EqualError(t, err, "rpc error: code = Unavailable desc = tenant for DynaKube a-dynakube has not been provisioned yet")
 		assert.Nil(t, bindCfg)
 	})
-	t.Run(`failed to create directories`, func(t *testing.T) {
+	t.Run(`version not provisioned`, func(t *testing.T) {
 		clt := fake.NewClient(
 			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{webhook.LabelInstance: dkName}}})
 		srv := &CSIDriverServer{
 			client: clt,
 			opts:   dtcsi.CSIOptions{RootDir: "/"},
 			fs:     afero.Afero{Fs: afero.NewMemMapFs()},
+			db:     newTestDB(t),
 		}
 		volumeCfg := &volumeConfig{
 			namespace: namespace,
 		}
 
-		_ = srv.fs.WriteFile(filepath.Join(srv.opts.RootDir, "tenant-"+dkName), []byte(tenantUuid), os.ModePerm)
+		require.NoError(t, srv.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUuid}))
 
 		bindCfg, err := newBindConfig(context.TODO(), srv, volumeCfg, srv.fs)
 
-		assert.Error(t, err)
+		assert.EqualError(t, err, "rpc error: code = Unavailable desc = no version has been provisioned yet for DynaKube a-dynakube")
 		assert.Nil(t, bindCfg)
 	})
-	t.Run(`failed to read version file`, func(t *testing.T) {
+	t.Run(`create correct bind config`, func(t *testing.T) {
 		clt := fake.NewClient(
-			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{webhook.LabelInstance: dkName}}})
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{webhook.LabelInstance: dkName}}},
+			&dynatracev1alpha1.DynaKube{
+				ObjectMeta: metav1.ObjectMeta{Name: dkName},
+			},
+		)
 		srv := &CSIDriverServer{
 			client: clt,
+			opts:   dtcsi.CSIOptions{RootDir: "/"},
 			fs:     afero.Afero{Fs: afero.NewMemMapFs()},
+			db:     newTestDB(t),
 		}
 		volumeCfg := &volumeConfig{
 			namespace: namespace,
 		}
 
+		require.NoError(t, srv.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUuid, LatestVersion: agentVersion}))
+
 		bindCfg, err := newBindConfig(context.TODO(), srv, volumeCfg, srv.fs)
 
-		assert.Error(t, err)
+		assert.NoError(t, err)
+		assert.NotNil(t, bindCfg)
+		assert.Equal(t, filepath.Join(srv.opts.RootDir, tenantUuid, "bin", agentVersion), bindCfg.agentDir)
+		assert.Equal(t, filepath.Join(srv.opts.RootDir, tenantUuid), bindCfg.envDir)
+	})
+	t.Run(`use flavor specific directory`, func(t *testing.T) {
+		clt := fake.NewClient(
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{webhook.LabelInstance: dkName}}},
+		)
+		srv := &CSIDriverServer{
+			client: clt,
+			log:    log,
+			opts:   dtcsi.CSIOptions{RootDir: "/"},
+			fs:     afero.Afero{Fs: afero.NewMemMapFs()},
+			db:     newTestDB(t),
+		}
+
+		require.NoError(t, srv.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUuid, LatestVersion: agentVersion}))
+
+		bindCfg, err := newBindConfig(context.TODO(), srv, &volumeConfig{namespace: namespace, flavor: dtclient.FlavorMUSL}, srv.fs)
+		assert.EqualError(t, err, "rpc error: code = Unavailable desc = flavor musl of version 1.2-3 has not been provisioned yet for DynaKube a-dynakube")
 		assert.Nil(t, bindCfg)
+
+		_ = srv.fs.MkdirAll(filepath.Join(srv.opts.RootDir, tenantUuid, "bin", agentVersion+"-"+dtclient.FlavorMUSL), os.ModePerm)
+
+		bindCfg, err = newBindConfig(context.TODO(), srv, &volumeConfig{namespace: namespace, flavor: dtclient.FlavorMUSL}, srv.fs)
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(srv.opts.RootDir, tenantUuid, "bin", agentVersion+"-"+dtclient.FlavorMUSL), bindCfg.agentDir)
 	})
-	t.Run(`create correct bind config`, func(t *testing.T) {
+	t.Run(`use requested version`, func(t *testing.T) {
 		clt := fake.NewClient(
 			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{webhook.LabelInstance: dkName}}},
-			&dynatracev1alpha1.DynaKube{
-				ObjectMeta: metav1.ObjectMeta{Name: dkName},
-			},
 		)
 		srv := &CSIDriverServer{
 			client: clt,
+			log:    log,
 			opts:   dtcsi.CSIOptions{RootDir: "/"},
 			fs:     afero.Afero{Fs: afero.NewMemMapFs()},
+			db:     newTestDB(t),
 		}
-		volumeCfg := &volumeConfig{
-			namespace: namespace,
+		pinnedVersion := "1.200.0"
+
+		require.NoError(t, srv.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUuid, LatestVersion: agentVersion}))
+
+		bindCfg, err := newBindConfig(context.TODO(), srv, &volumeConfig{namespace: namespace, version: pinnedVersion}, srv.fs)
+		assert.EqualError(t, err, "rpc error: code = Unavailable desc = version 1.200.0 has not been provisioned yet for DynaKube a-dynakube")
+		assert.Nil(t, bindCfg)
+
+		_ = srv.fs.MkdirAll(filepath.Join(srv.opts.RootDir, tenantUuid, "bin", pinnedVersion), os.ModePerm)
+
+		bindCfg, err = newBindConfig(context.TODO(), srv, &volumeConfig{namespace: namespace, version: pinnedVersion}, srv.fs)
+		assert.NoError(t, err)
+		assert.Equal(t, pinnedVersion, bindCfg.version)
+		assert.Equal(t, filepath.Join(srv.opts.RootDir, tenantUuid, "bin", pinnedVersion), bindCfg.agentDir)
+		assert.Equal(t, tenantUuid, bindCfg.tenantUUID)
+	})
+	t.Run(`use DynaKube of volume attribute`, func(t *testing.T) {
+		srv := &CSIDriverServer{
+			client: fake.NewClient(volumeNamespace("dynatrace"), volumeDynaKube()),
+			opts:   dtcsi.CSIOptions{RootDir: "/"},
+			fs:     afero.Afero{Fs: afero.NewMemMapFs()},
+			db:     newTestDB(t),
 		}
 
-		_ = srv.fs.WriteFile(filepath.Join(srv.opts.RootDir, "tenant-"+dkName), []byte(tenantUuid), os.ModePerm)
-		_ = srv.fs.WriteFile(filepath.Join(srv.opts.RootDir, tenantUuid, "version"), []byte(agentVersion), os.ModePerm)
+		require.NoError(t, srv.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUuid, LatestVersion: agentVersion}))
+
+		bindCfg, err := newBindConfig(context.TODO(), srv, &volumeConfig{namespace: "dynatrace", dynakube: dkName}, srv.fs)
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(srv.opts.RootDir, tenantUuid, "bin", agentVersion), bindCfg.agentDir)
+	})
+	t.Run(`use host agent directory`, func(t *testing.T) {
+		srv := &CSIDriverServer{
+			client: fake.NewClient(volumeNamespace("dynatrace"), volumeDynaKube()),
+			opts:   dtcsi.CSIOptions{RootDir: "/"},
+			fs:     afero.Afero{Fs: afero.NewMemMapFs()},
+			db:     newTestDB(t),
+		}
+		volumeCfg := &volumeConfig{namespace: "dynatrace", dynakube: dkName, volumeType: dtcsi.HostAgentVolumeType}
+
+		require.NoError(t, srv.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUuid, LatestVersion: agentVersion}))
 
 		bindCfg, err := newBindConfig(context.TODO(), srv, volumeCfg, srv.fs)
+		assert.EqualError(t, err, "rpc error: code = Unavailable desc = no host agent has been provisioned yet for DynaKube a-dynakube")
+		assert.Nil(t, bindCfg)
+
+		require.NoError(t, srv.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUuid, HostAgentVersions: []string{agentVersion}}))
+
+		bindCfg, err = newBindConfig(context.TODO(), srv, volumeCfg, srv.fs)
+		assert.EqualError(t, err, "rpc error: code = Unavailable desc = host agent version 1.2-3 has not been provisioned yet for DynaKube a-dynakube")
+		assert.Nil(t, bindCfg)
+
+		hostAgentDir := dtcsi.HostAgentDir(filepath.Join(srv.opts.RootDir, tenantUuid), agentVersion)
+		_ = srv.fs.MkdirAll(hostAgentDir, os.ModePerm)
 
+		bindCfg, err = newBindConfig(context.TODO(), srv, volumeCfg, srv.fs)
 		assert.NoError(t, err)
-		assert.NotNil(t, bindCfg)
+		assert.Equal(t, hostAgentDir, bindCfg.agentDir)
+		assert.Equal(t, agentVersion, bindCfg.version)
+	})
+}
+
+func TestCSIDriverServer_GetDynaKubeName(t *testing.T) {
+	getDynaKubeName := func(objs ...client.Object) (string, error) {
+		srv := &CSIDriverServer{client: fake.NewClient(objs...)}
+		return srv.getDynaKubeName(context.TODO(), &volumeConfig{namespace: namespace, dynakube: dkName})
+	}
+
+	t.Run(`reject volume attribute of other namespaces`, func(t *testing.T) {
+		_, err := getDynaKubeName(volumeNamespace(namespace), volumeDynaKube())
+
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+	t.Run(`reject volume attribute of namespaces assigned to other DynaKubes`, func(t *testing.T) {
+		ns := volumeNamespace(namespace)
+		ns.Labels = map[string]string{webhook.LabelInstance: "other"}
+		other := volumeDynaKube()
+		other.Name = "other"
+
+		_, err := getDynaKubeName(ns, volumeDynaKube(), other)
+
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+	t.Run(`reject volume attribute of unknown DynaKube`, func(t *testing.T) {
+		_, err := getDynaKubeName(volumeNamespace(namespace))
+
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+	t.Run(`accept volume attribute of injected namespaces`, func(t *testing.T) {
+		ns := volumeNamespace(namespace)
+		ns.Labels = map[string]string{webhook.LabelInstance: dkName}
+
+		name, err := getDynaKubeName(ns, volumeDynaKube())
+
+		require.NoError(t, err)
+		assert.Equal(t, dkName, name)
+	})
+	t.Run(`accept volume attribute of allowed namespaces`, func(t *testing.T) {
+		dk := volumeDynaKube()
+		dk.Spec.CodeModules.VolumeNamespaces = []string{namespace}
+
+		name, err := getDynaKubeName(volumeNamespace(namespace), dk)
+
+		require.NoError(t, err)
+		assert.Equal(t, dkName, name)
+	})
+}
+
+func volumeNamespace(name string) *v1.Namespace {
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func volumeDynaKube() *dynatracev1alpha1.DynaKube {
+	return &dynatracev1alpha1.DynaKube{ObjectMeta: metav1.ObjectMeta{Name: dkName, Namespace: "dynatrace"}}
+}
+
+type fakeTrigger struct {
+	dynakubes []string
+}
+
+func (trigger *fakeTrigger) TriggerProvisioning(dynakube string) {
+	trigger.dynakubes = append(trigger.dynakubes, dynakube)
+}
+
+func TestCSIDriverServer_WaitForBindConfig(t *testing.T) {
+	newServer := func(t *testing.T, publishTimeout time.Duration) *CSIDriverServer {
+		return &CSIDriverServer{
+			client: fake.NewClient(
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{webhook.LabelInstance: dkName}}}),
+			log:  log,
+			opts: dtcsi.CSIOptions{RootDir: "/", PublishTimeout: publishTimeout},
+			fs:   afero.Afero{Fs: afero.NewMemMapFs()},
+			db:   newTestDB(t),
+		}
+	}
+
+	t.Run(`fails after timeout`, func(t *testing.T) {
+		srv := newServer(t, 10*time.Millisecond)
+
+		bindCfg, err := srv.waitForBindConfig(context.TODO(), &volumeConfig{namespace: namespace})
+
+		assert.EqualError(t, err, "rpc error: code = Unavailable desc = tenant for DynaKube a-dynakube has not been provisioned yet")
+		assert.Nil(t, bindCfg)
+	})
+	t.Run(`waits for provisioning`, func(t *testing.T) {
+		srv := newServer(t, 10*time.Second)
+		trigger := &fakeTrigger{}
+		srv.trigger = trigger
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			_ = srv.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUuid, LatestVersion: agentVersion})
+		}()
+
+		bindCfg, err := srv.waitForBindConfig(context.TODO(), &volumeConfig{namespace: namespace})
+
+		require.NoError(t, err)
+		assert.Equal(t, agentVersion, bindCfg.version)
+		assert.Equal(t, []string{dkName}, trigger.dynakubes)
+	})
+	t.Run(`falls back to multidistro if flavor is not provisioned in time`, func(t *testing.T) {
+		srv := newServer(t, 10*time.Millisecond)
+		recorder := record.NewFakeRecorder(1)
+		srv.recorder = recorder
+		require.NoError(t, srv.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUuid, LatestVersion: agentVersion}))
+
+		bindCfg, err := srv.waitForBindConfig(context.TODO(), &volumeConfig{namespace: namespace, podName: "pod", flavor: dtclient.FlavorMUSL})
+
+		require.NoError(t, err)
 		assert.Equal(t, filepath.Join(srv.opts.RootDir, tenantUuid, "bin", agentVersion), bindCfg.agentDir)
-		assert.Equal(t, filepath.Join(srv.opts.RootDir, tenantUuid), bindCfg.envDir)
+		require.Len(t, recorder.Events, 1)
+		assert.Contains(t, <-recorder.Events, EventReasonFlavorUnavailable)
+	})
+	t.Run(`does not wait for other errors`, func(t *testing.T) {
+		srv := newServer(t, 10*time.Second)
+
+		bindCfg, err := srv.waitForBindConfig(context.TODO(), &volumeConfig{namespace: "other"})
+
+		assert.Error(t, err)
+		assert.Nil(t, bindCfg)
 	})
 }
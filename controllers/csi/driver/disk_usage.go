@@ -0,0 +1,65 @@
+package csidriver
+
+import (
+	"os"
+	"path/filepath"
+
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/spf13/afero"
+)
+
+// updateDiskUsageMetrics sets the disk usage metric for each agent version downloaded for the tenants on the node
+func (svr *CSIDriverServer) updateDiskUsageMetrics() {
+	tenantDirs, err := svr.fs.ReadDir(svr.opts.RootDir)
+	if err != nil {
+		svr.log.Info("failed to read data directory", "error", err)
+		return
+	}
+
+	agentsDiskUsageMetric.Reset()
+	for _, tenantDir := range tenantDirs {
+		if !tenantDir.IsDir() {
+			continue
+		}
+
+		binDir := filepath.Join(svr.opts.RootDir, tenantDir.Name(), "bin")
+		versionDirs, err := svr.fs.ReadDir(binDir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				svr.log.Info("failed to read agent directory", "path", binDir, "error", err)
+			}
+			continue
+		}
+
+		for _, versionDir := range versionDirs {
+			if !versionDir.IsDir() {
+				continue
+			}
+
+			usedBytes, _, err := dirUsage(svr.fs, filepath.Join(binDir, versionDir.Name()))
+			if err != nil {
+				svr.log.Info("failed to compute disk usage", "path", filepath.Join(binDir, versionDir.Name()), "error", err)
+				continue
+			}
+
+			version, flavor := dtcsi.ParseAgentBinaryDir(versionDir.Name())
+			agentsDiskUsageMetric.WithLabelValues(tenantDir.Name(), version, flavor).Set(float64(usedBytes))
+		}
+	}
+}
+
+// dirUsage returns the bytes and inodes used by the files and directories below dir, including dir itself
+func dirUsage(fs afero.Fs, dir string) (int64, int64, error) {
+	var bytes, inodes int64
+	err := afero.Walk(fs, dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		inodes++
+		if info.Mode().IsRegular() {
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return bytes, inodes, err
+}
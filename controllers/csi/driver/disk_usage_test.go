@@ -0,0 +1,31 @@
+package csidriver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+)
+
+func TestUpdateDiskUsageMetrics(t *testing.T) {
+	agentsDiskUsageMetric.Reset()
+	server := newServerForTesting(t, mount.NewFakeMounter(nil))
+	binDir := filepath.Join(server.opts.RootDir, tenantUuid, "bin")
+
+	require.NoError(t, server.fs.MkdirAll(filepath.Join(binDir, agentVersion, "agent"), os.ModePerm))
+	require.NoError(t, server.fs.WriteFile(filepath.Join(binDir, agentVersion, "agent", "lib"), make([]byte, 300), os.ModePerm))
+	require.NoError(t, server.fs.WriteFile(filepath.Join(binDir, agentVersion, "manifest.json"), make([]byte, 100), os.ModePerm))
+	require.NoError(t, server.fs.MkdirAll(filepath.Join(binDir, agentVersion+"-musl"), os.ModePerm))
+	require.NoError(t, server.fs.WriteFile(filepath.Join(binDir, agentVersion+"-musl", "lib"), make([]byte, 200), os.ModePerm))
+	require.NoError(t, server.fs.MkdirAll(filepath.Join(server.opts.RootDir, "gc"), os.ModePerm))
+
+	server.updateDiskUsageMetrics()
+
+	assert.Equal(t, 2, testutil.CollectAndCount(agentsDiskUsageMetric))
+	assert.Equal(t, float64(400), testutil.ToFloat64(agentsDiskUsageMetric.WithLabelValues(tenantUuid, agentVersion, "multidistro")))
+	assert.Equal(t, float64(200), testutil.ToFloat64(agentsDiskUsageMetric.WithLabelValues(tenantUuid, agentVersion, "musl")))
+}
@@ -0,0 +1,77 @@
+package csidriver
+
+import (
+	"path/filepath"
+
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EventReasonQuotaExceeded is the reason of the Event recorded on a pod whose volume exceeded its quota
+const EventReasonQuotaExceeded = "VolumeQuotaExceeded"
+
+// enforceQuotas makes the overlays of volumes read-only once the pod wrote more than the quota of the volume to it, so
+// a misbehaving agent can't fill the disk of the node. The agent keeps running, but can't write logs anymore.
+func (svr *CSIDriverServer) enforceQuotas() {
+	volumes, err := svr.db.GetVolumes()
+	if err != nil {
+		svr.log.Info("failed to load volumes for enforcing quotas", "error", err.Error())
+		return
+	}
+
+	exceeding := 0
+	for _, volume := range volumes {
+		if volume.QuotaExceeded {
+			exceeding++
+			continue
+		}
+		if volume.Quota <= 0 || volume.ReadOnly || !filepath.IsAbs(volume.OverlayFSPath) {
+			continue
+		}
+
+		upperDir := filepath.Join(volume.OverlayFSPath, "var")
+		usedBytes, _, err := dirUsage(svr.fs, upperDir)
+		if err != nil {
+			svr.log.Info("failed to compute disk usage", "path", upperDir, "error", err.Error())
+			continue
+		} else if usedBytes <= volume.Quota {
+			continue
+		}
+
+		if err := svr.exceedQuota(volume, usedBytes); err != nil {
+			svr.log.Error(err, "failed to make volume exceeding its quota read-only", "volumeID", volume.VolumeID)
+			continue
+		}
+		exceeding++
+	}
+	volumesExceedingQuotaMetric.Set(float64(exceeding))
+}
+
+// exceedQuota remounts the overlay of the volume read-only, which also applies to the bind mount of the pod, records
+// it in the metadata and reports it as Event on the pod. Unpublished volumes fail to be remounted, so they are not
+// recorded again.
+func (svr *CSIDriverServer) exceedQuota(volume *metadata.Volume, usedBytes int64) error {
+	mappedDir := filepath.Join(volume.OverlayFSPath, "mapped")
+	if err := svr.mounter.Mount("overlay", mappedDir, "overlay", []string{"remount", "ro"}); err != nil {
+		return err
+	}
+
+	volume.QuotaExceeded = true
+	if err := svr.db.InsertVolume(volume); err != nil {
+		return err
+	}
+
+	svr.log.Info("volume exceeded its quota and has been made read-only",
+		"volumeID", volume.VolumeID, "pod", volume.PodName, "namespace", volume.Namespace,
+		"quota", volume.Quota, "used", usedBytes)
+
+	if svr.recorder != nil && volume.PodName != "" {
+		svr.recorder.Eventf(&metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Name: volume.PodName, Namespace: volume.Namespace},
+		}, corev1.EventTypeWarning, EventReasonQuotaExceeded,
+			"OneAgent volume exceeded its quota of %d bytes with %d bytes and has been made read-only", volume.Quota, usedBytes)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package csidriver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/mount"
+)
+
+func TestCSIDriverServer_EnforceQuotas(t *testing.T) {
+	overlayFSPath := fmt.Sprintf("/%s/run/%s", tenantUuid, volumeId)
+	upperDir := filepath.Join(overlayFSPath, "var")
+
+	setup := func(t *testing.T, quota int64, written int) (*CSIDriverServer, *mount.FakeMounter, *record.FakeRecorder) {
+		mounter := mount.NewFakeMounter([]mount.MountPoint{})
+		server := newServerForTesting(t, mounter)
+		recorder := record.NewFakeRecorder(10)
+		server.recorder = recorder
+
+		require.NoError(t, server.db.InsertVolume(&metadata.Volume{
+			VolumeID:      volumeId,
+			PodName:       "a-pod",
+			Namespace:     namespace,
+			TenantUUID:    tenantUuid,
+			Version:       agentVersion,
+			TargetPath:    testTargetPath,
+			OverlayFSPath: overlayFSPath,
+			Quota:         quota,
+		}))
+		require.NoError(t, server.fs.MkdirAll(upperDir, os.ModePerm))
+		require.NoError(t, server.fs.WriteFile(filepath.Join(upperDir, "agent.log"), make([]byte, written), os.ModePerm))
+		return &server, mounter, recorder
+	}
+
+	t.Run(`makes volume exceeding quota read-only`, func(t *testing.T) {
+		server, mounter, recorder := setup(t, 100, 300)
+
+		server.enforceQuotas()
+
+		require.Len(t, mounter.MountPoints, 1)
+		assert.Equal(t, filepath.Join(overlayFSPath, "mapped"), mounter.MountPoints[0].Path)
+		assert.Equal(t, []string{"remount", "ro"}, mounter.MountPoints[0].Opts)
+
+		volume, err := server.db.GetVolume(volumeId)
+		require.NoError(t, err)
+		assert.True(t, volume.QuotaExceeded)
+
+		require.Len(t, recorder.Events, 1)
+		assert.Contains(t, <-recorder.Events, EventReasonQuotaExceeded)
+		assert.Equal(t, float64(1), testutil.ToFloat64(volumesExceedingQuotaMetric))
+
+		server.enforceQuotas()
+
+		assert.Len(t, mounter.MountPoints, 1)
+		assert.Empty(t, recorder.Events)
+		assert.Equal(t, float64(1), testutil.ToFloat64(volumesExceedingQuotaMetric))
+	})
+	t.Run(`keeps volume within quota`, func(t *testing.T) {
+		server, mounter, recorder := setup(t, 500, 300)
+
+		server.enforceQuotas()
+
+		assert.Empty(t, mounter.MountPoints)
+		assert.Empty(t, recorder.Events)
+		assert.Equal(t, float64(0), testutil.ToFloat64(volumesExceedingQuotaMetric))
+	})
+	t.Run(`ignores volume without quota`, func(t *testing.T) {
+		server, mounter, recorder := setup(t, 0, 300)
+
+		server.enforceQuotas()
+
+		assert.Empty(t, mounter.MountPoints)
+		assert.Empty(t, recorder.Events)
+	})
+}
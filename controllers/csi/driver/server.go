@@ -18,7 +18,6 @@ package csidriver
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net"
 	"os"
@@ -28,15 +27,18 @@ import (
 	"time"
 
 	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
 	"github.com/Dynatrace/dynatrace-operator/logger"
 	"github.com/Dynatrace/dynatrace-operator/version"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/afero"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/mount"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -45,8 +47,8 @@ import (
 )
 
 const (
-	podNamespaceContextKey   = "csi.storage.k8s.io/pod.namespace"
-	versionOffsetInUsagePath = -2
+	podNamespaceContextKey = "csi.storage.k8s.io/pod.namespace"
+	podNameContextKey      = "csi.storage.k8s.io/pod.name"
 )
 
 var (
@@ -62,44 +64,68 @@ var (
 		Name:      "agent_versions",
 		Help:      "Number of an agent version currently mounted",
 	}, []string{"version"})
-	memoryMetricTick = 5000 * time.Millisecond
+	agentsDiskUsageMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dynatrace",
+		Subsystem: "csi_driver",
+		Name:      "agent_disk_usage",
+		Help:      "Disk usage of an agent version of a tenant in bytes",
+	}, []string{"tenant", "version", "flavor"})
+	volumesExceedingQuotaMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "dynatrace",
+		Subsystem: "csi_driver",
+		Name:      "volumes_exceeding_quota",
+		Help:      "Number of volumes made read-only for exceeding their quota",
+	})
+	memoryMetricTick    = 5000 * time.Millisecond
+	diskUsageMetricTick = time.Minute
 )
 
 func init() {
 	metrics.Registry.MustRegister(memoryUsageMetric)
 	metrics.Registry.MustRegister(agentsVersionsMetric)
+	metrics.Registry.MustRegister(agentsDiskUsageMetric)
+	metrics.Registry.MustRegister(volumesExceedingQuotaMetric)
 }
 
 var log = logger.NewDTLogger().WithName("server")
 
 type CSIDriverServer struct {
-	client  client.Client
-	log     logr.Logger
-	opts    dtcsi.CSIOptions
-	fs      afero.Afero
-	mounter mount.Interface
+	client   client.Client
+	log      logr.Logger
+	opts     dtcsi.CSIOptions
+	fs       afero.Afero
+	mounter  mount.Interface
+	statfs   func(path string, buf *unix.Statfs_t) error
+	db       metadata.Access
+	recorder record.EventRecorder
+	trigger  provisioningTrigger
 }
 
-type volumeMetadata struct {
-	UsageFilePath string
-	OverlayFSPath string
+// provisioningTrigger reconciles a DynaKube right away, so OneAgent versions and flavors requested by a volume are
+// provisioned while the volume is waiting for them
+type provisioningTrigger interface {
+	TriggerProvisioning(dynakube string)
 }
 
 var _ manager.Runnable = &CSIDriverServer{}
 var _ csi.IdentityServer = &CSIDriverServer{}
 var _ csi.NodeServer = &CSIDriverServer{}
 
-func NewServer(client client.Client, opts dtcsi.CSIOptions) *CSIDriverServer {
+func NewServer(client client.Client, opts dtcsi.CSIOptions, db metadata.Access, trigger provisioningTrigger) *CSIDriverServer {
 	return &CSIDriverServer{
 		client:  client,
 		log:     log,
 		opts:    opts,
 		fs:      afero.Afero{Fs: afero.NewOsFs()},
 		mounter: mount.New(""),
+		statfs:  unix.Statfs,
+		db:      db,
+		trigger: trigger,
 	}
 }
 
 func (svr *CSIDriverServer) SetupWithManager(mgr ctrl.Manager) error {
+	svr.recorder = mgr.GetEventRecorderFor(dtcsi.DriverName)
 	return mgr.Add(svr)
 }
 
@@ -115,6 +141,11 @@ func (svr *CSIDriverServer) Start(ctx context.Context) error {
 		}
 	}
 
+	// Volumes published or unpublished while the driver was down or crashed are repaired before serving requests
+	if err := svr.reconcileVolumes(); err != nil {
+		svr.log.Error(err, "failed to reconcile volumes with the mounts of the node")
+	}
+
 	svr.log.Info("Starting listener", "protocol", proto, "address", addr)
 
 	listener, err := net.Listen(proto, addr)
@@ -125,6 +156,9 @@ func (svr *CSIDriverServer) Start(ctx context.Context) error {
 	server := grpc.NewServer(grpc.UnaryInterceptor(logGRPC(log)))
 	go func() {
 		ticker := time.NewTicker(memoryMetricTick)
+		diskUsageTicker := time.NewTicker(diskUsageMetricTick)
+		svr.updateDiskUsageMetrics()
+		svr.enforceQuotas()
 		done := false
 		for !done {
 			select {
@@ -137,6 +171,9 @@ func (svr *CSIDriverServer) Start(ctx context.Context) error {
 				var m runtime.MemStats
 				runtime.ReadMemStats(&m)
 				memoryUsageMetric.Set(float64(m.Alloc))
+			case <-diskUsageTicker.C:
+				svr.updateDiskUsageMetrics()
+				svr.enforceQuotas()
 			}
 		}
 	}()
@@ -194,7 +231,7 @@ func (svr *CSIDriverServer) NodePublishVolume(ctx context.Context, req *csi.Node
 		"mountflags", req.GetVolumeCapability().GetMount().GetMountFlags(),
 	)
 
-	bindCfg, err := newBindConfig(ctx, svr, volumeCfg, svr.fs)
+	bindCfg, err := svr.waitForBindConfig(ctx, volumeCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -203,7 +240,9 @@ func (svr *CSIDriverServer) NodePublishVolume(ctx context.Context, req *csi.Node
 		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to mount oneagent volume: %s", err))
 	}
 
-	if err := svr.storeVolumeMetadata(bindCfg, volumeCfg.volumeId); err != nil {
+	if err := svr.storeVolume(bindCfg, volumeCfg); err != nil {
+		// Without metadata the volume would never be unpublished properly, kubelet retries with a clean state
+		_ = svr.umountOneAgent(volumeCfg.targetPath, overlayFSPath(bindCfg, volumeCfg))
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Failed to store volume metadata: %s", err))
 	}
 	agentsVersionsMetric.WithLabelValues(bindCfg.version).Inc()
@@ -216,25 +255,20 @@ func (svr *CSIDriverServer) NodeUnpublishVolume(_ context.Context, req *csi.Node
 		return nil, err
 	}
 
-	volumeMetadataPath := filepath.Join(svr.opts.RootDir, dtcsi.GarbageCollectionPath, volumeID)
-
-	var metadata volumeMetadata
-	if err = svr.loadVolumeMetadata(volumeMetadataPath, &metadata); err != nil {
+	volume, err := svr.db.GetVolume(volumeID)
+	if err != nil {
 		svr.log.Info("failed to load volume metadata", "error", err.Error())
 	}
-
-	if err = svr.umountOneAgent(targetPath, &metadata); err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to unmount oneagent volume: %s", err.Error()))
+	if volume == nil {
+		volume = &metadata.Volume{}
 	}
 
-	if strings.HasPrefix(metadata.UsageFilePath, svr.opts.RootDir) {
-		if err = svr.fs.Remove(metadata.UsageFilePath); err != nil && !os.IsNotExist(err) {
-			return nil, status.Error(codes.Internal, fmt.Sprintf("failed to remove version reference for pod: %s", err))
-		}
+	if err = svr.umountOneAgent(targetPath, volume.OverlayFSPath); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to unmount oneagent volume: %s", err.Error()))
 	}
 
-	if err = svr.fs.Remove(volumeMetadataPath); err != nil && !os.IsNotExist(err) {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to remove volume to pod reference: %s", err))
+	if err = svr.db.DeleteVolume(volumeID); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to remove volume metadata: %s", err))
 	}
 
 	if err = svr.fs.RemoveAll(targetPath); err != nil {
@@ -243,45 +277,139 @@ func (svr *CSIDriverServer) NodeUnpublishVolume(_ context.Context, req *csi.Node
 
 	svr.log.Info("volume has been unpublished", "targetPath", targetPath)
 
-	fireVolumeUnpublishedMetric(metadata.UsageFilePath)
+	if volume.Version != "" {
+		agentsVersionsMetric.WithLabelValues(volume.Version).Dec()
+	}
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
-func fireVolumeUnpublishedMetric(usageFilePath string) {
-	if len(usageFilePath) > 0 {
-		tmp := strings.Split(usageFilePath, string(os.PathSeparator))
-		version := tmp[len(tmp)+versionOffsetInUsagePath]
-		agentsVersionsMetric.WithLabelValues(version).Dec()
-	}
-}
-
+// NodeStageVolume is not supported, volumes are ephemeral and published per pod
 func (svr *CSIDriverServer) NodeStageVolume(context.Context, *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	return nil, status.Error(codes.Unimplemented, "NodeStageVolume is not supported, the STAGE_UNSTAGE_VOLUME capability is not advertised")
 }
 
+// NodeUnstageVolume is not supported, volumes are ephemeral and published per pod
 func (svr *CSIDriverServer) NodeUnstageVolume(context.Context, *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	return nil, status.Error(codes.Unimplemented, "NodeUnstageVolume is not supported, the STAGE_UNSTAGE_VOLUME capability is not advertised")
 }
 
 func (svr *CSIDriverServer) NodeGetInfo(context.Context, *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	return &csi.NodeGetInfoResponse{NodeId: svr.opts.NodeID}, nil
+	return &csi.NodeGetInfoResponse{
+		NodeId: svr.opts.NodeID,
+		AccessibleTopology: &csi.Topology{
+			Segments: map[string]string{dtcsi.TopologyKeyNode: svr.opts.NodeID},
+		},
+	}, nil
 }
 
 func (svr *CSIDriverServer) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	return &csi.NodeGetCapabilitiesResponse{Capabilities: []*csi.NodeServiceCapability{}}, nil
+	return &csi.NodeGetCapabilitiesResponse{Capabilities: []*csi.NodeServiceCapability{
+		newNodeServiceCapability(csi.NodeServiceCapability_RPC_GET_VOLUME_STATS),
+		newNodeServiceCapability(csi.NodeServiceCapability_RPC_VOLUME_CONDITION),
+	}}, nil
+}
+
+func newNodeServiceCapability(capability csi.NodeServiceCapability_RPC_Type) *csi.NodeServiceCapability {
+	return &csi.NodeServiceCapability{
+		Type: &csi.NodeServiceCapability_Rpc{
+			Rpc: &csi.NodeServiceCapability_RPC{Type: capability},
+		},
+	}
+}
+
+// NodeGetVolumeStats reports the usage of the overlay upper directory of the volume, which holds everything the pod
+// wrote to the volume. Total and available capacity are the ones of the filesystem of the data directory.
+func (svr *CSIDriverServer) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+
+	volumePath := req.GetVolumePath()
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume path missing in request")
+	}
+
+	if _, err := svr.fs.Stat(volumePath); os.IsNotExist(err) {
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("volume path '%s' does not exist", volumePath))
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	volume, err := svr.db.GetVolume(volumeID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to load volume metadata: %s", err))
+	} else if volume == nil {
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("volume '%s' is not published", volumeID))
+	}
+
+	// Read-only volumes have no overlay, nothing is written to them
+	var usedBytes, usedInodes int64
+	if !volume.ReadOnly {
+		upperDir := filepath.Join(volume.OverlayFSPath, "var")
+		if _, err := svr.fs.Stat(upperDir); os.IsNotExist(err) {
+			return &csi.NodeGetVolumeStatsResponse{
+				VolumeCondition: &csi.VolumeCondition{
+					Abnormal: true,
+					Message:  fmt.Sprintf("overlay directory '%s' of the volume does not exist", upperDir),
+				},
+			}, nil
+		} else if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		usedBytes, usedInodes, err = dirUsage(svr.fs, upperDir)
+		if err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("failed to compute usage of '%s': %s", upperDir, err))
+		}
+	}
+
+	var stat unix.Statfs_t
+	if err := svr.statfs(svr.opts.RootDir, &stat); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to query filesystem of '%s': %s", svr.opts.RootDir, err))
+	}
+
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:      csi.VolumeUsage_BYTES,
+				Total:     int64(stat.Blocks) * int64(stat.Bsize),
+				Available: int64(stat.Bavail) * int64(stat.Bsize),
+				Used:      usedBytes,
+			},
+			{
+				Unit:      csi.VolumeUsage_INODES,
+				Total:     int64(stat.Files),
+				Available: int64(stat.Ffree),
+				Used:      usedInodes,
+			},
+		},
+		VolumeCondition: volumeCondition(volume),
+	}, nil
 }
 
-func (svr *CSIDriverServer) NodeGetVolumeStats(context.Context, *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+func volumeCondition(volume *metadata.Volume) *csi.VolumeCondition {
+	if volume.QuotaExceeded {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("volume exceeded its quota of %d bytes and has been made read-only", volume.Quota),
+		}
+	}
+	return &csi.VolumeCondition{Message: "volume is healthy"}
 }
 
+// NodeExpandVolume is not supported, the size of the volumes is defined by the OneAgent package
 func (svr *CSIDriverServer) NodeExpandVolume(context.Context, *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	return nil, status.Error(codes.Unimplemented, "NodeExpandVolume is not supported, the EXPAND_VOLUME capability is not advertised")
 }
 
 func (svr *CSIDriverServer) mountOneAgent(bindCfg *bindConfig, volumeCfg *volumeConfig) error {
-	agentDirectoryForPod := filepath.Join(bindCfg.envDir, "run", volumeCfg.volumeId)
+	if volumeCfg.readOnly {
+		return svr.mountOneAgentReadOnly(bindCfg, volumeCfg)
+	}
+
+	agentDirectoryForPod := overlayFSPath(bindCfg, volumeCfg)
 
 	mappedDir := filepath.Join(agentDirectoryForPod, "mapped")
 	_ = svr.fs.MkdirAll(mappedDir, os.ModePerm)
@@ -313,13 +441,22 @@ func (svr *CSIDriverServer) mountOneAgent(bindCfg *bindConfig, volumeCfg *volume
 	return nil
 }
 
-func (svr *CSIDriverServer) umountOneAgent(targetPath string, metadata *volumeMetadata) error {
+// mountOneAgentReadOnly binds the OneAgent package directly to the target path, pods not needing to write to the volume,
+// e.g. to the conf directory of the agent, don't use any space on the node then
+func (svr *CSIDriverServer) mountOneAgentReadOnly(bindCfg *bindConfig, volumeCfg *volumeConfig) error {
+	if err := svr.fs.MkdirAll(volumeCfg.targetPath, os.ModePerm); err != nil {
+		return err
+	}
+	return svr.mounter.Mount(bindCfg.agentDir, volumeCfg.targetPath, "", []string{"bind", "ro"})
+}
+
+func (svr *CSIDriverServer) umountOneAgent(targetPath string, overlayFSPath string) error {
 	if err := svr.mounter.Unmount(targetPath); err != nil {
 		svr.log.Error(err, "Unmount failed", "path", targetPath)
 	}
 
-	if filepath.IsAbs(metadata.OverlayFSPath) {
-		agentDirectoryForPod := filepath.Join(metadata.OverlayFSPath, "mapped")
+	if filepath.IsAbs(overlayFSPath) {
+		agentDirectoryForPod := filepath.Join(overlayFSPath, "mapped")
 		if err := svr.mounter.Unmount(agentDirectoryForPod); err != nil {
 			svr.log.Error(err, "Unmount failed", "path", agentDirectoryForPod)
 		}
@@ -328,39 +465,26 @@ func (svr *CSIDriverServer) umountOneAgent(targetPath string, metadata *volumeMe
 	return nil
 }
 
-func (svr *CSIDriverServer) storeVolumeMetadata(bindCfg *bindConfig, volumeID string) error {
-	podToVersionReference := filepath.Join(bindCfg.volumeToVersionReferenceDir, volumeID)
-	if err := svr.fs.WriteFile(podToVersionReference, nil, 0640); err != nil {
-		return err
-	}
-
-	metadata := volumeMetadata{
-		UsageFilePath: podToVersionReference,
-		OverlayFSPath: filepath.Join(bindCfg.envDir, "run", volumeID),
-	}
-
-	volumeMetadata, err := json.Marshal(metadata)
-	if err != nil {
-		return err
-	}
-
-	metadataFile := filepath.Join(svr.opts.RootDir, dtcsi.GarbageCollectionPath, volumeID)
-	if err = svr.fs.WriteFile(metadataFile, volumeMetadata, 0640); err != nil {
-		return err
-	}
-
-	return nil
+func (svr *CSIDriverServer) storeVolume(bindCfg *bindConfig, volumeCfg *volumeConfig) error {
+	return svr.db.InsertVolume(&metadata.Volume{
+		VolumeID:      volumeCfg.volumeId,
+		PodName:       volumeCfg.podName,
+		Namespace:     volumeCfg.namespace,
+		TenantUUID:    bindCfg.tenantUUID,
+		Version:       bindCfg.version,
+		TargetPath:    volumeCfg.targetPath,
+		OverlayFSPath: overlayFSPath(bindCfg, volumeCfg),
+		Quota:         volumeCfg.quota,
+		ReadOnly:      volumeCfg.readOnly,
+	})
 }
 
-func (svr *CSIDriverServer) loadVolumeMetadata(metadataPath string, metadata *volumeMetadata) error {
-	data, err := svr.fs.ReadFile(metadataPath)
-	if err != nil {
-		return err
+// overlayFSPath returns the directory of the overlay of the volume, which is empty for read-only volumes
+func overlayFSPath(bindCfg *bindConfig, volumeCfg *volumeConfig) string {
+	if volumeCfg.readOnly {
+		return ""
 	}
-
-	err = json.Unmarshal(data, &metadata)
-
-	return err
+	return filepath.Join(bindCfg.envDir, "run", volumeCfg.volumeId)
 }
 
 func logGRPC(log logr.Logger) grpc.UnaryServerInterceptor {
@@ -3,13 +3,13 @@ package csidriver
 import (
 	"context"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
 	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
 	"github.com/Dynatrace/dynatrace-operator/webhook"
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -18,6 +18,9 @@ import (
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/mount"
@@ -150,7 +153,37 @@ func TestServer_NodePublishVolume(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, response)
 	assert.NotEmpty(t, mounter.MountPoints)
-	assertReferencesForPublishedVolume(t, mounter, server.fs)
+	assertReferencesForPublishedVolume(t, mounter, server.db)
+}
+
+func TestServer_NodePublishVolume_ReadOnly(t *testing.T) {
+	mounter := mount.NewFakeMounter([]mount.MountPoint{})
+	server := newServerForTesting(t, mounter)
+	nodePublishVolumeRequest := &csi.NodePublishVolumeRequest{
+		VolumeId: volumeId,
+		VolumeContext: map[string]string{
+			podNamespaceContextKey: namespace,
+		},
+		TargetPath: testTargetPath,
+		Readonly:   true,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		},
+	}
+	mockOneAgent(t, &server)
+
+	_, err := server.NodePublishVolume(context.TODO(), nodePublishVolumeRequest)
+
+	require.NoError(t, err)
+	require.Len(t, mounter.MountPoints, 1)
+	assert.Equal(t, testTargetPath, mounter.MountPoints[0].Path)
+	assert.Equal(t, []string{"bind", "ro"}, mounter.MountPoints[0].Opts)
+
+	volume, err := server.db.GetVolume(volumeId)
+	require.NoError(t, err)
+	require.NotNil(t, volume)
+	assert.True(t, volume.ReadOnly)
+	assert.Empty(t, volume.OverlayFSPath)
 }
 
 func TestServer_NodeUnpublishVolume(t *testing.T) {
@@ -179,7 +212,7 @@ func TestServer_NodeUnpublishVolume(t *testing.T) {
 		assert.NoError(t, err)
 		assert.NotNil(t, response)
 		assert.Empty(t, mounter.MountPoints)
-		assertNoReferencesForUnpublishedVolume(t, server.fs)
+		assertNoReferencesForUnpublishedVolume(t, server.db)
 	})
 
 	t.Run(`invalid metadata`, func(t *testing.T) {
@@ -198,7 +231,7 @@ func TestServer_NodeUnpublishVolume(t *testing.T) {
 		assert.NoError(t, err)
 		assert.NotNil(t, response)
 		assert.NotEmpty(t, mounter.MountPoints)
-		assertNoReferencesForUnpublishedVolume(t, server.fs)
+		assertNoReferencesForUnpublishedVolume(t, server.db)
 	})
 }
 
@@ -230,7 +263,7 @@ func TestCSIDriverServer_NodePublishAndUnpublishVolume(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, publishResponse)
 	assert.NotEmpty(t, mounter.MountPoints)
-	assertReferencesForPublishedVolume(t, mounter, server.fs)
+	assertReferencesForPublishedVolume(t, mounter, server.db)
 
 	unpublishResponse, err := server.NodeUnpublishVolume(context.TODO(), nodeUnpublishVolumeRequest)
 
@@ -240,31 +273,162 @@ func TestCSIDriverServer_NodePublishAndUnpublishVolume(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, unpublishResponse)
 	assert.Empty(t, mounter.MountPoints)
-	assertNoReferencesForUnpublishedVolume(t, server.fs)
+	assertNoReferencesForUnpublishedVolume(t, server.db)
 }
 
-func TestCreateAndLoadVolumeMetadata(t *testing.T) {
+func TestCSIDriverServer_NodeGetInfo(t *testing.T) {
+	server := CSIDriverServer{opts: dtcsi.CSIOptions{NodeID: "a-node"}}
+
+	response, err := server.NodeGetInfo(context.TODO(), &csi.NodeGetInfoRequest{})
+
+	require.NoError(t, err)
+	assert.Equal(t, "a-node", response.NodeId)
+	assert.Equal(t, map[string]string{dtcsi.TopologyKeyNode: "a-node"}, response.AccessibleTopology.Segments)
+}
+
+func TestCSIDriverServer_NodeGetCapabilities(t *testing.T) {
+	server := CSIDriverServer{}
+
+	response, err := server.NodeGetCapabilities(context.TODO(), &csi.NodeGetCapabilitiesRequest{})
+
+	require.NoError(t, err)
+	var capabilities []csi.NodeServiceCapability_RPC_Type
+	for _, capability := range response.Capabilities {
+		capabilities = append(capabilities, capability.GetRpc().GetType())
+	}
+	assert.ElementsMatch(t, []csi.NodeServiceCapability_RPC_Type{
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+	}, capabilities)
+}
+
+func TestCSIDriverServer_NodeGetVolumeStats(t *testing.T) {
+	upperDir := fmt.Sprintf("/%s/run/%s/var", tenantUuid, volumeId)
+	request := &csi.NodeGetVolumeStatsRequest{
+		VolumeId:   volumeId,
+		VolumePath: testTargetPath,
+	}
+
+	t.Run(`volume id missing`, func(t *testing.T) {
+		server := newServerForTesting(t, mount.NewFakeMounter(nil))
+
+		_, err := server.NodeGetVolumeStats(context.TODO(), &csi.NodeGetVolumeStatsRequest{VolumePath: testTargetPath})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+	t.Run(`volume path missing`, func(t *testing.T) {
+		server := newServerForTesting(t, mount.NewFakeMounter(nil))
+
+		_, err := server.NodeGetVolumeStats(context.TODO(), &csi.NodeGetVolumeStatsRequest{VolumeId: volumeId})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+	t.Run(`volume path does not exist`, func(t *testing.T) {
+		server := newServerForTesting(t, mount.NewFakeMounter(nil))
+		mockPublishedVolume(t, &server)
+
+		_, err := server.NodeGetVolumeStats(context.TODO(), request)
+
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+	t.Run(`volume is not published`, func(t *testing.T) {
+		server := newServerForTesting(t, mount.NewFakeMounter(nil))
+		require.NoError(t, server.fs.MkdirAll(testTargetPath, os.ModePerm))
+
+		_, err := server.NodeGetVolumeStats(context.TODO(), request)
+
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+	t.Run(`overlay directory missing`, func(t *testing.T) {
+		server := newServerForTesting(t, mount.NewFakeMounter(nil))
+		mockPublishedVolume(t, &server)
+		require.NoError(t, server.fs.MkdirAll(testTargetPath, os.ModePerm))
+
+		response, err := server.NodeGetVolumeStats(context.TODO(), request)
+
+		require.NoError(t, err)
+		assert.True(t, response.VolumeCondition.Abnormal)
+		assert.Empty(t, response.Usage)
+	})
+	t.Run(`usage of overlay directory`, func(t *testing.T) {
+		server := newServerForTesting(t, mount.NewFakeMounter(nil))
+		mockPublishedVolume(t, &server)
+		require.NoError(t, server.fs.MkdirAll(testTargetPath, os.ModePerm))
+		require.NoError(t, server.fs.MkdirAll(filepath.Join(upperDir, "log"), os.ModePerm))
+		require.NoError(t, server.fs.WriteFile(filepath.Join(upperDir, "log", "agent.log"), make([]byte, 300), os.ModePerm))
+		require.NoError(t, server.fs.WriteFile(filepath.Join(upperDir, "agent.conf"), make([]byte, 200), os.ModePerm))
+
+		response, err := server.NodeGetVolumeStats(context.TODO(), request)
+
+		require.NoError(t, err)
+		assert.False(t, response.VolumeCondition.Abnormal)
+		require.Len(t, response.Usage, 2)
+		assert.Equal(t, &csi.VolumeUsage{Unit: csi.VolumeUsage_BYTES, Total: 102400, Available: 40960, Used: 500}, response.Usage[0])
+		assert.Equal(t, &csi.VolumeUsage{Unit: csi.VolumeUsage_INODES, Total: 50, Available: 20, Used: 4}, response.Usage[1])
+	})
+	t.Run(`read-only volume uses nothing`, func(t *testing.T) {
+		server := newServerForTesting(t, mount.NewFakeMounter(nil))
+		require.NoError(t, server.db.InsertVolume(&metadata.Volume{VolumeID: volumeId, TargetPath: testTargetPath, ReadOnly: true}))
+		require.NoError(t, server.fs.MkdirAll(testTargetPath, os.ModePerm))
+
+		response, err := server.NodeGetVolumeStats(context.TODO(), request)
+
+		require.NoError(t, err)
+		assert.False(t, response.VolumeCondition.Abnormal)
+		require.Len(t, response.Usage, 2)
+		assert.Equal(t, int64(0), response.Usage[0].Used)
+	})
+	t.Run(`volume exceeding quota is abnormal`, func(t *testing.T) {
+		server := newServerForTesting(t, mount.NewFakeMounter(nil))
+		require.NoError(t, server.db.InsertVolume(&metadata.Volume{
+			VolumeID:      volumeId,
+			TargetPath:    testTargetPath,
+			OverlayFSPath: fmt.Sprintf("/%s/run/%s", tenantUuid, volumeId),
+			Quota:         100,
+			QuotaExceeded: true,
+		}))
+		require.NoError(t, server.fs.MkdirAll(testTargetPath, os.ModePerm))
+		require.NoError(t, server.fs.MkdirAll(upperDir, os.ModePerm))
+
+		response, err := server.NodeGetVolumeStats(context.TODO(), request)
+
+		require.NoError(t, err)
+		assert.True(t, response.VolumeCondition.Abnormal)
+		assert.Len(t, response.Usage, 2)
+	})
+}
+
+func TestStoreVolume(t *testing.T) {
 	mounter := mount.NewFakeMounter([]mount.MountPoint{})
 	server := newServerForTesting(t, mounter)
 
-	metadataPath := filepath.Join(server.opts.RootDir, dtcsi.GarbageCollectionPath)
-	err := server.fs.MkdirAll(metadataPath, os.ModePerm)
-	assert.NoError(t, err)
-
 	bindCfg := &bindConfig{
-		agentDir:                    "",
-		envDir:                      tenantUuid,
-		version:                     agentVersion,
-		volumeToVersionReferenceDir: filepath.Join(tenantUuid, dtcsi.GarbageCollectionPath, agentVersion),
+		agentDir:   "",
+		envDir:     filepath.Join("/", tenantUuid),
+		tenantUUID: tenantUuid,
+		version:    agentVersion,
+	}
+	volumeCfg := &volumeConfig{
+		volumeId:   volumeId,
+		targetPath: testTargetPath,
+		namespace:  namespace,
+		podName:    "a-pod",
 	}
 
-	err = server.storeVolumeMetadata(bindCfg, volumeId)
-	assert.NoError(t, err)
+	err := server.storeVolume(bindCfg, volumeCfg)
+	require.NoError(t, err)
 
-	var metadata volumeMetadata
-	err = server.loadVolumeMetadata(filepath.Join(metadataPath, volumeId), &metadata)
-	assert.NoError(t, err)
-	assert.NotNil(t, metadata)
+	volume, err := server.db.GetVolume(volumeId)
+	require.NoError(t, err)
+	assert.Equal(t, &metadata.Volume{
+		VolumeID:      volumeId,
+		PodName:       "a-pod",
+		Namespace:     namespace,
+		TenantUUID:    tenantUuid,
+		Version:       agentVersion,
+		TargetPath:    testTargetPath,
+		OverlayFSPath: filepath.Join("/", tenantUuid, "run", volumeId),
+	}, volume)
 }
 
 func newServerForTesting(t *testing.T, mounter *mount.FakeMounter) CSIDriverServer {
@@ -298,7 +462,8 @@ func newServerForTesting(t *testing.T, mounter *mount.FakeMounter) CSIDriverServ
 
 	tmpFs := afero.NewMemMapFs()
 
-	err = afero.WriteFile(tmpFs, filepath.Join(csiOptions.RootDir, "tenant-"+dkName), []byte(tenantUuid), os.ModePerm)
+	db := newTestDB(t)
+	err = db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUuid})
 	require.NoError(t, err)
 
 	return CSIDriverServer{
@@ -307,47 +472,60 @@ func newServerForTesting(t *testing.T, mounter *mount.FakeMounter) CSIDriverServ
 		opts:    csiOptions,
 		fs:      afero.Afero{Fs: tmpFs},
 		mounter: mounter,
+		statfs:  fakeStatfs,
+		db:      db,
 	}
 }
 
-func mockPublishedVolume(t *testing.T, server *CSIDriverServer) {
-	metadata := fmt.Sprintf("{\"OverlayFSPath\":\"/%s/run/%s\", \"UsageFilePath\":\"/%s/gc/%s/%s\"}", tenantUuid, volumeId, tenantUuid, agentVersion, volumeId)
+func newTestDB(t *testing.T) metadata.Access {
+	db, err := metadata.NewAccess(filepath.Join(t.TempDir(), metadata.FileName))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
 
-	err := server.fs.WriteFile(filepath.Join(server.opts.RootDir, "gc", volumeId), []byte(metadata), os.ModePerm)
+func fakeStatfs(_ string, buf *unix.Statfs_t) error {
+	buf.Bsize = 1024
+	buf.Blocks = 100
+	buf.Bavail = 40
+	buf.Files = 50
+	buf.Ffree = 20
+	return nil
+}
+
+func mockPublishedVolume(t *testing.T, server *CSIDriverServer) {
+	err := server.db.InsertVolume(&metadata.Volume{
+		VolumeID:      volumeId,
+		TenantUUID:    tenantUuid,
+		Version:       agentVersion,
+		TargetPath:    testTargetPath,
+		OverlayFSPath: fmt.Sprintf("/%s/run/%s", tenantUuid, volumeId),
+	})
 	require.NoError(t, err)
 
 	agentsVersionsMetric.WithLabelValues(agentVersion).Inc()
 }
 
 func mockOneAgent(t *testing.T, server *CSIDriverServer) {
-	err := afero.WriteFile(server.fs, filepath.Join(server.opts.RootDir, tenantUuid, dtcsi.VersionDir), []byte(agentVersion), fs.FileMode(0755))
+	err := server.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUuid, LatestVersion: agentVersion})
 	require.NoError(t, err)
 }
 
-func assertReferencesForPublishedVolume(t *testing.T, mounter *mount.FakeMounter, fs afero.Afero) {
+func assertReferencesForPublishedVolume(t *testing.T, mounter *mount.FakeMounter, db metadata.Access) {
 	assert.NotEmpty(t, mounter.MountPoints)
 
-	metadataPath := filepath.Join("/", "gc", volumeId)
-	exists, err := fs.Exists(metadataPath)
-	assert.NoError(t, err)
-	assert.True(t, exists)
-
-	versionReferencePath := filepath.Join("/", tenantUuid, "gc", agentVersion)
-	exists, err = fs.Exists(versionReferencePath)
-	assert.NoError(t, err)
-	assert.True(t, exists)
+	volume, err := db.GetVolume(volumeId)
+	require.NoError(t, err)
+	require.NotNil(t, volume)
+	assert.Equal(t, tenantUuid, volume.TenantUUID)
+	assert.Equal(t, agentVersion, volume.Version)
+	assert.Equal(t, testTargetPath, volume.TargetPath)
 }
 
-func assertNoReferencesForUnpublishedVolume(t *testing.T, fs afero.Afero) {
-	metadataPath := filepath.Join("/", "gc", volumeId)
-	exists, err := fs.Exists(metadataPath)
-	assert.NoError(t, err)
-	assert.False(t, exists)
-
-	versionReferencePath := filepath.Join("/", tenantUuid, "gc", agentVersion, volumeId)
-	exists, err = fs.Exists(versionReferencePath)
+func assertNoReferencesForUnpublishedVolume(t *testing.T, db metadata.Access) {
+	volume, err := db.GetVolume(volumeId)
 	assert.NoError(t, err)
-	assert.False(t, exists)
+	assert.Nil(t, volume)
 }
 
 func resetMetrics() {
@@ -1,15 +1,27 @@
 package csidriver
 
 import (
+	"fmt"
+
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 type volumeConfig struct {
 	volumeId   string
 	targetPath string
 	namespace  string
+	podName    string
+	flavor     string
+	version    string
+	quota      int64
+	readOnly   bool
+	volumeType string
+	dynakube   string
 }
 
 func parsePublishVolumeRequest(req *csi.NodePublishVolumeRequest) (*volumeConfig, error) {
@@ -45,9 +57,54 @@ func parsePublishVolumeRequest(req *csi.NodePublishVolumeRequest) (*volumeConfig
 		return nil, status.Error(codes.InvalidArgument, "No namespace included with request")
 	}
 
+	podName := volCtx[podNameContextKey]
+
+	volumeType := volCtx[dtcsi.TypeVolumeAttribute]
+	if !dtcsi.IsValidVolumeType(volumeType) {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Unknown volume type '%s' in request", volumeType))
+	} else if volumeType == "" {
+		volumeType = dtcsi.CodeModulesVolumeType
+	}
+
+	dynakube := volCtx[dtcsi.DynaKubeVolumeAttribute]
+	if dynakube != "" && len(validation.IsDNS1123Subdomain(dynakube)) > 0 {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid DynaKube name '%s' in request", dynakube))
+	}
+
+	flavor := volCtx[dtcsi.FlavorVolumeAttribute]
+	if flavor != "" && !dtcsi.IsValidFlavor(flavor) {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Unknown flavor '%s' in request", flavor))
+	} else if flavor != "" && volumeType == dtcsi.HostAgentVolumeType {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Flavor '%s' is not supported by volume type '%s'", flavor, volumeType))
+	}
+
+	version := volCtx[dtcsi.VersionVolumeAttribute]
+	if version != "" && !dtcsi.IsValidVersion(version) {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid version '%s' in request", version))
+	}
+
+	var quota int64
+	if value := volCtx[dtcsi.QuotaVolumeAttribute]; value != "" && volumeType == dtcsi.HostAgentVolumeType {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Quota is not supported by read-only volume type '%s'", volumeType))
+	} else if value != "" {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil || quantity.Sign() <= 0 {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid quota '%s' in request", value))
+		}
+		quota = quantity.Value()
+	}
+
 	return &volumeConfig{
 		volumeId:   volID,
 		targetPath: targetPath,
 		namespace:  nsName,
+		podName:    podName,
+		flavor:     flavor,
+		version:    version,
+		quota:      quota,
+		// The installer of the host agent is never written to by the pods
+		readOnly:   req.GetReadonly() || volumeType == dtcsi.HostAgentVolumeType,
+		volumeType: volumeType,
+		dynakube:   dynakube,
 	}, nil
 }
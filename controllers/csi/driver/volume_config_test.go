@@ -3,6 +3,7 @@ package csidriver
 import (
 	"testing"
 
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
 )
@@ -135,4 +136,175 @@ func TestCSIDriverServer_ParsePublishVolumeRequest(t *testing.T) {
 		assert.Equal(t, volumeId, volumeCfg.volumeId)
 		assert.Equal(t, targetPath, volumeCfg.targetPath)
 	})
+	t.Run(`unknown flavor`, func(t *testing.T) {
+		request := &csi.NodePublishVolumeRequest{
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			VolumeId:   volumeId,
+			TargetPath: targetPath,
+			VolumeContext: map[string]string{
+				podNamespaceContextKey:      namespace,
+				dtcsi.FlavorVolumeAttribute: "unknown",
+			},
+		}
+		volumeCfg, err := parsePublishVolumeRequest(request)
+
+		assert.EqualError(t, err, "rpc error: code = InvalidArgument desc = Unknown flavor 'unknown' in request")
+		assert.Nil(t, volumeCfg)
+	})
+	t.Run(`invalid version`, func(t *testing.T) {
+		request := &csi.NodePublishVolumeRequest{
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			VolumeId:   volumeId,
+			TargetPath: targetPath,
+			VolumeContext: map[string]string{
+				podNamespaceContextKey:       namespace,
+				dtcsi.VersionVolumeAttribute: "../../etc",
+			},
+		}
+		volumeCfg, err := parsePublishVolumeRequest(request)
+
+		assert.EqualError(t, err, "rpc error: code = InvalidArgument desc = Invalid version '../../etc' in request")
+		assert.Nil(t, volumeCfg)
+	})
+	t.Run(`quota and read-only mode are parsed`, func(t *testing.T) {
+		request := &csi.NodePublishVolumeRequest{
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			VolumeId:   volumeId,
+			TargetPath: targetPath,
+			Readonly:   true,
+			VolumeContext: map[string]string{
+				podNamespaceContextKey:     namespace,
+				dtcsi.QuotaVolumeAttribute: "100Mi",
+			},
+		}
+		volumeCfg, err := parsePublishVolumeRequest(request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(100*1024*1024), volumeCfg.quota)
+		assert.True(t, volumeCfg.readOnly)
+	})
+	t.Run(`invalid quota`, func(t *testing.T) {
+		for _, quota := range []string{"lots", "-1Mi", "0"} {
+			request := &csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+				VolumeId:   volumeId,
+				TargetPath: targetPath,
+				VolumeContext: map[string]string{
+					podNamespaceContextKey:     namespace,
+					dtcsi.QuotaVolumeAttribute: quota,
+				},
+			}
+			volumeCfg, err := parsePublishVolumeRequest(request)
+
+			assert.EqualError(t, err, "rpc error: code = InvalidArgument desc = Invalid quota '"+quota+"' in request")
+			assert.Nil(t, volumeCfg)
+		}
+	})
+	t.Run(`volume type and DynaKube are parsed`, func(t *testing.T) {
+		request := &csi.NodePublishVolumeRequest{
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			VolumeId:   volumeId,
+			TargetPath: targetPath,
+			VolumeContext: map[string]string{
+				podNamespaceContextKey:        namespace,
+				dtcsi.TypeVolumeAttribute:     dtcsi.HostAgentVolumeType,
+				dtcsi.DynaKubeVolumeAttribute: "dynakube",
+			},
+		}
+		volumeCfg, err := parsePublishVolumeRequest(request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, dtcsi.HostAgentVolumeType, volumeCfg.volumeType)
+		assert.Equal(t, "dynakube", volumeCfg.dynakube)
+		assert.True(t, volumeCfg.readOnly)
+	})
+	t.Run(`code modules by default`, func(t *testing.T) {
+		request := &csi.NodePublishVolumeRequest{
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			VolumeId:   volumeId,
+			TargetPath: targetPath,
+			VolumeContext: map[string]string{
+				podNamespaceContextKey: namespace,
+			},
+		}
+		volumeCfg, err := parsePublishVolumeRequest(request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, dtcsi.CodeModulesVolumeType, volumeCfg.volumeType)
+		assert.Empty(t, volumeCfg.dynakube)
+		assert.False(t, volumeCfg.readOnly)
+	})
+	t.Run(`invalid attributes`, func(t *testing.T) {
+		for attributes, expected := range map[[2]string]string{
+			{dtcsi.TypeVolumeAttribute, "activegate"}:    "Unknown volume type 'activegate' in request",
+			{dtcsi.DynaKubeVolumeAttribute, "Dyna_Kube"}: "Invalid DynaKube name 'Dyna_Kube' in request",
+		} {
+			request := &csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+				VolumeId:   volumeId,
+				TargetPath: targetPath,
+				VolumeContext: map[string]string{
+					podNamespaceContextKey: namespace,
+					attributes[0]:          attributes[1],
+				},
+			}
+			volumeCfg, err := parsePublishVolumeRequest(request)
+
+			assert.EqualError(t, err, "rpc error: code = InvalidArgument desc = "+expected)
+			assert.Nil(t, volumeCfg)
+		}
+	})
+	t.Run(`attributes not supported by host agent`, func(t *testing.T) {
+		for _, attribute := range [][3]string{
+			{dtcsi.FlavorVolumeAttribute, "musl", "Flavor 'musl' is not supported by volume type 'host-agent'"},
+			{dtcsi.QuotaVolumeAttribute, "10Mi", "Quota is not supported by read-only volume type 'host-agent'"},
+		} {
+			request := &csi.NodePublishVolumeRequest{
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+				VolumeId:   volumeId,
+				TargetPath: targetPath,
+				VolumeContext: map[string]string{
+					podNamespaceContextKey:    namespace,
+					dtcsi.TypeVolumeAttribute: dtcsi.HostAgentVolumeType,
+					attribute[0]:              attribute[1],
+				},
+			}
+			volumeCfg, err := parsePublishVolumeRequest(request)
+
+			assert.EqualError(t, err, "rpc error: code = InvalidArgument desc = "+attribute[2])
+			assert.Nil(t, volumeCfg)
+		}
+	})
 }
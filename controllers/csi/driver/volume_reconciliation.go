@@ -0,0 +1,112 @@
+package csidriver
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
+	"k8s.io/utils/mount"
+)
+
+// reconcileVolumes compares the volumes of the metadata store with the overlay mounts of the node and repairs both.
+// Volumes without overlay mount are removed, overlay mounts without volume are recorded, so the garbage collector
+// neither keeps unused versions forever nor removes versions which are still mounted. Read-only volumes have no overlay
+// and are kept as long as their target path is mounted. The overlay directories are left to the log garbage collection.
+func (svr *CSIDriverServer) reconcileVolumes() error {
+	mountPoints, err := svr.mounter.List()
+	if err != nil {
+		return fmt.Errorf("failed to list mounts: %w", err)
+	}
+
+	mounted := map[string]bool{}
+	overlays := map[string]mount.MountPoint{}
+	for _, mountPoint := range mountPoints {
+		mounted[mountPoint.Path] = true
+		if _, volumeID, ok := svr.parseOverlayPath(mountPoint.Path); ok {
+			overlays[volumeID] = mountPoint
+		}
+	}
+
+	volumes, err := svr.db.GetVolumes()
+	if err != nil {
+		return err
+	}
+
+	known := map[string]bool{}
+	for _, volume := range volumes {
+		if volume.ReadOnly && mounted[volume.TargetPath] {
+			agentsVersionsMetric.WithLabelValues(volume.Version).Inc()
+			continue
+		}
+		if _, ok := overlays[volume.VolumeID]; ok {
+			known[volume.VolumeID] = true
+			agentsVersionsMetric.WithLabelValues(volume.Version).Inc()
+			continue
+		}
+
+		svr.log.Info("removing volume without mount", "volumeID", volume.VolumeID, "pod", volume.PodName)
+		if err := svr.removeVolume(volume, mounted[volume.TargetPath]); err != nil {
+			return err
+		}
+	}
+
+	for volumeID, mountPoint := range overlays {
+		if known[volumeID] {
+			continue
+		}
+
+		tenantUUID, _, _ := svr.parseOverlayPath(mountPoint.Path)
+		volume := &metadata.Volume{
+			VolumeID:      volumeID,
+			TenantUUID:    tenantUUID,
+			Version:       versionOfOverlay(mountPoint),
+			OverlayFSPath: filepath.Dir(mountPoint.Path),
+		}
+
+		svr.log.Info("recording overlay mount without volume", "volumeID", volumeID, "version", volume.Version)
+		if err := svr.db.InsertVolume(volume); err != nil {
+			return err
+		}
+		agentsVersionsMetric.WithLabelValues(volume.Version).Inc()
+	}
+
+	return nil
+}
+
+// removeVolume unmounts the target path of a volume whose overlay is gone and removes its metadata
+func (svr *CSIDriverServer) removeVolume(volume *metadata.Volume, targetMounted bool) error {
+	if volume.TargetPath != "" && targetMounted {
+		if err := svr.mounter.Unmount(volume.TargetPath); err != nil {
+			svr.log.Error(err, "Unmount failed", "path", volume.TargetPath)
+		}
+	}
+
+	return svr.db.DeleteVolume(volume.VolumeID)
+}
+
+// parseOverlayPath returns the tenant and volume of an overlay mounted at <root>/<tenant>/run/<volume>/mapped
+func (svr *CSIDriverServer) parseOverlayPath(path string) (string, string, bool) {
+	rel, err := filepath.Rel(svr.opts.RootDir, path)
+	if err != nil {
+		return "", "", false
+	}
+
+	parts := strings.Split(rel, string(filepath.Separator))
+	if len(parts) != 4 || parts[0] == ".." || parts[1] != "run" || parts[3] != "mapped" {
+		return "", "", false
+	}
+	return parts[0], parts[2], true
+}
+
+// versionOfOverlay returns the OneAgent version of the lower directory of an overlay mount
+func versionOfOverlay(mountPoint mount.MountPoint) string {
+	for _, opt := range mountPoint.Opts {
+		if strings.HasPrefix(opt, "lowerdir=") {
+			version, _ := dtcsi.ParseAgentBinaryDir(filepath.Base(strings.TrimPrefix(opt, "lowerdir=")))
+			return version
+		}
+	}
+	return ""
+}
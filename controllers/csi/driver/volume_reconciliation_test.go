@@ -0,0 +1,95 @@
+package csidriver
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+)
+
+func TestCSIDriverServer_ReconcileVolumes(t *testing.T) {
+	t.Run(`keeps mounted volumes`, func(t *testing.T) {
+		resetMetrics()
+		mounter := mount.NewFakeMounter([]mount.MountPoint{
+			{Path: fmt.Sprintf("/%s/run/%s/mapped", tenantUuid, volumeId), Type: "overlay"},
+			{Path: testTargetPath},
+		})
+		server := newServerForTesting(t, mounter)
+		mockPublishedVolume(t, &server)
+		resetMetrics()
+
+		require.NoError(t, server.reconcileVolumes())
+
+		volume, err := server.db.GetVolume(volumeId)
+		require.NoError(t, err)
+		assert.NotNil(t, volume)
+		assert.Len(t, mounter.MountPoints, 2)
+		assert.Equal(t, float64(1), testutil.ToFloat64(agentsVersionsMetric.WithLabelValues(agentVersion)))
+	})
+	t.Run(`removes volumes without overlay mount`, func(t *testing.T) {
+		mounter := mount.NewFakeMounter([]mount.MountPoint{
+			{Path: testTargetPath},
+		})
+		server := newServerForTesting(t, mounter)
+		mockPublishedVolume(t, &server)
+
+		require.NoError(t, server.reconcileVolumes())
+
+		volume, err := server.db.GetVolume(volumeId)
+		require.NoError(t, err)
+		assert.Nil(t, volume)
+		assert.Empty(t, mounter.MountPoints)
+	})
+	t.Run(`keeps mounted read-only volumes`, func(t *testing.T) {
+		mounter := mount.NewFakeMounter([]mount.MountPoint{
+			{Path: testTargetPath},
+		})
+		server := newServerForTesting(t, mounter)
+		require.NoError(t, server.db.InsertVolume(&metadata.Volume{
+			VolumeID:   volumeId,
+			TenantUUID: tenantUuid,
+			Version:    agentVersion,
+			TargetPath: testTargetPath,
+			ReadOnly:   true,
+		}))
+
+		require.NoError(t, server.reconcileVolumes())
+
+		volume, err := server.db.GetVolume(volumeId)
+		require.NoError(t, err)
+		assert.NotNil(t, volume)
+		assert.Len(t, mounter.MountPoints, 1)
+	})
+	t.Run(`records overlay mounts without volume`, func(t *testing.T) {
+		resetMetrics()
+		mounter := mount.NewFakeMounter([]mount.MountPoint{
+			{
+				Device: "overlay",
+				Path:   fmt.Sprintf("/%s/run/%s/mapped", tenantUuid, volumeId),
+				Type:   "overlay",
+				Opts: []string{
+					fmt.Sprintf("lowerdir=/%s/bin/%s-musl", tenantUuid, agentVersion),
+					fmt.Sprintf("upperdir=/%s/run/%s/var", tenantUuid, volumeId),
+				},
+			},
+			{Path: "/var/lib/kubelet/pods/other/volumes/mount"},
+		})
+		server := newServerForTesting(t, mounter)
+
+		require.NoError(t, server.reconcileVolumes())
+
+		volumes, err := server.db.GetVolumes()
+		require.NoError(t, err)
+		assert.Equal(t, []*metadata.Volume{{
+			VolumeID:      volumeId,
+			TenantUUID:    tenantUuid,
+			Version:       agentVersion,
+			OverlayFSPath: fmt.Sprintf("/%s/run/%s", tenantUuid, volumeId),
+		}}, volumes)
+		assert.Equal(t, float64(1), testutil.ToFloat64(agentsVersionsMetric.WithLabelValues(agentVersion)))
+	})
+}
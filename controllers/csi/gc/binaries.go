@@ -3,6 +3,10 @@ package csigc
 import (
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
 	"github.com/go-logr/logr"
@@ -33,82 +37,211 @@ var (
 		Name:      "gc_runs",
 		Help:      "Number of GC runs",
 	})
+
+	gcDecisionsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dynatrace",
+		Subsystem: "csi_driver",
+		Name:      "gc_decisions",
+		Help:      "Number of decisions of the GC to keep or remove agent versions and logs",
+	}, []string{"resource", "decision", "reason"})
+)
+
+const (
+	resourceBinary = "binary"
+	resourceLog    = "log"
+
+	decisionKeep   = "keep"
+	decisionRemove = "remove"
+	decisionDryRun = "dry_run"
+
+	reasonLatestOrRequested = "latest_or_requested"
+	reasonInUse             = "in_use"
+	reasonRecent            = "recent"
+	reasonTooYoung          = "too_young"
+	reasonUnused            = "unused"
+	reasonMaxAge            = "max_age"
+	reasonDiskPressure      = "disk_pressure"
 )
 
 func init() {
 	metrics.Registry.MustRegister(reclaimedMemoryMetric)
 	metrics.Registry.MustRegister(foldersRemovedMetric)
 	metrics.Registry.MustRegister(gcRunsMetric)
+	metrics.Registry.MustRegister(gcDecisionsMetric)
 }
 
-func (gc *CSIGarbageCollector) runBinaryGarbageCollection(tenantUUID string, latestVersion string) {
-	fs := &afero.Afero{Fs: gc.fs}
+// binaryDir is the directory of an installed version and flavor of the OneAgent package
+type binaryDir struct {
+	path    string
+	version string
+	modTime time.Time
+}
+
+// runBinaryGarbageCollection removes the versions which are neither kept, i.e. the latest and the requested versions,
+// nor mounted by any volume nor among the most recent versions, once they reached the maximum age of the GC policy
+func (gc *CSIGarbageCollector) runBinaryGarbageCollection(tenantUUID string, keptVersions ...string) {
+	gc.collectBinaries(tenantUUID, gc.opts.GCPolicy, keptVersions)
+}
+
+func (gc *CSIGarbageCollector) collectBinaries(tenantUUID string, policy dtcsi.GCPolicy, keptVersions []string) {
 	gcRunsMetric.Inc()
-	versionReferences, err := gc.getVersionReferences(tenantUUID)
+
+	usedVersions, err := gc.db.GetUsedVersions(tenantUUID)
 	if err != nil {
-		gc.logger.Info("failed to get version references", "error", err)
+		gc.logger.Info("failed to get used versions", "error", err)
 		return
 	}
 
-	versionReferencesBase := filepath.Join(gc.opts.RootDir, tenantUUID, dtcsi.GarbageCollectionPath)
+	binaries, err := gc.getBinaries(tenantUUID)
+	if err != nil {
+		gc.logger.Info("failed to get agent binary directories", "error", err)
+		return
+	}
 
-	for _, fileInfo := range versionReferences {
-		version := fileInfo.Name()
-		references := filepath.Join(versionReferencesBase, version)
+	recent := mostRecentVersions(binaries, policy.KeepVersions)
+	for _, binary := range binaries {
+		logger := gc.logger.WithValues("version", binary.version, "path", binary.path)
 
-		shouldDelete := isNotLatestVersion(version, latestVersion, gc.logger) &&
-			shouldDeleteVersion(fs, references, gc.logger.WithValues("version", version))
+		switch {
+		case isKeptVersion(binary.version, keptVersions, logger):
+			gc.keep(resourceBinary, reasonLatestOrRequested)
+		case isUsedVersion(binary.version, usedVersions, logger):
+			gc.keep(resourceBinary, reasonInUse)
+		case recent[binary.version]:
+			logger.Info("skipped, is one of the most recent versions")
+			gc.keep(resourceBinary, reasonRecent)
+		case policy.MaxVersionAge == 0:
+			gc.remove(resourceBinary, reasonUnused, binary.path, logger)
+		case time.Since(binary.modTime) < policy.MaxVersionAge:
+			logger.Info("skipped, has not reached the maximum age")
+			gc.keep(resourceBinary, reasonTooYoung)
+		default:
+			gc.remove(resourceBinary, reasonMaxAge, binary.path, logger)
+		}
+	}
+}
 
-		if shouldDelete {
-			binaryPath := filepath.Join(gc.opts.RootDir, tenantUUID, "bin", version)
-			gc.logger.Info("deleting unused version", "version", version, "path", binaryPath)
+// getBinaries returns the directories of the installed versions and flavors of the tenant
+func (gc *CSIGarbageCollector) getBinaries(tenantUUID string) ([]binaryDir, error) {
+	binaryDirs, err := gc.getBinaryDirs(tenantUUID)
+	if err != nil {
+		return nil, err
+	}
 
-			removeUnusedVersion(fs, binaryPath, references, gc.logger)
+	binaryBase := filepath.Join(gc.opts.RootDir, tenantUUID, "bin")
+	var binaries []binaryDir
+	for _, fileInfo := range binaryDirs {
+		if !fileInfo.IsDir() {
+			continue
 		}
+
+		version, _ := dtcsi.ParseAgentBinaryDir(fileInfo.Name())
+		binaries = append(binaries, binaryDir{
+			path:    filepath.Join(binaryBase, fileInfo.Name()),
+			version: version,
+			modTime: fileInfo.ModTime(),
+		})
 	}
+	return binaries, nil
 }
 
-func (gc *CSIGarbageCollector) getVersionReferences(tenantUUID string) ([]os.FileInfo, error) {
+func (gc *CSIGarbageCollector) getBinaryDirs(tenantUUID string) ([]os.FileInfo, error) {
 	fs := &afero.Afero{Fs: gc.fs}
 
-	versionReferencesBase := filepath.Join(gc.opts.RootDir, tenantUUID, dtcsi.GarbageCollectionPath)
-	versionReferences, err := fs.ReadDir(versionReferencesBase)
+	binaryBase := filepath.Join(gc.opts.RootDir, tenantUUID, "bin")
+	binaryDirs, err := fs.ReadDir(binaryBase)
 	if err != nil {
-		exists, _ := fs.DirExists(versionReferencesBase)
+		exists, _ := fs.DirExists(binaryBase)
 		if !exists {
-			gc.logger.Info("skipped, version reference base directory not exists", "path", versionReferencesBase)
+			gc.logger.Info("skipped, agent binary base directory not exists", "path", binaryBase)
 			return nil, nil
 		}
 		return nil, errors.WithStack(err)
 	}
 
-	return versionReferences, nil
+	return binaryDirs, nil
 }
 
-func shouldDeleteVersion(fs *afero.Afero, references string, logger logr.Logger) bool {
-	podReferences, err := fs.ReadDir(references)
-	if err != nil {
-		logger.Info("skipped, failed to get references", "error", err)
-		return false
+// mostRecentVersions returns the given number of most recent versions, flavors of a version count as one version
+func mostRecentVersions(binaries []binaryDir, count int) map[string]bool {
+	var versions []string
+	seen := map[string]bool{}
+	for _, binary := range binaries {
+		if !seen[binary.version] {
+			seen[binary.version] = true
+			versions = append(versions, binary.version)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) > 0
+	})
+
+	recent := map[string]bool{}
+	for i := 0; i < count && i < len(versions); i++ {
+		recent[versions[i]] = true
+	}
+	return recent
+}
+
+// compareVersions compares the numeric parts of OneAgent versions like 1.203.0.20201029-151112
+func compareVersions(a string, b string) int {
+	isSeparator := func(r rune) bool { return r == '.' || r == '-' }
+	partsA, partsB := strings.FieldsFunc(a, isSeparator), strings.FieldsFunc(b, isSeparator)
+
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		numA, errA := strconv.Atoi(partsA[i])
+		numB, errB := strconv.Atoi(partsB[i])
+		if errA != nil || errB != nil {
+			if c := strings.Compare(partsA[i], partsB[i]); c != 0 {
+				return c
+			}
+		} else if numA != numB {
+			return numA - numB
+		}
+	}
+	return len(partsA) - len(partsB)
+}
 
-	} else if len(podReferences) > 0 {
-		logger.Info("skipped, in use", "references", len(podReferences))
-		return false
+func isUsedVersion(version string, usedVersions map[string]bool, logger logr.Logger) bool {
+	if usedVersions[version] {
+		logger.Info("skipped, in use")
+		return true
 	}
 
-	return true
+	return false
+}
+
+// keep records the decision to keep a version or log directory
+func (gc *CSIGarbageCollector) keep(resource string, reason string) {
+	gcDecisionsMetric.WithLabelValues(resource, decisionKeep, reason).Inc()
 }
 
-func isNotLatestVersion(version string, latestVersion string, logger logr.Logger) bool {
-	if version == latestVersion {
-		logger.Info("skipped, is latest")
-		return false
+// remove removes a version or log directory, in dry-run mode it is only logged
+func (gc *CSIGarbageCollector) remove(resource string, reason string, path string, logger logr.Logger) {
+	if gc.opts.GCPolicy.DryRun {
+		logger.Info("dry run, would be removed", "resource", resource, "reason", reason, "path", path)
+		gcDecisionsMetric.WithLabelValues(resource, decisionDryRun, reason).Inc()
+		return
 	}
 
-	return true
+	logger.Info("removing", "resource", resource, "reason", reason, "path", path)
+	removeDir(&afero.Afero{Fs: gc.fs}, path, logger)
+	gcDecisionsMetric.WithLabelValues(resource, decisionRemove, reason).Inc()
 }
 
-func removeUnusedVersion(fs *afero.Afero, binaryPath string, references string, logger logr.Logger) {
+func isKeptVersion(version string, keptVersions []string, logger logr.Logger) bool {
+	for _, kept := range keptVersions {
+		if version == kept {
+			logger.Info("skipped, is latest or requested", "version", version)
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeDir(fs *afero.Afero, binaryPath string, logger logr.Logger) {
 	size, _ := dirSize(fs, binaryPath)
 	err := fs.RemoveAll(binaryPath)
 	if err != nil {
@@ -117,10 +250,6 @@ func removeUnusedVersion(fs *afero.Afero, binaryPath string, references string,
 		foldersRemovedMetric.Inc()
 		reclaimedMemoryMetric.Add(float64(size))
 	}
-
-	if err := fs.RemoveAll(references); err != nil {
-		logger.Info("delete failed", "path", references)
-	}
 }
 
 func dirSize(fs *afero.Afero, path string) (int64, error) {
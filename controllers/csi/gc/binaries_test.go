@@ -1,15 +1,22 @@
 package csigc
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
+	"time"
 
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
 	"github.com/Dynatrace/dynatrace-operator/logger"
+	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
@@ -18,26 +25,26 @@ const (
 	version_2  = "2"
 	version_3  = "3"
 	rootDir    = "/tmp"
+	namespace  = "dynatrace"
 )
 
 var (
-	versionReferenceBasePath = filepath.Join(rootDir, tenantUUID, dtcsi.GarbageCollectionPath)
+	binaryBasePath = filepath.Join(rootDir, tenantUUID, "bin")
 )
 
-func TestBinaryGarbageCollector_versionReferenceGenerationSuccess(t *testing.T) {
-	gc := NewMockGarbageCollector()
+func TestBinaryGarbageCollector_binaryDirsSuccess(t *testing.T) {
+	gc := NewMockGarbageCollector(t)
 	gc.mockUnusedVersions(version_1, version_2, version_3)
 
-	versionReferences, err := gc.getVersionReferences(tenantUUID)
+	binaryDirs, err := gc.getBinaryDirs(tenantUUID)
 	assert.NoError(t, err)
 
-	assert.NotNil(t, versionReferences)
-	assert.NoError(t, err)
+	assert.Len(t, binaryDirs, 3)
 }
 
-func TestBinaryGarbageCollector_succeedsWhenVersionReferenceBaseDirectoryNotExists(t *testing.T) {
+func TestBinaryGarbageCollector_succeedsWhenBinaryBaseDirectoryNotExists(t *testing.T) {
 	resetMetrics()
-	gc := NewMockGarbageCollector()
+	gc := NewMockGarbageCollector(t)
 
 	gc.runBinaryGarbageCollection(tenantUUID, version_1)
 
@@ -48,8 +55,8 @@ func TestBinaryGarbageCollector_succeedsWhenVersionReferenceBaseDirectoryNotExis
 
 func TestBinaryGarbageCollector_succeedsWhenNoVersionsAvailable(t *testing.T) {
 	resetMetrics()
-	gc := NewMockGarbageCollector()
-	_ = gc.fs.MkdirAll(versionReferenceBasePath, 0770)
+	gc := NewMockGarbageCollector(t)
+	_ = gc.fs.MkdirAll(binaryBasePath, 0770)
 
 	gc.runBinaryGarbageCollection(tenantUUID, version_1)
 
@@ -60,7 +67,7 @@ func TestBinaryGarbageCollector_succeedsWhenNoVersionsAvailable(t *testing.T) {
 
 func TestBinaryGarbageCollector_ignoresLatest(t *testing.T) {
 	resetMetrics()
-	gc := NewMockGarbageCollector()
+	gc := NewMockGarbageCollector(t)
 	gc.mockUnusedVersions(version_1)
 
 	gc.runBinaryGarbageCollection(tenantUUID, version_1)
@@ -74,7 +81,7 @@ func TestBinaryGarbageCollector_ignoresLatest(t *testing.T) {
 
 func TestBinaryGarbageCollector_removesUnused(t *testing.T) {
 	resetMetrics()
-	gc := NewMockGarbageCollector()
+	gc := NewMockGarbageCollector(t)
 	gc.mockUnusedVersions(version_1, version_2, version_3)
 
 	gc.runBinaryGarbageCollection(tenantUUID, version_2)
@@ -85,9 +92,73 @@ func TestBinaryGarbageCollector_removesUnused(t *testing.T) {
 	gc.assertVersionNotExists(t, version_1, version_3)
 }
 
+func TestBinaryGarbageCollector_removesUnusedFlavors(t *testing.T) {
+	resetMetrics()
+	gc := NewMockGarbageCollector(t)
+	gc.mockUnusedVersions(version_1, version_1+"-musl", version_2, version_2+"-musl")
+
+	gc.runBinaryGarbageCollection(tenantUUID, version_2)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(foldersRemovedMetric))
+
+	gc.assertVersionNotExists(t, version_1, version_1+"-musl")
+	gc.assertVersionExists(t, version_2, version_2+"-musl")
+}
+
+func TestBinaryGarbageCollector_keepsMostRecentVersions(t *testing.T) {
+	resetMetrics()
+	gc := NewMockGarbageCollector(t)
+	gc.opts.GCPolicy.KeepVersions = 2
+	gc.mockUnusedVersions("1.199.0", "1.200.0", "1.201.0", "1.201.0-musl", "1.202.0")
+
+	gc.runBinaryGarbageCollection(tenantUUID, "1.202.0")
+
+	gc.assertVersionExists(t, "1.201.0", "1.201.0-musl", "1.202.0")
+	gc.assertVersionNotExists(t, "1.199.0", "1.200.0")
+	assert.Equal(t, float64(1), testutil.ToFloat64(gcDecisionsMetric.WithLabelValues(resourceBinary, decisionKeep, reasonLatestOrRequested)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(gcDecisionsMetric.WithLabelValues(resourceBinary, decisionKeep, reasonRecent)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(gcDecisionsMetric.WithLabelValues(resourceBinary, decisionRemove, reasonUnused)))
+}
+
+func TestBinaryGarbageCollector_removesVersionsOlderThanMaxAge(t *testing.T) {
+	resetMetrics()
+	gc := NewMockGarbageCollector(t)
+	gc.opts.GCPolicy.MaxVersionAge = 24 * time.Hour
+	gc.mockUnusedVersions(version_1, version_2, version_3)
+	_ = gc.fs.Chtimes(filepath.Join(binaryBasePath, version_1), time.Now(), time.Now().Add(-48*time.Hour))
+	_ = gc.fs.Chtimes(filepath.Join(binaryBasePath, version_2), time.Now(), time.Now())
+
+	gc.runBinaryGarbageCollection(tenantUUID, version_3)
+
+	gc.assertVersionNotExists(t, version_1)
+	gc.assertVersionExists(t, version_2, version_3)
+	assert.Equal(t, float64(1), testutil.ToFloat64(gcDecisionsMetric.WithLabelValues(resourceBinary, decisionRemove, reasonMaxAge)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(gcDecisionsMetric.WithLabelValues(resourceBinary, decisionKeep, reasonTooYoung)))
+}
+
+func TestBinaryGarbageCollector_dryRun(t *testing.T) {
+	resetMetrics()
+	gc := NewMockGarbageCollector(t)
+	gc.opts.GCPolicy.DryRun = true
+	gc.mockUnusedVersions(version_1, version_2)
+
+	gc.runBinaryGarbageCollection(tenantUUID, version_2)
+
+	assert.Equal(t, float64(0), testutil.ToFloat64(foldersRemovedMetric))
+	assert.Equal(t, float64(1), testutil.ToFloat64(gcDecisionsMetric.WithLabelValues(resourceBinary, decisionDryRun, reasonUnused)))
+	gc.assertVersionExists(t, version_1, version_2)
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Zero(t, compareVersions("1.203.0", "1.203.0"))
+	assert.Greater(t, compareVersions("1.203.0", "1.99.0"), 0)
+	assert.Less(t, compareVersions("1.203.0.20201029-151112", "1.203.0.20201030-090000"), 0)
+	assert.Greater(t, compareVersions("1.203.0.20201029-151112", "1.203.0"), 0)
+}
+
 func TestBinaryGarbageCollector_ignoresUsed(t *testing.T) {
 	resetMetrics()
-	gc := NewMockGarbageCollector()
+	gc := NewMockGarbageCollector(t)
 	gc.mockUsedVersions(version_1, version_2, version_3)
 
 	gc.runBinaryGarbageCollection(tenantUUID, version_3)
@@ -99,29 +170,50 @@ func TestBinaryGarbageCollector_ignoresUsed(t *testing.T) {
 	gc.assertVersionExists(t, version_1, version_2, version_3)
 }
 
-func NewMockGarbageCollector() *CSIGarbageCollector {
+func TestBinaryGarbageCollector_ignoresRequested(t *testing.T) {
+	resetMetrics()
+	gc := NewMockGarbageCollector(t)
+	gc.mockUnusedVersions(version_1, version_2, version_3)
+	gc.client = fake.NewClient(&dynatracev1alpha1.DynaKube{ObjectMeta: metav1.ObjectMeta{Name: "dynakube", Namespace: namespace}})
+	_ = gc.db.UpdateTenant(&metadata.Tenant{DynakubeName: "dynakube", TenantUUID: tenantUUID, LatestVersion: version_3, RequestedVersions: []string{version_1}, HostAgentVersions: []string{version_2}})
+
+	keptVersions, _, err := gc.getTenantVersions(context.TODO())
+	require.NoError(t, err)
+	gc.runBinaryGarbageCollection(tenantUUID, keptVersions[tenantUUID]...)
+
+	assert.Equal(t, float64(0), testutil.ToFloat64(foldersRemovedMetric))
+	gc.assertVersionExists(t, version_1, version_2, version_3)
+}
+
+func NewMockGarbageCollector(t *testing.T) *CSIGarbageCollector {
+	db, err := metadata.NewAccess(filepath.Join(t.TempDir(), metadata.FileName))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
 	return &CSIGarbageCollector{
+		client: fake.NewClient(),
 		logger: logger.NewDTLogger(),
 		opts:   dtcsi.CSIOptions{RootDir: rootDir},
 		fs:     afero.NewMemMapFs(),
+		db:     db,
 	}
 }
 
 func (gc *CSIGarbageCollector) mockUnusedVersions(versions ...string) {
 	for _, version := range versions {
-		_ = gc.fs.MkdirAll(filepath.Join(versionReferenceBasePath, version), 0770)
+		_ = gc.fs.MkdirAll(filepath.Join(binaryBasePath, version), 0770)
 	}
 }
 func (gc *CSIGarbageCollector) mockUsedVersions(versions ...string) {
 	for _, version := range versions {
-		_ = gc.fs.MkdirAll(filepath.Join(versionReferenceBasePath, version), 0770)
-		_, _ = gc.fs.Create(filepath.Join(versionReferenceBasePath, version, "somePodID"))
+		_ = gc.fs.MkdirAll(filepath.Join(binaryBasePath, version), 0770)
+		_ = gc.db.InsertVolume(&metadata.Volume{VolumeID: "volume-" + version, TenantUUID: tenantUUID, Version: version})
 	}
 }
 
 func (gc *CSIGarbageCollector) assertVersionNotExists(t *testing.T, versions ...string) {
 	for _, version := range versions {
-		exists, err := afero.DirExists(gc.fs, filepath.Join(versionReferenceBasePath, version))
+		exists, err := afero.DirExists(gc.fs, filepath.Join(binaryBasePath, version))
 		assert.False(t, exists)
 		assert.NoError(t, err)
 	}
@@ -129,7 +221,7 @@ func (gc *CSIGarbageCollector) assertVersionNotExists(t *testing.T, versions ...
 
 func (gc *CSIGarbageCollector) assertVersionExists(t *testing.T, versions ...string) {
 	for _, version := range versions {
-		exists, err := afero.DirExists(gc.fs, filepath.Join(versionReferenceBasePath, version))
+		exists, err := afero.DirExists(gc.fs, filepath.Join(binaryBasePath, version))
 		assert.True(t, exists)
 		assert.NoError(t, err)
 	}
@@ -152,4 +244,5 @@ func resetMetrics() {
 		Subsystem: "csi_driver",
 		Name:      "gc_memory_reclaimed",
 	})
+	gcDecisionsMetric.Reset()
 }
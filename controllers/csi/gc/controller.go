@@ -2,42 +2,52 @@ package csigc
 
 import (
 	"context"
+	"fmt"
+	"path/filepath"
 
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
-	"github.com/Dynatrace/dynatrace-operator/controllers/dynakube"
-	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
 	"github.com/go-logr/logr"
 	"github.com/spf13/afero"
-	corev1 "k8s.io/api/core/v1"
+	"golang.org/x/sys/unix"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 // CSIGarbageCollector removes unused and outdated agent versions
 type CSIGarbageCollector struct {
-	client       client.Client
-	logger       logr.Logger
-	opts         dtcsi.CSIOptions
-	dtcBuildFunc dynakube.DynatraceClientFunc
-	fs           afero.Fs
+	client client.Client
+	logger logr.Logger
+	opts   dtcsi.CSIOptions
+	fs     afero.Fs
+	db     metadata.Access
+	statfs func(path string, buf *unix.Statfs_t) error
 }
 
 // NewReconciler returns a new CSIGarbageCollector
-func NewReconciler(client client.Client, opts dtcsi.CSIOptions) *CSIGarbageCollector {
+func NewReconciler(client client.Client, opts dtcsi.CSIOptions, db metadata.Access) *CSIGarbageCollector {
 	return &CSIGarbageCollector{
-		client:       client,
-		logger:       log.Log.WithName("csi.gc.controller"),
-		opts:         opts,
-		dtcBuildFunc: dynakube.BuildDynatraceClient,
-		fs:           afero.NewOsFs(),
+		client: client,
+		logger: log.Log.WithName("csi.gc.controller"),
+		opts:   opts,
+		fs:     afero.NewOsFs(),
+		db:     db,
+		statfs: unix.Statfs,
 	}
 }
 
 func (gc *CSIGarbageCollector) SetupWithManager(mgr ctrl.Manager) error {
+	if gc.opts.GCPolicy.DiskHighWatermark > 0 {
+		if err := mgr.Add(manager.RunnableFunc(gc.monitorDiskPressure)); err != nil {
+			return err
+		}
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&dynatracev1alpha1.DynaKube{}).
 		Complete(gc)
@@ -50,45 +60,131 @@ func (gc *CSIGarbageCollector) Reconcile(ctx context.Context, request reconcile.
 	reconcileResult := reconcile.Result{RequeueAfter: gc.opts.GCInterval}
 
 	var dk dynatracev1alpha1.DynaKube
-	if err := gc.client.Get(ctx, request.NamespacedName, &dk); err != nil {
-		if k8serrors.IsNotFound(err) {
-			gc.logger.Info("given DynaKube object not found")
-			return reconcileResult, nil
-		}
-
+	dkDeleted := false
+	if err := gc.client.Get(ctx, request.NamespacedName, &dk); k8serrors.IsNotFound(err) {
+		gc.logger.Info("given DynaKube object not found, collecting its tenant")
+		dkDeleted = true
+	} else if err != nil {
 		gc.logger.Error(err, "failed to get DynaKube object")
 		return reconcileResult, nil
 	}
 
-	var tokens corev1.Secret
-	if err := gc.client.Get(ctx, client.ObjectKey{Name: dk.Tokens(), Namespace: dk.Namespace}, &tokens); err != nil {
-		gc.logger.Error(err, "failed to query tokens")
+	keptVersions, deletedTenants, err := gc.getTenantVersions(ctx)
+	if err != nil {
+		gc.logger.Error(err, "failed to get tenants")
 		return reconcileResult, nil
 	}
 
-	dtc, err := gc.dtcBuildFunc(gc.client, &dk, &tokens)
-	if err != nil {
-		gc.logger.Error(err, "failed to create Dynatrace client")
-		return reconcileResult, nil
+	for tenantUUID, kept := range keptVersions {
+		gc.logger.Info("running binary garbage collection", "tenantUUID", tenantUUID)
+		gc.runBinaryGarbageCollection(tenantUUID, kept...)
+
+		gc.logger.Info("running log garbage collection", "tenantUUID", tenantUUID)
+		gc.runLogGarbageCollection(tenantUUID)
 	}
 
-	ci, err := dtc.GetConnectionInfo()
-	if err != nil {
-		gc.logger.Info("failed to fetch connection info")
-		return reconcileResult, nil
+	pending := false
+	for _, tenant := range deletedTenants {
+		if !gc.collectDeletedTenant(tenant, keptVersions) && tenant.DynakubeName == request.Name {
+			pending = true
+		}
 	}
 
-	latestAgentVersion, err := dtc.GetLatestAgentVersion(dtclient.OsUnix, dtclient.InstallerTypePaaS)
+	if dkDeleted && !pending {
+		return reconcile.Result{}, nil
+	}
+	return reconcileResult, nil
+}
+
+// getTenantVersions returns the versions to keep per tenant UUID of the existing DynaKubes, i.e. their latest and
+// requested versions of the OneAgent package and the host agent, as well as the tenants whose DynaKube was deleted. It
+// only relies on the metadata store and the DynaKube status, so the garbage collection keeps working while the
+// Dynatrace API is unreachable.
+func (gc *CSIGarbageCollector) getTenantVersions(ctx context.Context) (map[string][]string, []*metadata.Tenant, error) {
+	var dynakubes dynatracev1alpha1.DynaKubeList
+	if err := gc.client.List(ctx, &dynakubes); err != nil {
+		return nil, nil, fmt.Errorf("failed to list DynaKubes: %w", err)
+	}
+
+	tenants, err := gc.db.GetTenants()
 	if err != nil {
-		gc.logger.Info("failed to query OneAgent version")
-		return reconcileResult, nil
+		return nil, nil, err
 	}
 
-	gc.logger.Info("running binary garbage collection")
-	gc.runBinaryGarbageCollection(ci.TenantUUID, latestAgentVersion)
+	existing := map[string]*dynatracev1alpha1.DynaKube{}
+	for i := range dynakubes.Items {
+		existing[dynakubes.Items[i].Name] = &dynakubes.Items[i]
+	}
 
-	gc.logger.Info("running log garbage collection")
-	gc.runLogGarbageCollection(ci.TenantUUID)
+	// DynaKubes connected to the same tenant share its versions
+	keptVersions := map[string][]string{}
+	var deletedTenants []*metadata.Tenant
+	for _, tenant := range tenants {
+		dk, ok := existing[tenant.DynakubeName]
+		if !ok {
+			deletedTenants = append(deletedTenants, tenant)
+			continue
+		}
 
-	return reconcileResult, nil
+		kept := append(keptVersions[tenant.TenantUUID], tenant.LatestVersion)
+		if dk.Status.LatestAgentVersionUnixPaas != "" {
+			kept = append(kept, dk.Status.LatestAgentVersionUnixPaas)
+		}
+		kept = append(kept, tenant.RequestedVersions...)
+		keptVersions[tenant.TenantUUID] = append(kept, tenant.HostAgentVersions...)
+	}
+	return keptVersions, deletedTenants, nil
+}
+
+// collectDeletedTenant removes the unused versions of a tenant whose DynaKube was deleted regardless of the GC policy,
+// and the logs of its unpublished volumes once they reached the maximum age. The tenant is removed from the metadata
+// store as soon as neither versions nor logs are left, or if another DynaKube is connected to the same tenant.
+// Returns if the tenant has been removed.
+func (gc *CSIGarbageCollector) collectDeletedTenant(tenant *metadata.Tenant, keptVersions map[string][]string) bool {
+	logger := gc.logger.WithValues("dynakube", tenant.DynakubeName, "tenantUUID", tenant.TenantUUID)
+
+	if _, ok := keptVersions[tenant.TenantUUID]; !ok {
+		logger.Info("running garbage collection for deleted DynaKube")
+		policy := gc.opts.GCPolicy
+		policy.KeepVersions = 0
+		policy.MaxVersionAge = 0
+		gc.collectBinaries(tenant.TenantUUID, policy, nil)
+		gc.runLogGarbageCollection(tenant.TenantUUID)
+
+		tenantDir := filepath.Join(gc.opts.RootDir, tenant.TenantUUID)
+		if !gc.isEmptyTenantDir(tenantDir) {
+			logger.Info("keeping deleted DynaKube, versions or logs are left")
+			return false
+		}
+
+		if gc.opts.GCPolicy.DryRun {
+			logger.Info("dry run, would have removed tenant directory", "path", tenantDir)
+			return true
+		}
+		if err := gc.fs.RemoveAll(tenantDir); err != nil {
+			logger.Info("failed to remove tenant directory", "path", tenantDir, "error", err)
+			return false
+		}
+	}
+
+	if err := gc.db.DeleteTenant(tenant.DynakubeName); err != nil {
+		logger.Info("failed to remove deleted DynaKube", "error", err)
+		return false
+	}
+	logger.Info("removed deleted DynaKube")
+	return true
+}
+
+// isEmptyTenantDir returns if the tenant directory holds neither versions nor logs
+func (gc *CSIGarbageCollector) isEmptyTenantDir(tenantDir string) bool {
+	for _, dir := range []string{"bin", "run"} {
+		path := filepath.Join(tenantDir, dir)
+		if exists, _ := afero.DirExists(gc.fs, path); !exists {
+			continue
+		}
+		if empty, err := afero.IsEmpty(gc.fs, path); err != nil || !empty {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,125 @@
+package csigc
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
+	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	dkName         = "dynakube"
+	otherTenant    = "def67890"
+	gcTestInterval = 5 * time.Minute
+)
+
+func newReconcileRequest(name string) reconcile.Request {
+	return reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+}
+
+func TestCSIGarbageCollector_Reconcile(t *testing.T) {
+	setup := func(t *testing.T, dynakubes ...*dynatracev1alpha1.DynaKube) *CSIGarbageCollector {
+		resetMetrics()
+		gc := NewMockGarbageCollector(t)
+		gc.opts.GCInterval = gcTestInterval
+
+		objs := make([]client.Object, 0, len(dynakubes))
+		for _, dk := range dynakubes {
+			objs = append(objs, dk)
+		}
+		gc.client = fake.NewClient(objs...)
+		return gc
+	}
+
+	t.Run(`keeps latest version of DynaKube status without Dynatrace API`, func(t *testing.T) {
+		gc := setup(t, &dynatracev1alpha1.DynaKube{
+			ObjectMeta: metav1.ObjectMeta{Name: dkName, Namespace: namespace},
+			Status:     dynatracev1alpha1.DynaKubeStatus{LatestAgentVersionUnixPaas: version_3},
+		})
+		require.NoError(t, gc.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUUID, LatestVersion: version_2}))
+		gc.mockUnusedVersions(version_1, version_2, version_3)
+
+		result, err := gc.Reconcile(context.TODO(), newReconcileRequest(dkName))
+
+		require.NoError(t, err)
+		assert.Equal(t, reconcile.Result{RequeueAfter: gcTestInterval}, result)
+		gc.assertVersionNotExists(t, version_1)
+		gc.assertVersionExists(t, version_2, version_3)
+	})
+	t.Run(`skips DynaKube which has not been provisioned`, func(t *testing.T) {
+		gc := setup(t, &dynatracev1alpha1.DynaKube{ObjectMeta: metav1.ObjectMeta{Name: dkName, Namespace: namespace}})
+		gc.mockUnusedVersions(version_1)
+
+		result, err := gc.Reconcile(context.TODO(), newReconcileRequest(dkName))
+
+		require.NoError(t, err)
+		assert.Equal(t, reconcile.Result{RequeueAfter: gcTestInterval}, result)
+		gc.assertVersionExists(t, version_1)
+	})
+	t.Run(`removes tenant of deleted DynaKube`, func(t *testing.T) {
+		gc := setup(t)
+		gc.opts.GCPolicy.KeepVersions = 5
+		require.NoError(t, gc.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUUID, LatestVersion: version_2}))
+		gc.mockUnusedVersions(version_1, version_2)
+
+		result, err := gc.Reconcile(context.TODO(), newReconcileRequest(dkName))
+
+		require.NoError(t, err)
+		assert.Equal(t, reconcile.Result{}, result)
+		gc.assertDirExists(t, false, filepath.Join(rootDir, tenantUUID))
+		tenant, err := gc.db.GetTenant(dkName)
+		require.NoError(t, err)
+		assert.Nil(t, tenant)
+	})
+	t.Run(`keeps used versions and logs of deleted DynaKube`, func(t *testing.T) {
+		gc := setup(t)
+		gc.opts.GCPolicy.MaxLogAge = time.Hour
+		require.NoError(t, gc.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUUID, LatestVersion: version_2}))
+		gc.mockUnusedVersions(version_1, version_2)
+		gc.mockUsedVersions(version_3)
+		gc.mockUnmountedVolumeIDPath("volume")
+		require.NoError(t, gc.fs.Chtimes(filepath.Join(logPath, "volume"), time.Now(), time.Now()))
+
+		result, err := gc.Reconcile(context.TODO(), newReconcileRequest(dkName))
+
+		require.NoError(t, err)
+		assert.Equal(t, reconcile.Result{RequeueAfter: gcTestInterval}, result)
+		gc.assertVersionNotExists(t, version_1, version_2)
+		gc.assertVersionExists(t, version_3)
+		gc.assertDirExists(t, true, filepath.Join(logPath, "volume"))
+		tenant, err := gc.db.GetTenant(dkName)
+		require.NoError(t, err)
+		assert.NotNil(t, tenant)
+	})
+	t.Run(`keeps versions of tenant shared with existing DynaKube`, func(t *testing.T) {
+		gc := setup(t, &dynatracev1alpha1.DynaKube{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: namespace}})
+		require.NoError(t, gc.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUUID, LatestVersion: version_1}))
+		require.NoError(t, gc.db.UpdateTenant(&metadata.Tenant{DynakubeName: "other", TenantUUID: tenantUUID, LatestVersion: version_2}))
+		require.NoError(t, gc.db.UpdateTenant(&metadata.Tenant{DynakubeName: "third", TenantUUID: otherTenant, LatestVersion: version_2}))
+		gc.mockUnusedVersions(version_1, version_2)
+
+		result, err := gc.Reconcile(context.TODO(), newReconcileRequest(dkName))
+
+		require.NoError(t, err)
+		assert.Equal(t, reconcile.Result{}, result)
+		gc.assertVersionNotExists(t, version_1)
+		gc.assertVersionExists(t, version_2)
+
+		tenants, err := gc.db.GetTenants()
+		require.NoError(t, err)
+		assert.Equal(t, []*metadata.Tenant{{DynakubeName: "other", TenantUUID: tenantUUID, LatestVersion: version_2}}, tenants)
+		exists, _ := afero.DirExists(gc.fs, filepath.Join(rootDir, otherTenant))
+		assert.False(t, exists)
+	})
+}
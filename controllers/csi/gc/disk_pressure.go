@@ -0,0 +1,152 @@
+package csigc
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/afero"
+	"golang.org/x/sys/unix"
+)
+
+const diskPressureCheckInterval = time.Minute
+
+// removalCandidate is an unused version or log directory which can be removed under disk pressure
+type removalCandidate struct {
+	resource string
+	path     string
+	modTime  time.Time
+}
+
+// monitorDiskPressure checks the disk usage of the data directory periodically and collects as soon as it exceeds the
+// high-water mark, instead of waiting for the next garbage collection interval
+func (gc *CSIGarbageCollector) monitorDiskPressure(ctx context.Context) error {
+	ticker := time.NewTicker(diskPressureCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		gc.runDiskPressureGarbageCollection(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDiskPressureGarbageCollection runs the regular collection for all tenants if the disk usage exceeds the high-water
+// mark. If that is not sufficient, unused versions and logs are removed regardless of the GC policy, oldest first, until
+// the disk usage is below the high-water mark again. Tenants of deleted DynaKubes keep no versions at all.
+func (gc *CSIGarbageCollector) runDiskPressureGarbageCollection(ctx context.Context) {
+	if gc.opts.GCPolicy.DiskHighWatermark <= 0 {
+		return
+	}
+
+	if underPressure, _ := gc.isUnderDiskPressure(); !underPressure {
+		return
+	}
+
+	keptVersions, deletedTenants, err := gc.getTenantVersions(ctx)
+	if err != nil {
+		gc.logger.Info("failed to get tenants", "error", err)
+		return
+	}
+
+	gc.logger.Info("disk usage above high-water mark, running garbage collection", "watermark", gc.opts.GCPolicy.DiskHighWatermark)
+	for tenantUUID, kept := range keptVersions {
+		gc.runBinaryGarbageCollection(tenantUUID, kept...)
+		gc.runLogGarbageCollection(tenantUUID)
+	}
+	for _, tenant := range deletedTenants {
+		gc.collectDeletedTenant(tenant, keptVersions)
+	}
+
+	underPressure, excessBytes := gc.isUnderDiskPressure()
+	if !underPressure {
+		return
+	}
+
+	var candidates []removalCandidate
+	for tenantUUID, kept := range keptVersions {
+		candidates = append(candidates, gc.getRemovalCandidates(tenantUUID, kept)...)
+	}
+	for _, tenant := range deletedTenants {
+		if _, ok := keptVersions[tenant.TenantUUID]; !ok {
+			keptVersions[tenant.TenantUUID] = nil
+			candidates = append(candidates, gc.getRemovalCandidates(tenant.TenantUUID, nil)...)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].modTime.Before(candidates[j].modTime)
+	})
+
+	fs := &afero.Afero{Fs: gc.fs}
+	for _, candidate := range candidates {
+		if excessBytes <= 0 {
+			break
+		}
+
+		// The freed space is estimated, so the dry-run mode reports the same decisions
+		size, _ := dirSize(fs, candidate.path)
+		gc.remove(candidate.resource, reasonDiskPressure, candidate.path, gc.logger)
+		excessBytes -= size
+	}
+
+	if excessBytes > 0 {
+		gc.logger.Info("disk usage still above high-water mark, no more unused versions or logs to remove")
+	}
+}
+
+// getRemovalCandidates returns the versions which are neither kept nor used and the logs of unpublished volumes
+func (gc *CSIGarbageCollector) getRemovalCandidates(tenantUUID string, keptVersions []string) []removalCandidate {
+	var candidates []removalCandidate
+
+	usedVersions, err := gc.db.GetUsedVersions(tenantUUID)
+	if err != nil {
+		gc.logger.Info("failed to get used versions", "error", err)
+		return nil
+	}
+
+	binaries, err := gc.getBinaries(tenantUUID)
+	if err != nil {
+		gc.logger.Info("failed to get agent binary directories", "error", err)
+	}
+	for _, binary := range binaries {
+		if !isKeptVersion(binary.version, keptVersions, logr.Discard()) && !usedVersions[binary.version] {
+			candidates = append(candidates, removalCandidate{resource: resourceBinary, path: binary.path, modTime: binary.modTime})
+		}
+	}
+
+	agentDirectoryForPod := filepath.Join(gc.opts.RootDir, tenantUUID, "run")
+	unusedVolumeIDs, err := gc.getUnusedVolumeIDs(agentDirectoryForPod)
+	if err != nil {
+		gc.logger.Info("failed to get log file information", "error", err)
+	}
+	for _, volumeID := range unusedVolumeIDs {
+		candidates = append(candidates, removalCandidate{
+			resource: resourceLog,
+			path:     filepath.Join(agentDirectoryForPod, volumeID.Name()),
+			modTime:  volumeID.ModTime(),
+		})
+	}
+
+	return candidates
+}
+
+// isUnderDiskPressure returns if the disk usage of the data directory exceeds the high-water mark and by how many bytes
+func (gc *CSIGarbageCollector) isUnderDiskPressure() (bool, int64) {
+	var stat unix.Statfs_t
+	if err := gc.statfs(gc.opts.RootDir, &stat); err != nil {
+		gc.logger.Info("failed to query disk usage", "path", gc.opts.RootDir, "error", err)
+		return false, 0
+	}
+
+	total := int64(stat.Blocks) * int64(stat.Bsize)
+	used := total - int64(stat.Bavail)*int64(stat.Bsize)
+	excess := used - total*int64(gc.opts.GCPolicy.DiskHighWatermark)/100
+
+	return excess > 0, excess
+}
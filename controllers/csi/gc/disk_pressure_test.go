@@ -0,0 +1,84 @@
+package csigc
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
+	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeStatfs reports a filesystem of 100 bytes with the given number of available bytes
+func fakeStatfs(available uint64) func(string, *unix.Statfs_t) error {
+	return func(_ string, buf *unix.Statfs_t) error {
+		buf.Bsize = 1
+		buf.Blocks = 100
+		buf.Bavail = available
+		return nil
+	}
+}
+
+func TestDiskPressureGarbageCollector(t *testing.T) {
+	setup := func(t *testing.T, available uint64) *CSIGarbageCollector {
+		resetMetrics()
+		gc := NewMockGarbageCollector(t)
+		gc.client = fake.NewClient(&dynatracev1alpha1.DynaKube{ObjectMeta: metav1.ObjectMeta{Name: "dynakube", Namespace: namespace}})
+		gc.statfs = fakeStatfs(available)
+		gc.opts.GCPolicy.KeepVersions = 5
+		gc.opts.GCPolicy.MaxLogAge = 24 * time.Hour
+		gc.opts.GCPolicy.DiskHighWatermark = 50
+
+		require.NoError(t, gc.db.UpdateTenant(&metadata.Tenant{DynakubeName: "dynakube", TenantUUID: tenantUUID, LatestVersion: version_3}))
+		gc.mockUnusedVersions(version_1, version_2, version_3)
+		for _, version := range []string{version_1, version_2, version_3} {
+			require.NoError(t, afero.WriteFile(gc.fs, filepath.Join(binaryBasePath, version, "agent"), make([]byte, 15), 0644))
+		}
+		gc.mockUnmountedVolumeIDPath("volume")
+		require.NoError(t, afero.WriteFile(gc.fs, filepath.Join(logPath, "volume", "var", "log"), make([]byte, 15), 0644))
+
+		now := time.Now()
+		require.NoError(t, gc.fs.Chtimes(filepath.Join(binaryBasePath, version_1), now, now.Add(-3*time.Hour)))
+		require.NoError(t, gc.fs.Chtimes(filepath.Join(binaryBasePath, version_2), now, now.Add(-2*time.Hour)))
+		require.NoError(t, gc.fs.Chtimes(filepath.Join(binaryBasePath, version_3), now, now.Add(-4*time.Hour)))
+		require.NoError(t, gc.fs.Chtimes(filepath.Join(logPath, "volume"), now, now.Add(-time.Hour)))
+		return gc
+	}
+
+	t.Run(`nothing removed below high-water mark`, func(t *testing.T) {
+		gc := setup(t, 60)
+
+		gc.runDiskPressureGarbageCollection(context.TODO())
+
+		gc.assertVersionExists(t, version_1, version_2, version_3)
+		gc.assertDirExists(t, true, filepath.Join(logPath, "volume"))
+		assert.Equal(t, float64(0), testutil.ToFloat64(gcRunsMetric))
+	})
+	t.Run(`oldest unused versions and logs removed above high-water mark`, func(t *testing.T) {
+		gc := setup(t, 30)
+
+		gc.runDiskPressureGarbageCollection(context.TODO())
+
+		gc.assertVersionNotExists(t, version_1, version_2)
+		gc.assertVersionExists(t, version_3)
+		gc.assertDirExists(t, true, filepath.Join(logPath, "volume"))
+		assert.Equal(t, float64(2), testutil.ToFloat64(gcDecisionsMetric.WithLabelValues(resourceBinary, decisionRemove, reasonDiskPressure)))
+	})
+	t.Run(`dry run`, func(t *testing.T) {
+		gc := setup(t, 30)
+		gc.opts.GCPolicy.DryRun = true
+
+		gc.runDiskPressureGarbageCollection(context.TODO())
+
+		gc.assertVersionExists(t, version_1, version_2, version_3)
+		assert.Equal(t, float64(2), testutil.ToFloat64(gcDecisionsMetric.WithLabelValues(resourceBinary, decisionDryRun, reasonDiskPressure)))
+	})
+}
@@ -8,69 +8,26 @@ import (
 	"github.com/spf13/afero"
 )
 
-const (
-	maxLogFolderSizeBytes = 300000
-	maxNumberOfLogFiles   = 1000
-	maxLogAge             = 14 * 24 * time.Hour
-)
-
-type logFileInfo struct {
-	UnusedVolumeIDs []os.FileInfo
-	NumberOfFiles   int64
-	OverallSize     int64
-}
-
+// runLogGarbageCollection removes the logs of unpublished volumes once they reached the maximum age of the GC policy
 func (gc *CSIGarbageCollector) runLogGarbageCollection(tenantUUID string) {
 	agentDirectoryForPod := filepath.Join(gc.opts.RootDir, tenantUUID, "run")
-	logs, err := gc.getLogFileInfo(agentDirectoryForPod)
+	unusedVolumeIDs, err := gc.getUnusedVolumeIDs(agentDirectoryForPod)
 	if err != nil {
 		gc.logger.Info("failed to get log file information")
 		return
 	}
 
-	gc.removeLogsIfNecessary(logs, maxLogFolderSizeBytes, maxNumberOfLogFiles, agentDirectoryForPod)
-}
-
-func (gc *CSIGarbageCollector) removeLogsIfNecessary(logs *logFileInfo, maxSize int64, maxFile int64, agentDirectoryForPod string) {
-	shouldDelete := logs.NumberOfFiles > 0 && (logs.OverallSize > maxSize || logs.NumberOfFiles > maxFile)
-	if shouldDelete {
-		gc.tryRemoveLogFolders(logs.UnusedVolumeIDs, agentDirectoryForPod)
-	}
-}
-
-func (gc *CSIGarbageCollector) getLogFileInfo(agentDirectoryForPod string) (*logFileInfo, error) {
-	unusedVolumeIDs, err := gc.getUnusedVolumeIDs(agentDirectoryForPod)
-	if err != nil {
-		return nil, err
-	}
-
-	var nrOfFiles int64
-	var size int64
-	for _, volumeID := range unusedVolumeIDs {
-		_ = afero.Walk(gc.fs, filepath.Join(agentDirectoryForPod, volumeID.Name(), "var"), func(_ string, file os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !file.IsDir() {
-				nrOfFiles++
-				size += file.Size()
-			}
-			return nil
-		})
-	}
-
-	return &logFileInfo{
-		UnusedVolumeIDs: unusedVolumeIDs,
-		NumberOfFiles:   nrOfFiles,
-		OverallSize:     size,
-	}, nil
+	gc.tryRemoveLogFolders(unusedVolumeIDs, agentDirectoryForPod)
 }
 
+// getUnusedVolumeIDs returns the overlay directories of unpublished volumes, which only hold the logs of the pods
 func (gc *CSIGarbageCollector) getUnusedVolumeIDs(agentDirectoryForPod string) ([]os.FileInfo, error) {
 	var unusedVolumeIDs []os.FileInfo
 
 	volumeIDs, err := afero.ReadDir(gc.fs, agentDirectoryForPod)
-	if err != nil {
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -90,14 +47,15 @@ func (gc *CSIGarbageCollector) getUnusedVolumeIDs(agentDirectoryForPod string) (
 
 func (gc *CSIGarbageCollector) tryRemoveLogFolders(unusedVolumeIDs []os.FileInfo, agentDirectoryForPod string) {
 	for _, unusedVolumeID := range unusedVolumeIDs {
-		if isOlderThanTwoWeeks(unusedVolumeID.ModTime()) {
-			if err := gc.fs.RemoveAll(filepath.Join(agentDirectoryForPod, unusedVolumeID.Name())); err != nil {
-				gc.logger.Info("failed to remove logs for pod", "podUID", unusedVolumeID.Name(), "error", err)
-			}
+		logger := gc.logger.WithValues("podUID", unusedVolumeID.Name())
+		if isOlderThan(unusedVolumeID.ModTime(), gc.opts.GCPolicy.MaxLogAge) {
+			gc.remove(resourceLog, reasonMaxAge, filepath.Join(agentDirectoryForPod, unusedVolumeID.Name()), logger)
+		} else {
+			gc.keep(resourceLog, reasonTooYoung)
 		}
 	}
 }
 
-func isOlderThanTwoWeeks(t time.Time) bool {
-	return time.Since(t) > maxLogAge
+func isOlderThan(t time.Time, maxAge time.Duration) bool {
+	return time.Since(t) > maxAge
 }
@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,173 +18,95 @@ var (
 )
 
 func TestLogGarbageCollector_noErrorWithoutLogs(t *testing.T) {
-	gc := NewMockGarbageCollector()
+	gc := NewMockGarbageCollector(t)
 
 	_ = gc.fs.MkdirAll(logPath, 0770)
-	logs, err := gc.getLogFileInfo(logPath)
+	unusedVolumeIDs, err := gc.getUnusedVolumeIDs(logPath)
 
 	assert.NoError(t, err)
-	assert.Equal(t, &logFileInfo{
-		UnusedVolumeIDs: []os.FileInfo(nil),
-		NumberOfFiles:   0,
-		OverallSize:     0,
-	}, logs)
+	assert.Empty(t, unusedVolumeIDs)
 }
 
-func TestLogGarbageCollector_emptyLogFileInfoWithNoUnmountedLogs(t *testing.T) {
-	gc := NewMockGarbageCollector()
-	gc.mockMountedVolumeIDPath(version_1)
+func TestLogGarbageCollector_noErrorWithoutRunDirectory(t *testing.T) {
+	gc := NewMockGarbageCollector(t)
 
-	logs, err := gc.getLogFileInfo(logPath)
+	unusedVolumeIDs, err := gc.getUnusedVolumeIDs(logPath)
 
 	assert.NoError(t, err)
-	assert.Equal(t, &logFileInfo{
-		UnusedVolumeIDs: []os.FileInfo(nil),
-		NumberOfFiles:   0,
-		OverallSize:     0,
-	}, logs)
+	assert.Empty(t, unusedVolumeIDs)
 }
 
-func TestLogGarbageCollector_logFileInfo_JustVolumeID_WithUnmountedLogs(t *testing.T) {
-	gc := NewMockGarbageCollector()
-
-	gc.mockUnmountedVolumeIDPath(version_1)
-
-	logs, err := gc.getLogFileInfo(logPath)
-
-	assert.NoError(t, err)
-	assert.Equal(t, int64(0), logs.NumberOfFiles)
-	assert.Equal(t, int64(0), logs.OverallSize)
-	assert.Equal(t, version_1, logs.UnusedVolumeIDs[0].Name())
-}
-
-func TestLogGarbageCollector_logFileInfo_SingleVolumeID_WithUnmountedLogs(t *testing.T) {
-	gc := NewMockGarbageCollector()
-
-	gc.mockUnmountedVolumeIDPath(version_1)
-	gc.mockLogsInPodFolders(5, version_1)
-
-	logs, err := gc.getLogFileInfo(logPath)
-
-	assert.NoError(t, err)
-	assert.Equal(t, int64(5), logs.NumberOfFiles)
-	assert.Equal(t, int64(0), logs.OverallSize)
-	assert.Equal(t, version_1, logs.UnusedVolumeIDs[0].Name())
-}
-
-func TestLogGarbageCollector_logFileInfo_MultipleVolumeIDs_WithUnmountedLogs(t *testing.T) {
-	gc := NewMockGarbageCollector()
-
-	gc.mockUnmountedVolumeIDPath(version_1, version_2, version_3)
-	gc.mockLogsInPodFolders(5, version_1, version_2)
+func TestLogGarbageCollector_noUnusedVolumeIDsWithMountedLogs(t *testing.T) {
+	gc := NewMockGarbageCollector(t)
+	gc.mockMountedVolumeIDPath(version_1)
 
-	logs, err := gc.getLogFileInfo(logPath)
+	unusedVolumeIDs, err := gc.getUnusedVolumeIDs(logPath)
 
 	assert.NoError(t, err)
-	assert.Equal(t, int64(10), logs.NumberOfFiles)
-	assert.Equal(t, int64(0), logs.OverallSize)
-	assert.Equal(t, version_1, logs.UnusedVolumeIDs[0].Name())
-	assert.Equal(t, version_2, logs.UnusedVolumeIDs[1].Name())
-	assert.Equal(t, version_3, logs.UnusedVolumeIDs[2].Name())
+	assert.Empty(t, unusedVolumeIDs)
 }
 
-func TestLogGarbageCollector_logFileInfo_MultipleVolumeIDs_WithUnmountedAndMountedLogs(t *testing.T) {
-	gc := NewMockGarbageCollector()
+func TestLogGarbageCollector_unusedVolumeIDs_WithUnmountedAndMountedLogs(t *testing.T) {
+	gc := NewMockGarbageCollector(t)
 
 	gc.mockMountedVolumeIDPath(version_3)
 	gc.mockUnmountedVolumeIDPath(version_1, version_2)
 	gc.mockLogsInPodFolders(5, version_1, version_2)
 
-	logs, err := gc.getLogFileInfo(logPath)
+	unusedVolumeIDs, err := gc.getUnusedVolumeIDs(logPath)
 
 	assert.NoError(t, err)
-	assert.Equal(t, int64(10), logs.NumberOfFiles)
-	assert.Equal(t, int64(0), logs.OverallSize)
-	assert.Equal(t, version_1, logs.UnusedVolumeIDs[0].Name())
-	assert.Equal(t, version_2, logs.UnusedVolumeIDs[1].Name())
-	assert.Equal(t, 2, len(logs.UnusedVolumeIDs))
+	assert.Equal(t, 2, len(unusedVolumeIDs))
+	assert.Equal(t, version_1, unusedVolumeIDs[0].Name())
+	assert.Equal(t, version_2, unusedVolumeIDs[1].Name())
 }
 
-func TestLogGarbageCollector_modificationDateOlderThanTwoWeeks(t *testing.T) {
+func TestLogGarbageCollector_isOlderThan(t *testing.T) {
 	t.Run("is false for current timestamp", func(t *testing.T) {
-		isOlder := isOlderThanTwoWeeks(time.Now())
+		isOlder := isOlderThan(time.Now(), 14*24*time.Hour)
 
 		assert.False(t, isOlder)
 	})
 
 	t.Run("is true for timestamp 14 days in past", func(t *testing.T) {
-		isOlder := isOlderThanTwoWeeks(time.Now().AddDate(0, 0, -14))
+		isOlder := isOlderThan(time.Now().AddDate(0, 0, -14), 14*24*time.Hour)
 
 		assert.True(t, isOlder)
 	})
 }
 
-func TestLogGarbageCollector_cleanUpSuccessful(t *testing.T) {
-	gc := NewMockGarbageCollector()
-
-	gc.mockUnmountedVolumeIDPath(version_1, version_2)
-	gc.mockLogsInPodFolders(5, version_1, version_2)
-
-	logs, err := gc.getLogFileInfo(logPath)
-	assert.NoError(t, err)
-	assert.NotNil(t, logs)
-
-	older := isOlderThanTwoWeeks(logs.UnusedVolumeIDs[0].ModTime())
-	assert.True(t, older)
-
-	gc.tryRemoveLogFolders(logs.UnusedVolumeIDs, logPath)
-	assert.NoDirExists(t, filepath.Join(logPath, logs.UnusedVolumeIDs[0].Name()))
-}
-
-func TestLogGarbageCollector_removeLogsNecessary_filesGetDeleted(t *testing.T) {
-	gc := NewMockGarbageCollector()
-
-	gc.mockUnmountedVolumeIDPath(version_1, version_2)
-	gc.mockLogsInPodFolders(5, version_1, version_2)
-
-	logs, err := gc.getLogFileInfo(logPath)
-	assert.NoError(t, err)
-	assert.NotNil(t, logs)
-
-	gc.removeLogsIfNecessary(logs, int64(0), int64(1), logPath)
-	newLogs, err := gc.getLogFileInfo(logPath)
-	assert.NoError(t, err)
-	assert.NotEqual(t, newLogs, logs)
-	assert.Equal(t, newLogs.NumberOfFiles, int64(0))
-}
-
-func TestLogGarbageCollector_removeLogsNecessary_tooLessFiles(t *testing.T) {
-	gc := NewMockGarbageCollector()
+func TestLogGarbageCollector_removesLogsOlderThanMaxAge(t *testing.T) {
+	resetMetrics()
+	gc := NewMockGarbageCollector(t)
+	gc.opts.GCPolicy.MaxLogAge = 24 * time.Hour
 
 	gc.mockUnmountedVolumeIDPath(version_1, version_2)
+	gc.mockMountedVolumeIDPath(version_3)
 	gc.mockLogsInPodFolders(5, version_1, version_2)
+	_ = gc.fs.Chtimes(filepath.Join(logPath, version_1), time.Now(), time.Now().Add(-48*time.Hour))
+	_ = gc.fs.Chtimes(filepath.Join(logPath, version_2), time.Now(), time.Now())
 
-	logs, err := gc.getLogFileInfo(logPath)
-	assert.NoError(t, err)
-	assert.NotNil(t, logs)
+	gc.runLogGarbageCollection(tenantUUID)
 
-	gc.removeLogsIfNecessary(logs, int64(0), int64(11), logPath)
-	newLogs, err := gc.getLogFileInfo(logPath)
-	assert.NoError(t, err)
-	assert.Equal(t, newLogs, logs)
-	assert.Equal(t, newLogs.NumberOfFiles, int64(10))
+	gc.assertDirExists(t, false, filepath.Join(logPath, version_1))
+	gc.assertDirExists(t, true, filepath.Join(logPath, version_2))
+	gc.assertDirExists(t, true, filepath.Join(logPath, version_3))
+	assert.Equal(t, float64(1), testutil.ToFloat64(gcDecisionsMetric.WithLabelValues(resourceLog, decisionRemove, reasonMaxAge)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(gcDecisionsMetric.WithLabelValues(resourceLog, decisionKeep, reasonTooYoung)))
 }
 
-func TestLogGarbageCollector_removeLogsNecessary_FileSizeTooSmall(t *testing.T) {
-	gc := NewMockGarbageCollector()
+func TestLogGarbageCollector_dryRun(t *testing.T) {
+	resetMetrics()
+	gc := NewMockGarbageCollector(t)
+	gc.opts.GCPolicy.DryRun = true
 
-	gc.mockUnmountedVolumeIDPath(version_1, version_2)
-	gc.mockLogsInPodFolders(5, version_1, version_2)
+	gc.mockUnmountedVolumeIDPath(version_1)
+	gc.mockLogsInPodFolders(5, version_1)
 
-	logs, err := gc.getLogFileInfo(logPath)
-	assert.NoError(t, err)
-	assert.NotNil(t, logs)
+	gc.runLogGarbageCollection(tenantUUID)
 
-	gc.removeLogsIfNecessary(logs, int64(10), int64(11), logPath)
-	newLogs, err := gc.getLogFileInfo(logPath)
-	assert.NoError(t, err)
-	assert.Equal(t, newLogs, logs)
-	assert.Equal(t, newLogs.NumberOfFiles, int64(10))
+	gc.assertDirExists(t, true, filepath.Join(logPath, version_1))
+	assert.Equal(t, float64(1), testutil.ToFloat64(gcDecisionsMetric.WithLabelValues(resourceLog, decisionDryRun, reasonMaxAge)))
 }
 
 func (gc *CSIGarbageCollector) mockMountedVolumeIDPath(volumeIDs ...string) {
@@ -206,3 +130,9 @@ func (gc *CSIGarbageCollector) mockLogsInPodFolders(nrOfLogFiles int, volumeIDs
 		}
 	}
 }
+
+func (gc *CSIGarbageCollector) assertDirExists(t *testing.T, expected bool, path string) {
+	exists, err := afero.DirExists(gc.fs, path)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, exists, path)
+}
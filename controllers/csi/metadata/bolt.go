@@ -0,0 +1,183 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// FileName is the name of the database file in the data directory of the CSI driver
+	FileName = "csi.db"
+
+	openTimeout = 5 * time.Second
+)
+
+var (
+	tenantsBucket = []byte("tenants")
+	volumesBucket = []byte("volumes")
+)
+
+// BoltAccess stores the metadata in a bbolt database, every change is written in its own transaction
+type BoltAccess struct {
+	db *bolt.DB
+}
+
+var _ Access = &BoltAccess{}
+
+// NewAccess opens or creates the database at the given path
+func NewAccess(path string) (*BoltAccess, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata database '%s': %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{tenantsBucket, volumesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize metadata database '%s': %w", path, err)
+	}
+
+	return &BoltAccess{db: db}, nil
+}
+
+func (a *BoltAccess) GetTenant(dynakubeName string) (*Tenant, error) {
+	var tenant *Tenant
+	err := a.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(tenantsBucket).Get([]byte(dynakubeName))
+		if data == nil {
+			return nil
+		}
+		tenant = &Tenant{}
+		return json.Unmarshal(data, tenant)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant of DynaKube '%s': %w", dynakubeName, err)
+	}
+	return tenant, nil
+}
+
+func (a *BoltAccess) GetTenants() ([]*Tenant, error) {
+	var tenants []*Tenant
+	err := a.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tenantsBucket).ForEach(func(_, data []byte) error {
+			var tenant Tenant
+			if err := json.Unmarshal(data, &tenant); err != nil {
+				return err
+			}
+			tenants = append(tenants, &tenant)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenants: %w", err)
+	}
+	return tenants, nil
+}
+
+func (a *BoltAccess) UpdateTenant(tenant *Tenant) error {
+	if err := a.put(tenantsBucket, tenant.DynakubeName, tenant); err != nil {
+		return fmt.Errorf("failed to update tenant of DynaKube '%s': %w", tenant.DynakubeName, err)
+	}
+	return nil
+}
+
+func (a *BoltAccess) DeleteTenant(dynakubeName string) error {
+	if err := a.delete(tenantsBucket, dynakubeName); err != nil {
+		return fmt.Errorf("failed to delete tenant of DynaKube '%s': %w", dynakubeName, err)
+	}
+	return nil
+}
+
+func (a *BoltAccess) GetVolume(volumeID string) (*Volume, error) {
+	var volume *Volume
+	err := a.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(volumesBucket).Get([]byte(volumeID))
+		if data == nil {
+			return nil
+		}
+		volume = &Volume{}
+		return json.Unmarshal(data, volume)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volume '%s': %w", volumeID, err)
+	}
+	return volume, nil
+}
+
+func (a *BoltAccess) GetVolumes() ([]*Volume, error) {
+	var volumes []*Volume
+	err := a.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(volumesBucket).ForEach(func(_, data []byte) error {
+			var volume Volume
+			if err := json.Unmarshal(data, &volume); err != nil {
+				return err
+			}
+			volumes = append(volumes, &volume)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volumes: %w", err)
+	}
+	return volumes, nil
+}
+
+func (a *BoltAccess) InsertVolume(volume *Volume) error {
+	if err := a.put(volumesBucket, volume.VolumeID, volume); err != nil {
+		return fmt.Errorf("failed to insert volume '%s': %w", volume.VolumeID, err)
+	}
+	return nil
+}
+
+func (a *BoltAccess) DeleteVolume(volumeID string) error {
+	if err := a.delete(volumesBucket, volumeID); err != nil {
+		return fmt.Errorf("failed to delete volume '%s': %w", volumeID, err)
+	}
+	return nil
+}
+
+// GetUsedVersions returns the versions mounted by the volumes of the tenant
+func (a *BoltAccess) GetUsedVersions(tenantUUID string) (map[string]bool, error) {
+	volumes, err := a.GetVolumes()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := map[string]bool{}
+	for _, volume := range volumes {
+		if volume.TenantUUID == tenantUUID {
+			versions[volume.Version] = true
+		}
+	}
+	return versions, nil
+}
+
+func (a *BoltAccess) Close() error {
+	return a.db.Close()
+}
+
+func (a *BoltAccess) put(bucket []byte, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return a.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+func (a *BoltAccess) delete(bucket []byte, key string) error {
+	return a.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
@@ -0,0 +1,82 @@
+package metadata
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAccess(t *testing.T) *BoltAccess {
+	access, err := NewAccess(filepath.Join(t.TempDir(), FileName))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = access.Close() })
+	return access
+}
+
+func TestBoltAccess_Tenants(t *testing.T) {
+	access := newTestAccess(t)
+
+	tenant, err := access.GetTenant("dynakube")
+	require.NoError(t, err)
+	assert.Nil(t, tenant)
+
+	require.NoError(t, access.UpdateTenant(&Tenant{DynakubeName: "dynakube", TenantUUID: "abc12345", LatestVersion: "1.2.3"}))
+	require.NoError(t, access.UpdateTenant(&Tenant{DynakubeName: "other", TenantUUID: "def67890", LatestVersion: "1.2.4"}))
+	require.NoError(t, access.UpdateTenant(&Tenant{DynakubeName: "dynakube", TenantUUID: "abc12345", LatestVersion: "1.2.4", RequestedVersions: []string{"1.2.4", "1.2.3"}}))
+
+	tenant, err = access.GetTenant("dynakube")
+	require.NoError(t, err)
+	assert.Equal(t, &Tenant{DynakubeName: "dynakube", TenantUUID: "abc12345", LatestVersion: "1.2.4", RequestedVersions: []string{"1.2.4", "1.2.3"}}, tenant)
+
+	tenants, err := access.GetTenants()
+	require.NoError(t, err)
+	assert.Len(t, tenants, 2)
+
+	require.NoError(t, access.DeleteTenant("other"))
+	tenant, err = access.GetTenant("other")
+	require.NoError(t, err)
+	assert.Nil(t, tenant)
+}
+
+func TestBoltAccess_Volumes(t *testing.T) {
+	access := newTestAccess(t)
+
+	volume, err := access.GetVolume("vol-1")
+	require.NoError(t, err)
+	assert.Nil(t, volume)
+
+	require.NoError(t, access.InsertVolume(&Volume{VolumeID: "vol-1", PodName: "pod-1", TenantUUID: "abc12345", Version: "1.2.3"}))
+	require.NoError(t, access.InsertVolume(&Volume{VolumeID: "vol-2", PodName: "pod-2", TenantUUID: "abc12345", Version: "1.2.4"}))
+	require.NoError(t, access.InsertVolume(&Volume{VolumeID: "vol-3", PodName: "pod-3", TenantUUID: "def67890", Version: "1.2.5"}))
+
+	volume, err = access.GetVolume("vol-1")
+	require.NoError(t, err)
+	assert.Equal(t, &Volume{VolumeID: "vol-1", PodName: "pod-1", TenantUUID: "abc12345", Version: "1.2.3"}, volume)
+
+	versions, err := access.GetUsedVersions("abc12345")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"1.2.3": true, "1.2.4": true}, versions)
+
+	require.NoError(t, access.DeleteVolume("vol-1"))
+	volumes, err := access.GetVolumes()
+	require.NoError(t, err)
+	assert.Len(t, volumes, 2)
+}
+
+func TestBoltAccess_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	access, err := NewAccess(path)
+	require.NoError(t, err)
+	require.NoError(t, access.InsertVolume(&Volume{VolumeID: "vol-1", Version: "1.2.3"}))
+	require.NoError(t, access.Close())
+
+	access, err = NewAccess(path)
+	require.NoError(t, err)
+	defer func() { _ = access.Close() }()
+
+	volume, err := access.GetVolume("vol-1")
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3", volume.Version)
+}
@@ -0,0 +1,46 @@
+package metadata
+
+// Tenant is the tenant a DynaKube is connected to and the versions of the OneAgent package provisioned for it
+type Tenant struct {
+	DynakubeName      string   `json:"dynakubeName"`
+	TenantUUID        string   `json:"tenantUUID"`
+	LatestVersion     string   `json:"latestVersion"`
+	RequestedVersions []string `json:"requestedVersions,omitempty"`
+	// HostAgentVersions are the versions of the installer of the full-stack host agent requested by volumes on the node,
+	// starting with the default version
+	HostAgentVersions []string `json:"hostAgentVersions,omitempty"`
+}
+
+// Volume is a volume published by the CSI driver and the OneAgent version mounted into it
+type Volume struct {
+	VolumeID      string `json:"volumeID"`
+	PodName       string `json:"podName"`
+	Namespace     string `json:"namespace"`
+	TenantUUID    string `json:"tenantUUID"`
+	Version       string `json:"version"`
+	TargetPath    string `json:"targetPath"`
+	OverlayFSPath string `json:"overlayFSPath"`
+	// Quota is the limit in bytes of what the pod writes to the overlay of the volume, unlimited if 0
+	Quota int64 `json:"quota,omitempty"`
+	// QuotaExceeded is set once the overlay has been made read-only for exceeding the quota
+	QuotaExceeded bool `json:"quotaExceeded,omitempty"`
+	// ReadOnly volumes bind the OneAgent package directly, without overlay
+	ReadOnly bool `json:"readOnly,omitempty"`
+}
+
+// Access gives access to the metadata of the CSI driver, which is shared by the driver, the provisioner and the
+// garbage collector. Getters return nil if the entry does not exist.
+type Access interface {
+	GetTenant(dynakubeName string) (*Tenant, error)
+	GetTenants() ([]*Tenant, error)
+	UpdateTenant(tenant *Tenant) error
+	DeleteTenant(dynakubeName string) error
+
+	GetVolume(volumeID string) (*Volume, error)
+	GetVolumes() ([]*Volume, error)
+	InsertVolume(volume *Volume) error
+	DeleteVolume(volumeID string) error
+	GetUsedVersions(tenantUUID string) (map[string]bool, error)
+
+	Close() error
+}
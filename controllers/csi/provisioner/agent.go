@@ -2,41 +2,84 @@ package csiprovisioner
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/Dynatrace/dynatrace-operator/installer"
 	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/afero"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
-const agentConfPath = "agent/conf/"
+var verificationFailuresMetric = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "dynatrace",
+	Subsystem: "csi_driver",
+	Name:      "agent_verification_failures",
+	Help:      "Number of downloaded OneAgent packages which failed the verification",
+})
+
+var missingChecksumsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "dynatrace",
+	Subsystem: "csi_driver",
+	Name:      "agent_missing_checksums",
+	Help:      "Number of downloaded OneAgent packages the deployment API didn't provide a checksum for",
+}, []string{"installer_type"})
+
+func init() {
+	metrics.Registry.MustRegister(verificationFailuresMetric, missingChecksumsMetric)
+}
+
+// agentDistribution fetches OneAgent packages from the CSI driver pod elected for distributing them in the cluster
+type agentDistribution interface {
+	GetAgent(ctx context.Context, dynakube, flavor, arch, version string, writer io.Writer) (string, error)
+}
 
 type installAgentConfig struct {
-	logger    logr.Logger
-	dtc       dtclient.Client
-	arch      string
-	targetDir string
-	fs        afero.Fs
+	logger       logr.Logger
+	dtc          dtclient.Client
+	distribution agentDistribution
+	dynakube     string
+	arch         string
+	flavor       string
+	version      string
+	targetDir    string
+	stagingDir   string
+	fs           afero.Fs
+	// requireChecksums rejects packages without checksum instead of only verifying the checksums of the archive
+	requireChecksums bool
 }
 
-func newInstallAgentConfig(logger logr.Logger, dtc dtclient.Client, arch, targetDir string) *installAgentConfig {
+func newInstallAgentConfig(logger logr.Logger, dtc dtclient.Client, arch, flavor, version, targetDir, stagingDir string) *installAgentConfig {
 	return &installAgentConfig{
-		logger:    logger,
-		dtc:       dtc,
-		arch:      arch,
-		targetDir: targetDir,
-		fs:        afero.NewOsFs(),
+		logger:     logger,
+		dtc:        dtc,
+		arch:       arch,
+		flavor:     flavor,
+		version:    version,
+		targetDir:  targetDir,
+		stagingDir: stagingDir,
+		fs:         afero.NewOsFs(),
 	}
 }
 
+// installAgent downloads and verifies the OneAgent package, which is unpacked into a staging directory and renamed to
+// the target directory afterwards. The target directory is left untouched if any of the steps fails.
 func installAgent(installAgentCfg *installAgentConfig) error {
 	logger := installAgentCfg.logger
 	dtc := installAgentCfg.dtc
 	arch := installAgentCfg.arch
+	flavor := installAgentCfg.flavor
+	if flavor == "" {
+		flavor = dtclient.FlavorMultidistro
+	}
 	fs := installAgentCfg.fs
 
 	tmpFile, err := afero.TempFile(fs, "", "download")
@@ -50,12 +93,23 @@ func installAgent(installAgentCfg *installAgentConfig) error {
 		}
 	}()
 
-	logger.Info("Downloading OneAgent package", "architecture", arch)
-	err = dtc.GetLatestAgent(dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro, arch, tmpFile)
+	inCluster, err := downloadFromDistribution(installAgentCfg, tmpFile, flavor)
 	if err != nil {
-		return fmt.Errorf("failed to fetch latest OneAgent version: %w", err)
+		return fmt.Errorf("failed to reset file for download: %w", err)
+	} else if !inCluster {
+		version := installAgentCfg.version
+		logger.Info("Downloading OneAgent package", "version", version, "architecture", arch, "flavor", flavor)
+		err = dtc.GetAgent(dtclient.OsUnix, dtclient.InstallerTypePaaS, flavor, arch, version, tmpFile)
+		if err != nil {
+			return fmt.Errorf("failed to fetch OneAgent version %s: %w", version, err)
+		}
+		logger.Info("Saved OneAgent package", "dest", tmpFile.Name())
+
+		if err := verifyChecksum(installAgentCfg, tmpFile, flavor); err != nil {
+			verificationFailuresMetric.Inc()
+			return fmt.Errorf("failed to verify OneAgent package: %w", err)
+		}
 	}
-	logger.Info("Saved OneAgent package", "dest", tmpFile.Name())
 
 	fileInfo, err := tmpFile.Stat()
 	if err != nil {
@@ -64,81 +118,116 @@ func installAgent(installAgentCfg *installAgentConfig) error {
 
 	zipr, err := zip.NewReader(tmpFile, fileInfo.Size())
 	if err != nil {
+		verificationFailuresMetric.Inc()
 		return fmt.Errorf("failed to open ZIP file: %w", err)
 	}
 
-	logger.Info("Unzipping OneAgent package")
+	// Leftovers of interrupted installations are replaced
+	_ = fs.RemoveAll(installAgentCfg.stagingDir)
+
+	logger.Info("Unzipping OneAgent package", "staging", installAgentCfg.stagingDir)
 	if err := unzip(zipr, installAgentCfg); err != nil {
+		_ = fs.RemoveAll(installAgentCfg.stagingDir)
+		if errors.Is(err, zip.ErrChecksum) {
+			verificationFailuresMetric.Inc()
+		}
 		return fmt.Errorf("failed to unzip file: %w", err)
 	}
 
-	logger.Info("Unzipped OneAgent package")
+	if err := fs.MkdirAll(filepath.Dir(installAgentCfg.targetDir), 0755); err != nil {
+		_ = fs.RemoveAll(installAgentCfg.stagingDir)
+		return fmt.Errorf("failed to create directory for OneAgent package: %w", err)
+	}
+	if err := fs.Rename(installAgentCfg.stagingDir, installAgentCfg.targetDir); err != nil {
+		_ = fs.RemoveAll(installAgentCfg.stagingDir)
+		return fmt.Errorf("failed to move OneAgent package to %s: %w", installAgentCfg.targetDir, err)
+	}
+
+	logger.Info("Unzipped OneAgent package", "dest", installAgentCfg.targetDir)
 
 	return nil
 }
 
-func unzip(r *zip.Reader, installAgentCfg *installAgentConfig) error {
-	outDir := installAgentCfg.targetDir
-	logger := installAgentCfg.logger
-	fs := installAgentCfg.fs
-
-	_ = fs.MkdirAll(outDir, 0755)
-
-	// Closure to address file descriptors issue with all the deferred .Close() methods
-	extract := func(zipf *zip.File) error {
-		rc, err := zipf.Open()
-		if err != nil {
-			return err
-		}
-
-		defer func() {
-			if err := rc.Close(); err != nil {
-				logger.Error(err, "Failed to close ZIP entry file", "path", zipf.Name)
-			}
-		}()
-
-		path := filepath.Join(outDir, zipf.Name)
-
-		// Check for ZipSlip (Directory traversal)
-		if !strings.HasPrefix(path, filepath.Clean(outDir)+string(os.PathSeparator)) {
-			return fmt.Errorf("illegal file path: %s", path)
-		}
+// downloadFromDistribution fetches the OneAgent package from the CSI driver pod distributing the packages in the
+// cluster, if enabled, and verifies it with the checksum provided by the deployment API of the tenant. Packages without
+// checksum are not taken from the distributing pod. Returns false if the package has to be downloaded from the tenant
+// instead, the file is emptied in that case.
+func downloadFromDistribution(installAgentCfg *installAgentConfig, file afero.File, flavor string) (bool, error) {
+	if installAgentCfg.distribution == nil {
+		return false, nil
+	}
 
-		mode := zipf.Mode()
+	logger := installAgentCfg.logger
+	expected, err := installAgentCfg.dtc.GetAgentChecksum(dtclient.OsUnix, dtclient.InstallerTypePaaS, flavor, installAgentCfg.arch, installAgentCfg.version)
+	if err != nil {
+		logger.Info("Failed to fetch checksum of OneAgent package, falling back to tenant", "error", err.Error())
+		return false, nil
+	} else if expected == "" {
+		logger.Info("No checksum provided for OneAgent package, which is required for the in-cluster distribution, falling back to tenant")
+		return false, nil
+	}
 
-		// Mark all files inside ./agent/conf as group-writable
-		if zipf.Name != agentConfPath && strings.HasPrefix(zipf.Name, agentConfPath) {
-			mode |= 020
+	logger.Info("Downloading OneAgent package in cluster", "version", installAgentCfg.version, "architecture", installAgentCfg.arch, "flavor", flavor)
+	_, err = installAgentCfg.distribution.GetAgent(context.TODO(), installAgentCfg.dynakube, flavor, installAgentCfg.arch, installAgentCfg.version, file)
+	if err == nil {
+		if err = compareChecksum(file, expected); err != nil {
+			verificationFailuresMetric.Inc()
 		}
-
-		if zipf.FileInfo().IsDir() {
-			return fs.MkdirAll(path, mode)
+	}
+	if err != nil {
+		logger.Info("Failed to download OneAgent package in cluster, falling back to tenant", "error", err.Error())
+		if err := file.Truncate(0); err != nil {
+			return false, err
 		}
+		_, err := file.Seek(0, io.SeekStart)
+		return false, err
+	}
 
-		if err = fs.MkdirAll(filepath.Dir(path), mode); err != nil {
-			return err
-		}
+	logger.Info("Saved OneAgent package", "dest", file.Name())
+	return true, nil
+}
 
-		f, err := fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
-		if err != nil {
-			return err
-		}
+// verifyChecksum compares the SHA-256 checksum of the downloaded package with the one provided by the deployment API.
+// Packages without checksum are only verified by the CRC-32 checksums of the ZIP entries while unpacking, unless
+// checksums are required.
+func verifyChecksum(installAgentCfg *installAgentConfig, file afero.File, flavor string) error {
+	expected, err := installAgentCfg.dtc.GetAgentChecksum(dtclient.OsUnix, dtclient.InstallerTypePaaS, flavor, installAgentCfg.arch, installAgentCfg.version)
+	if err != nil {
+		return fmt.Errorf("failed to fetch checksum: %w", err)
+	}
+	if expected == "" {
+		return handleMissingChecksum(dtclient.InstallerTypePaaS, installAgentCfg.requireChecksums, installAgentCfg.logger)
+	}
+	return compareChecksum(file, expected)
+}
 
-		defer func() {
-			if err := f.Close(); err != nil {
-				logger.Error(err, "Failed to close target file", "path", f.Name)
-			}
-		}()
+// handleMissingChecksum counts a package the deployment API didn't provide a checksum for, which is rejected if
+// checksums are required
+func handleMissingChecksum(installerType string, requireChecksums bool, logger logr.Logger) error {
+	missingChecksumsMetric.WithLabelValues(installerType).Inc()
+	if requireChecksums {
+		return errors.New("no checksum provided by the deployment API, but checksums are required")
+	}
+	logger.Info("No checksum provided by the deployment API, skipping checksum verification", "installerType", installerType)
+	return nil
+}
 
-		_, err = io.Copy(f, rc)
+func compareChecksum(file afero.File, expected string) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
 
-	for _, f := range r.File {
-		if err := extract(f); err != nil {
-			return err
-		}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
 	}
 
+	if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
 	return nil
 }
+
+func unzip(r *zip.Reader, installAgentCfg *installAgentConfig) error {
+	return installer.Unzip(installAgentCfg.fs, r, installAgentCfg.stagingDir, installAgentCfg.logger)
+}
@@ -2,15 +2,21 @@ package csiprovisioner
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"testing"
 
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
 	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/Dynatrace/dynatrace-operator/installer"
 	"github.com/Dynatrace/dynatrace-operator/logger"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -21,8 +27,23 @@ const (
 	testZip      = `UEsDBAoAAAAAAKh0p1JsLSFnGQAAABkAAAAIABwAdGVzdC50eHRVVAkAA3w0lWATB55gdXgLAAEE6AMAAAToAwAAeW91IGZvdW5kIHRoZSBlYXN0ZXIgZWdnClBLAwQKAAAAAADAOa5SAAAAAAAAAAAAAAAABQAcAHRlc3QvVVQJAAMXB55gHQeeYHV4CwABBOgDAAAE6AMAAFBLAwQKAAAAAACodKdSbC0hZxkAAAAZAAAADQAcAHRlc3QvdGVzdC50eHRVVAkAA3w0lWATB55gdXgLAAEE6AMAAAToAwAAeW91IGZvdW5kIHRoZSBlYXN0ZXIgZWdnClBLAwQKAAAAAADCOa5SAAAAAAAAAAAAAAAACgAcAHRlc3QvdGVzdC9VVAkAAxwHnmAgB55gdXgLAAEE6AMAAAToAwAAUEsDBAoAAAAAAKh0p1JsLSFnGQAAABkAAAASABwAdGVzdC90ZXN0L3Rlc3QudHh0VVQJAAN8NJVgHAeeYHV4CwABBOgDAAAE6AMAAHlvdSBmb3VuZCB0aGUgZWFzdGVyIGVnZwpQSwMECgAAAAAA2zquUgAAAAAAAAAAAAAAAAYAHABhZ2VudC9VVAkAAy4JnmAxCZ5gdXgLAAEE6AMAAAToAwAAUEsDBAoAAAAAAOI6rlIAAAAAAAAAAAAAAAALABwAYWdlbnQvY29uZi9VVAkAAzgJnmA+CZ5gdXgLAAEE6AMAAAToAwAAUEsDBAoAAAAAAKh0p1JsLSFnGQAAABkAAAATABwAYWdlbnQvY29uZi90ZXN0LnR4dFVUCQADfDSVYDgJnmB1eAsAAQToAwAABOgDAAB5b3UgZm91bmQgdGhlIGVhc3RlciBlZ2cKUEsBAh4DCgAAAAAAqHSnUmwtIWcZAAAAGQAAAAgAGAAAAAAAAQAAAKSBAAAAAHRlc3QudHh0VVQFAAN8NJVgdXgLAAEE6AMAAAToAwAAUEsBAh4DCgAAAAAAwDmuUgAAAAAAAAAAAAAAAAUAGAAAAAAAAAAQAO1BWwAAAHRlc3QvVVQFAAMXB55gdXgLAAEE6AMAAAToAwAAUEsBAh4DCgAAAAAAqHSnUmwtIWcZAAAAGQAAAA0AGAAAAAAAAQAAAKSBmgAAAHRlc3QvdGVzdC50eHRVVAUAA3w0lWB1eAsAAQToAwAABOgDAABQSwECHgMKAAAAAADCOa5SAAAAAAAAAAAAAAAACgAYAAAAAAAAABAA7UH6AAAAdGVzdC90ZXN0L1VUBQADHAeeYHV4CwABBOgDAAAE6AMAAFBLAQIeAwoAAAAAAKh0p1JsLSFnGQAAABkAAAASABgAAAAAAAEAAACkgT4BAAB0ZXN0L3Rlc3QvdGVzdC50eHRVVAUAA3w0lWB1eAsAAQToAwAABOgDAABQSwECHgMKAAAAAADbOq5SAAAAAAAAAAAAAAAABgAYAAAAAAAAABAA7UGjAQAAYWdlbnQvVVQFAAMuCZ5gdXgLAAEE6AMAAAToAwAAUEsBAh4DCgAAAAAA4jquUgAAAAAAAAAAAAAAAAsAGAAAAAAAAAAQAO1B4wEAAGFnZW50L2NvbmYvVVQFAAM4CZ5gdXgLAAEE6AMAAAToAwAAUEsBAh4DCgAAAAAAqHSnUmwtIWcZAAAAGQAAABMAGAAAAAAAAQAAAKSBKAIAAGFnZW50L2NvbmYvdGVzdC50eHRVVAUAA3w0lWB1eAsAAQToAwAABOgDAABQSwUGAAAAAAgACACKAgAAjgIAAAAA`
 	testDir      = "test"
 	testFilename = "test.txt"
+	testVersion  = "1.200.0"
 )
 
+type fakeDistribution struct {
+	content  []byte
+	checksum string
+	err      error
+}
+
+func (d *fakeDistribution) GetAgent(_ context.Context, _, _, _, _ string, writer io.Writer) (string, error) {
+	if d.err != nil {
+		return "", d.err
+	}
+	_, err := writer.Write(d.content)
+	return d.checksum, err
+}
+
 type failFs struct {
 	afero.Fs
 }
@@ -48,27 +69,28 @@ func TestOneAgentProvisioner_InstallAgent(t *testing.T) {
 		fs := afero.NewMemMapFs()
 		dtc := &dtclient.MockDynatraceClient{}
 		dtc.
-			On("GetLatestAgent", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
-				mock.AnythingOfType("string"), mock.AnythingOfType("*mem.File")).
+			On("GetAgent", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
+				mock.AnythingOfType("string"), testVersion, mock.AnythingOfType("*mem.File")).
 			Return(fmt.Errorf(errorMsg))
 		installAgentCfg := &installAgentConfig{
-			fs:     fs,
-			dtc:    dtc,
-			logger: log,
+			fs:      fs,
+			dtc:     dtc,
+			logger:  log,
+			version: testVersion,
 		}
 
 		err := installAgent(installAgentCfg)
-		assert.EqualError(t, err, "failed to fetch latest OneAgent version: "+errorMsg)
+		assert.EqualError(t, err, "failed to fetch OneAgent version "+testVersion+": "+errorMsg)
 	})
 	t.Run(`error unzipping file`, func(t *testing.T) {
 		fs := afero.NewMemMapFs()
 
 		dtc := &dtclient.MockDynatraceClient{}
 		dtc.
-			On("GetLatestAgent", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
-				mock.AnythingOfType("string"), mock.AnythingOfType("*mem.File")).
+			On("GetAgent", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
+				mock.AnythingOfType("string"), testVersion, mock.AnythingOfType("*mem.File")).
 			Run(func(args mock.Arguments) {
-				writer := args.Get(4).(io.Writer)
+				writer := args.Get(5).(io.Writer)
 
 				zipFile := setupTestZip(t, fs)
 				defer func() { _ = zipFile.Close() }()
@@ -77,47 +99,201 @@ func TestOneAgentProvisioner_InstallAgent(t *testing.T) {
 				require.NoError(t, err)
 			}).
 			Return(nil)
+		dtc.
+			On("GetAgentChecksum", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
+				mock.AnythingOfType("string"), testVersion).
+			Return("", nil)
 		installAgentCfg := &installAgentConfig{
-			fs:     fs,
-			dtc:    dtc,
-			logger: log,
+			fs:      fs,
+			dtc:     dtc,
+			logger:  log,
+			version: testVersion,
 		}
 
 		err := installAgent(installAgentCfg)
 		assert.EqualError(t, err, "failed to unzip file: illegal file path: test.txt")
 	})
 	t.Run(`downloading and unzipping agent`, func(t *testing.T) {
-		fs := afero.NewMemMapFs()
+		fs := newTestOsFs(t)
+		dtc := mockDownload(t, fs, testChecksum(t))
+		installAgentCfg := &installAgentConfig{
+			fs:         fs,
+			dtc:        dtc,
+			logger:     log,
+			version:    testVersion,
+			targetDir:  filepath.Join("bin", testDir),
+			stagingDir: filepath.Join(dtcsi.StagingDir, testDir),
+		}
+
+		err := installAgent(installAgentCfg)
+		assert.NoError(t, err)
+
+		info, err := fs.Stat(filepath.Join("bin", testDir, testFilename))
+		assert.NoError(t, err)
+		assert.NotNil(t, info)
+		assert.False(t, info.IsDir())
+		assert.Equal(t, int64(25), info.Size())
+
+		exists, err := afero.Exists(fs, installAgentCfg.stagingDir)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+	t.Run(`checksum mismatch keeps current version`, func(t *testing.T) {
+		fs := newTestOsFs(t)
+		dtc := mockDownload(t, fs, "0123456789abcdef")
+		installAgentCfg := &installAgentConfig{
+			fs:         fs,
+			dtc:        dtc,
+			logger:     log,
+			version:    testVersion,
+			targetDir:  filepath.Join("bin", testDir),
+			stagingDir: filepath.Join(dtcsi.StagingDir, testDir),
+		}
+		require.NoError(t, fs.MkdirAll(installAgentCfg.targetDir, 0755))
+		require.NoError(t, afero.WriteFile(fs, filepath.Join(installAgentCfg.targetDir, "current.txt"), []byte("current"), 0644))
+
+		before := testutil.ToFloat64(verificationFailuresMetric)
+
+		err := installAgent(installAgentCfg)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "checksum mismatch")
+		assert.Equal(t, before+1, testutil.ToFloat64(verificationFailuresMetric))
+
+		exists, err := afero.Exists(fs, filepath.Join(installAgentCfg.targetDir, "current.txt"))
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		exists, err = afero.Exists(fs, filepath.Join(installAgentCfg.targetDir, testFilename))
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+	t.Run(`truncated download`, func(t *testing.T) {
+		fs := newTestOsFs(t)
+		zipf, err := base64.StdEncoding.DecodeString(testZip)
+		require.NoError(t, err)
 
 		dtc := &dtclient.MockDynatraceClient{}
 		dtc.
-			On("GetLatestAgent", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
-				mock.AnythingOfType("string"), mock.AnythingOfType("*mem.File")).
+			On("GetAgent", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
+				mock.AnythingOfType("string"), testVersion, mock.Anything).
 			Run(func(args mock.Arguments) {
-				writer := args.Get(4).(io.Writer)
-
-				zipFile := setupTestZip(t, fs)
-				defer func() { _ = zipFile.Close() }()
-
-				_, err := io.Copy(writer, zipFile)
+				_, err := args.Get(5).(io.Writer).Write(zipf[:len(zipf)/2])
 				require.NoError(t, err)
 			}).
 			Return(nil)
+		dtc.
+			On("GetAgentChecksum", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
+				mock.AnythingOfType("string"), testVersion).
+			Return("", nil)
 		installAgentCfg := &installAgentConfig{
-			fs:        fs,
-			dtc:       dtc,
-			logger:    log,
-			targetDir: testDir,
+			fs:         fs,
+			dtc:        dtc,
+			logger:     log,
+			version:    testVersion,
+			targetDir:  filepath.Join("bin", testDir),
+			stagingDir: filepath.Join(dtcsi.StagingDir, testDir),
+		}
+
+		err = installAgent(installAgentCfg)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to open ZIP file")
+
+		exists, err := afero.Exists(fs, installAgentCfg.targetDir)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+	t.Run(`downloading agent in cluster`, func(t *testing.T) {
+		fs := newTestOsFs(t)
+		zipf, err := base64.StdEncoding.DecodeString(testZip)
+		require.NoError(t, err)
+		installAgentCfg := &installAgentConfig{
+			fs:           fs,
+			dtc:          mockChecksum(testChecksum(t)),
+			distribution: &fakeDistribution{content: zipf, checksum: testChecksum(t)},
+			dynakube:     dkName,
+			logger:       log,
+			version:      testVersion,
+			targetDir:    filepath.Join("bin", testDir),
+			stagingDir:   filepath.Join(dtcsi.StagingDir, testDir),
+		}
+
+		require.NoError(t, installAgent(installAgentCfg))
+
+		exists, err := afero.Exists(fs, filepath.Join("bin", testDir, testFilename))
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+	t.Run(`falls back to tenant if in-cluster download fails`, func(t *testing.T) {
+		for name, distribution := range map[string]*fakeDistribution{
+			"unavailable":       {err: fmt.Errorf(errorMsg)},
+			"checksum mismatch": {content: []byte("partial"), checksum: testChecksum(t)},
+			"tampered package":  {content: []byte("tampered"), checksum: sha256Hex([]byte("tampered"))},
+		} {
+			t.Run(name, func(t *testing.T) {
+				fs := newTestOsFs(t)
+				installAgentCfg := &installAgentConfig{
+					fs:           fs,
+					dtc:          mockDownload(t, fs, testChecksum(t)),
+					distribution: distribution,
+					dynakube:     dkName,
+					logger:       log,
+					version:      testVersion,
+					targetDir:    filepath.Join("bin", testDir),
+					stagingDir:   filepath.Join(dtcsi.StagingDir, testDir),
+				}
+
+				require.NoError(t, installAgent(installAgentCfg))
+
+				info, err := fs.Stat(filepath.Join("bin", testDir, testFilename))
+				require.NoError(t, err)
+				assert.Equal(t, int64(25), info.Size())
+			})
+		}
+	})
+	t.Run(`downloads from tenant if no checksum is provided`, func(t *testing.T) {
+		fs := newTestOsFs(t)
+		zipf, err := base64.StdEncoding.DecodeString(testZip)
+		require.NoError(t, err)
+		distribution := &fakeDistribution{content: zipf, checksum: testChecksum(t)}
+		dtc := mockDownload(t, fs, "")
+		installAgentCfg := &installAgentConfig{
+			fs:           fs,
+			dtc:          dtc,
+			distribution: distribution,
+			dynakube:     dkName,
+			logger:       log,
+			version:      testVersion,
+			targetDir:    filepath.Join("bin", testDir),
+			stagingDir:   filepath.Join(dtcsi.StagingDir, testDir),
+		}
+
+		before := testutil.ToFloat64(missingChecksumsMetric.WithLabelValues(dtclient.InstallerTypePaaS))
+
+		require.NoError(t, installAgent(installAgentCfg))
+
+		dtc.AssertCalled(t, "GetAgent", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
+			mock.AnythingOfType("string"), testVersion, mock.Anything)
+		assert.Equal(t, before+1, testutil.ToFloat64(missingChecksumsMetric.WithLabelValues(dtclient.InstallerTypePaaS)))
+	})
+	t.Run(`rejects package without checksum if checksums are required`, func(t *testing.T) {
+		fs := newTestOsFs(t)
+		installAgentCfg := &installAgentConfig{
+			fs:               fs,
+			dtc:              mockDownload(t, fs, ""),
+			logger:           log,
+			version:          testVersion,
+			targetDir:        filepath.Join("bin", testDir),
+			stagingDir:       filepath.Join(dtcsi.StagingDir, testDir),
+			requireChecksums: true,
 		}
 
 		err := installAgent(installAgentCfg)
-		assert.NoError(t, err)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "checksums are required")
 
-		info, err := fs.Stat(filepath.Join(testDir, testFilename))
-		assert.NoError(t, err)
-		assert.NotNil(t, info)
-		assert.False(t, info.IsDir())
-		assert.Equal(t, int64(25), info.Size())
+		exists, err := afero.Exists(fs, installAgentCfg.targetDir)
+		require.NoError(t, err)
+		assert.False(t, exists)
 	})
 }
 
@@ -125,8 +301,8 @@ func TestOneAgentProvisioner_Unzip(t *testing.T) {
 	t.Run(`create output directory`, func(t *testing.T) {
 		fs := afero.NewMemMapFs()
 		installAgentCfg := &installAgentConfig{
-			targetDir: testDir,
-			fs:        fs,
+			stagingDir: testDir,
+			fs:         fs,
 		}
 		zipReader := &zip.Reader{File: nil}
 		err := unzip(zipReader, installAgentCfg)
@@ -140,8 +316,8 @@ func TestOneAgentProvisioner_Unzip(t *testing.T) {
 	t.Run(`illegal file path`, func(t *testing.T) {
 		fs := afero.NewMemMapFs()
 		installAgentCfg := &installAgentConfig{
-			targetDir: "/",
-			fs:        fs,
+			stagingDir: "/",
+			fs:         fs,
 		}
 		zipFile := setupTestZip(t, fs)
 		defer func() { _ = zipFile.Close() }()
@@ -158,8 +334,8 @@ func TestOneAgentProvisioner_Unzip(t *testing.T) {
 	t.Run(`unzip test zip file`, func(t *testing.T) {
 		fs := afero.NewMemMapFs()
 		installAgentCfg := &installAgentConfig{
-			targetDir: testDir,
-			fs:        fs,
+			stagingDir: testDir,
+			fs:         fs,
 		}
 		zipFile := setupTestZip(t, fs)
 		defer func() { _ = zipFile.Close() }()
@@ -185,7 +361,7 @@ func TestOneAgentProvisioner_Unzip(t *testing.T) {
 		require.NoError(t, err)
 		assert.True(t, exists)
 
-		exists, err = afero.Exists(fs, filepath.Join(testDir, agentConfPath, testFilename))
+		exists, err = afero.Exists(fs, filepath.Join(testDir, installer.AgentConfPath, testFilename))
 		require.NoError(t, err)
 		assert.True(t, exists)
 
@@ -207,7 +383,7 @@ func TestOneAgentProvisioner_Unzip(t *testing.T) {
 		assert.False(t, info.IsDir())
 		assert.Equal(t, int64(25), info.Size())
 
-		info, err = fs.Stat(filepath.Join(testDir, agentConfPath, testFilename))
+		info, err = fs.Stat(filepath.Join(testDir, installer.AgentConfPath, testFilename))
 		require.NoError(t, err)
 		require.NotNil(t, info)
 		assert.False(t, info.IsDir())
@@ -237,3 +413,53 @@ func setupTestZip(t *testing.T, fs afero.Fs) afero.File {
 
 	return zipFile
 }
+
+func testChecksum(t *testing.T) string {
+	zipf, err := base64.StdEncoding.DecodeString(testZip)
+	require.NoError(t, err)
+
+	return sha256Hex(zipf)
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func mockChecksum(checksum string) *dtclient.MockDynatraceClient {
+	dtc := &dtclient.MockDynatraceClient{}
+	dtc.
+		On("GetAgentChecksum", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
+			mock.AnythingOfType("string"), testVersion).
+		Return(checksum, nil)
+	return dtc
+}
+
+func mockDownload(t *testing.T, fs afero.Fs, checksum string) *dtclient.MockDynatraceClient {
+	dtc := &dtclient.MockDynatraceClient{}
+	dtc.
+		On("GetAgent", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
+			mock.AnythingOfType("string"), testVersion, mock.Anything).
+		Run(func(args mock.Arguments) {
+			writer := args.Get(5).(io.Writer)
+
+			zipFile := setupTestZip(t, fs)
+			defer func() { _ = zipFile.Close() }()
+
+			_, err := io.Copy(writer, zipFile)
+			require.NoError(t, err)
+		}).
+		Return(nil)
+	dtc.
+		On("GetAgentChecksum", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
+			mock.AnythingOfType("string"), testVersion).
+		Return(checksum, nil)
+	return dtc
+}
+
+// newTestOsFs returns a file system in a temporary directory, since directories of the MemMapFs can't be renamed
+func newTestOsFs(t *testing.T) afero.Fs {
+	fs := afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+	require.NoError(t, fs.MkdirAll(os.TempDir(), 0755))
+	return fs
+}
@@ -0,0 +1,85 @@
+package csiprovisioner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/go-logr/logr"
+	"github.com/spf13/afero"
+)
+
+// installHostAgentVersion downloads the installer of the full-stack host agent into the directory mounted by host agent
+// volumes, if not installed yet. The installer is not distributed in the cluster, it's always fetched from the tenant.
+func (r *OneAgentProvisioner) installHostAgentVersion(version string, envDir string, dtc dtclient.Client, logger logr.Logger) error {
+	targetDir := dtcsi.HostAgentDir(envDir, version)
+	if _, err := r.fs.Stat(targetDir); !os.IsNotExist(err) {
+		return err
+	}
+
+	arch := dtclient.ArchX86
+	if runtime.GOARCH == "arm64" {
+		arch = dtclient.ArchARM
+	}
+
+	stagingDir := filepath.Join(envDir, dtcsi.StagingDir, filepath.Base(targetDir))
+	// Leftovers of interrupted installations are replaced
+	_ = r.fs.RemoveAll(stagingDir)
+	if err := r.fs.MkdirAll(stagingDir, 0755); err != nil {
+		return fmt.Errorf("failed to create staging directory for host agent: %w", err)
+	}
+
+	if err := r.downloadHostAgent(version, arch, stagingDir, dtc, logger); err != nil {
+		_ = r.fs.RemoveAll(stagingDir)
+		return err
+	}
+
+	if err := r.fs.MkdirAll(filepath.Dir(targetDir), 0755); err != nil {
+		_ = r.fs.RemoveAll(stagingDir)
+		return fmt.Errorf("failed to create directory for host agent installer: %w", err)
+	}
+	if err := r.fs.Rename(stagingDir, targetDir); err != nil {
+		_ = r.fs.RemoveAll(stagingDir)
+		return fmt.Errorf("failed to move host agent installer to %s: %w", targetDir, err)
+	}
+
+	logger.Info("Installed host agent installer", "dest", targetDir)
+	return nil
+}
+
+func (r *OneAgentProvisioner) downloadHostAgent(version string, arch string, stagingDir string, dtc dtclient.Client, logger logr.Logger) error {
+	file, err := r.fs.OpenFile(filepath.Join(stagingDir, dtcsi.HostAgentInstaller), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create host agent installer: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	logger.Info("Downloading host agent installer", "version", version, "architecture", arch)
+	if err := dtc.GetAgent(dtclient.OsUnix, dtclient.InstallerTypeDefault, dtclient.FlavorDefault, arch, version, file); err != nil {
+		return fmt.Errorf("failed to fetch host agent version %s: %w", version, err)
+	}
+
+	return verifyHostAgentChecksum(dtc, file, arch, version, r.opts.RequireChecksums, logger)
+}
+
+func verifyHostAgentChecksum(dtc dtclient.Client, file afero.File, arch string, version string, requireChecksums bool, logger logr.Logger) error {
+	expected, err := dtc.GetAgentChecksum(dtclient.OsUnix, dtclient.InstallerTypeDefault, dtclient.FlavorDefault, arch, version)
+	if err != nil {
+		return fmt.Errorf("failed to fetch checksum: %w", err)
+	}
+	if expected == "" {
+		if err := handleMissingChecksum(dtclient.InstallerTypeDefault, requireChecksums, logger); err != nil {
+			return fmt.Errorf("failed to verify host agent installer: %w", err)
+		}
+		return nil
+	}
+
+	if err := compareChecksum(file, expected); err != nil {
+		verificationFailuresMetric.Inc()
+		return fmt.Errorf("failed to verify host agent installer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package csiprovisioner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"testing"
+
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/Dynatrace/dynatrace-operator/logger"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testInstaller = "#!/bin/sh\necho host agent\n"
+
+func mockHostAgent(dtc *dtclient.MockDynatraceClient, checksum string) {
+	dtc.On("GetAgent", dtclient.OsUnix, dtclient.InstallerTypeDefault, dtclient.FlavorDefault, mock.AnythingOfType("string"), testVersion, mock.Anything).
+		Run(func(args mock.Arguments) {
+			_, _ = io.WriteString(args.Get(5).(io.Writer), testInstaller)
+		}).
+		Return(nil)
+	dtc.On("GetAgentChecksum", dtclient.OsUnix, dtclient.InstallerTypeDefault, dtclient.FlavorDefault, mock.AnythingOfType("string"), testVersion).
+		Return(checksum, nil)
+}
+
+func TestOneAgentProvisioner_InstallHostAgentVersion(t *testing.T) {
+	envDir := filepath.Join("/data", tenantUUID)
+	targetDir := dtcsi.HostAgentDir(envDir, testVersion)
+	hash := sha256.Sum256([]byte(testInstaller))
+
+	t.Run(`installs verified installer`, func(t *testing.T) {
+		dtc := &dtclient.MockDynatraceClient{}
+		mockHostAgent(dtc, hex.EncodeToString(hash[:]))
+		// Directories are renamed with their content on a real filesystem only
+		r := &OneAgentProvisioner{fs: afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())}
+
+		require.NoError(t, r.installHostAgentVersion(testVersion, envDir, dtc, logger.NewDTLogger()))
+
+		content, err := afero.ReadFile(r.fs, filepath.Join(targetDir, dtcsi.HostAgentInstaller))
+		require.NoError(t, err)
+		assert.Equal(t, testInstaller, string(content))
+
+		require.NoError(t, r.installHostAgentVersion(testVersion, envDir, dtc, logger.NewDTLogger()))
+		dtc.AssertNumberOfCalls(t, "GetAgent", 1)
+	})
+	t.Run(`does not install installer with checksum mismatch`, func(t *testing.T) {
+		dtc := &dtclient.MockDynatraceClient{}
+		mockHostAgent(dtc, "invalid")
+		r := &OneAgentProvisioner{fs: afero.NewMemMapFs()}
+
+		assert.Error(t, r.installHostAgentVersion(testVersion, envDir, dtc, logger.NewDTLogger()))
+
+		exists, _ := afero.DirExists(r.fs, targetDir)
+		assert.False(t, exists)
+		exists, _ = afero.DirExists(r.fs, filepath.Join(envDir, dtcsi.StagingDir, filepath.Base(targetDir)))
+		assert.False(t, exists)
+	})
+}
@@ -0,0 +1,145 @@
+package csiprovisioner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ReadyzCheck reports the CSI driver as ready once the OneAgent has been provisioned for all DynaKubes using it, or the
+// provisioning timeout has passed. The driver is always ready if no provisioning timeout is configured.
+func (r *OneAgentProvisioner) ReadyzCheck(req *http.Request) error {
+	if r.provisioningTimedOut() {
+		return nil
+	}
+
+	pending, err := r.getUnprovisionedDynaKubes(req.Context())
+	if err != nil {
+		return err
+	} else if len(pending) > 0 {
+		return fmt.Errorf("OneAgent has not been provisioned yet for DynaKubes %v", pending)
+	}
+	return nil
+}
+
+// provisioningTimedOut returns true if the driver doesn't wait for the provisioning (anymore)
+func (r *OneAgentProvisioner) provisioningTimedOut() bool {
+	return r.opts.ProvisioningTimeout <= 0 || time.Since(r.started) >= r.opts.ProvisioningTimeout
+}
+
+// untaintNodeAfterTimeout removes the not-ready taint from the node once the provisioning timeout has passed, even if
+// the OneAgent hasn't been provisioned for all DynaKubes by then. Without a provisioning timeout the taint is removed
+// right away. The taint is never added by the driver, nodes have to be registered with it by the kubelet.
+func (r *OneAgentProvisioner) untaintNodeAfterTimeout(ctx context.Context) error {
+	if r.opts.ProvisioningTimeout > 0 {
+		timer := time.NewTimer(time.Until(r.started.Add(r.opts.ProvisioningTimeout)))
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+
+		if pending, err := r.getUnprovisionedDynaKubes(ctx); err == nil && len(pending) > 0 {
+			log.Info("OneAgent has not been provisioned within the provisioning timeout", "node", r.opts.NodeID, "dynakubes", pending)
+		}
+	}
+
+	if err := r.removeNodeTaint(ctx); err != nil {
+		log.Error(err, "failed to remove taint from node", "node", r.opts.NodeID)
+	}
+	return nil
+}
+
+// removeNodeTaintIfProvisioned removes the taint of the node once the OneAgent has been provisioned for all DynaKubes
+func (r *OneAgentProvisioner) removeNodeTaintIfProvisioned(ctx context.Context) {
+	if r.provisioningTimedOut() {
+		return
+	}
+
+	pending, err := r.getUnprovisionedDynaKubes(ctx)
+	if err != nil {
+		log.Error(err, "failed to check if the OneAgent has been provisioned")
+		return
+	} else if len(pending) > 0 {
+		return
+	}
+
+	if err := r.removeNodeTaint(ctx); err != nil {
+		log.Error(err, "failed to remove taint from node", "node", r.opts.NodeID)
+	}
+}
+
+// getUnprovisionedDynaKubes returns the names of the DynaKubes using the CSI driver which have no version provisioned
+// on the node yet
+func (r *OneAgentProvisioner) getUnprovisionedDynaKubes(ctx context.Context) ([]string, error) {
+	var dynakubes dynatracev1alpha1.DynaKubeList
+	if err := r.client.List(ctx, &dynakubes); err != nil {
+		return nil, fmt.Errorf("failed to list DynaKubes: %w", err)
+	}
+
+	var pending []string
+	for i := range dynakubes.Items {
+		dk := &dynakubes.Items[i]
+		if !hasCodeModulesWithCSIVolumeEnabled(dk) {
+			continue
+		}
+
+		tenant, err := r.db.GetTenant(dk.Name)
+		if err != nil {
+			return nil, err
+		}
+		if tenant == nil || tenant.LatestVersion == "" || tenant.TenantUUID != dk.ConnectionInfo().TenantUUID {
+			pending = append(pending, dk.Name)
+		}
+	}
+	return pending, nil
+}
+
+// removeNodeTaint removes the not-ready taint of the CSI driver from its node
+func (r *OneAgentProvisioner) removeNodeTaint(ctx context.Context) error {
+	if r.opts.NodeID == "" {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var node corev1.Node
+		if err := r.apiReader.Get(ctx, client.ObjectKey{Name: r.opts.NodeID}, &node); err != nil {
+			return err
+		}
+		if !hasTaint(node.Spec.Taints) {
+			return nil
+		}
+
+		var taints []corev1.Taint
+		for _, taint := range node.Spec.Taints {
+			if taint.Key != dtcsi.NodeNotReadyTaintKey {
+				taints = append(taints, taint)
+			}
+		}
+
+		// The taints are replaced as a whole by the merge patch, the optimistic lock keeps taints added in the
+		// meantime
+		log.Info("removing taint from node", "node", r.opts.NodeID)
+		patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		node.Spec.Taints = taints
+		return r.client.Patch(ctx, &node, patch)
+	})
+}
+
+func hasTaint(taints []corev1.Taint) bool {
+	for _, taint := range taints {
+		if taint.Key == dtcsi.NodeNotReadyTaintKey {
+			return true
+		}
+	}
+	return false
+}
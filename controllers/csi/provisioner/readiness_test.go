@@ -0,0 +1,112 @@
+package csiprovisioner
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
+	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	testNode  = "node-1"
+	namespace = "dynatrace"
+)
+
+func newReadinessProvisioner(t *testing.T, timeout time.Duration, objs ...client.Object) *OneAgentProvisioner {
+	clt := fake.NewClient(append(objs,
+		// The taint is removed by a patch with optimistic lock, which requires a resource version
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: testNode, ResourceVersion: "1"},
+			Spec: v1.NodeSpec{Taints: []v1.Taint{
+				{Key: "other", Effect: v1.TaintEffectNoExecute},
+				{Key: dtcsi.NodeNotReadyTaintKey, Effect: v1.TaintEffectNoSchedule},
+			}},
+		},
+		&v1alpha1.DynaKube{
+			ObjectMeta: metav1.ObjectMeta{Name: dkName, Namespace: namespace},
+			Spec:       v1alpha1.DynaKubeSpec{CodeModules: v1alpha1.CodeModulesSpec{Enabled: true}},
+			Status:     v1alpha1.DynaKubeStatus{ConnectionInfo: v1alpha1.ConnectionInfoStatus{TenantUUID: tenantUUID}},
+		},
+		&v1alpha1.DynaKube{ObjectMeta: metav1.ObjectMeta{Name: "disabled", Namespace: namespace}},
+	)...)
+	return &OneAgentProvisioner{
+		client:    clt,
+		apiReader: clt,
+		opts:      dtcsi.CSIOptions{NodeID: testNode, ProvisioningTimeout: timeout},
+		db:        newTestDB(t),
+		started:   time.Now(),
+	}
+}
+
+func getNodeTaints(t *testing.T, r *OneAgentProvisioner) []v1.Taint {
+	var node v1.Node
+	require.NoError(t, r.client.Get(context.TODO(), client.ObjectKey{Name: testNode}, &node))
+	return node.Spec.Taints
+}
+
+func TestOneAgentProvisioner_Readiness(t *testing.T) {
+	notReadyTaint := v1.Taint{Key: dtcsi.NodeNotReadyTaintKey, Effect: v1.TaintEffectNoSchedule}
+	otherTaint := v1.Taint{Key: "other", Effect: v1.TaintEffectNoExecute}
+
+	t.Run(`node not ready until provisioned`, func(t *testing.T) {
+		r := newReadinessProvisioner(t, time.Hour)
+
+		request, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+		assert.EqualError(t, r.ReadyzCheck(request), "OneAgent has not been provisioned yet for DynaKubes [dynakube-test]")
+
+		r.removeNodeTaintIfProvisioned(context.TODO())
+		assert.Equal(t, []v1.Taint{otherTaint, notReadyTaint}, getNodeTaints(t, r))
+
+		require.NoError(t, r.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUUID, LatestVersion: agentVersion}))
+		r.removeNodeTaintIfProvisioned(context.TODO())
+
+		assert.Equal(t, []v1.Taint{otherTaint}, getNodeTaints(t, r))
+		assert.NoError(t, r.ReadyzCheck(request))
+	})
+	t.Run(`node ready after the provisioning timeout`, func(t *testing.T) {
+		r := newReadinessProvisioner(t, time.Millisecond)
+
+		require.NoError(t, r.untaintNodeAfterTimeout(context.TODO()))
+		assert.Equal(t, []v1.Taint{otherTaint}, getNodeTaints(t, r))
+
+		request, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+		assert.NoError(t, r.ReadyzCheck(request))
+	})
+	t.Run(`node ready right away without provisioning timeout`, func(t *testing.T) {
+		r := newReadinessProvisioner(t, 0)
+
+		request, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+		assert.NoError(t, r.ReadyzCheck(request))
+
+		require.NoError(t, r.untaintNodeAfterTimeout(context.TODO()))
+		assert.Equal(t, []v1.Taint{otherTaint}, getNodeTaints(t, r))
+	})
+	t.Run(`taint is kept until the provisioning timeout`, func(t *testing.T) {
+		r := newReadinessProvisioner(t, time.Hour)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+		require.NoError(t, r.untaintNodeAfterTimeout(ctx))
+
+		assert.Equal(t, []v1.Taint{otherTaint, notReadyTaint}, getNodeTaints(t, r))
+	})
+	t.Run(`tenant of other connection is not provisioned`, func(t *testing.T) {
+		r := newReadinessProvisioner(t, time.Hour)
+		require.NoError(t, r.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: "other", LatestVersion: agentVersion}))
+
+		pending, err := r.getUnprovisionedDynaKubes(context.TODO())
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{dkName}, pending)
+	})
+}
@@ -26,18 +26,25 @@ import (
 
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/distribution"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
 	"github.com/Dynatrace/dynatrace-operator/controllers/dynakube"
 	"github.com/Dynatrace/dynatrace-operator/dtclient"
 	"github.com/Dynatrace/dynatrace-operator/logger"
+	"github.com/Dynatrace/dynatrace-operator/webhook"
 	"github.com/go-logr/logr"
 	"github.com/spf13/afero"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 var (
@@ -47,30 +54,70 @@ var (
 // OneAgentProvisioner reconciles a DynaKube object
 type OneAgentProvisioner struct {
 	client       client.Client
+	apiReader    client.Reader
 	opts         dtcsi.CSIOptions
 	dtcBuildFunc dynakube.DynatraceClientFunc
 	fs           afero.Fs
+	db           metadata.Access
+	distribution agentDistribution
+	// started is the time the driver started, the provisioning timeout is counted from
+	started time.Time
+	// triggers receives DynaKubes the CSI driver is waiting for, which are reconciled right away
+	triggers chan event.GenericEvent
 }
 
 // NewReconciler returns a new OneAgentProvisioner
-func NewReconciler(mgr manager.Manager, opts dtcsi.CSIOptions) *OneAgentProvisioner {
-	return &OneAgentProvisioner{
+func NewReconciler(mgr manager.Manager, opts dtcsi.CSIOptions, db metadata.Access) *OneAgentProvisioner {
+	r := &OneAgentProvisioner{
 		client:       mgr.GetClient(),
+		apiReader:    mgr.GetAPIReader(),
 		opts:         opts,
 		dtcBuildFunc: dynakube.BuildDynatraceClient,
 		fs:           afero.NewOsFs(),
+		db:           db,
+		started:      time.Now(),
+		triggers:     make(chan event.GenericEvent, 100),
 	}
+	if opts.Distribution.Enabled {
+		r.distribution = distribution.NewClient(mgr.GetAPIReader(), opts.Distribution.Namespace)
+	}
+	return r
 }
 
 func (r *OneAgentProvisioner) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.Add(manager.RunnableFunc(r.untaintNodeAfterTimeout)); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&dynatracev1alpha1.DynaKube{}).
+		Watches(&source.Channel{Source: r.triggers}, &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
 
+// TriggerProvisioning reconciles the DynaKube right away instead of with its next periodic reconciliation, so versions
+// and flavors requested by a volume are installed before the CSI driver stops waiting for them. Triggers are dropped
+// while the queue is full, since the DynaKube is reconciled anyway then.
+func (r *OneAgentProvisioner) TriggerProvisioning(dynakube string) {
+	select {
+	case r.triggers <- event.GenericEvent{Object: &dynatracev1alpha1.DynaKube{
+		ObjectMeta: metav1.ObjectMeta{Name: dynakube, Namespace: r.opts.Distribution.Namespace},
+	}}:
+	default:
+	}
+}
+
 var _ reconcile.Reconciler = &OneAgentProvisioner{}
 
 func (r *OneAgentProvisioner) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	result, err := r.reconcile(ctx, request)
+	if err == nil {
+		r.removeNodeTaintIfProvisioned(ctx)
+	}
+	return result, err
+}
+
+func (r *OneAgentProvisioner) reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	rlog := log.WithValues("namespace", request.Namespace, "name", request.Name)
 	rlog.Info("Reconciling DynaKube")
 
@@ -82,8 +129,12 @@ func (r *OneAgentProvisioner) Reconcile(ctx context.Context, request reconcile.R
 		return reconcile.Result{}, err
 	}
 	if !hasCodeModulesWithCSIVolumeEnabled(dk) {
-		rlog.Info("Code modules or csi driver disabled")
-		return reconcile.Result{RequeueAfter: 30 * time.Minute}, nil
+		if referenced, err := r.hasVolumesOfDynaKube(ctx, dk); err != nil {
+			return reconcile.Result{}, err
+		} else if !referenced {
+			rlog.Info("Code modules or csi driver disabled")
+			return reconcile.Result{RequeueAfter: 30 * time.Minute}, nil
+		}
 	}
 
 	if dk.ConnectionInfo().TenantUUID == "" {
@@ -98,17 +149,46 @@ func (r *OneAgentProvisioner) Reconcile(ctx context.Context, request reconcile.R
 
 	ci := dk.ConnectionInfo()
 	envDir := filepath.Join(r.opts.RootDir, ci.TenantUUID)
-	tenantFile := filepath.Join(r.opts.RootDir, fmt.Sprintf("tenant-%s", dk.Name))
 
 	if err = r.createCSIDirectories(envDir); err != nil {
 		return reconcile.Result{}, err
 	}
 
-	if err = r.updateTenantFile(ci.TenantUUID, tenantFile); err != nil {
+	tenant, err := r.db.GetTenant(dk.Name)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if tenant == nil || tenant.TenantUUID != ci.TenantUUID {
+		tenant = &metadata.Tenant{DynakubeName: dk.Name, TenantUUID: ci.TenantUUID}
+	}
+
+	versions, hostAgentVersions, err := r.requestedVersions(ctx, dk)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	podFlavors, err := r.requestedFlavors(ctx, dk)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err = r.updateAgent(dk, dtc, envDir, tenant.LatestVersion, versions, additionalFlavors(dk, podFlavors), rlog); err != nil {
 		return reconcile.Result{}, err
 	}
 
-	if err = r.updateAgent(dk, dtc, envDir, rlog); err != nil {
+	for _, version := range hostAgentVersions {
+		if err = r.installHostAgentVersion(version, envDir, dtc, rlog); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	// The tenant is only updated once its versions are installed, the garbage collector keeps the requested versions
+	tenant.RequestedVersions = versions
+	if len(versions) > 0 {
+		tenant.LatestVersion = versions[0]
+	}
+	tenant.HostAgentVersions = hostAgentVersions
+	if err = r.db.UpdateTenant(tenant); err != nil {
 		return reconcile.Result{}, err
 	}
 
@@ -129,6 +209,26 @@ func buildDtc(r *OneAgentProvisioner, ctx context.Context, dk *dynatracev1alpha1
 	return dtc, nil
 }
 
+// GetDistributionSource returns the Dynatrace client and the tenant UUID of a DynaKube for the distributing pod, which
+// downloads the OneAgent packages from its tenant
+func (r *OneAgentProvisioner) GetDistributionSource(ctx context.Context, dkName string) (dtclient.Client, string, error) {
+	dk, err := r.getDynaKube(ctx, types.NamespacedName{Name: dkName, Namespace: r.opts.Distribution.Namespace})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query DynaKube %s: %w", dkName, err)
+	}
+
+	tenantUUID := dk.ConnectionInfo().TenantUUID
+	if tenantUUID == "" {
+		return nil, "", fmt.Errorf("DynaKube %s has not been reconciled yet", dkName)
+	}
+
+	dtc, err := buildDtc(r, ctx, dk)
+	if err != nil {
+		return nil, "", err
+	}
+	return dtc, tenantUUID, nil
+}
+
 func (r *OneAgentProvisioner) getDynaKube(ctx context.Context, name types.NamespacedName) (*dynatracev1alpha1.DynaKube, error) {
 	var dk dynatracev1alpha1.DynaKube
 	err := r.client.Get(ctx, name, &dk)
@@ -136,66 +236,225 @@ func (r *OneAgentProvisioner) getDynaKube(ctx context.Context, name types.Namesp
 	return &dk, err
 }
 
-func (r *OneAgentProvisioner) updateAgent(dk *dynatracev1alpha1.DynaKube, dtc dtclient.Client, envDir string, logger logr.Logger) error {
-	versionFile := filepath.Join(envDir, dtcsi.VersionDir)
-	ver := dk.Status.LatestAgentVersionUnixPaas
+// updateAgent installs the requested versions, the first one being the default version of the DynaKube, which is
+// mounted into pods not requesting a specific version, each with the multidistro and the additional flavors
+func (r *OneAgentProvisioner) updateAgent(dk *dynatracev1alpha1.DynaKube, dtc dtclient.Client, envDir string, currentVersion string, versions []string, flavors []string, logger logr.Logger) error {
+	for _, ver := range versions {
+		if ver != currentVersion {
+			if err := r.installAgentVersion(dk.Name, ver, dtclient.FlavorMultidistro, envDir, dtc, logger); err != nil {
+				return err
+			}
+		}
+
+		for _, flavor := range flavors {
+			if err := r.installAgentVersion(dk.Name, ver, flavor, envDir, dtc, logger); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// requestedVersions returns the versions of the OneAgent package and of the host agent requested for the DynaKube,
+// each starting with its default version. The default version of the OneAgent package is the pinned or the latest
+// version. Namespaces and pods on this node can request other versions by the version annotation, which is passed as
+// volume attribute to the CSI driver. Host agent versions are only requested by volumes of pods on this node.
+func (r *OneAgentProvisioner) requestedVersions(ctx context.Context, dk *dynatracev1alpha1.DynaKube) ([]string, []string, error) {
+	var versions, podVersions, hostAgentVersions, podHostAgentVersions []string
+	add := func(versions *[]string, version string) {
+		for _, v := range *versions {
+			if v == version {
+				return
+			}
+		}
+		if version != "" {
+			*versions = append(*versions, version)
+		}
+	}
+
+	namespaces, err := r.injectedNamespaces(ctx, dk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	injected := map[string]bool{}
+	var nsVersions []string
+	for _, ns := range namespaces {
+		injected[ns.Name] = true
+
+		if version := ns.Annotations[webhook.AnnotationVersion]; dtcsi.IsValidVersion(version) {
+			add(&nsVersions, version)
+		}
+	}
+
+	pods, err := r.podsOnNode(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	codeModulesRequested := hasCodeModulesWithCSIVolumeEnabled(dk)
+	hostAgentRequested := false
+	for _, pod := range pods {
+		for _, vol := range pod.Spec.Volumes {
+			if !isDynatraceOneAgentCSIVolumeSource(&vol.VolumeSource) || !isVolumeOfDynaKube(vol.CSI, pod.Namespace, injected, dk) {
+				continue
+			}
+
+			version := vol.CSI.VolumeAttributes[dtcsi.VersionVolumeAttribute]
+			if !dtcsi.IsValidVersion(version) {
+				version = ""
+			}
+			if vol.CSI.VolumeAttributes[dtcsi.TypeVolumeAttribute] == dtcsi.HostAgentVolumeType {
+				hostAgentRequested = true
+				add(&podHostAgentVersions, version)
+			} else {
+				codeModulesRequested = true
+				add(&podVersions, version)
+			}
+		}
+	}
 
-	var currentVersion string
-	if currentVersionBytes, err := afero.ReadFile(r.fs, versionFile); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to query installed OneAgent latestAgentVersion: %w", err)
-	} else {
-		currentVersion = string(currentVersionBytes)
+	if codeModulesRequested {
+		defaultVersion := dk.Spec.CodeModules.Version
+		if defaultVersion == "" {
+			defaultVersion = dk.Status.LatestAgentVersionUnixPaas
+		}
+		add(&versions, defaultVersion)
+		for _, version := range append(nsVersions, podVersions...) {
+			add(&versions, version)
+		}
 	}
 
-	if ver != currentVersion {
-		if err := r.installAgentVersion(ver, envDir, dtc, logger); err != nil {
-			return err
+	if hostAgentRequested {
+		add(&hostAgentVersions, dk.Status.LatestAgentVersionUnixDefault)
+		for _, version := range podHostAgentVersions {
+			add(&hostAgentVersions, version)
 		}
 	}
 
-	return afero.WriteFile(r.fs, versionFile, []byte(ver), 0644)
+	return versions, hostAgentVersions, nil
 }
 
-func (r *OneAgentProvisioner) installAgentVersion(version string, envDir string, dtc dtclient.Client, logger logr.Logger) error {
-	versionFile := filepath.Join(envDir, dtcsi.VersionDir)
-	arch := dtclient.ArchX86
-	if runtime.GOARCH == "arm64" {
-		arch = dtclient.ArchARM
+// injectedNamespaces returns the namespaces the DynaKube injects into
+func (r *OneAgentProvisioner) injectedNamespaces(ctx context.Context, dk *dynatracev1alpha1.DynaKube) ([]corev1.Namespace, error) {
+	var dynakubes dynatracev1alpha1.DynaKubeList
+	if err := r.client.List(ctx, &dynakubes, client.InNamespace(dk.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to query DynaKubes: %w", err)
 	}
 
-	gcDir := filepath.Join(envDir, dtcsi.GarbageCollectionPath, version)
-	if err := r.fs.MkdirAll(gcDir, 0755); err != nil {
-		logger.Error(err, "failed to create directory %s: %w", gcDir)
-		return err
+	var namespaces corev1.NamespaceList
+	if err := r.client.List(ctx, &namespaces); err != nil {
+		return nil, fmt.Errorf("failed to query namespaces: %w", err)
 	}
 
-	targetDir := filepath.Join(envDir, "bin", version)
+	var injected []corev1.Namespace
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		if match, err := webhook.FindDynaKube(ns, dynakubes.Items); err == nil && match != nil && match.Name == dk.Name {
+			injected = append(injected, *ns)
+		}
+	}
+	return injected, nil
+}
 
-	if _, err := r.fs.Stat(targetDir); os.IsNotExist(err) {
-		installAgentCfg := newInstallAgentConfig(logger, dtc, arch, targetDir)
+// requestedFlavors returns the flavors of the OneAgent package requested by the volumes of pods on this node, e.g. by
+// the flavor annotation, so pods don't silently fall back to multidistro if the flavor rules don't cover them
+func (r *OneAgentProvisioner) requestedFlavors(ctx context.Context, dk *dynatracev1alpha1.DynaKube) ([]string, error) {
+	pods, err := r.podsOnNode(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		if err := installAgent(installAgentCfg); err != nil {
-			_ = r.fs.RemoveAll(targetDir)
+	namespaces, err := r.injectedNamespaces(ctx, dk)
+	if err != nil {
+		return nil, err
+	}
+	injected := map[string]bool{}
+	for _, ns := range namespaces {
+		injected[ns.Name] = true
+	}
 
-			return fmt.Errorf("failed to install agent: %w", err)
+	var flavors []string
+	for _, pod := range pods {
+		for _, vol := range pod.Spec.Volumes {
+			if !isDynatraceOneAgentCSIVolumeSource(&vol.VolumeSource) || !isVolumeOfDynaKube(vol.CSI, pod.Namespace, injected, dk) ||
+				vol.CSI.VolumeAttributes[dtcsi.TypeVolumeAttribute] == dtcsi.HostAgentVolumeType {
+				continue
+			}
+			if flavor := vol.CSI.VolumeAttributes[dtcsi.FlavorVolumeAttribute]; flavor != "" {
+				flavors = append(flavors, flavor)
+			}
 		}
 	}
+	return flavors, nil
+}
 
-	_ = afero.WriteFile(r.fs, versionFile, []byte(version), 0644)
+// hasVolumesOfDynaKube checks if pods on this node mount volumes selecting the DynaKube by the dynakube volume
+// attribute, which are provisioned even if the DynaKube doesn't use the CSI driver for code modules
+func (r *OneAgentProvisioner) hasVolumesOfDynaKube(ctx context.Context, dk *dynatracev1alpha1.DynaKube) (bool, error) {
+	pods, err := r.podsOnNode(ctx)
+	if err != nil {
+		return false, err
+	}
 
-	return nil
+	namespaces, err := r.injectedNamespaces(ctx, dk)
+	if err != nil {
+		return false, err
+	}
+	injected := map[string]bool{}
+	for _, ns := range namespaces {
+		injected[ns.Name] = true
+	}
+
+	for _, pod := range pods {
+		for _, vol := range pod.Spec.Volumes {
+			if isDynatraceOneAgentCSIVolumeSource(&vol.VolumeSource) && isVolumeOfDynaKube(vol.CSI, pod.Namespace, injected, dk) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (r *OneAgentProvisioner) podsOnNode(ctx context.Context) ([]corev1.Pod, error) {
+	var pods corev1.PodList
+	if err := r.apiReader.List(ctx, &pods, client.MatchingFields{"spec.nodeName": r.opts.NodeID}); err != nil {
+		return nil, fmt.Errorf("failed to query pods on node: %w", err)
+	}
+	return pods.Items, nil
 }
 
-func (r *OneAgentProvisioner) updateTenantFile(tenantUUID string, tenantFile string) error {
-	var oldDynaKubeTenant string
-	if b, err := afero.ReadFile(r.fs, tenantFile); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to query assigned DynaKube tenant: %w", err)
-	} else {
-		oldDynaKubeTenant = string(b)
+// isVolumeOfDynaKube checks if a volume is served by the DynaKube, either selected by the dynakube volume attribute or
+// assigned to the namespace of the pod. The volume attribute is only accepted for namespaces the DynaKube injects into
+// or allows volumes of, like the CSI driver does.
+func isVolumeOfDynaKube(volume *corev1.CSIVolumeSource, namespace string, injected map[string]bool, dk *dynatracev1alpha1.DynaKube) bool {
+	if name, ok := volume.VolumeAttributes[dtcsi.DynaKubeVolumeAttribute]; ok {
+		return name == dk.Name && (injected[namespace] || dk.AllowsVolumeNamespace(namespace))
 	}
+	return injected[namespace]
+}
 
-	if oldDynaKubeTenant != tenantUUID {
-		_ = afero.WriteFile(r.fs, tenantFile, []byte(tenantUUID), 0644)
+func (r *OneAgentProvisioner) installAgentVersion(dkName string, version string, flavor string, envDir string, dtc dtclient.Client, logger logr.Logger) error {
+	arch := dtclient.ArchX86
+	if runtime.GOARCH == "arm64" {
+		arch = dtclient.ArchARM
+	}
+
+	targetDir := dtcsi.AgentBinaryDir(envDir, version, flavor)
+
+	if _, err := r.fs.Stat(targetDir); os.IsNotExist(err) {
+		stagingDir := filepath.Join(envDir, dtcsi.StagingDir, filepath.Base(targetDir))
+		installAgentCfg := newInstallAgentConfig(logger, dtc, arch, flavor, version, targetDir, stagingDir)
+		installAgentCfg.distribution = r.distribution
+		installAgentCfg.dynakube = dkName
+		installAgentCfg.requireChecksums = r.opts.RequireChecksums
+
+		// The current version stays in use until the new one is installed and verified, the installation is retried
+		// with the next reconciliation
+		if err := installAgent(installAgentCfg); err != nil {
+			return fmt.Errorf("failed to install agent: %w", err)
+		}
 	}
 
 	return nil
@@ -209,6 +468,27 @@ func (r *OneAgentProvisioner) createCSIDirectories(envDir string) error {
 	return nil
 }
 
+// additionalFlavors returns the flavors besides multidistro, which are referenced by the flavor rules of the DynaKube
+// or requested by pods
+func additionalFlavors(dk *dynatracev1alpha1.DynaKube, podFlavors []string) []string {
+	var flavors []string
+	seen := map[string]bool{dtclient.FlavorMultidistro: true}
+	add := func(flavor string) {
+		if !seen[flavor] && flavor != "" && dtcsi.IsValidFlavor(flavor) {
+			seen[flavor] = true
+			flavors = append(flavors, flavor)
+		}
+	}
+
+	for _, rule := range dk.Spec.CodeModules.FlavorRules {
+		add(rule.Flavor)
+	}
+	for _, flavor := range podFlavors {
+		add(flavor)
+	}
+	return flavors
+}
+
 func hasCodeModulesWithCSIVolumeEnabled(dk *dynatracev1alpha1.DynaKube) bool {
 	return dk.Spec.CodeModules.Enabled &&
 		(dk.Spec.CodeModules.Volume == corev1.VolumeSource{} || isDynatraceOneAgentCSIVolumeSource(&dk.Spec.CodeModules.Volume))
@@ -3,17 +3,17 @@ package csiprovisioner
 import (
 	"context"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
 	"testing"
 	"time"
 
 	"github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/controllers/csi/metadata"
 	"github.com/Dynatrace/dynatrace-operator/dtclient"
 	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/Dynatrace/dynatrace-operator/webhook"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -22,6 +22,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
@@ -30,7 +31,6 @@ const (
 	errorMsg          = "test-error"
 	tenantUUID        = "test-uid"
 	agentVersion      = "12345"
-	dkTenantMapping   = "tenant-dynakube-test"
 	invalidDriverName = "csi.not.dynatrace.com"
 )
 
@@ -42,23 +42,17 @@ func (fs *mkDirAllErrorFs) MkdirAll(_ string, _ os.FileMode) error {
 	return fmt.Errorf(errorMsg)
 }
 
-type readFileErrorFs struct {
-	afero.Fs
-}
-
-func (fs *readFileErrorFs) Open(_ string) (afero.File, error) {
-	return nil, fmt.Errorf(errorMsg)
-}
-
-type readVersionFileErrorFs struct {
-	afero.Fs
+func newTestDB(t *testing.T) metadata.Access {
+	db, err := metadata.NewAccess(filepath.Join(t.TempDir(), metadata.FileName))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
 }
 
-func (fs *readVersionFileErrorFs) Open(name string) (afero.File, error) {
-	if strings.HasSuffix(name, dtcsi.VersionDir) {
-		return nil, fmt.Errorf(errorMsg)
-	}
-	return fs.Fs.Open(name)
+func closedTestDB(t *testing.T) metadata.Access {
+	db := newTestDB(t)
+	require.NoError(t, db.Close())
+	return db
 }
 
 func TestOneAgentProvisioner_Reconcile(t *testing.T) {
@@ -83,6 +77,7 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 					},
 				},
 			),
+			apiReader: fake.NewClient(),
 		}
 		result, err := r.Reconcile(context.TODO(), reconcile.Request{})
 
@@ -102,6 +97,7 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 					},
 				},
 			),
+			apiReader: fake.NewClient(),
 		}
 		result, err := r.Reconcile(context.TODO(), reconcile.Request{})
 
@@ -155,6 +151,8 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 					},
 				},
 			),
+			apiReader: fake.NewClient(),
+			db:        newTestDB(t),
 			dtcBuildFunc: func(rtc client.Client, instance *v1alpha1.DynaKube, secret *v1.Secret) (dtclient.Client, error) {
 				return nil, fmt.Errorf(errorMsg)
 			},
@@ -185,6 +183,8 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 					},
 				},
 			),
+			apiReader: fake.NewClient(),
+			db:        newTestDB(t),
 			dtcBuildFunc: func(rtc client.Client, instance *v1alpha1.DynaKube, secret *v1.Secret) (dtclient.Client, error) {
 				return mockClient, nil
 			},
@@ -224,6 +224,8 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 					},
 				},
 			),
+			apiReader: fake.NewClient(),
+			db:        newTestDB(t),
 			dtcBuildFunc: func(rtc client.Client, instance *v1alpha1.DynaKube, secret *v1.Secret) (dtclient.Client, error) {
 				return mockClient, nil
 			},
@@ -235,10 +237,7 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 		assert.NotNil(t, result)
 		assert.Equal(t, reconcile.Result{}, result)
 	})
-	t.Run(`error reading tenant file`, func(t *testing.T) {
-		errorFs := &readFileErrorFs{
-			Fs: afero.NewMemMapFs(),
-		}
+	t.Run(`error reading tenant metadata`, func(t *testing.T) {
 		mockClient := &dtclient.MockDynatraceClient{}
 		mockClient.On("GetConnectionInfo").Return(dtclient.ConnectionInfo{
 			TenantUUID: tenantUUID,
@@ -264,14 +263,16 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 					},
 				},
 			),
+			apiReader: fake.NewClient(),
+			db:        closedTestDB(t),
 			dtcBuildFunc: func(rtc client.Client, instance *v1alpha1.DynaKube, secret *v1.Secret) (dtclient.Client, error) {
 				return mockClient, nil
 			},
-			fs: errorFs,
+			fs: afero.NewMemMapFs(),
 		}
 		result, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: dkName}})
 
-		assert.EqualError(t, err, "failed to query assigned DynaKube tenant: "+errorMsg)
+		assert.EqualError(t, err, "failed to get tenant of DynaKube '"+dkName+"': database not open")
 		assert.NotNil(t, result)
 		assert.Equal(t, reconcile.Result{}, result)
 	})
@@ -305,6 +306,8 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 					},
 				},
 			),
+			apiReader: fake.NewClient(),
+			db:        newTestDB(t),
 			dtcBuildFunc: func(rtc client.Client, instance *v1alpha1.DynaKube, secret *v1.Secret) (dtclient.Client, error) {
 				return mockClient, nil
 			},
@@ -321,27 +324,24 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 		assert.NoError(t, err)
 		assert.True(t, exists)
 
-		exists, err = afero.Exists(memFs, dkTenantMapping)
-
-		assert.NoError(t, err)
-		assert.True(t, exists)
-
-		data, err := afero.ReadFile(memFs, dkTenantMapping)
+		tenant, err := r.db.GetTenant(dkName)
 
 		assert.NoError(t, err)
-		assert.Equal(t, tenantUUID, string(data))
+		assert.Equal(t, &metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUUID}, tenant)
 	})
-	t.Run(`error reading install version`, func(t *testing.T) {
-		errorFs := &readVersionFileErrorFs{
-			Fs: afero.NewMemMapFs(),
-		}
+	t.Run(`error installing agent`, func(t *testing.T) {
+		memFs := afero.NewMemMapFs()
 		mockClient := &dtclient.MockDynatraceClient{}
 		mockClient.On("GetConnectionInfo").Return(dtclient.ConnectionInfo{
 			TenantUUID: tenantUUID,
 		}, nil)
-		mockClient.On("GetLatestAgentVersion",
+		mockClient.On("GetAgent",
 			mock.AnythingOfType("string"),
-			mock.AnythingOfType("string")).Return(agentVersion, nil)
+			mock.AnythingOfType("string"),
+			mock.AnythingOfType("string"),
+			mock.AnythingOfType("string"),
+			mock.AnythingOfType("string"),
+			mock.Anything).Return(fmt.Errorf(errorMsg))
 		r := &OneAgentProvisioner{
 			client: fake.NewClient(
 				&v1alpha1.DynaKube{
@@ -355,6 +355,7 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 						ConnectionInfo: v1alpha1.ConnectionInfoStatus{
 							TenantUUID: tenantUUID,
 						},
+						LatestAgentVersionUnixPaas: agentVersion,
 					},
 				},
 				&v1.Secret{
@@ -363,10 +364,12 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 					},
 				},
 			),
+			apiReader: fake.NewClient(),
+			db:        newTestDB(t),
 			dtcBuildFunc: func(rtc client.Client, instance *v1alpha1.DynaKube, secret *v1.Secret) (dtclient.Client, error) {
 				return mockClient, nil
 			},
-			fs: errorFs,
+			fs: memFs,
 		}
 
 		result, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: dkName}})
@@ -374,20 +377,16 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 		assert.Error(t, err)
 		assert.Empty(t, result)
 
-		exists, err := afero.Exists(errorFs, tenantUUID)
-
-		assert.NoError(t, err)
-		assert.True(t, exists)
-
-		exists, err = afero.Exists(errorFs, dkTenantMapping)
+		exists, err := afero.Exists(memFs, tenantUUID)
 
 		assert.NoError(t, err)
 		assert.True(t, exists)
 
-		data, err := afero.ReadFile(errorFs, dkTenantMapping)
+		// The tenant is recorded once its version is installed
+		tenant, err := r.db.GetTenant(dkName)
 
 		assert.NoError(t, err)
-		assert.Equal(t, tenantUUID, string(data))
+		assert.Nil(t, tenant)
 	})
 	t.Run(`correct directories are created`, func(t *testing.T) {
 		memFs := afero.NewMemMapFs()
@@ -420,13 +419,15 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 					},
 				},
 			),
+			apiReader: fake.NewClient(),
+			db:        newTestDB(t),
 			dtcBuildFunc: func(rtc client.Client, instance *v1alpha1.DynaKube, secret *v1.Secret) (dtclient.Client, error) {
 				return mockClient, nil
 			},
 			fs: memFs,
 		}
 
-		err := afero.WriteFile(memFs, filepath.Join(tenantUUID, dtcsi.VersionDir), []byte(agentVersion), fs.FileMode(0755))
+		err := r.db.UpdateTenant(&metadata.Tenant{DynakubeName: dkName, TenantUUID: tenantUUID, LatestVersion: agentVersion})
 
 		require.NoError(t, err)
 
@@ -445,6 +446,16 @@ func TestOneAgentProvisioner_Reconcile(t *testing.T) {
 
 		assert.NoError(t, err)
 		assert.True(t, fileInfo.IsDir())
+
+		tenant, err := r.db.GetTenant(dkName)
+
+		assert.NoError(t, err)
+		assert.Equal(t, &metadata.Tenant{
+			DynakubeName:      dkName,
+			TenantUUID:        tenantUUID,
+			LatestVersion:     agentVersion,
+			RequestedVersions: []string{agentVersion},
+		}, tenant)
 	})
 }
 
@@ -556,3 +567,241 @@ func buildValidCodeModulesSpec(_ *testing.T) v1alpha1.CodeModulesSpec {
 		},
 	}
 }
+
+func TestAdditionalFlavors(t *testing.T) {
+	dk := &v1alpha1.DynaKube{
+		Spec: v1alpha1.DynaKubeSpec{
+			CodeModules: v1alpha1.CodeModulesSpec{
+				FlavorRules: []v1alpha1.FlavorRule{
+					{Image: "*alpine*", Flavor: dtclient.FlavorMUSL},
+					{Image: "*", Flavor: dtclient.FlavorMultidistro},
+					{Image: "*busybox*", Flavor: dtclient.FlavorMUSL},
+					{Image: "*ubi*", Flavor: dtclient.FlavorDefault},
+				},
+			},
+		},
+	}
+
+	assert.Equal(t, []string{dtclient.FlavorMUSL, dtclient.FlavorDefault}, additionalFlavors(dk, nil))
+	assert.Empty(t, additionalFlavors(&v1alpha1.DynaKube{}, nil))
+	assert.Equal(t, []string{dtclient.FlavorMUSL}, additionalFlavors(&v1alpha1.DynaKube{},
+		[]string{dtclient.FlavorMUSL, dtclient.FlavorMultidistro, "invalid", dtclient.FlavorMUSL}))
+}
+
+func TestRequestedFlavors(t *testing.T) {
+	dk := &v1alpha1.DynaKube{ObjectMeta: metav1.ObjectMeta{Name: dkName, Namespace: "dynatrace"}}
+	pod := func(name string, namespace string, attributes map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: v1.PodSpec{Volumes: []v1.Volume{{
+				Name: "oneagent",
+				VolumeSource: v1.VolumeSource{CSI: &v1.CSIVolumeSource{
+					Driver:           dtcsi.DriverName,
+					VolumeAttributes: attributes,
+				}},
+			}}},
+		}
+	}
+
+	r := &OneAgentProvisioner{
+		client: fake.NewClient(dk,
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "injected", Labels: map[string]string{webhook.LabelInstance: dkName}}},
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}),
+		apiReader: fake.NewClient(
+			pod("musl", "injected", map[string]string{dtcsi.FlavorVolumeAttribute: dtclient.FlavorMUSL}),
+			pod("multidistro", "injected", map[string]string{}),
+			pod("other", "other", map[string]string{dtcsi.FlavorVolumeAttribute: dtclient.FlavorDefault}),
+		),
+	}
+
+	flavors, err := r.requestedFlavors(context.TODO(), dk)
+	require.NoError(t, err)
+	assert.Equal(t, []string{dtclient.FlavorMUSL}, flavors)
+}
+
+func TestRequestedVersions(t *testing.T) {
+	dk := &v1alpha1.DynaKube{
+		ObjectMeta: metav1.ObjectMeta{Name: dkName, Namespace: "dynatrace"},
+		Spec: v1alpha1.DynaKubeSpec{
+			CodeModules: buildValidCodeModulesSpec(t),
+		},
+		Status: v1alpha1.DynaKubeStatus{
+			LatestAgentVersionUnixPaas: "1.203.0",
+		},
+	}
+	csiVolume := func(version string) v1.Volume {
+		return v1.Volume{
+			Name: "oneagent-bin",
+			VolumeSource: v1.VolumeSource{CSI: &v1.CSIVolumeSource{
+				Driver:           dtcsi.DriverName,
+				VolumeAttributes: map[string]string{dtcsi.VersionVolumeAttribute: version},
+			}},
+		}
+	}
+
+	r := &OneAgentProvisioner{
+		client: fake.NewClient(
+			dk,
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:        "staged",
+				Labels:      map[string]string{webhook.LabelInstance: dkName},
+				Annotations: map[string]string{webhook.AnnotationVersion: "1.201.0"},
+			}},
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   "default",
+				Labels: map[string]string{webhook.LabelInstance: dkName},
+			}},
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:        "other",
+				Annotations: map[string]string{webhook.AnnotationVersion: "1.100.0"},
+			}},
+		),
+		apiReader: fake.NewClient(
+			&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pinned", Namespace: "default"},
+				Spec:       v1.PodSpec{Volumes: []v1.Volume{csiVolume("1.202.0")}},
+			},
+			&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "staged", Namespace: "staged"},
+				Spec:       v1.PodSpec{Volumes: []v1.Volume{csiVolume("1.201.0")}},
+			},
+			&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "other"},
+				Spec:       v1.PodSpec{Volumes: []v1.Volume{csiVolume("1.100.0")}},
+			},
+		),
+	}
+
+	versions, hostAgentVersions, err := r.requestedVersions(context.TODO(), dk)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.203.0", "1.201.0", "1.202.0"}, versions)
+	assert.Empty(t, hostAgentVersions)
+
+	dk.Spec.CodeModules.Version = "1.200.0"
+	versions, _, err = r.requestedVersions(context.TODO(), dk)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.200.0", "1.201.0", "1.202.0"}, versions)
+}
+
+func TestRequestedVersions_VolumeTypes(t *testing.T) {
+	dk := &v1alpha1.DynaKube{
+		ObjectMeta: metav1.ObjectMeta{Name: dkName, Namespace: "dynatrace"},
+		Status: v1alpha1.DynaKubeStatus{
+			LatestAgentVersionUnixPaas:    "1.203.0",
+			LatestAgentVersionUnixDefault: "1.204.0",
+		},
+	}
+	csiVolume := func(attributes map[string]string) v1.Volume {
+		return v1.Volume{
+			Name: "oneagent",
+			VolumeSource: v1.VolumeSource{CSI: &v1.CSIVolumeSource{
+				Driver:           dtcsi.DriverName,
+				VolumeAttributes: attributes,
+			}},
+		}
+	}
+	pod := func(name string, attributes map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dynatrace"},
+			Spec:       v1.PodSpec{Volumes: []v1.Volume{csiVolume(attributes)}},
+		}
+	}
+
+	r := &OneAgentProvisioner{
+		client: fake.NewClient(dk),
+		apiReader: fake.NewClient(
+			pod("oneagent", map[string]string{
+				dtcsi.DynaKubeVolumeAttribute: dkName,
+				dtcsi.TypeVolumeAttribute:     dtcsi.HostAgentVolumeType,
+			}),
+			pod("pinned", map[string]string{
+				dtcsi.DynaKubeVolumeAttribute: dkName,
+				dtcsi.TypeVolumeAttribute:     dtcsi.HostAgentVolumeType,
+				dtcsi.VersionVolumeAttribute:  "1.200.0",
+			}),
+			pod("other", map[string]string{
+				dtcsi.DynaKubeVolumeAttribute: "other",
+				dtcsi.TypeVolumeAttribute:     dtcsi.HostAgentVolumeType,
+				dtcsi.VersionVolumeAttribute:  "1.100.0",
+			}),
+		),
+	}
+
+	referenced, err := r.hasVolumesOfDynaKube(context.TODO(), dk)
+	require.NoError(t, err)
+	assert.True(t, referenced)
+
+	versions, hostAgentVersions, err := r.requestedVersions(context.TODO(), dk)
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+	assert.Equal(t, []string{"1.204.0", "1.200.0"}, hostAgentVersions)
+
+	r.apiReader = fake.NewClient(pod("activegate", map[string]string{dtcsi.DynaKubeVolumeAttribute: dkName}))
+
+	versions, hostAgentVersions, err = r.requestedVersions(context.TODO(), dk)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.203.0"}, versions)
+	assert.Empty(t, hostAgentVersions)
+}
+
+func TestHasVolumesOfDynaKube_Namespaces(t *testing.T) {
+	dk := &v1alpha1.DynaKube{ObjectMeta: metav1.ObjectMeta{Name: dkName, Namespace: "dynatrace"}}
+	volumePod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "foreign"},
+		Spec: v1.PodSpec{Volumes: []v1.Volume{{
+			Name: "oneagent",
+			VolumeSource: v1.VolumeSource{CSI: &v1.CSIVolumeSource{
+				Driver:           dtcsi.DriverName,
+				VolumeAttributes: map[string]string{dtcsi.DynaKubeVolumeAttribute: dkName},
+			}},
+		}}},
+	}
+	newProvisioner := func(objs ...client.Object) *OneAgentProvisioner {
+		return &OneAgentProvisioner{
+			client:    fake.NewClient(append(objs, dk)...),
+			apiReader: fake.NewClient(volumePod),
+		}
+	}
+
+	t.Run(`ignores volume attribute of other namespaces`, func(t *testing.T) {
+		referenced, err := newProvisioner(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foreign"}}).
+			hasVolumesOfDynaKube(context.TODO(), dk)
+
+		require.NoError(t, err)
+		assert.False(t, referenced)
+	})
+	t.Run(`accepts volume attribute of injected namespaces`, func(t *testing.T) {
+		referenced, err := newProvisioner(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "foreign",
+			Labels: map[string]string{webhook.LabelInstance: dkName},
+		}}).hasVolumesOfDynaKube(context.TODO(), dk)
+
+		require.NoError(t, err)
+		assert.True(t, referenced)
+	})
+	t.Run(`accepts volume attribute of allowed namespaces`, func(t *testing.T) {
+		allowed := dk.DeepCopy()
+		allowed.Spec.CodeModules.VolumeNamespaces = []string{"foreign"}
+
+		referenced, err := newProvisioner().hasVolumesOfDynaKube(context.TODO(), allowed)
+
+		require.NoError(t, err)
+		assert.True(t, referenced)
+	})
+}
+
+func TestTriggerProvisioning(t *testing.T) {
+	r := &OneAgentProvisioner{
+		opts:     dtcsi.CSIOptions{Distribution: dtcsi.DistributionOptions{Namespace: "dynatrace"}},
+		triggers: make(chan event.GenericEvent, 1),
+	}
+
+	r.TriggerProvisioning(dkName)
+	// Triggers are dropped instead of blocking the CSI driver while the queue is full
+	r.TriggerProvisioning("other")
+
+	require.Len(t, r.triggers, 1)
+	triggered := <-r.triggers
+	assert.Equal(t, dkName, triggered.Object.GetName())
+	assert.Equal(t, "dynatrace", triggered.Object.GetNamespace())
+}
@@ -0,0 +1,295 @@
+package customproperties
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+const (
+	SectionConnectivity = "connectivity"
+	SectionCollector    = "collector"
+
+	KeyNetworkZone   = "networkZone"
+	KeyDNSEntryPoint = "dnsEntryPoint"
+	KeyGroup         = "group"
+)
+
+// properties holds the sections of a custom properties file in the order they have been defined
+type properties struct {
+	sectionNames []string
+	sections     map[string]*section
+	// trailingComments are the comments after the last key/value pair
+	trailingComments []string
+}
+
+type section struct {
+	keys   []string
+	values map[string]string
+	// comments are the comments preceding the section header
+	comments []string
+	// keyComments are the comments preceding the key/value pairs by key
+	keyComments map[string][]string
+}
+
+func newProperties() *properties {
+	return &properties{sections: map[string]*section{}}
+}
+
+// parseProperties parses the ini syntax used by the ActiveGate custom properties.
+// Empty lines are dropped, comments starting with '#' are kept with the section header or key/value pair they precede.
+func parseProperties(raw string) (*properties, error) {
+	props := newProperties()
+	currentSection := ""
+	var comments []string
+
+	scanner := bufio.NewScanner(strings.NewReader(raw))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#"):
+			comments = append(comments, line)
+			continue
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, errors.Errorf("line %d: section header '%s' is not closed", lineNumber, line)
+			}
+			currentSection = strings.TrimSpace(line[1 : len(line)-1])
+			if err := validateSectionName(currentSection); err != nil {
+				return nil, errors.Wrapf(err, "line %d", lineNumber)
+			}
+			s := props.addSection(currentSection)
+			s.comments = append(s.comments, comments...)
+		default:
+			separator := strings.Index(line, "=")
+			if separator < 0 {
+				return nil, errors.Errorf("line %d: '%s' is neither a section header nor a key/value pair", lineNumber, line)
+			}
+			if currentSection == "" {
+				return nil, errors.Errorf("line %d: key/value pair '%s' is not part of a section", lineNumber, line)
+			}
+
+			key := strings.TrimSpace(line[:separator])
+			if err := validateKey(key); err != nil {
+				return nil, errors.Wrapf(err, "line %d", lineNumber)
+			}
+			props.set(currentSection, key, strings.TrimSpace(line[separator+1:]))
+			s := props.sections[currentSection]
+			s.keyComments[key] = append(s.keyComments[key], comments...)
+		}
+		comments = nil
+	}
+	props.trailingComments = comments
+
+	return props, errors.WithStack(scanner.Err())
+}
+
+func (props *properties) addSection(name string) *section {
+	if s, ok := props.sections[name]; ok {
+		return s
+	}
+
+	s := &section{values: map[string]string{}, keyComments: map[string][]string{}}
+	props.sectionNames = append(props.sectionNames, name)
+	props.sections[name] = s
+	return s
+}
+
+func (props *properties) set(sectionName string, key string, value string) {
+	s := props.addSection(sectionName)
+	if _, ok := s.values[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.values[key] = value
+}
+
+// merge adds the structured properties, overwriting values which are already defined in the raw properties.
+// Keys of the generic sections must not be set by the typed fields as well.
+func (props *properties) merge(structured *dynatracev1alpha1.ActiveGateCustomProperties) error {
+	if structured == nil {
+		return nil
+	}
+	if err := validateSections(structured); err != nil {
+		return err
+	}
+
+	if connectivity := structured.Connectivity; connectivity != nil {
+		if err := props.setIfNotEmpty(SectionConnectivity, KeyNetworkZone, connectivity.NetworkZone); err != nil {
+			return err
+		}
+		if err := props.setIfNotEmpty(SectionConnectivity, KeyDNSEntryPoint, connectivity.DNSEntryPoint); err != nil {
+			return err
+		}
+	}
+
+	if collector := structured.Collector; collector != nil {
+		if err := props.setIfNotEmpty(SectionCollector, KeyGroup, collector.Group); err != nil {
+			return err
+		}
+	}
+
+	for _, sectionName := range sortedKeys(structured.Sections) {
+		if err := validateSectionName(sectionName); err != nil {
+			return err
+		}
+
+		values := structured.Sections[sectionName]
+		for _, key := range sortedStringKeys(values) {
+			if err := validateKey(key); err != nil {
+				return errors.Wrapf(err, "section '%s'", sectionName)
+			}
+			if err := validateValue(values[key]); err != nil {
+				return errors.Wrapf(err, "section '%s', key '%s'", sectionName, key)
+			}
+			props.set(sectionName, key, values[key])
+		}
+	}
+
+	return nil
+}
+
+func (props *properties) setIfNotEmpty(sectionName string, key string, value string) error {
+	if value == "" {
+		return nil
+	}
+	if err := validateValue(value); err != nil {
+		return errors.Wrapf(err, "section '%s', key '%s'", sectionName, key)
+	}
+
+	props.set(sectionName, key, value)
+	return nil
+}
+
+func (props *properties) render() string {
+	var sb strings.Builder
+
+	for i, sectionName := range props.sectionNames {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		s := props.sections[sectionName]
+		writeComments(&sb, s.comments)
+		sb.WriteString(fmt.Sprintf("[%s]\n", sectionName))
+
+		for _, key := range s.keys {
+			writeComments(&sb, s.keyComments[key])
+			sb.WriteString(fmt.Sprintf("%s=%s\n", key, s.values[key]))
+		}
+	}
+	writeComments(&sb, props.trailingComments)
+
+	return sb.String()
+}
+
+func writeComments(sb *strings.Builder, comments []string) {
+	for _, comment := range comments {
+		sb.WriteString(comment)
+		sb.WriteString("\n")
+	}
+}
+
+// Render merges the raw custom properties with the structured ones and returns the resulting file content.
+// If no structured properties are given, the raw value is returned as is.
+func Render(raw string, structured *dynatracev1alpha1.ActiveGateCustomProperties) (string, error) {
+	if structured == nil {
+		return raw, nil
+	}
+
+	props, err := parseProperties(raw)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid custom properties")
+	}
+
+	if err = props.merge(structured); err != nil {
+		return "", errors.Wrap(err, "invalid structured custom properties")
+	}
+
+	return props.render(), nil
+}
+
+// Hash returns the hash of the rendered custom properties, or an empty string if there are none
+func Hash(data string) (string, error) {
+	if data == "" {
+		return "", nil
+	}
+
+	hash := fnv.New32()
+	if _, err := hash.Write([]byte(data)); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return strconv.FormatUint(uint64(hash.Sum32()), 10), nil
+}
+
+// validateSections returns an error if keys of the generic sections are also set by the typed fields, since it would
+// be ambiguous which of the values is used
+func validateSections(structured *dynatracev1alpha1.ActiveGateCustomProperties) error {
+	type typedKey struct {
+		section, key, value string
+	}
+
+	var typed []typedKey
+	if connectivity := structured.Connectivity; connectivity != nil {
+		typed = append(typed,
+			typedKey{SectionConnectivity, KeyNetworkZone, connectivity.NetworkZone},
+			typedKey{SectionConnectivity, KeyDNSEntryPoint, connectivity.DNSEntryPoint})
+	}
+	if collector := structured.Collector; collector != nil {
+		typed = append(typed, typedKey{SectionCollector, KeyGroup, collector.Group})
+	}
+
+	for _, t := range typed {
+		if _, ok := structured.Sections[t.section][t.key]; ok && t.value != "" {
+			return errors.Errorf("section '%s', key '%s' is already set by the typed %s properties", t.section, t.key, t.section)
+		}
+	}
+	return nil
+}
+
+func validateSectionName(name string) error {
+	if name == "" || strings.ContainsAny(name, "[]\n\r") {
+		return errors.Errorf("invalid section name '%s'", name)
+	}
+	return nil
+}
+
+func validateKey(key string) error {
+	if key == "" || strings.ContainsAny(key, "=[]#\n\r") || strings.TrimSpace(key) != key {
+		return errors.Errorf("invalid key '%s'", key)
+	}
+	return nil
+}
+
+func validateValue(value string) error {
+	if strings.ContainsAny(value, "\n\r") {
+		return errors.New("values must not contain line breaks")
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,112 @@
+package customproperties
+
+import (
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	t.Run(`returns raw value without structured properties`, func(t *testing.T) {
+		data, err := Render(testValue, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, testValue, data)
+	})
+	t.Run(`renders structured properties`, func(t *testing.T) {
+		data, err := Render("", &dynatracev1alpha1.ActiveGateCustomProperties{
+			Connectivity: &dynatracev1alpha1.ActiveGateConnectivityProperties{
+				NetworkZone:   "zone",
+				DNSEntryPoint: "https://example.com",
+			},
+			Collector: &dynatracev1alpha1.ActiveGateCollectorProperties{Group: "group"},
+			Sections: map[string]map[string]string{
+				"http.client": {"proxy-server": "proxy", "proxy-port": "8080"},
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "[connectivity]\nnetworkZone=zone\ndnsEntryPoint=https://example.com\n"+
+			"\n[collector]\ngroup=group\n"+
+			"\n[http.client]\nproxy-port=8080\nproxy-server=proxy\n", data)
+	})
+	t.Run(`structured properties take precedence over raw value`, func(t *testing.T) {
+		raw := "# comment\n[collector]\ngroup = old\nMSGrouter = true\n\n[other]\nkey=value\n"
+		data, err := Render(raw, &dynatracev1alpha1.ActiveGateCustomProperties{
+			Collector: &dynatracev1alpha1.ActiveGateCollectorProperties{Group: "new"},
+			Sections: map[string]map[string]string{
+				"other": {"added": "value"},
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "# comment\n[collector]\ngroup=new\nMSGrouter=true\n\n[other]\nkey=value\nadded=value\n", data)
+	})
+	t.Run(`keeps comments of raw value`, func(t *testing.T) {
+		raw := "# header\n[collector]\n# the group\ngroup=old\n\n# other\n[other]\nkey=value\n# trailing\n"
+		data, err := Render(raw, &dynatracev1alpha1.ActiveGateCustomProperties{
+			Collector: &dynatracev1alpha1.ActiveGateCollectorProperties{Group: "new"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "# header\n[collector]\n# the group\ngroup=new\n\n# other\n[other]\nkey=value\n# trailing\n", data)
+	})
+	t.Run(`rejects keys of sections set by typed properties`, func(t *testing.T) {
+		_, err := Render("", &dynatracev1alpha1.ActiveGateCustomProperties{
+			Connectivity: &dynatracev1alpha1.ActiveGateConnectivityProperties{NetworkZone: "zone"},
+			Sections: map[string]map[string]string{
+				SectionConnectivity: {KeyNetworkZone: "other"},
+			},
+		})
+		assert.EqualError(t, err, "invalid structured custom properties: section 'connectivity', key 'networkZone' is already set by the typed connectivity properties")
+
+		data, err := Render("", &dynatracev1alpha1.ActiveGateCustomProperties{
+			Connectivity: &dynatracev1alpha1.ActiveGateConnectivityProperties{DNSEntryPoint: "https://example.com"},
+			Sections: map[string]map[string]string{
+				SectionConnectivity: {KeyNetworkZone: "zone"},
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "[connectivity]\ndnsEntryPoint=https://example.com\nnetworkZone=zone\n", data)
+	})
+	t.Run(`rejects invalid raw value`, func(t *testing.T) {
+		structured := &dynatracev1alpha1.ActiveGateCustomProperties{}
+
+		_, err := Render("[collector\ngroup=test", structured)
+		assert.Error(t, err)
+
+		_, err = Render("group=test", structured)
+		assert.Error(t, err)
+
+		_, err = Render("[collector]\ngroup", structured)
+		assert.Error(t, err)
+	})
+	t.Run(`rejects invalid structured properties`, func(t *testing.T) {
+		_, err := Render("", &dynatracev1alpha1.ActiveGateCustomProperties{
+			Sections: map[string]map[string]string{"": {testKey: testValue}},
+		})
+		assert.Error(t, err)
+
+		_, err = Render("", &dynatracev1alpha1.ActiveGateCustomProperties{
+			Sections: map[string]map[string]string{testName: {"a=b": testValue}},
+		})
+		assert.Error(t, err)
+
+		_, err = Render("", &dynatracev1alpha1.ActiveGateCustomProperties{
+			Collector: &dynatracev1alpha1.ActiveGateCollectorProperties{Group: "a\n[b]"},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestHash(t *testing.T) {
+	hash, err := Hash("")
+	assert.NoError(t, err)
+	assert.Empty(t, hash)
+
+	hash, err = Hash(testValue)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, hash)
+
+	otherHash, err := Hash(testKey)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, otherHash)
+}
@@ -28,23 +28,35 @@ type Reconciler struct {
 	scheme                    *runtime.Scheme
 	log                       logr.Logger
 	customPropertiesSource    dynatracev1alpha1.DynaKubeValueSource
+	structuredProperties      *dynatracev1alpha1.ActiveGateCustomProperties
 	customPropertiesOwnerName string
 	instance                  *dynatracev1alpha1.DynaKube
+	data                      string
 }
 
-func NewReconciler(clt client.Client, instance *dynatracev1alpha1.DynaKube, log logr.Logger, customPropertiesOwnerName string, customPropertiesSource dynatracev1alpha1.DynaKubeValueSource, scheme *runtime.Scheme) *Reconciler {
+func NewReconciler(clt client.Client, instance *dynatracev1alpha1.DynaKube, log logr.Logger, customPropertiesOwnerName string,
+	customPropertiesSource dynatracev1alpha1.DynaKubeValueSource, structuredProperties *dynatracev1alpha1.ActiveGateCustomProperties,
+	scheme *runtime.Scheme) *Reconciler {
 	return &Reconciler{
 		Client:                    clt,
 		instance:                  instance,
 		scheme:                    scheme,
 		log:                       log,
 		customPropertiesSource:    customPropertiesSource,
+		structuredProperties:      structuredProperties,
 		customPropertiesOwnerName: customPropertiesOwnerName,
 	}
 }
 
 func (r *Reconciler) Reconcile() error {
-	if r.hasCustomPropertiesValueOnly() {
+	if r.hasCustomPropertiesValueOnly() || r.structuredProperties != nil {
+		data, err := GetData(r, r.instance.Namespace, &r.customPropertiesSource, r.structuredProperties)
+		if err != nil {
+			r.log.Error(err, fmt.Sprintf("could not render custom properties for '%s'", r.customPropertiesOwnerName))
+			return errors.WithStack(err)
+		}
+		r.data = data
+
 		mustNotUpdate, err := r.createCustomPropertiesIfNotExists()
 		if err != nil {
 			r.log.Error(err, fmt.Sprintf("could not create custom properties for '%s'", r.customPropertiesOwnerName))
@@ -88,18 +100,21 @@ func (r *Reconciler) updateCustomPropertiesIfOutdated() error {
 }
 
 func (r *Reconciler) isOutdated(customProperties *corev1.Secret) bool {
-	return r.customPropertiesSource.Value != string(customProperties.Data[DataKey])
+	return r.data != string(customProperties.Data[DataKey])
 }
 
 func (r *Reconciler) updateCustomProperties(customProperties *corev1.Secret) error {
-	customProperties.Data[DataKey] = []byte(r.customPropertiesSource.Value)
+	if customProperties.Data == nil {
+		customProperties.Data = map[string][]byte{}
+	}
+	customProperties.Data[DataKey] = []byte(r.data)
 	return r.Update(context.TODO(), customProperties)
 }
 
 func (r *Reconciler) createCustomProperties() error {
 	customPropertiesSecret := r.buildCustomPropertiesSecret(
 		r.buildCustomPropertiesName(r.instance.Name),
-		r.customPropertiesSource.Value,
+		r.data,
 	)
 
 	err := controllerutil.SetControllerReference(r.instance, customPropertiesSecret, r.scheme)
@@ -130,3 +145,29 @@ func (r *Reconciler) hasCustomPropertiesValueOnly() bool {
 	return r.customPropertiesSource.Value != "" &&
 		r.customPropertiesSource.ValueFrom == ""
 }
+
+// GetData returns the content of the custom properties file, read from the given value or secret
+// and merged with the structured properties
+func GetData(reader client.Reader, namespace string, customPropertiesSource *dynatracev1alpha1.DynaKubeValueSource,
+	structuredProperties *dynatracev1alpha1.ActiveGateCustomProperties) (string, error) {
+	raw := ""
+	if customPropertiesSource != nil {
+		raw = customPropertiesSource.Value
+	}
+
+	if customPropertiesSource != nil && customPropertiesSource.ValueFrom != "" {
+		var secret corev1.Secret
+		err := reader.Get(context.TODO(), client.ObjectKey{Name: customPropertiesSource.ValueFrom, Namespace: namespace}, &secret)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		dataBytes, ok := secret.Data[DataKey]
+		if !ok {
+			return "", errors.Errorf("no custom properties found on secret '%s' on namespace '%s'", customPropertiesSource.ValueFrom, namespace)
+		}
+		raw = string(dataBytes)
+	}
+
+	return Render(raw, structuredProperties)
+}
@@ -23,7 +23,7 @@ const (
 
 func TestReconciler_Reconcile(t *testing.T) {
 	t.Run(`Reconile works with minimal setup`, func(t *testing.T) {
-		r := NewReconciler(nil, nil, nil, "", dynatracev1alpha1.DynaKubeValueSource{}, nil)
+		r := NewReconciler(nil, nil, nil, "", dynatracev1alpha1.DynaKubeValueSource{}, nil, nil)
 		err := r.Reconcile()
 		assert.NoError(t, err)
 	})
@@ -35,7 +35,7 @@ func TestReconciler_Reconcile(t *testing.T) {
 				Namespace: testNamespace,
 			}}
 		fakeClient := fake.NewClient(instance)
-		r := NewReconciler(fakeClient, instance, nil, testOwner, valueSource, scheme.Scheme)
+		r := NewReconciler(fakeClient, instance, nil, testOwner, valueSource, nil, scheme.Scheme)
 		err := r.Reconcile()
 
 		assert.NoError(t, err)
@@ -57,7 +57,7 @@ func TestReconciler_Reconcile(t *testing.T) {
 				Namespace: testNamespace,
 			}}
 		fakeClient := fake.NewClient(instance)
-		r := NewReconciler(fakeClient, instance, nil, testOwner, valueSource, scheme.Scheme)
+		r := NewReconciler(fakeClient, instance, nil, testOwner, valueSource, nil, scheme.Scheme)
 		err := r.Reconcile()
 
 		assert.NoError(t, err)
@@ -96,4 +96,35 @@ func TestReconciler_Reconcile(t *testing.T) {
 		assert.Contains(t, customPropertiesSecret.Data, DataKey)
 		assert.Equal(t, customPropertiesSecret.Data[DataKey], []byte(testKey))
 	})
+	t.Run(`Reconcile merges structured properties with properties from secret`, func(t *testing.T) {
+		valueSource := dynatracev1alpha1.DynaKubeValueSource{ValueFrom: testKey}
+		structured := &dynatracev1alpha1.ActiveGateCustomProperties{
+			Connectivity: &dynatracev1alpha1.ActiveGateConnectivityProperties{NetworkZone: testValue},
+		}
+		instance := &dynatracev1alpha1.DynaKube{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      testName,
+				Namespace: testNamespace,
+			}}
+		userSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      testKey,
+				Namespace: testNamespace,
+			},
+			Data: map[string][]byte{
+				DataKey: []byte("[connectivity]\nnetworkZone=other\ndnsEntryPoint=https://example.com\n"),
+			},
+		}
+		fakeClient := fake.NewClient(instance, userSecret)
+		r := NewReconciler(fakeClient, instance, nil, testOwner, valueSource, structured, scheme.Scheme)
+		err := r.Reconcile()
+
+		assert.NoError(t, err)
+
+		var customPropertiesSecret corev1.Secret
+		err = fakeClient.Get(context.TODO(), client.ObjectKey{Name: r.buildCustomPropertiesName(testName), Namespace: testNamespace}, &customPropertiesSecret)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "[connectivity]\nnetworkZone=test-value\ndnsEntryPoint=https://example.com\n", string(customPropertiesSecret.Data[DataKey]))
+	})
 }
@@ -0,0 +1,274 @@
+package dynakube
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	sts "github.com/Dynatrace/dynatrace-operator/controllers/activegate/reconciler/statefulset"
+	"github.com/Dynatrace/dynatrace-operator/controllers/utils"
+	"github.com/Dynatrace/dynatrace-operator/downloadcache"
+	"github.com/Dynatrace/dynatrace-operator/installer"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	downloadCacheComponent = "download-cache"
+	downloadCacheSubcmd    = "download-cache"
+
+	annotationConfigHash = "internal.operator.dynatrace.com/config-hash"
+)
+
+// reconcileDownloadCache deploys the download cache, which serves the OneAgent packages to the injected pods, and
+// removes it again once it isn't used anymore
+func (r *ReconcileDynaKube) reconcileDownloadCache(ctx context.Context, rec *utils.Reconciliation, tokens *corev1.Secret) error {
+	dk := rec.Instance
+	meta := metav1.ObjectMeta{Name: dk.DownloadCacheName(), Namespace: dk.Namespace}
+
+	if !dk.NeedsDownloadCache() {
+		for _, obj := range []client.Object{&appsv1.Deployment{ObjectMeta: meta}, &corev1.Service{ObjectMeta: meta}, &corev1.Secret{ObjectMeta: meta}} {
+			if err := r.ensureDeleted(obj); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	}
+
+	config, err := r.buildDownloadCacheConfig(ctx, dk, tokens)
+	if err != nil {
+		return err
+	}
+	configHash, err := generateHash(config)
+	if err != nil {
+		return err
+	}
+
+	image, pullSecrets, err := r.getDownloadCacheImage(dk)
+	if err != nil {
+		return err
+	}
+
+	if err = r.createOrUpdateByHash(ctx, rec, &corev1.Secret{ObjectMeta: meta, Data: config}, &corev1.Secret{}, nil); err != nil {
+		return errors.Wrap(err, "failed to reconcile download cache secret")
+	}
+
+	if err = r.createOrUpdateByHash(ctx, rec, buildDownloadCacheDeployment(dk, image, pullSecrets, configHash), &appsv1.Deployment{}, nil); err != nil {
+		return errors.Wrap(err, "failed to reconcile download cache deployment")
+	}
+
+	service := buildDownloadCacheService(dk)
+	err = r.createOrUpdateByHash(ctx, rec, service, &corev1.Service{}, func(current client.Object) {
+		// The cluster IP is assigned by Kubernetes and must not be changed on updates
+		service.Spec.ClusterIP = current.(*corev1.Service).Spec.ClusterIP
+	})
+	return errors.Wrap(err, "failed to reconcile download cache service")
+}
+
+// buildDownloadCacheConfig returns the install configuration of the download cache, which holds the PaaS token, since
+// the download cache is the only component contacting the tenant for downloads
+func (r *ReconcileDynaKube) buildDownloadCacheConfig(ctx context.Context, dk *dynatracev1alpha1.DynaKube, tokens *corev1.Secret) (map[string][]byte, error) {
+	var proxy string
+	if p := dk.Spec.Proxy; p != nil {
+		if p.ValueFrom != "" {
+			var proxySecret corev1.Secret
+			if err := r.client.Get(ctx, client.ObjectKey{Name: p.ValueFrom, Namespace: dk.Namespace}, &proxySecret); err != nil {
+				return nil, errors.Wrap(err, "failed to get proxy secret")
+			}
+			proxy = string(proxySecret.Data[Proxy])
+		} else {
+			proxy = p.Value
+		}
+	}
+
+	config, err := json.Marshal(installer.Config{
+		APIURL:        dk.Spec.APIURL,
+		PaaSToken:     string(tokens.Data[utils.DynatracePaasToken]),
+		Proxy:         proxy,
+		SkipCertCheck: dk.Spec.SkipCertCheck,
+		TenantUUID:    dk.Status.ConnectionInfo.TenantUUID,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	data := map[string][]byte{installer.ConfigFileName: config}
+
+	if dk.Spec.TrustedCAs != "" {
+		var certs corev1.ConfigMap
+		if err := r.client.Get(ctx, client.ObjectKey{Name: dk.Spec.TrustedCAs, Namespace: dk.Namespace}, &certs); err != nil {
+			return nil, errors.Wrap(err, "failed to get certificate configmap")
+		}
+		data[installer.TrustedCAsFileName] = []byte(certs.Data[Certificates])
+	}
+
+	return data, nil
+}
+
+// getDownloadCacheImage returns the configured image of the download cache, or the image and pull secrets of the
+// Operator, which provides the download-cache subcommand
+func (r *ReconcileDynaKube) getDownloadCacheImage(dk *dynatracev1alpha1.DynaKube) (string, []corev1.LocalObjectReference, error) {
+	if image := dk.Spec.CodeModules.DownloadCache.Image; image != "" {
+		return image, nil, nil
+	}
+
+	deployment, err := utils.GetDeployment(r.client, dk.Namespace)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to get image of the Operator")
+	}
+	return deployment.Spec.Template.Spec.Containers[0].Image, deployment.Spec.Template.Spec.ImagePullSecrets, nil
+}
+
+func buildDownloadCacheLabels(dk *dynatracev1alpha1.DynaKube) map[string]string {
+	return map[string]string{
+		sts.KeyDynatrace:  downloadCacheComponent,
+		sts.KeyActiveGate: dk.Name,
+	}
+}
+
+func buildDownloadCacheDeployment(dk *dynatracev1alpha1.DynaKube, image string, pullSecrets []corev1.LocalObjectReference, configHash string) *appsv1.Deployment {
+	spec := &dk.Spec.CodeModules.DownloadCache
+	labels := buildDownloadCacheLabels(dk)
+
+	replicas := int32(1)
+	if spec.Replicas != nil {
+		replicas = *spec.Replicas
+	}
+
+	probe := &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: downloadcache.HealthPath,
+				Port: intstr.FromInt(downloadcache.Port),
+			},
+		},
+		PeriodSeconds: 10,
+	}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dk.DownloadCacheName(),
+			Namespace: dk.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+					// Restarts the pods on configuration changes, e.g. a new token, the configuration is only read on startup
+					Annotations: map[string]string{annotationConfigHash: configHash},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:            downloadCacheComponent,
+						Image:           image,
+						ImagePullPolicy: corev1.PullAlways,
+						Args:            []string{downloadCacheSubcmd},
+						Ports: []corev1.ContainerPort{{
+							Name:          "http",
+							ContainerPort: downloadcache.Port,
+						}},
+						Resources:      spec.Resources,
+						ReadinessProbe: probe,
+						LivenessProbe:  probe,
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "config", MountPath: installer.ConfigDir, ReadOnly: true},
+							{Name: "cache", MountPath: downloadcache.CacheDir},
+						},
+					}},
+					ImagePullSecrets: pullSecrets,
+					Volumes: []corev1.Volume{
+						{
+							Name:         "config",
+							VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: dk.DownloadCacheName()}},
+						},
+						{
+							Name:         "cache",
+							VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func buildDownloadCacheService(dk *dynatracev1alpha1.DynaKube) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dk.DownloadCacheName(),
+			Namespace: dk.Namespace,
+			Labels:    buildDownloadCacheLabels(dk),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: buildDownloadCacheLabels(dk),
+			Ports: []corev1.ServicePort{{
+				Name:       "http",
+				Port:       downloadcache.Port,
+				TargetPort: intstr.FromString("http"),
+			}},
+		},
+	}
+}
+
+// createOrUpdateByHash creates the desired object, or updates the current one if the hash of the desired object changed.
+// prepareUpdate can copy values assigned by Kubernetes from the current object.
+func (r *ReconcileDynaKube) createOrUpdateByHash(ctx context.Context, rec *utils.Reconciliation, desired client.Object, current client.Object, prepareUpdate func(current client.Object)) error {
+	if err := controllerutil.SetControllerReference(rec.Instance, desired, r.scheme); err != nil {
+		return errors.WithStack(err)
+	}
+
+	hash, err := generateHash(desired)
+	if err != nil {
+		return err
+	}
+	annotations := desired.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[sts.AnnotationTemplateHash] = hash
+	desired.SetAnnotations(annotations)
+
+	err = r.client.Get(ctx, client.ObjectKey{Name: desired.GetName(), Namespace: desired.GetNamespace()}, current)
+	if k8serrors.IsNotFound(err) {
+		rec.Log.Info("creating download cache object", "name", desired.GetName())
+		return errors.WithStack(r.client.Create(ctx, desired))
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if sts.GetTemplateHash(current) == hash {
+		return nil
+	}
+
+	rec.Log.Info("updating download cache object", "name", desired.GetName())
+	desired.SetResourceVersion(current.GetResourceVersion())
+	if prepareUpdate != nil {
+		prepareUpdate(current)
+	}
+	return errors.WithStack(r.client.Update(ctx, desired))
+}
+
+func generateHash(obj interface{}) (string, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	hasher := fnv.New32()
+	if _, err = hasher.Write(data); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return strconv.FormatUint(uint64(hasher.Sum32()), 10), nil
+}
@@ -0,0 +1,101 @@
+package dynakube
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/controllers/utils"
+	"github.com/Dynatrace/dynatrace-operator/installer"
+	"github.com/Dynatrace/dynatrace-operator/scheme"
+	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestReconcileDownloadCache(t *testing.T) {
+	buildDynaKube := func(enabled bool) *v1alpha1.DynaKube {
+		return &v1alpha1.DynaKube{
+			ObjectMeta: metav1.ObjectMeta{Name: testName, Namespace: testNamespace},
+			Spec: v1alpha1.DynaKubeSpec{
+				APIURL: "https://test-api-url.com/api",
+				Proxy:  &v1alpha1.DynaKubeProxy{Value: "http://proxy:3128"},
+				CodeModules: v1alpha1.CodeModulesSpec{
+					Enabled: true,
+					Volume:  corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+					DownloadCache: v1alpha1.DownloadCacheSpec{
+						Enabled: enabled,
+						Image:   "registry/dynatrace-operator:snapshot",
+					},
+				},
+			},
+		}
+	}
+	buildTokens := func(paasToken string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: testName, Namespace: testNamespace},
+			Data:       map[string][]byte{utils.DynatracePaasToken: []byte(paasToken)},
+		}
+	}
+	key := client.ObjectKey{Name: testName + v1alpha1.DownloadCacheSuffix, Namespace: testNamespace}
+
+	t.Run(`create download cache`, func(t *testing.T) {
+		instance := buildDynaKube(true)
+		r := &ReconcileDynaKube{client: fake.NewClient(instance), scheme: scheme.Scheme}
+
+		require.NoError(t, r.reconcileDownloadCache(context.TODO(), utils.NewReconciliation(logf.Log, instance), buildTokens("paas")))
+
+		var secret corev1.Secret
+		require.NoError(t, r.client.Get(context.TODO(), key, &secret))
+		var config installer.Config
+		require.NoError(t, json.Unmarshal(secret.Data[installer.ConfigFileName], &config))
+		assert.Equal(t, "paas", config.PaaSToken)
+		assert.Equal(t, "http://proxy:3128", config.Proxy)
+
+		var deployment appsv1.Deployment
+		require.NoError(t, r.client.Get(context.TODO(), key, &deployment))
+		container := deployment.Spec.Template.Spec.Containers[0]
+		assert.Equal(t, "registry/dynatrace-operator:snapshot", container.Image)
+		assert.Equal(t, []string{downloadCacheSubcmd}, container.Args)
+		assert.Equal(t, int32(1), *deployment.Spec.Replicas)
+
+		var service corev1.Service
+		require.NoError(t, r.client.Get(context.TODO(), key, &service))
+		assert.Equal(t, deployment.Spec.Selector.MatchLabels, service.Spec.Selector)
+	})
+	t.Run(`restart download cache on configuration changes`, func(t *testing.T) {
+		instance := buildDynaKube(true)
+		r := &ReconcileDynaKube{client: fake.NewClient(instance), scheme: scheme.Scheme}
+		rec := utils.NewReconciliation(logf.Log, instance)
+
+		require.NoError(t, r.reconcileDownloadCache(context.TODO(), rec, buildTokens("paas")))
+		var deployment appsv1.Deployment
+		require.NoError(t, r.client.Get(context.TODO(), key, &deployment))
+		configHash := deployment.Spec.Template.Annotations[annotationConfigHash]
+
+		require.NoError(t, r.reconcileDownloadCache(context.TODO(), rec, buildTokens("renewed")))
+		require.NoError(t, r.client.Get(context.TODO(), key, &deployment))
+		assert.NotEqual(t, configHash, deployment.Spec.Template.Annotations[annotationConfigHash])
+	})
+	t.Run(`remove download cache if disabled`, func(t *testing.T) {
+		instance := buildDynaKube(false)
+		meta := metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}
+		r := &ReconcileDynaKube{
+			client: fake.NewClient(instance, &appsv1.Deployment{ObjectMeta: meta}, &corev1.Service{ObjectMeta: meta}, &corev1.Secret{ObjectMeta: meta}),
+			scheme: scheme.Scheme,
+		}
+
+		require.NoError(t, r.reconcileDownloadCache(context.TODO(), utils.NewReconciliation(logf.Log, instance), buildTokens("paas")))
+
+		assert.True(t, k8serrors.IsNotFound(r.client.Get(context.TODO(), key, &appsv1.Deployment{})))
+		assert.True(t, k8serrors.IsNotFound(r.client.Get(context.TODO(), key, &corev1.Service{})))
+		assert.True(t, k8serrors.IsNotFound(r.client.Get(context.TODO(), key, &corev1.Secret{})))
+	})
+}
@@ -9,6 +9,7 @@ import (
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	"github.com/Dynatrace/dynatrace-operator/controllers/activegate/capability"
 	rcap "github.com/Dynatrace/dynatrace-operator/controllers/activegate/reconciler/capability"
+	"github.com/Dynatrace/dynatrace-operator/controllers/activegate/reconciler/connection"
 	"github.com/Dynatrace/dynatrace-operator/controllers/dtpullsecret"
 	"github.com/Dynatrace/dynatrace-operator/controllers/dtversion"
 	"github.com/Dynatrace/dynatrace-operator/controllers/dynakube/status"
@@ -57,7 +58,9 @@ func (r *ReconcileDynaKube) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&dynatracev1alpha1.DynaKube{}).
 		Owns(&appsv1.StatefulSet{}).
+		Owns(&appsv1.Deployment{}).
 		Owns(&appsv1.DaemonSet{}).
+		Owns(&corev1.Service{}).
 		Complete(r)
 }
 
@@ -180,6 +183,24 @@ func (r *ReconcileDynaKube) reconcileDynaKube(ctx context.Context, rec *utils.Re
 		}
 	}
 
+	err = r.reconcileInjectionConflicts(ctx, rec)
+	if rec.Error(err) {
+		rec.Log.Error(err, "could not reconcile injection conflicts")
+		return
+	}
+
+	err = r.reconcileInstallerToken(ctx, rec, dtc)
+	if rec.Error(err) {
+		rec.Log.Error(err, "could not reconcile installer token")
+		return
+	}
+
+	err = r.reconcileDownloadCache(ctx, rec, secret)
+	if rec.Error(err) {
+		rec.Log.Error(err, "could not reconcile download cache")
+		return
+	}
+
 	err = dtpullsecret.
 		NewReconciler(r.client, r.apiReader, r.scheme, rec.Instance, rec.Log, secret).
 		Reconcile()
@@ -192,7 +213,7 @@ func (r *ReconcileDynaKube) reconcileDynaKube(ctx context.Context, rec *utils.Re
 	rec.Update(upd, defaultUpdateInterval, "Found updates")
 	rec.Error(err)
 
-	if !r.reconcileActiveGateCapabilities(rec) {
+	if !r.reconcileActiveGateCapabilities(rec, dtc) {
 		return
 	}
 
@@ -232,7 +253,7 @@ func (r *ReconcileDynaKube) ensureDeleted(obj client.Object) error {
 	return nil
 }
 
-func (r *ReconcileDynaKube) reconcileActiveGateCapabilities(rec *utils.Reconciliation) bool {
+func (r *ReconcileDynaKube) reconcileActiveGateCapabilities(rec *utils.Reconciliation, dtc dtclient.Client) bool {
 	var caps = []capability.Capability{
 		capability.NewKubeMonCapability(&rec.Instance.Spec.KubernetesMonitoringSpec.CapabilityProperties),
 		capability.NewRoutingCapability(&rec.Instance.Spec.RoutingSpec.CapabilityProperties),
@@ -268,10 +289,23 @@ func (r *ReconcileDynaKube) reconcileActiveGateCapabilities(rec *utils.Reconcili
 				if err := r.ensureDeleted(&svc); rec.Error(err) {
 					return false
 				}
+
+				if _, ok := rec.Instance.Status.ActiveGate.ServiceEndpoints[c.GetModuleName()]; ok {
+					delete(rec.Instance.Status.ActiveGate.ServiceEndpoints, c.GetModuleName())
+					rec.Update(true, defaultUpdateInterval, c.GetModuleName()+" service endpoint removed")
+				}
 			}
 		}
 	}
 
+	upd, err := connection.NewReconciler(r.client, dtc, rec.Log, rec.Instance, caps).Reconcile()
+	if err != nil {
+		// The API token might lack the scope to read ActiveGates, which must not block the deployment
+		rec.Log.Info("could not determine ActiveGate connection status", "error", err.Error())
+	} else {
+		rec.Update(upd, defaultUpdateInterval, "ActiveGate connection status updated")
+	}
+
 	return true
 }
 
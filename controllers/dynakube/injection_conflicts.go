@@ -0,0 +1,72 @@
+package dynakube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/controllers/utils"
+	"github.com/Dynatrace/dynatrace-operator/webhook"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcileInjectionConflicts reports the Namespaces which are matched by the DynaKube and other DynaKubes at the same
+// time. The webhook doesn't inject into these Namespaces until the conflict is resolved.
+func (r *ReconcileDynaKube) reconcileInjectionConflicts(ctx context.Context, rec *utils.Reconciliation) error {
+	if !rec.Instance.Spec.CodeModules.Enabled {
+		if meta.FindStatusCondition(rec.Instance.Status.Conditions, dynatracev1alpha1.InjectionConflictConditionType) != nil {
+			meta.RemoveStatusCondition(&rec.Instance.Status.Conditions, dynatracev1alpha1.InjectionConflictConditionType)
+			rec.Update(true, defaultUpdateInterval, "Injection conflicts removed")
+		}
+		return nil
+	}
+
+	var dynakubes dynatracev1alpha1.DynaKubeList
+	if err := r.client.List(ctx, &dynakubes, client.InNamespace(rec.Instance.Namespace)); err != nil {
+		return errors.WithStack(err)
+	}
+
+	var namespaces corev1.NamespaceList
+	if err := r.client.List(ctx, &namespaces); err != nil {
+		return errors.WithStack(err)
+	}
+
+	var conflicting []string
+	for i := range namespaces.Items {
+		_, err := webhook.FindDynaKube(&namespaces.Items[i], dynakubes.Items)
+
+		var conflict *webhook.ConflictError
+		if errors.As(err, &conflict) && containsString(conflict.DynaKubes, rec.Instance.Name) {
+			conflicting = append(conflicting, conflict.Namespace)
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:    dynatracev1alpha1.InjectionConflictConditionType,
+		Status:  metav1.ConditionFalse,
+		Reason:  dynatracev1alpha1.ReasonNoConflict,
+		Message: "No namespace is matched by other DynaKubes",
+	}
+	if len(conflicting) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = dynatracev1alpha1.ReasonNamespacesConflicting
+		condition.Message = fmt.Sprintf("Namespaces matched by other DynaKubes as well: %s", strings.Join(conflicting, ", "))
+	}
+
+	rec.Update(setCondition(&rec.Instance.Status.Conditions, condition), defaultUpdateInterval, "Injection conflicts updated")
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package dynakube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/controllers/utils"
+	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/Dynatrace/dynatrace-operator/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestReconcileInjectionConflicts(t *testing.T) {
+	buildDynaKube := func(name string) *v1alpha1.DynaKube {
+		return &v1alpha1.DynaKube{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+			Spec: v1alpha1.DynaKubeSpec{
+				CodeModules: v1alpha1.CodeModulesSpec{
+					Enabled:           true,
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"inject": "true"}},
+				},
+			},
+		}
+	}
+
+	t.Run(`reports conflicting namespaces`, func(t *testing.T) {
+		instance := buildDynaKube(testName)
+		r := &ReconcileDynaKube{
+			client: fake.NewClient(instance, buildDynaKube("other"),
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "conflicting", Labels: map[string]string{"inject": "true"}}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "assigned", Labels: map[string]string{webhook.LabelInstance: testName}}},
+			),
+		}
+		rec := utils.NewReconciliation(logf.Log, instance)
+
+		require.NoError(t, r.reconcileInjectionConflicts(context.TODO(), rec))
+		assert.True(t, rec.Updated)
+
+		condition := meta.FindStatusCondition(instance.Status.Conditions, v1alpha1.InjectionConflictConditionType)
+		require.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, v1alpha1.ReasonNamespacesConflicting, condition.Reason)
+		assert.Equal(t, "Namespaces matched by other DynaKubes as well: conflicting", condition.Message)
+	})
+	t.Run(`reports no conflict`, func(t *testing.T) {
+		instance := buildDynaKube(testName)
+		r := &ReconcileDynaKube{
+			client: fake.NewClient(instance,
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "selected", Labels: map[string]string{"inject": "true"}}},
+			),
+		}
+		rec := utils.NewReconciliation(logf.Log, instance)
+
+		require.NoError(t, r.reconcileInjectionConflicts(context.TODO(), rec))
+
+		condition := meta.FindStatusCondition(instance.Status.Conditions, v1alpha1.InjectionConflictConditionType)
+		require.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, v1alpha1.ReasonNoConflict, condition.Reason)
+	})
+	t.Run(`removes condition if code modules are disabled`, func(t *testing.T) {
+		instance := buildDynaKube(testName)
+		instance.Spec.CodeModules.Enabled = false
+		instance.Status.Conditions = []metav1.Condition{{Type: v1alpha1.InjectionConflictConditionType, Status: metav1.ConditionTrue}}
+		r := &ReconcileDynaKube{client: fake.NewClient(instance)}
+		rec := utils.NewReconciliation(logf.Log, instance)
+
+		require.NoError(t, r.reconcileInjectionConflicts(context.TODO(), rec))
+		assert.True(t, rec.Updated)
+		assert.Empty(t, instance.Status.Conditions)
+	})
+}
@@ -0,0 +1,113 @@
+package dynakube
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dynatrace/dynatrace-operator/controllers/utils"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	installerTokenExpirationKey = "expirationDate"
+	installerTokenIDKey         = "tokenId"
+	installerTokenRenewalKey    = "renewalDate"
+	supersededTokenIDKey        = "supersededTokenId"
+
+	// supersededTokenRevocationDelay gives the namespace controller, which resyncs every 5 minutes, time to distribute
+	// the renewed token before the superseded one is revoked
+	supersededTokenRevocationDelay = 10 * time.Minute
+)
+
+// reconcileInstallerToken creates the short-lived installer token, which is distributed to the injected namespaces
+// instead of the PaaS token, renews it once half of its lifetime has passed and revokes the superseded token afterwards
+func (r *ReconcileDynaKube) reconcileInstallerToken(ctx context.Context, rec *utils.Reconciliation, dtc dtclient.Client) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: rec.Instance.InstallerTokenSecret(), Namespace: rec.Instance.Namespace},
+	}
+	if !rec.Instance.UsesShortLivedInstallerToken() {
+		return errors.WithStack(r.ensureDeleted(secret))
+	}
+
+	lifetime := rec.Instance.Spec.CodeModules.InstallerTokenLifetime.Duration
+	requeueBefore(rec, lifetime/2)
+
+	err := r.client.Get(ctx, client.ObjectKey{Name: secret.Name, Namespace: secret.Namespace}, secret)
+	exists := err == nil
+	if exists {
+		expiration, err := time.Parse(time.RFC3339, string(secret.Data[installerTokenExpirationKey]))
+		if err == nil && time.Until(expiration) > lifetime/2 {
+			return r.revokeSupersededToken(ctx, rec, dtc, secret)
+		}
+	} else if !k8serrors.IsNotFound(err) {
+		return errors.WithStack(err)
+	}
+
+	token, err := dtc.CreateToken(rec.Instance.InstallerTokenSecret(), []string{dtclient.TokenScopeInstallerDownload}, time.Now().Add(lifetime))
+	if err != nil {
+		return errors.Wrap(err, "failed to create installer token")
+	}
+
+	// A token superseded by the previous renewal, which hasn't been revoked yet, can't be in use anymore
+	if superseded := string(secret.Data[supersededTokenIDKey]); superseded != "" {
+		if err := dtc.RevokeToken(superseded); err != nil {
+			rec.Log.Error(err, "failed to revoke superseded installer token")
+		}
+	}
+
+	data := map[string][]byte{
+		dtclient.DynatracePaasToken: []byte(token.Token),
+		installerTokenExpirationKey: []byte(token.ExpirationDate.UTC().Format(time.RFC3339)),
+		installerTokenIDKey:         []byte(token.ID),
+		installerTokenRenewalKey:    []byte(time.Now().UTC().Format(time.RFC3339)),
+	}
+	if previous := secret.Data[installerTokenIDKey]; len(previous) > 0 {
+		data[supersededTokenIDKey] = previous
+		requeueBefore(rec, supersededTokenRevocationDelay)
+	}
+	secret.Data = data
+
+	if err = controllerutil.SetControllerReference(rec.Instance, secret, r.scheme); err != nil {
+		return errors.WithStack(err)
+	}
+
+	rec.Log.Info("renewing installer token", "expiration", token.ExpirationDate)
+	if !exists {
+		return errors.WithStack(r.client.Create(ctx, secret))
+	}
+	return errors.WithStack(r.client.Update(ctx, secret))
+}
+
+// revokeSupersededToken revokes the token replaced by the last renewal, once the namespaces had time to get the new one
+func (r *ReconcileDynaKube) revokeSupersededToken(ctx context.Context, rec *utils.Reconciliation, dtc dtclient.Client, secret *corev1.Secret) error {
+	superseded := string(secret.Data[supersededTokenIDKey])
+	if superseded == "" {
+		return nil
+	}
+
+	renewal, err := time.Parse(time.RFC3339, string(secret.Data[installerTokenRenewalKey]))
+	if err == nil && time.Since(renewal) < supersededTokenRevocationDelay {
+		requeueBefore(rec, supersededTokenRevocationDelay-time.Since(renewal))
+		return nil
+	}
+
+	if err := dtc.RevokeToken(superseded); err != nil {
+		return errors.Wrap(err, "failed to revoke superseded installer token")
+	}
+
+	rec.Log.Info("revoked superseded installer token")
+	delete(secret.Data, supersededTokenIDKey)
+	return errors.WithStack(r.client.Update(ctx, secret))
+}
+
+func requeueBefore(rec *utils.Reconciliation, d time.Duration) {
+	if d < rec.RequeueAfter {
+		rec.RequeueAfter = d
+	}
+}
@@ -0,0 +1,168 @@
+package dynakube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/controllers/utils"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/Dynatrace/dynatrace-operator/scheme"
+	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestReconcileInstallerToken(t *testing.T) {
+	buildDynaKube := func(lifetime *metav1.Duration) *v1alpha1.DynaKube {
+		return &v1alpha1.DynaKube{
+			ObjectMeta: metav1.ObjectMeta{Name: testName, Namespace: testNamespace},
+			Spec: v1alpha1.DynaKubeSpec{
+				CodeModules: v1alpha1.CodeModulesSpec{
+					Enabled:                true,
+					Volume:                 corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+					InstallerTokenLifetime: lifetime,
+				},
+			},
+		}
+	}
+	getSecret := func(r *ReconcileDynaKube) (*corev1.Secret, error) {
+		var secret corev1.Secret
+		err := r.client.Get(context.TODO(), client.ObjectKey{Name: testName + v1alpha1.InstallerTokenSuffix, Namespace: testNamespace}, &secret)
+		return &secret, err
+	}
+
+	t.Run(`create token`, func(t *testing.T) {
+		instance := buildDynaKube(&metav1.Duration{Duration: time.Hour})
+		r := &ReconcileDynaKube{client: fake.NewClient(instance), scheme: scheme.Scheme}
+		expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("CreateToken", testName+v1alpha1.InstallerTokenSuffix, []string{dtclient.TokenScopeInstallerDownload}, mock.AnythingOfType("time.Time")).
+			Return(&dtclient.Token{ID: "id", Token: "token", ExpirationDate: expiration}, nil)
+
+		require.NoError(t, r.reconcileInstallerToken(context.TODO(), utils.NewReconciliation(logf.Log, instance), dtc))
+
+		secret, err := getSecret(r)
+		require.NoError(t, err)
+		assert.Equal(t, "token", string(secret.Data[dtclient.DynatracePaasToken]))
+		assert.Equal(t, expiration.Format(time.RFC3339), string(secret.Data[installerTokenExpirationKey]))
+		assert.Len(t, secret.OwnerReferences, 1)
+	})
+	t.Run(`keep valid token`, func(t *testing.T) {
+		instance := buildDynaKube(&metav1.Duration{Duration: time.Hour})
+		r := &ReconcileDynaKube{
+			client: fake.NewClient(instance, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: testName + v1alpha1.InstallerTokenSuffix, Namespace: testNamespace},
+				Data: map[string][]byte{
+					dtclient.DynatracePaasToken: []byte("token"),
+					installerTokenExpirationKey: []byte(time.Now().Add(45 * time.Minute).Format(time.RFC3339)),
+				},
+			}),
+			scheme: scheme.Scheme,
+		}
+		dtc := &dtclient.MockDynatraceClient{}
+
+		require.NoError(t, r.reconcileInstallerToken(context.TODO(), utils.NewReconciliation(logf.Log, instance), dtc))
+		dtc.AssertNotCalled(t, "CreateToken")
+	})
+	t.Run(`renew token after half of its lifetime`, func(t *testing.T) {
+		instance := buildDynaKube(&metav1.Duration{Duration: time.Hour})
+		r := &ReconcileDynaKube{
+			client: fake.NewClient(instance, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: testName + v1alpha1.InstallerTokenSuffix, Namespace: testNamespace},
+				Data: map[string][]byte{
+					dtclient.DynatracePaasToken: []byte("token"),
+					installerTokenExpirationKey: []byte(time.Now().Add(15 * time.Minute).Format(time.RFC3339)),
+					installerTokenIDKey:         []byte("old"),
+				},
+			}),
+			scheme: scheme.Scheme,
+		}
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("CreateToken", mock.Anything, mock.Anything, mock.Anything).
+			Return(&dtclient.Token{ID: "id", Token: "renewed", ExpirationDate: time.Now().Add(time.Hour)}, nil)
+
+		rec := utils.NewReconciliation(logf.Log, instance)
+		require.NoError(t, r.reconcileInstallerToken(context.TODO(), rec, dtc))
+		assert.Equal(t, supersededTokenRevocationDelay, rec.RequeueAfter)
+
+		secret, err := getSecret(r)
+		require.NoError(t, err)
+		assert.Equal(t, "renewed", string(secret.Data[dtclient.DynatracePaasToken]))
+		assert.Equal(t, "id", string(secret.Data[installerTokenIDKey]))
+		assert.Equal(t, "old", string(secret.Data[supersededTokenIDKey]))
+		dtc.AssertNotCalled(t, "RevokeToken", mock.Anything)
+	})
+	t.Run(`requeue before half of the lifetime has passed`, func(t *testing.T) {
+		instance := buildDynaKube(&metav1.Duration{Duration: time.Hour})
+		r := &ReconcileDynaKube{client: fake.NewClient(instance), scheme: scheme.Scheme}
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("CreateToken", mock.Anything, mock.Anything, mock.Anything).
+			Return(&dtclient.Token{ID: "id", Token: "token", ExpirationDate: time.Now().Add(time.Hour)}, nil)
+
+		rec := utils.NewReconciliation(logf.Log, instance)
+		require.NoError(t, r.reconcileInstallerToken(context.TODO(), rec, dtc))
+		assert.Equal(t, 30*time.Minute, rec.RequeueAfter)
+
+		instance = buildDynaKube(&metav1.Duration{Duration: 24 * time.Hour})
+		r = &ReconcileDynaKube{client: fake.NewClient(instance), scheme: scheme.Scheme}
+		rec = utils.NewReconciliation(logf.Log, instance)
+		require.NoError(t, r.reconcileInstallerToken(context.TODO(), rec, dtc))
+		assert.Equal(t, 30*time.Minute, rec.RequeueAfter)
+	})
+	t.Run(`revoke superseded token after the delay`, func(t *testing.T) {
+		buildSecret := func(renewal time.Time) *corev1.Secret {
+			return &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: testName + v1alpha1.InstallerTokenSuffix, Namespace: testNamespace},
+				Data: map[string][]byte{
+					dtclient.DynatracePaasToken: []byte("token"),
+					installerTokenExpirationKey: []byte(time.Now().Add(45 * time.Minute).Format(time.RFC3339)),
+					installerTokenIDKey:         []byte("id"),
+					installerTokenRenewalKey:    []byte(renewal.Format(time.RFC3339)),
+					supersededTokenIDKey:        []byte("old"),
+				},
+			}
+		}
+
+		instance := buildDynaKube(&metav1.Duration{Duration: time.Hour})
+		r := &ReconcileDynaKube{client: fake.NewClient(instance, buildSecret(time.Now().Add(-time.Minute))), scheme: scheme.Scheme}
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("RevokeToken", "old").Return(nil)
+
+		rec := utils.NewReconciliation(logf.Log, instance)
+		require.NoError(t, r.reconcileInstallerToken(context.TODO(), rec, dtc))
+		assert.LessOrEqual(t, int64(rec.RequeueAfter), int64(supersededTokenRevocationDelay-time.Minute))
+		dtc.AssertNotCalled(t, "RevokeToken", mock.Anything)
+
+		r = &ReconcileDynaKube{client: fake.NewClient(instance, buildSecret(time.Now().Add(-time.Hour))), scheme: scheme.Scheme}
+		require.NoError(t, r.reconcileInstallerToken(context.TODO(), utils.NewReconciliation(logf.Log, instance), dtc))
+		dtc.AssertCalled(t, "RevokeToken", "old")
+
+		secret, err := getSecret(r)
+		require.NoError(t, err)
+		assert.NotContains(t, secret.Data, supersededTokenIDKey)
+		assert.Equal(t, "token", string(secret.Data[dtclient.DynatracePaasToken]))
+	})
+	t.Run(`remove token if not used anymore`, func(t *testing.T) {
+		instance := buildDynaKube(nil)
+		r := &ReconcileDynaKube{
+			client: fake.NewClient(instance, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: testName + v1alpha1.InstallerTokenSuffix, Namespace: testNamespace},
+			}),
+			scheme: scheme.Scheme,
+		}
+
+		require.NoError(t, r.reconcileInstallerToken(context.TODO(), utils.NewReconciliation(logf.Log, instance), &dtclient.MockDynatraceClient{}))
+
+		_, err := getSecret(r)
+		assert.True(t, k8serrors.IsNotFound(err))
+	})
+}
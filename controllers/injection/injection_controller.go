@@ -0,0 +1,196 @@
+package injection
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/controllers/utils"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// requestName is the name of the single request of the controller, since all Namespaces are checked at once
+	requestName = "injection"
+
+	reconcileInterval      = 5 * time.Minute
+	defaultRestartInterval = 5 * time.Minute
+	defaultBatchSize       = 1
+
+	// EventReasonOrphanedInjection is the reason of the Events recorded on the owners of pods, which have been injected
+	// by a deleted DynaKube and aren't handled by any other DynaKube
+	EventReasonOrphanedInjection = "OrphanedInjection"
+)
+
+func Add(mgr manager.Manager, ns string) error {
+	logger := log.Log.WithName("injection.controller")
+	apmExists, err := utils.CheckIfOneAgentAPMExists(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	if apmExists {
+		logger.Info("OneAgentAPM object detected - Injection reconciler disabled until the OneAgent Operator has been uninstalled")
+		return nil
+	}
+
+	return add(mgr, &ReconcileInjection{
+		client:    mgr.GetClient(),
+		apiReader: mgr.GetAPIReader(),
+		recorder:  mgr.GetEventRecorderFor("injection-controller"),
+		namespace: ns,
+		logger:    logger,
+		now:       time.Now,
+	})
+}
+
+func add(mgr manager.Manager, r *ReconcileInjection) error {
+	c, err := controller.New("injection-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Changes of DynaKubes can change the desired injection state of pods in any Namespace
+	return c.Watch(&source.Kind{Type: &dynatracev1alpha1.DynaKube{}},
+		handler.EnqueueRequestsFromMapFunc(func(_ client.Object) []reconcile.Request {
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: requestName, Namespace: r.namespace}}}
+		}), predicate.GenerationChangedPredicate{})
+}
+
+// ReconcileInjection finds workloads whose pods don't match the desired injection state anymore, lists them in the
+// status of the responsible DynaKube and restarts them, if enabled for the DynaKube
+type ReconcileInjection struct {
+	client client.Client
+	// apiReader is used for pods and workloads, since caching them for the whole cluster is too expensive
+	apiReader client.Reader
+	recorder  record.EventRecorder
+	namespace string
+	logger    logr.Logger
+	now       func() time.Time
+	// injectedNamespaces holds the Namespaces pods have been injected into at the last reconcile. Until the first
+	// reconcile has checked all Namespaces, it is nil.
+	injectedNamespaces map[string]bool
+}
+
+func (r *ReconcileInjection) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	r.logger.Info("reconciling injection of workloads")
+
+	var dynakubes dynatracev1alpha1.DynaKubeList
+	if err := r.client.List(ctx, &dynakubes, client.InNamespace(r.namespace)); err != nil {
+		return reconcile.Result{}, errors.WithMessage(err, "failed to query DynaKubes")
+	}
+
+	var namespaces corev1.NamespaceList
+	if err := r.client.List(ctx, &namespaces); err != nil {
+		return reconcile.Result{}, errors.WithMessage(err, "failed to query Namespaces")
+	}
+
+	finder := newWorkloadFinder(r.apiReader, dynakubes.Items, r.injectedNamespaces)
+	for i := range namespaces.Items {
+		// The Operator's own Namespace is never injected into
+		if namespaces.Items[i].Name == r.namespace {
+			continue
+		}
+		if err := finder.findOutdatedWorkloads(ctx, &namespaces.Items[i]); err != nil {
+			return reconcile.Result{}, errors.WithMessagef(err, "failed to check workloads of Namespace %s", namespaces.Items[i].Name)
+		}
+	}
+
+	r.injectedNamespaces = finder.injectedNamespaces
+
+	for i := range dynakubes.Items {
+		dk := &dynakubes.Items[i]
+		if err := r.updateDynaKube(ctx, dk, finder.outdated[dk.Name], finder.failedInstallations[dk.Name]); err != nil {
+			return reconcile.Result{}, errors.WithMessagef(err, "failed to update DynaKube %s", dk.Name)
+		}
+	}
+
+	for _, workload := range finder.orphaned {
+		r.reportOrphanedWorkload(workload)
+	}
+
+	return reconcile.Result{RequeueAfter: reconcileInterval}, nil
+}
+
+// updateDynaKube reports the outdated workloads and failed installations in the status of the DynaKube and restarts the
+// next batch of outdated workloads, if enabled and the restart interval has passed since the last batch
+func (r *ReconcileInjection) updateDynaKube(ctx context.Context, dk *dynatracev1alpha1.DynaKube, workloads []dynatracev1alpha1.OutdatedWorkload, failedInstallations int32) error {
+	sort.Slice(workloads, func(i, j int) bool {
+		a, b := workloads[i], workloads[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+
+	status := &dk.Status.CodeModules
+	changed := !reflect.DeepEqual(status.OutdatedWorkloads, workloads) || status.FailedInstallations != failedInstallations
+	status.OutdatedWorkloads = workloads
+	status.FailedInstallations = failedInstallations
+
+	if spec := dk.Spec.CodeModules.RestartWorkloads; spec.Enabled && len(workloads) > 0 {
+		interval := defaultRestartInterval
+		if spec.Interval != nil {
+			interval = spec.Interval.Duration
+		}
+		batchSize := defaultBatchSize
+		if spec.BatchSize != nil && *spec.BatchSize > 0 {
+			batchSize = int(*spec.BatchSize)
+		}
+
+		now := r.now()
+		if status.LastWorkloadRestart == nil || now.Sub(status.LastWorkloadRestart.Time) >= interval {
+			restarted, err := r.restartWorkloads(ctx, workloads, batchSize, now, interval)
+			if err != nil {
+				return err
+			}
+			if restarted > 0 {
+				status.LastWorkloadRestart = &metav1.Time{Time: now}
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return errors.WithStack(r.client.Status().Update(ctx, dk))
+}
+
+// reportOrphanedWorkload records an Event for outdated workloads, which can't be listed in the status of a DynaKube
+func (r *ReconcileInjection) reportOrphanedWorkload(workload orphanedWorkload) {
+	r.logger.Info("workload injected by deleted DynaKube", "kind", workload.Kind, "namespace", workload.Namespace, "name", workload.Name, "dynakube", workload.injectedBy)
+
+	owner := metav1.GetControllerOf(workload.pod)
+	if owner == nil || r.recorder == nil {
+		return
+	}
+
+	injectedBy := "a DynaKube"
+	if workload.injectedBy != "" {
+		injectedBy = "DynaKube " + workload.injectedBy
+	}
+	r.recorder.Eventf(&metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: owner.APIVersion, Kind: owner.Kind},
+		ObjectMeta: metav1.ObjectMeta{Name: owner.Name, Namespace: workload.Namespace, UID: owner.UID},
+	}, corev1.EventTypeWarning, EventReasonOrphanedInjection,
+		"Pods of %s %s have been injected by %s, which doesn't exist anymore. Restart the %s to remove the injection",
+		workload.Kind, workload.Name, injectedBy, workload.Kind)
+}
@@ -0,0 +1,297 @@
+package injection
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/installer"
+	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/Dynatrace/dynatrace-operator/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	testOperatorNamespace = "dynatrace"
+	testNamespace         = "test-namespace"
+	testDynaKube          = "oneagent"
+)
+
+var testTime = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func buildTestDynaKube(restart bool) *dynatracev1alpha1.DynaKube {
+	return &dynatracev1alpha1.DynaKube{
+		ObjectMeta: metav1.ObjectMeta{Name: testDynaKube, Namespace: testOperatorNamespace},
+		Spec: dynatracev1alpha1.DynaKubeSpec{
+			CodeModules: dynatracev1alpha1.CodeModulesSpec{
+				Enabled:          true,
+				RestartWorkloads: dynatracev1alpha1.RestartWorkloadsSpec{Enabled: restart},
+			},
+		},
+	}
+}
+
+func buildTestPod(name string, annotations map[string]string, owner metav1.OwnerReference) *corev1.Pod {
+	controller := true
+	owner.Controller = &controller
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       testNamespace,
+			Annotations:     annotations,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+	}
+}
+
+func buildTestReconciler(objs ...client.Object) (*ReconcileInjection, client.Client) {
+	c := fake.NewClient(objs...)
+	return &ReconcileInjection{
+		client:    c,
+		apiReader: c,
+		recorder:  record.NewFakeRecorder(10),
+		namespace: testOperatorNamespace,
+		logger:    zap.New(zap.UseDevMode(true), zap.WriteTo(os.Stdout)),
+		now:       func() time.Time { return testTime },
+	}, c
+}
+
+func TestReconcileInjection(t *testing.T) {
+	controller := true
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   testNamespace,
+			Labels: map[string]string{webhook.LabelInstance: testDynaKube},
+		},
+	}
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-1234",
+			Namespace: testNamespace,
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: KindDeployment, Name: "app", Controller: &controller},
+			},
+		},
+	}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: testNamespace}}
+	statefulSet := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: testNamespace}}
+
+	injected := map[string]string{webhook.AnnotationInjected: "true", webhook.AnnotationDynaKube: testDynaKube}
+
+	t.Run(`report workloads with pods not injected`, func(t *testing.T) {
+		r, c := buildTestReconciler(buildTestDynaKube(false), namespace, replicaSet, deployment, statefulSet,
+			buildTestPod("app-1234-a", nil, metav1.OwnerReference{Kind: "ReplicaSet", Name: "app-1234"}),
+			buildTestPod("app-1234-b", nil, metav1.OwnerReference{Kind: "ReplicaSet", Name: "app-1234"}),
+			buildTestPod("db-0", injected, metav1.OwnerReference{Kind: KindStatefulSet, Name: "db"}),
+			buildTestPod("job-abc", nil, metav1.OwnerReference{Kind: "Job", Name: "job"}))
+
+		result, err := r.Reconcile(context.TODO(), reconcile.Request{})
+		require.NoError(t, err)
+		assert.Equal(t, reconcileInterval, result.RequeueAfter)
+
+		var dk dynatracev1alpha1.DynaKube
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: testDynaKube, Namespace: testOperatorNamespace}, &dk))
+		assert.Equal(t, []dynatracev1alpha1.OutdatedWorkload{
+			{Kind: KindDeployment, Namespace: testNamespace, Name: "app", Reason: dynatracev1alpha1.ReasonNotInjected},
+		}, dk.Status.CodeModules.OutdatedWorkloads)
+		assert.Nil(t, dk.Status.CodeModules.LastWorkloadRestart)
+
+		var current appsv1.Deployment
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "app", Namespace: testNamespace}, &current))
+		assert.Empty(t, current.Spec.Template.Annotations)
+	})
+	t.Run(`report workloads with injection disabled`, func(t *testing.T) {
+		disabled := buildTestDynaKube(false)
+		disabled.Spec.CodeModules.Enabled = false
+
+		r, c := buildTestReconciler(disabled, namespace, statefulSet,
+			buildTestPod("db-0", injected, metav1.OwnerReference{Kind: KindStatefulSet, Name: "db"}))
+
+		_, err := r.Reconcile(context.TODO(), reconcile.Request{})
+		require.NoError(t, err)
+
+		var dk dynatracev1alpha1.DynaKube
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: testDynaKube, Namespace: testOperatorNamespace}, &dk))
+		assert.Equal(t, []dynatracev1alpha1.OutdatedWorkload{
+			{Kind: KindStatefulSet, Namespace: testNamespace, Name: "db", Reason: dynatracev1alpha1.ReasonInjectionDisabled},
+		}, dk.Status.CodeModules.OutdatedWorkloads)
+	})
+	t.Run(`report workloads injected by another DynaKube`, func(t *testing.T) {
+		other := map[string]string{webhook.AnnotationInjected: "true", webhook.AnnotationDynaKube: "other"}
+
+		r, c := buildTestReconciler(buildTestDynaKube(false), namespace, statefulSet,
+			buildTestPod("db-0", other, metav1.OwnerReference{Kind: KindStatefulSet, Name: "db"}))
+
+		_, err := r.Reconcile(context.TODO(), reconcile.Request{})
+		require.NoError(t, err)
+
+		var dk dynatracev1alpha1.DynaKube
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: testDynaKube, Namespace: testOperatorNamespace}, &dk))
+		assert.Equal(t, []dynatracev1alpha1.OutdatedWorkload{
+			{Kind: KindStatefulSet, Namespace: testNamespace, Name: "db", Reason: dynatracev1alpha1.ReasonDynaKubeChanged},
+		}, dk.Status.CodeModules.OutdatedWorkloads)
+	})
+	t.Run(`report workloads injected by a deleted DynaKube`, func(t *testing.T) {
+		removed := map[string]string{webhook.AnnotationInjected: "true", webhook.AnnotationDynaKube: "removed"}
+		unassigned := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   testNamespace,
+			Labels: map[string]string{webhook.LabelInstance: "removed"},
+		}}
+
+		r, c := buildTestReconciler(buildTestDynaKube(false), unassigned, statefulSet,
+			buildTestPod("db-0", removed, metav1.OwnerReference{Kind: KindStatefulSet, Name: "db"}),
+			buildTestPod("db-1", removed, metav1.OwnerReference{Kind: KindStatefulSet, Name: "db"}))
+
+		_, err := r.Reconcile(context.TODO(), reconcile.Request{})
+		require.NoError(t, err)
+
+		var dk dynatracev1alpha1.DynaKube
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: testDynaKube, Namespace: testOperatorNamespace}, &dk))
+		assert.Empty(t, dk.Status.CodeModules.OutdatedWorkloads)
+
+		events := r.recorder.(*record.FakeRecorder).Events
+		require.Len(t, events, 1)
+		event := <-events
+		assert.Contains(t, event, EventReasonOrphanedInjection)
+		assert.Contains(t, event, "Pods of StatefulSet db have been injected by DynaKube removed")
+	})
+	t.Run(`ignore pods without selected containers`, func(t *testing.T) {
+		skipped := map[string]string{webhook.AnnotationInjected: "false"}
+
+		r, c := buildTestReconciler(buildTestDynaKube(false), namespace, statefulSet,
+			buildTestPod("db-0", skipped, metav1.OwnerReference{Kind: KindStatefulSet, Name: "db"}))
+
+		_, err := r.Reconcile(context.TODO(), reconcile.Request{})
+		require.NoError(t, err)
+
+		var dk dynatracev1alpha1.DynaKube
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: testDynaKube, Namespace: testOperatorNamespace}, &dk))
+		assert.Empty(t, dk.Status.CodeModules.OutdatedWorkloads)
+	})
+	t.Run(`restart batch of outdated workloads`, func(t *testing.T) {
+		r, c := buildTestReconciler(buildTestDynaKube(true), namespace, replicaSet, deployment, statefulSet,
+			buildTestPod("app-1234-a", nil, metav1.OwnerReference{Kind: "ReplicaSet", Name: "app-1234"}),
+			buildTestPod("db-0", nil, metav1.OwnerReference{Kind: KindStatefulSet, Name: "db"}))
+
+		_, err := r.Reconcile(context.TODO(), reconcile.Request{})
+		require.NoError(t, err)
+
+		var dk dynatracev1alpha1.DynaKube
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: testDynaKube, Namespace: testOperatorNamespace}, &dk))
+		assert.Len(t, dk.Status.CodeModules.OutdatedWorkloads, 2)
+		require.NotNil(t, dk.Status.CodeModules.LastWorkloadRestart)
+		assert.True(t, testTime.Equal(dk.Status.CodeModules.LastWorkloadRestart.Time))
+
+		var currentDeployment appsv1.Deployment
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "app", Namespace: testNamespace}, &currentDeployment))
+		assert.Equal(t, testTime.Format(time.RFC3339), currentDeployment.Spec.Template.Annotations[AnnotationRestartedAt])
+
+		var currentStatefulSet appsv1.StatefulSet
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "db", Namespace: testNamespace}, &currentStatefulSet))
+		assert.Empty(t, currentStatefulSet.Spec.Template.Annotations)
+
+		// The next batch is only restarted after the interval has passed
+		r.now = func() time.Time { return testTime.Add(time.Minute) }
+		_, err = r.Reconcile(context.TODO(), reconcile.Request{})
+		require.NoError(t, err)
+
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "db", Namespace: testNamespace}, &currentStatefulSet))
+		assert.Empty(t, currentStatefulSet.Spec.Template.Annotations)
+
+		// The restarted pods of the Deployment are injected now
+		var pod corev1.Pod
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "app-1234-a", Namespace: testNamespace}, &pod))
+		pod.Annotations = injected
+		require.NoError(t, c.Update(context.TODO(), &pod))
+
+		r.now = func() time.Time { return testTime.Add(defaultRestartInterval) }
+		_, err = r.Reconcile(context.TODO(), reconcile.Request{})
+		require.NoError(t, err)
+
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "db", Namespace: testNamespace}, &currentStatefulSet))
+		assert.Equal(t, testTime.Add(defaultRestartInterval).Format(time.RFC3339), currentStatefulSet.Spec.Template.Annotations[AnnotationRestartedAt])
+	})
+}
+
+type listRecorder struct {
+	client.Reader
+	namespaces []string
+}
+
+func (r *listRecorder) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if _, ok := list.(*corev1.PodList); ok {
+		listOpts := client.ListOptions{}
+		listOpts.ApplyOptions(opts)
+		r.namespaces = append(r.namespaces, listOpts.Namespace)
+	}
+	return r.Reader.List(ctx, list, opts...)
+}
+
+func TestReconcileInjection_Namespaces(t *testing.T) {
+	injected := map[string]string{webhook.AnnotationInjected: "true", webhook.AnnotationDynaKube: testDynaKube}
+	assigned := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "assigned",
+		Labels: map[string]string{webhook.LabelInstance: testDynaKube},
+	}}
+	unassigned := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace}}
+	untouched := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "untouched"}}
+
+	r, _ := buildTestReconciler(buildTestDynaKube(false), assigned, unassigned, untouched,
+		buildTestPod("db-0", injected, metav1.OwnerReference{Kind: KindStatefulSet, Name: "db"}))
+	reader := &listRecorder{Reader: r.apiReader}
+	r.apiReader = reader
+
+	// All Namespaces are checked once
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"assigned", testNamespace, "untouched"}, reader.namespaces)
+
+	// Afterwards only assigned Namespaces and Namespaces with injected pods are checked
+	reader.namespaces = nil
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"assigned", testNamespace}, reader.namespaces)
+}
+
+func TestReconcileInjection_FailedInstallations(t *testing.T) {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   testNamespace,
+			Labels: map[string]string{webhook.LabelInstance: testDynaKube},
+		},
+	}
+	injected := map[string]string{webhook.AnnotationInjected: "true", webhook.AnnotationDynaKube: testDynaKube}
+
+	failed := buildTestPod("db-0", injected, metav1.OwnerReference{Kind: KindStatefulSet, Name: "db"})
+	failed.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name: webhook.InstallContainerName,
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			Message: installer.FailureMessagePrefix + "failed to download the OneAgent package",
+		}},
+	}}
+	succeeded := buildTestPod("db-1", injected, metav1.OwnerReference{Kind: KindStatefulSet, Name: "db"})
+	succeeded.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name:  webhook.InstallContainerName,
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}},
+	}}
+
+	r, c := buildTestReconciler(buildTestDynaKube(false), namespace, failed, succeeded)
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{})
+	require.NoError(t, err)
+
+	var dk dynatracev1alpha1.DynaKube
+	require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: testDynaKube, Namespace: testOperatorNamespace}, &dk))
+	assert.Equal(t, int32(1), dk.Status.CodeModules.FailedInstallations)
+	assert.Empty(t, dk.Status.CodeModules.OutdatedWorkloads)
+}
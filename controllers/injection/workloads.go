@@ -0,0 +1,304 @@
+package injection
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/controllers/utils"
+	"github.com/Dynatrace/dynatrace-operator/installer"
+	"github.com/Dynatrace/dynatrace-operator/webhook"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+
+	// AnnotationRestartedAt is set on the pod template of restarted workloads, the same way as by kubectl rollout restart
+	AnnotationRestartedAt = "kubectl.kubernetes.io/restartedAt"
+)
+
+// workloadFinder collects the outdated workloads and failed installations of all Namespaces by the DynaKube responsible
+// for them
+type workloadFinder struct {
+	apiReader client.Reader
+	dynakubes []dynatracev1alpha1.DynaKube
+
+	outdated            map[string][]dynatracev1alpha1.OutdatedWorkload
+	failedInstallations map[string]int32
+	// orphaned holds outdated workloads no existing DynaKube is responsible for, since the one which injected them has
+	// been deleted and their Namespace isn't assigned to another one
+	orphaned  []orphanedWorkload
+	workloads map[ownerKey]ownerKey
+
+	// previouslyInjected holds the Namespaces pods have been injected into at the last check, nil if all Namespaces
+	// have to be checked. injectedNamespaces collects them for the next check.
+	previouslyInjected map[string]bool
+	injectedNamespaces map[string]bool
+}
+
+type orphanedWorkload struct {
+	dynatracev1alpha1.OutdatedWorkload
+	pod        *corev1.Pod
+	injectedBy string
+}
+
+type ownerKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+func newWorkloadFinder(apiReader client.Reader, dynakubes []dynatracev1alpha1.DynaKube, previouslyInjected map[string]bool) *workloadFinder {
+	return &workloadFinder{
+		apiReader:           apiReader,
+		dynakubes:           dynakubes,
+		outdated:            map[string][]dynatracev1alpha1.OutdatedWorkload{},
+		failedInstallations: map[string]int32{},
+		workloads:           map[ownerKey]ownerKey{},
+		previouslyInjected:  previouslyInjected,
+		injectedNamespaces:  map[string]bool{},
+	}
+}
+
+// findOutdatedWorkloads compares the injection state of the pods in the Namespace with the state the webhook would
+// create now. Pods not owned by a Deployment, StatefulSet or DaemonSet are ignored, since they can't be restarted.
+func (finder *workloadFinder) findOutdatedWorkloads(ctx context.Context, ns *corev1.Namespace) error {
+	assigned, err := webhook.FindDynaKube(ns, finder.dynakubes)
+	var conflict *webhook.ConflictError
+	if errors.As(err, &conflict) {
+		// The webhook doesn't inject into the Namespace until the conflict is resolved, so pods are left as they are.
+		// The Namespace is checked again once the conflict has been resolved.
+		finder.injectedNamespaces[ns.Name] = true
+		return nil
+	} else if err != nil {
+		// The assigned DynaKube doesn't exist (anymore)
+		assigned = nil
+	}
+
+	// Listing the pods of all Namespaces is expensive, so Namespaces neither assigned to a DynaKube nor injected into
+	// at the last check are skipped
+	if _, labeled := ns.Labels[webhook.LabelInstance]; !labeled && assigned == nil &&
+		finder.previouslyInjected != nil && !finder.previouslyInjected[ns.Name] {
+		return nil
+	}
+
+	var pods corev1.PodList
+	if err := finder.apiReader.List(ctx, &pods, client.InNamespace(ns.Name)); err != nil {
+		return errors.WithStack(err)
+	}
+
+	found := map[dynatracev1alpha1.OutdatedWorkload]bool{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		if pod.Annotations[webhook.AnnotationInjected] == "true" {
+			finder.injectedNamespaces[ns.Name] = true
+		}
+
+		if hasFailedInstallation(pod) {
+			if injectedBy := finder.getDynaKube(pod.Annotations[webhook.AnnotationDynaKube]); injectedBy != nil {
+				finder.failedInstallations[injectedBy.Name]++
+			}
+		}
+
+		desired, err := desiredDynaKube(ns, pod, assigned)
+		if err != nil {
+			return err
+		}
+
+		reason := getOutdatedReason(pod, desired)
+		if reason == "" {
+			continue
+		}
+
+		responsible := desired
+		if responsible == nil {
+			responsible = finder.getDynaKube(pod.Annotations[webhook.AnnotationDynaKube])
+		}
+		if responsible == nil {
+			responsible = assigned
+		}
+
+		kind, name, err := finder.getWorkload(ctx, pod)
+		if err != nil {
+			return err
+		} else if kind == "" {
+			continue
+		}
+
+		workload := dynatracev1alpha1.OutdatedWorkload{Kind: kind, Namespace: ns.Name, Name: name, Reason: reason}
+		if found[workload] {
+			continue
+		}
+		found[workload] = true
+
+		if responsible == nil {
+			finder.orphaned = append(finder.orphaned, orphanedWorkload{
+				OutdatedWorkload: workload,
+				pod:              pod,
+				injectedBy:       pod.Annotations[webhook.AnnotationDynaKube],
+			})
+			continue
+		}
+		finder.outdated[responsible.Name] = append(finder.outdated[responsible.Name], workload)
+	}
+	return nil
+}
+
+// desiredDynaKube returns the DynaKube which would inject into the pod now, or nil if the pod wouldn't be injected
+func desiredDynaKube(ns *corev1.Namespace, pod *corev1.Pod, assigned *dynatracev1alpha1.DynaKube) (*dynatracev1alpha1.DynaKube, error) {
+	if assigned == nil || !assigned.Spec.CodeModules.Enabled {
+		return nil, nil
+	}
+
+	inject := utils.GetField(ns.Annotations, webhook.AnnotationInject, "true")
+	inject = utils.GetField(pod.Annotations, webhook.AnnotationInject, inject)
+	if inject == "false" {
+		return nil, nil
+	}
+
+	selected, err := webhook.MatchesPodSelector(assigned, pod)
+	if err != nil || !selected {
+		return nil, err
+	}
+	return assigned, nil
+}
+
+func getOutdatedReason(pod *corev1.Pod, desired *dynatracev1alpha1.DynaKube) string {
+	injectedBy := pod.Annotations[webhook.AnnotationDynaKube]
+
+	switch pod.Annotations[webhook.AnnotationInjected] {
+	case "true":
+		if desired == nil {
+			return dynatracev1alpha1.ReasonInjectionDisabled
+		}
+		// Pods injected before the DynaKube has been recorded on them are assumed to be injected by the desired one
+		if injectedBy != "" && injectedBy != desired.Name {
+			return dynatracev1alpha1.ReasonDynaKubeChanged
+		}
+	case "":
+		if desired != nil {
+			return dynatracev1alpha1.ReasonNotInjected
+		}
+	}
+	// Pods annotated with "false" have been handled by the webhook, but none of their containers has been selected
+	return ""
+}
+
+// hasFailedInstallation returns true if the install container of the pod reported a failure in its termination message
+func hasFailedInstallation(pod *corev1.Pod) bool {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name != webhook.InstallContainerName {
+			continue
+		}
+		for _, state := range []corev1.ContainerState{status.State, status.LastTerminationState} {
+			if state.Terminated != nil && strings.HasPrefix(state.Terminated.Message, installer.FailureMessagePrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (finder *workloadFinder) getDynaKube(name string) *dynatracev1alpha1.DynaKube {
+	for i := range finder.dynakubes {
+		if finder.dynakubes[i].Name == name {
+			return &finder.dynakubes[i]
+		}
+	}
+	return nil
+}
+
+// getWorkload returns the kind and name of the Deployment, StatefulSet or DaemonSet owning the pod, or an empty kind if
+// the pod isn't owned by any of them. Pods of the same ReplicaSet are resolved only once.
+func (finder *workloadFinder) getWorkload(ctx context.Context, pod *corev1.Pod) (string, string, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", "", nil
+	}
+
+	key := ownerKey{kind: owner.Kind, namespace: pod.Namespace, name: owner.Name}
+	workload, ok := finder.workloads[key]
+	if !ok {
+		kind, name, err := webhook.FindWorkload(ctx, finder.apiReader, pod, pod.Namespace)
+		if err != nil {
+			return "", "", err
+		}
+		workload = ownerKey{kind: kind, namespace: pod.Namespace, name: name}
+		finder.workloads[key] = workload
+	}
+
+	switch workload.kind {
+	case KindDeployment, KindStatefulSet, KindDaemonSet:
+		return workload.kind, workload.name, nil
+	}
+	return "", "", nil
+}
+
+// restartWorkloads restarts up to batchSize of the workloads, workloads restarted within the interval are skipped, since
+// their rollout may still be in progress. Returns the amount of restarted workloads.
+func (r *ReconcileInjection) restartWorkloads(ctx context.Context, workloads []dynatracev1alpha1.OutdatedWorkload, batchSize int, now time.Time, interval time.Duration) (int, error) {
+	restarted := 0
+	for _, workload := range workloads {
+		if restarted >= batchSize {
+			break
+		}
+
+		ok, err := r.restartWorkload(ctx, workload, now, interval)
+		if err != nil {
+			return restarted, errors.WithMessagef(err, "failed to restart %s %s/%s", workload.Kind, workload.Namespace, workload.Name)
+		}
+		if ok {
+			r.logger.Info("restarted outdated workload", "kind", workload.Kind, "namespace", workload.Namespace, "name", workload.Name, "reason", workload.Reason)
+			restarted++
+		}
+	}
+	return restarted, nil
+}
+
+func (r *ReconcileInjection) restartWorkload(ctx context.Context, workload dynatracev1alpha1.OutdatedWorkload, now time.Time, interval time.Duration) (bool, error) {
+	var obj client.Object
+	var template *corev1.PodTemplateSpec
+	switch workload.Kind {
+	case KindDeployment:
+		deployment := &appsv1.Deployment{}
+		obj, template = deployment, &deployment.Spec.Template
+	case KindStatefulSet:
+		statefulSet := &appsv1.StatefulSet{}
+		obj, template = statefulSet, &statefulSet.Spec.Template
+	case KindDaemonSet:
+		daemonSet := &appsv1.DaemonSet{}
+		obj, template = daemonSet, &daemonSet.Spec.Template
+	default:
+		return false, nil
+	}
+
+	if err := r.apiReader.Get(ctx, client.ObjectKey{Name: workload.Name, Namespace: workload.Namespace}, obj); k8serrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	if last, err := time.Parse(time.RFC3339, template.Annotations[AnnotationRestartedAt]); err == nil && now.Sub(last) < interval {
+		return false, nil
+	}
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[AnnotationRestartedAt] = now.Format(time.RFC3339)
+	return true, errors.WithStack(r.client.Patch(ctx, obj, patch))
+}
@@ -1,35 +1,31 @@
 package namespace
 
 import (
-	"bytes"
 	"context"
-	_ "embed"
+	"encoding/json"
 	"fmt"
-	"text/template"
 	"time"
 
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	"github.com/Dynatrace/dynatrace-operator/controllers/utils"
+	"github.com/Dynatrace/dynatrace-operator/installer"
 	"github.com/Dynatrace/dynatrace-operator/webhook"
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-//go:embed init.sh.tmpl
-var scriptContent string
-
-var scriptTmpl = template.Must(template.New("initScript").Parse(scriptContent))
-
 func Add(mgr manager.Manager, ns string) error {
 	logger := log.Log.WithName("namespaces.controller")
 	apmExists, err := utils.CheckIfOneAgentAPMExists(mgr.GetConfig())
@@ -62,9 +58,30 @@ func add(mgr manager.Manager, r *ReconcileNamespaces) error {
 		return err
 	}
 
+	// Changed namespace selectors of DynaKubes can affect any Namespace
+	err = c.Watch(&source.Kind{Type: &dynatracev1alpha1.DynaKube{}},
+		handler.EnqueueRequestsFromMapFunc(r.mapDynaKubeToNamespaces), predicate.GenerationChangedPredicate{})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+func (r *ReconcileNamespaces) mapDynaKubeToNamespaces(_ client.Object) []reconcile.Request {
+	var namespaces corev1.NamespaceList
+	if err := r.client.List(context.TODO(), &namespaces); err != nil {
+		r.logger.Error(err, "failed to query Namespaces")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: ns.Name}})
+	}
+	return requests
+}
+
 type ReconcileNamespaces struct {
 	client    client.Client
 	apiReader client.Reader
@@ -84,31 +101,42 @@ func (r *ReconcileNamespaces) Reconcile(ctx context.Context, request reconcile.R
 		return reconcile.Result{}, errors.WithMessage(err, "failed to query Namespace")
 	}
 
-	if ns.Labels == nil {
+	// The Operator's own Namespace is never injected into
+	if targetNS == r.namespace {
 		return reconcile.Result{}, nil
 	}
 
-	oaName := ns.Labels[webhook.LabelInstance]
-	if oaName == "" {
-		return reconcile.Result{}, nil
+	var ims dynatracev1alpha1.DynaKubeList
+	if err := r.client.List(ctx, &ims, client.InNamespace(r.namespace)); err != nil {
+		return reconcile.Result{}, errors.WithMessage(err, "failed to query DynaKubeList")
 	}
 
-	var dk dynatracev1alpha1.DynaKube
-	if err := r.client.Get(ctx, client.ObjectKey{Name: oaName, Namespace: r.namespace}, &dk); err != nil {
+	dk, err := webhook.FindDynaKube(&ns, ims.Items)
+	var conflict *webhook.ConflictError
+	if errors.As(err, &conflict) {
+		log.Info("Namespace is matched by multiple DynaKubes, injection is skipped", "dynakubes", conflict.DynaKubes)
+		if err = r.updateInstanceAssignment(ctx, &ns, nil, conflict.Error()); err != nil {
+			return reconcile.Result{}, errors.WithMessage(err, "failed to update Namespace")
+		}
+		return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
+	} else if err != nil {
 		return reconcile.Result{}, errors.WithMessage(err, "failed to query DynaKubes")
 	}
 
+	if err = r.updateInstanceAssignment(ctx, &ns, dk, ""); err != nil {
+		return reconcile.Result{}, errors.WithMessage(err, "failed to update Namespace")
+	}
+
+	if dk == nil {
+		return reconcile.Result{}, nil
+	}
+
 	tokenName := dk.Tokens()
 	if !dk.Spec.CodeModules.Enabled {
 		_ = r.ensureSecretDeleted(tokenName, targetNS)
 		return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
 	}
 
-	var ims dynatracev1alpha1.DynaKubeList
-	if err := r.client.List(ctx, &ims, client.InNamespace(r.namespace)); err != nil {
-		return reconcile.Result{}, errors.WithMessage(err, "failed to query DynaKubeList")
-	}
-
 	imNodes := map[string]string{}
 	for i := range ims.Items {
 		if s := &ims.Items[i].Status; s.ConnectionInfo.TenantUUID != "" && ims.Items[i].Spec.InfraMonitoring.Enabled {
@@ -120,19 +148,19 @@ func (r *ReconcileNamespaces) Reconcile(ctx context.Context, request reconcile.R
 		}
 	}
 
-	var tkns corev1.Secret
-	if err := r.client.Get(ctx, client.ObjectKey{Name: tokenName, Namespace: r.namespace}, &tkns); err != nil {
+	paasToken, err := r.getInstallerToken(ctx, dk)
+	if err != nil {
 		return reconcile.Result{}, errors.WithMessage(err, "failed to query tokens")
 	}
 
-	script, err := newScript(ctx, r.client, dk, tkns, imNodes, r.namespace)
+	cfg, err := newInstallerConfig(ctx, r.client, *dk, paasToken, imNodes, r.namespace)
 	if err != nil {
-		return reconcile.Result{}, errors.WithMessage(err, "failed to generate init script")
+		return reconcile.Result{}, errors.WithMessage(err, "failed to generate installer config")
 	}
 
-	data, err := script.generate()
+	data, err := cfg.generate()
 	if err != nil {
-		return reconcile.Result{}, errors.WithMessage(err, "failed to generate script")
+		return reconcile.Result{}, errors.WithMessage(err, "failed to generate installer config")
 	}
 
 	// The default cache-based Client doesn't support cross-namespace queries, unless configured to do so in Manager
@@ -145,7 +173,8 @@ func (r *ReconcileNamespaces) Reconcile(ctx context.Context, request reconcile.R
 	return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
 }
 
-type script struct {
+// installerConfig holds the data install-oneagent reads from the config secret in the namespace of an injected pod
+type installerConfig struct {
 	DynaKube   *dynatracev1alpha1.DynaKube
 	PaaSToken  string
 	Proxy      string
@@ -154,6 +183,71 @@ type script struct {
 	IMNodes    map[string]string
 }
 
+// updateInstanceAssignment labels Namespaces matched by the namespaceSelector of a DynaKube, so they are handled by the
+// webhook, and removes the label again once they aren't matched anymore. Conflicts are reported in an annotation.
+func (r *ReconcileNamespaces) updateInstanceAssignment(ctx context.Context, ns *corev1.Namespace, dk *dynatracev1alpha1.DynaKube, conflict string) error {
+	desired := ns.DeepCopy()
+	if desired.Labels == nil {
+		desired.Labels = map[string]string{}
+	}
+	if desired.Annotations == nil {
+		desired.Annotations = map[string]string{}
+	}
+
+	managed := desired.Annotations[webhook.AnnotationManagedInstance] == "true"
+	if dk != nil && (managed || desired.Labels[webhook.LabelInstance] == "") {
+		desired.Labels[webhook.LabelInstance] = dk.Name
+		desired.Annotations[webhook.AnnotationManagedInstance] = "true"
+	} else if dk == nil && managed {
+		delete(desired.Labels, webhook.LabelInstance)
+		delete(desired.Annotations, webhook.AnnotationManagedInstance)
+	}
+
+	if conflict != "" {
+		desired.Annotations[webhook.AnnotationConflict] = conflict
+	} else {
+		delete(desired.Annotations, webhook.AnnotationConflict)
+	}
+
+	if mapsEqual(desired.Labels, ns.Labels) && mapsEqual(desired.Annotations, ns.Annotations) {
+		return nil
+	}
+
+	r.logger.Info("updating DynaKube assignment of Namespace", "name", ns.Name, "dynakube", desired.Labels[webhook.LabelInstance])
+	return r.client.Update(ctx, desired)
+}
+
+func mapsEqual(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// getInstallerToken returns the token, which is used by injected pods to download the OneAgent package. Pods using the
+// CSI driver or the download cache don't download the package from the tenant and therefore don't get a token.
+func (r *ReconcileNamespaces) getInstallerToken(ctx context.Context, dk *dynatracev1alpha1.DynaKube) (string, error) {
+	if !dk.NeedsInstallerDownload() || dk.NeedsDownloadCache() {
+		return "", nil
+	}
+
+	tokenName := dk.Tokens()
+	if dk.UsesShortLivedInstallerToken() {
+		tokenName = dk.InstallerTokenSecret()
+	}
+
+	var tkns corev1.Secret
+	if err := r.client.Get(ctx, client.ObjectKey{Name: tokenName, Namespace: r.namespace}, &tkns); err != nil {
+		return "", err
+	}
+	return string(tkns.Data[utils.DynatracePaasToken]), nil
+}
+
 func (r *ReconcileNamespaces) ensureSecretDeleted(name string, ns string) error {
 	secret := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns}}
 	if err := r.client.Delete(context.TODO(), &secret); err != nil && !k8serrors.IsNotFound(err) {
@@ -162,14 +256,15 @@ func (r *ReconcileNamespaces) ensureSecretDeleted(name string, ns string) error
 	return nil
 }
 
-func newScript(ctx context.Context, c client.Client, dynaKube dynatracev1alpha1.DynaKube, tkns corev1.Secret, imNodes map[string]string, ns string) (*script, error) {
+func newInstallerConfig(ctx context.Context, c client.Client, dynaKube dynatracev1alpha1.DynaKube, paasToken string, imNodes map[string]string, ns string) (*installerConfig, error) {
 	var kubeSystemNS corev1.Namespace
 	if err := c.Get(ctx, client.ObjectKey{Name: "kube-system"}, &kubeSystemNS); err != nil {
 		return nil, fmt.Errorf("failed to query for cluster ID: %w", err)
 	}
 
+	// The download cache is reached inside the cluster, so the proxy is only needed for downloads from the tenant
 	var proxy string
-	if dynaKube.Spec.Proxy != nil {
+	if dynaKube.Spec.Proxy != nil && !dynaKube.NeedsDownloadCache() {
 		if dynaKube.Spec.Proxy.ValueFrom != "" {
 			var ps corev1.Secret
 			if err := c.Get(ctx, client.ObjectKey{Name: dynaKube.Spec.Proxy.ValueFrom, Namespace: ns}, &ps); err != nil {
@@ -190,9 +285,9 @@ func newScript(ctx context.Context, c client.Client, dynaKube dynatracev1alpha1.
 		trustedCAs = []byte(cam.Data["certs"])
 	}
 
-	return &script{
+	return &installerConfig{
 		DynaKube:   &dynaKube,
-		PaaSToken:  string(tkns.Data[utils.DynatracePaasToken]),
+		PaaSToken:  paasToken,
 		Proxy:      proxy,
 		TrustedCAs: trustedCAs,
 		ClusterID:  string(kubeSystemNS.UID),
@@ -200,19 +295,26 @@ func newScript(ctx context.Context, c client.Client, dynaKube dynatracev1alpha1.
 	}, nil
 }
 
-func (s *script) generate() (map[string][]byte, error) {
-	var buf bytes.Buffer
-
-	if err := scriptTmpl.Execute(&buf, s); err != nil {
+func (s *installerConfig) generate() (map[string][]byte, error) {
+	config, err := json.Marshal(installer.Config{
+		APIURL:        s.DynaKube.Spec.APIURL,
+		PaaSToken:     s.PaaSToken,
+		Proxy:         s.Proxy,
+		SkipCertCheck: s.DynaKube.Spec.SkipCertCheck,
+		TenantUUID:    s.DynaKube.Status.ConnectionInfo.TenantUUID,
+		ClusterID:     s.ClusterID,
+		HostTenants:   s.IMNodes,
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	data := map[string][]byte{
-		"init.sh": buf.Bytes(),
+		installer.ConfigFileName: config,
 	}
 
 	if s.TrustedCAs != nil {
-		data["ca.pem"] = s.TrustedCAs
+		data[installer.TrustedCAsFileName] = s.TrustedCAs
 	}
 
 	if s.Proxy != "" {
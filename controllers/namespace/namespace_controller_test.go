@@ -2,12 +2,15 @@ package namespace
 
 import (
 	"context"
-	_ "embed"
+	"encoding/json"
 	"os"
 	"testing"
+	"time"
 
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/installer"
 	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/Dynatrace/dynatrace-operator/webhook"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
@@ -18,9 +21,6 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-//go:embed init.sh.test-sample
-var scriptSample string
-
 func TestReconcileNamespace(t *testing.T) {
 	c := fake.NewClient(
 		&dynatracev1alpha1.DynaKube{
@@ -29,6 +29,7 @@ func TestReconcileNamespace(t *testing.T) {
 				APIURL: "https://test-url/api",
 				CodeModules: dynatracev1alpha1.CodeModulesSpec{
 					Enabled: true,
+					Volume:  corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
 				},
 				InfraMonitoring: dynatracev1alpha1.FullStackSpec{
 					Enabled: true,
@@ -80,7 +81,138 @@ func TestReconcileNamespace(t *testing.T) {
 	}, &nsSecret))
 
 	require.Len(t, nsSecret.Data, 1)
-	require.Contains(t, nsSecret.Data, "init.sh")
-	require.NotEmpty(t, scriptSample) // sanity check to confirm that the sample script has been embedded
-	require.Equal(t, scriptSample, string(nsSecret.Data["init.sh"]))
+
+	var config installer.Config
+	require.NoError(t, json.Unmarshal(nsSecret.Data[installer.ConfigFileName], &config))
+	assert.Equal(t, installer.Config{
+		APIURL:      "https://test-url/api",
+		PaaSToken:   "42",
+		TenantUUID:  "abc12345",
+		ClusterID:   "42",
+		HostTenants: map[string]string{"node1": "abc12345"},
+	}, config)
+}
+
+func TestReconcileNamespace_InstallerToken(t *testing.T) {
+	buildClient := func(codeModules dynatracev1alpha1.CodeModulesSpec) client.Client {
+		return fake.NewClient(
+			&dynatracev1alpha1.DynaKube{
+				ObjectMeta: metav1.ObjectMeta{Name: "oneagent", Namespace: "dynatrace"},
+				Spec:       dynatracev1alpha1.DynaKubeSpec{APIURL: "https://test-url/api", CodeModules: codeModules},
+			},
+			&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "test-namespace",
+					Labels: map[string]string{"oneagent.dynatrace.com/instance": "oneagent"},
+				},
+			},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "42"}},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "oneagent", Namespace: "dynatrace"},
+				Data:       map[string][]byte{"paasToken": []byte("42"), "apiToken": []byte("84")},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "oneagent-installer-token", Namespace: "dynatrace"},
+				Data:       map[string][]byte{"paasToken": []byte("short-lived")},
+			},
+		)
+	}
+	reconcileConfig := func(t *testing.T, c client.Client) installer.Config {
+		r := ReconcileNamespaces{
+			client:    c,
+			apiReader: c,
+			logger:    zap.New(zap.UseDevMode(true), zap.WriteTo(os.Stdout)),
+			namespace: "dynatrace",
+		}
+		_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-namespace"}})
+		require.NoError(t, err)
+
+		var nsSecret corev1.Secret
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "dynatrace-dynakube-config", Namespace: "test-namespace"}, &nsSecret))
+
+		var config installer.Config
+		require.NoError(t, json.Unmarshal(nsSecret.Data[installer.ConfigFileName], &config))
+		return config
+	}
+
+	t.Run(`no token for CSI driver`, func(t *testing.T) {
+		c := buildClient(dynatracev1alpha1.CodeModulesSpec{Enabled: true})
+		assert.Empty(t, reconcileConfig(t, c).PaaSToken)
+	})
+	t.Run(`short-lived token for installer`, func(t *testing.T) {
+		c := buildClient(dynatracev1alpha1.CodeModulesSpec{
+			Enabled:                true,
+			Volume:                 corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			InstallerTokenLifetime: &metav1.Duration{Duration: time.Hour},
+		})
+		assert.Equal(t, "short-lived", reconcileConfig(t, c).PaaSToken)
+	})
+}
+
+func TestReconcileNamespace_NamespaceSelector(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"inject": "true"}}
+	buildDynaKube := func(name string) *dynatracev1alpha1.DynaKube {
+		return &dynatracev1alpha1.DynaKube{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dynatrace"},
+			Spec: dynatracev1alpha1.DynaKubeSpec{
+				APIURL: "https://test-url/api",
+				CodeModules: dynatracev1alpha1.CodeModulesSpec{
+					Enabled:           true,
+					NamespaceSelector: selector,
+				},
+			},
+		}
+	}
+	reconcileNamespace := func(t *testing.T, c client.Client) corev1.Namespace {
+		r := ReconcileNamespaces{
+			client:    c,
+			apiReader: c,
+			logger:    zap.New(zap.UseDevMode(true), zap.WriteTo(os.Stdout)),
+			namespace: "dynatrace",
+		}
+
+		_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-namespace"}})
+		require.NoError(t, err)
+
+		var ns corev1.Namespace
+		require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "test-namespace"}, &ns))
+		return ns
+	}
+
+	t.Run(`matched namespaces are assigned to the DynaKube`, func(t *testing.T) {
+		c := fake.NewClient(
+			buildDynaKube("oneagent"),
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-namespace", Labels: map[string]string{"inject": "true"}}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "42"}},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "oneagent", Namespace: "dynatrace"},
+				Data:       map[string][]byte{"paasToken": []byte("42"), "apiToken": []byte("84")},
+			},
+		)
+
+		ns := reconcileNamespace(t, c)
+		assert.Equal(t, "oneagent", ns.Labels[webhook.LabelInstance])
+		assert.Equal(t, "true", ns.Annotations[webhook.AnnotationManagedInstance])
+
+		var nsSecret corev1.Secret
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: webhook.SecretConfigName, Namespace: "test-namespace"}, &nsSecret))
+
+		delete(ns.Labels, "inject")
+		require.NoError(t, c.Update(context.TODO(), &ns))
+
+		ns = reconcileNamespace(t, c)
+		assert.NotContains(t, ns.Labels, webhook.LabelInstance)
+		assert.NotContains(t, ns.Annotations, webhook.AnnotationManagedInstance)
+	})
+	t.Run(`conflicts are reported on the namespace`, func(t *testing.T) {
+		c := fake.NewClient(
+			buildDynaKube("oneagent"),
+			buildDynaKube("other"),
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-namespace", Labels: map[string]string{"inject": "true"}}},
+		)
+
+		ns := reconcileNamespace(t, c)
+		assert.NotContains(t, ns.Labels, webhook.LabelInstance)
+		assert.Equal(t, "namespace 'test-namespace' is matched by multiple DynaKubes: oneagent, other", ns.Annotations[webhook.AnnotationConflict])
+	})
 }
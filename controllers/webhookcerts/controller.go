@@ -5,8 +5,10 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sort"
 	"time"
 
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	"github.com/Dynatrace/dynatrace-operator/webhook"
 	"github.com/go-logr/logr"
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
@@ -14,6 +16,7 @@ import (
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -21,12 +24,19 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
 	webhookName = "dynatrace-webhook"
+
+	// defaultWebhookName is the name of the webhook for namespaces assigned to missing DynaKubes, the webhooks of the
+	// DynaKubes are prefixed with their name
+	defaultWebhookName = "webhook.dynatrace.com"
+
+	defaultTimeoutSeconds = 10
 )
 
 func Add(mgr manager.Manager, ns string) error {
@@ -50,6 +60,15 @@ func add(mgr manager.Manager, r *ReconcileWebhookCertificates) error {
 		return err
 	}
 
+	// The webhook configuration holds the failure policy and timeout of each DynaKube
+	err = c.Watch(&source.Kind{Type: &dynatracev1alpha1.DynaKube{}},
+		handler.EnqueueRequestsFromMapFunc(func(_ client.Object) []reconcile.Request {
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: webhookName, Namespace: r.namespace}}}
+		}), predicate.GenerationChangedPredicate{})
+	if err != nil {
+		return err
+	}
+
 	// Create artificial requests
 	go func() {
 		// Because of https://github.com/kubernetes-sigs/controller-runtime/issues/942, waiting
@@ -183,12 +202,36 @@ func (r *ReconcileWebhookCertificates) reconcileCerts(ctx context.Context, log l
 	return append(cs.Data["ca.crt"], cs.Data["ca.crt.old"]...), nil
 }
 
+// reconcileWebhookConfig creates a webhook for each DynaKube, which handles the namespaces assigned to it with the
+// failure policy and timeout of the DynaKube, and a default webhook for namespaces assigned to DynaKubes which don't exist
 func (r *ReconcileWebhookCertificates) reconcileWebhookConfig(ctx context.Context, log logr.Logger, rootCerts []byte) error {
 	log.Info("Reconciling MutatingWebhookConfiguration...")
 
-	path := "/inject"
-	scope := admissionregistrationv1.NamespacedScope
-	sideEffects := admissionregistrationv1.SideEffectClassNone
+	var dynakubes dynatracev1alpha1.DynaKubeList
+	if err := r.client.List(ctx, &dynakubes, client.InNamespace(r.namespace)); err != nil {
+		return err
+	}
+	sort.Slice(dynakubes.Items, func(i, j int) bool { return dynakubes.Items[i].Name < dynakubes.Items[j].Name })
+
+	var names []string
+	var webhooks []admissionregistrationv1.MutatingWebhook
+	for i := range dynakubes.Items {
+		dk := &dynakubes.Items[i]
+		names = append(names, dk.Name)
+		webhooks = append(webhooks, r.buildWebhook(dk.Name+"."+defaultWebhookName, metav1.LabelSelectorRequirement{
+			Key:      webhook.LabelInstance,
+			Operator: metav1.LabelSelectorOpIn,
+			Values:   []string{dk.Name},
+		}, &dk.Spec.CodeModules.Webhook, rootCerts))
+	}
+
+	// Pods of namespaces assigned to missing DynaKubes are rejected by the webhook
+	defaultSelector := metav1.LabelSelectorRequirement{Key: webhook.LabelInstance, Operator: metav1.LabelSelectorOpExists}
+	if len(names) > 0 {
+		defaultSelector = metav1.LabelSelectorRequirement{Key: webhook.LabelInstance, Operator: metav1.LabelSelectorOpNotIn, Values: names}
+	}
+	webhooks = append(webhooks, r.buildWebhook(defaultWebhookName, defaultSelector, &dynatracev1alpha1.WebhookSpec{}, rootCerts))
+
 	webhookConfiguration := &admissionregistrationv1.MutatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: webhookName,
@@ -197,34 +240,7 @@ func (r *ReconcileWebhookCertificates) reconcileWebhookConfig(ctx context.Contex
 				"internal.dynatrace.com/component": "webhook",
 			},
 		},
-		Webhooks: []admissionregistrationv1.MutatingWebhook{{
-			Name:                    "webhook.dynatrace.com",
-			AdmissionReviewVersions: []string{"v1"},
-			Rules: []admissionregistrationv1.RuleWithOperations{{
-				Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
-				Rule: admissionregistrationv1.Rule{
-					APIGroups:   []string{""},
-					APIVersions: []string{"v1"},
-					Resources:   []string{"pods"},
-					Scope:       &scope,
-				},
-			}},
-			NamespaceSelector: &metav1.LabelSelector{
-				MatchExpressions: []metav1.LabelSelectorRequirement{{
-					Key:      webhook.LabelInstance,
-					Operator: metav1.LabelSelectorOpExists,
-				}},
-			},
-			ClientConfig: admissionregistrationv1.WebhookClientConfig{
-				Service: &admissionregistrationv1.ServiceReference{
-					Name:      webhookName,
-					Namespace: r.namespace,
-					Path:      &path,
-				},
-				CABundle: rootCerts,
-			},
-			SideEffects: &sideEffects,
-		}},
+		Webhooks: webhooks,
 	}
 
 	var cfg admissionregistrationv1.MutatingWebhookConfiguration
@@ -242,7 +258,7 @@ func (r *ReconcileWebhookCertificates) reconcileWebhookConfig(ctx context.Contex
 		return err
 	}
 
-	if len(cfg.Webhooks) == 1 && bytes.Equal(cfg.Webhooks[0].ClientConfig.CABundle, rootCerts) {
+	if !isWebhookConfigOutdated(cfg.Webhooks, webhooks) {
 		return nil
 	}
 
@@ -250,3 +266,66 @@ func (r *ReconcileWebhookCertificates) reconcileWebhookConfig(ctx context.Contex
 	cfg.Webhooks = webhookConfiguration.Webhooks
 	return r.client.Update(ctx, &cfg)
 }
+
+func (r *ReconcileWebhookCertificates) buildWebhook(name string, selector metav1.LabelSelectorRequirement, spec *dynatracev1alpha1.WebhookSpec, rootCerts []byte) admissionregistrationv1.MutatingWebhook {
+	path := "/inject"
+	scope := admissionregistrationv1.NamespacedScope
+	sideEffects := admissionregistrationv1.SideEffectClassNoneOnDryRun
+
+	failurePolicy := admissionregistrationv1.Fail
+	if spec.FailurePolicy != "" {
+		failurePolicy = admissionregistrationv1.FailurePolicyType(spec.FailurePolicy)
+	}
+	timeout := int32(defaultTimeoutSeconds)
+	if spec.TimeoutSeconds != nil {
+		timeout = *spec.TimeoutSeconds
+	}
+
+	return admissionregistrationv1.MutatingWebhook{
+		Name:                    name,
+		AdmissionReviewVersions: []string{"v1"},
+		Rules: []admissionregistrationv1.RuleWithOperations{{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"pods"},
+				Scope:       &scope,
+			},
+		}},
+		NamespaceSelector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{selector},
+		},
+		ClientConfig: admissionregistrationv1.WebhookClientConfig{
+			Service: &admissionregistrationv1.ServiceReference{
+				Name:      webhookName,
+				Namespace: r.namespace,
+				Path:      &path,
+			},
+			CABundle: rootCerts,
+		},
+		SideEffects:    &sideEffects,
+		FailurePolicy:  &failurePolicy,
+		TimeoutSeconds: &timeout,
+	}
+}
+
+// isWebhookConfigOutdated compares the fields set by the Operator, since the Kubernetes API server sets defaults for
+// the others
+func isWebhookConfigOutdated(current []admissionregistrationv1.MutatingWebhook, desired []admissionregistrationv1.MutatingWebhook) bool {
+	if len(current) != len(desired) {
+		return true
+	}
+
+	for i := range desired {
+		c, d := &current[i], &desired[i]
+		if c.Name != d.Name ||
+			!bytes.Equal(c.ClientConfig.CABundle, d.ClientConfig.CABundle) ||
+			!reflect.DeepEqual(c.NamespaceSelector, d.NamespaceSelector) ||
+			!reflect.DeepEqual(c.FailurePolicy, d.FailurePolicy) ||
+			!reflect.DeepEqual(c.TimeoutSeconds, d.TimeoutSeconds) {
+			return true
+		}
+	}
+	return false
+}
@@ -7,12 +7,14 @@ import (
 	"testing"
 	"time"
 
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
 	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
 	"github.com/Dynatrace/dynatrace-operator/webhook"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -106,3 +108,53 @@ func TestReconcileWebhookCertificates(t *testing.T) {
 	assert.Equal(t, secret400, secret401)
 	assert.Equal(t, secret401["ca.crt"]+secret401["ca.crt.old"], getWebhookCA())
 }
+
+func TestReconcileWebhookConfig(t *testing.T) {
+	logger := zap.New(zap.UseDevMode(true), zap.WriteTo(os.Stdout))
+	ns := "dynatrace"
+	timeout := int32(5)
+
+	c := fake.NewClient(
+		&dynatracev1alpha1.DynaKube{
+			ObjectMeta: metav1.ObjectMeta{Name: "b-dynakube", Namespace: ns},
+			Spec: dynatracev1alpha1.DynaKubeSpec{
+				CodeModules: dynatracev1alpha1.CodeModulesSpec{
+					Webhook: dynatracev1alpha1.WebhookSpec{FailurePolicy: "Ignore", TimeoutSeconds: &timeout},
+				},
+			},
+		},
+		&dynatracev1alpha1.DynaKube{ObjectMeta: metav1.ObjectMeta{Name: "a-dynakube", Namespace: ns}},
+	)
+	r := ReconcileWebhookCertificates{client: c, logger: logger, namespace: ns, scheme: scheme.Scheme}
+
+	require.NoError(t, r.reconcileWebhookConfig(context.TODO(), logger, []byte("ca")))
+
+	var webhookCfg admissionregistrationv1.MutatingWebhookConfiguration
+	require.NoError(t, c.Get(context.TODO(), types.NamespacedName{Name: webhook.ServiceName}, &webhookCfg))
+	require.Len(t, webhookCfg.Webhooks, 3)
+
+	a, b, fallback := webhookCfg.Webhooks[0], webhookCfg.Webhooks[1], webhookCfg.Webhooks[2]
+	assert.Equal(t, "a-dynakube.webhook.dynatrace.com", a.Name)
+	assert.Equal(t, admissionregistrationv1.Fail, *a.FailurePolicy)
+	assert.Equal(t, int32(10), *a.TimeoutSeconds)
+	assert.Equal(t, admissionregistrationv1.SideEffectClassNoneOnDryRun, *a.SideEffects)
+	assert.Equal(t, []string{"a-dynakube"}, a.NamespaceSelector.MatchExpressions[0].Values)
+
+	assert.Equal(t, "b-dynakube.webhook.dynatrace.com", b.Name)
+	assert.Equal(t, admissionregistrationv1.Ignore, *b.FailurePolicy)
+	assert.Equal(t, timeout, *b.TimeoutSeconds)
+
+	assert.Equal(t, "webhook.dynatrace.com", fallback.Name)
+	assert.Equal(t, metav1.LabelSelectorOpNotIn, fallback.NamespaceSelector.MatchExpressions[0].Operator)
+	assert.Equal(t, []string{"a-dynakube", "b-dynakube"}, fallback.NamespaceSelector.MatchExpressions[0].Values)
+
+	// Changes of the DynaKubes update the configuration
+	var dk dynatracev1alpha1.DynaKube
+	require.NoError(t, c.Get(context.TODO(), types.NamespacedName{Name: "a-dynakube", Namespace: ns}, &dk))
+	dk.Spec.CodeModules.Webhook.FailurePolicy = "Ignore"
+	require.NoError(t, c.Update(context.TODO(), &dk))
+
+	require.NoError(t, r.reconcileWebhookConfig(context.TODO(), logger, []byte("ca")))
+	require.NoError(t, c.Get(context.TODO(), types.NamespacedName{Name: webhook.ServiceName}, &webhookCfg))
+	assert.Equal(t, admissionregistrationv1.Ignore, *webhookCfg.Webhooks[0].FailurePolicy)
+}
@@ -0,0 +1,318 @@
+package downloadcache
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+const (
+	// Port is the port the download cache is listening on
+	Port = 8080
+	// PackagePath is the path of the endpoint serving the OneAgent packages
+	PackagePath = "/oneagent"
+	// HealthPath is the path of the endpoint used by the probes of the download cache
+	HealthPath = "/healthz"
+
+	// FlavorParam, ArchParam and TechnologiesParam are the query parameters selecting the OneAgent package
+	FlavorParam       = "flavor"
+	ArchParam         = "arch"
+	TechnologiesParam = "technologies"
+
+	// CacheDir is the directory the OneAgent packages are stored in
+	CacheDir = "/var/cache/dynatrace"
+
+	defaultVersionTTL = 5 * time.Minute
+)
+
+// Cache serves the latest OneAgent package inside the cluster. Each package is only downloaded once from the tenant per
+// version, flavor, architecture and set of technologies, packages of outdated versions are removed once no request
+// is downloading or serving them anymore.
+type Cache struct {
+	fs     afero.Fs
+	dtc    dtclient.Client
+	logger logr.Logger
+	dir    string
+	now    func() time.Time
+
+	versionTTL     time.Duration
+	versionMu      sync.Mutex
+	version        string
+	versionFetched time.Time
+
+	// packagesMu guards packages and inUse, which counts the requests per version that are downloading or serving
+	// one of its packages
+	packagesMu sync.Mutex
+	packages   map[string]*sync.Mutex
+	inUse      map[string]int
+}
+
+func NewCache(fs afero.Fs, dtc dtclient.Client, dir string, logger logr.Logger) *Cache {
+	return &Cache{
+		fs:         fs,
+		dtc:        dtc,
+		logger:     logger,
+		dir:        dir,
+		now:        time.Now,
+		versionTTL: defaultVersionTTL,
+		packages:   map[string]*sync.Mutex{},
+		inUse:      map[string]int{},
+	}
+}
+
+// Handler returns the handler serving the package and health endpoints
+func (cache *Cache) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PackagePath, cache.servePackage)
+	mux.HandleFunc(HealthPath, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+func (cache *Cache) servePackage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	flavor := getParam(query.Get(FlavorParam), dtclient.FlavorMultidistro)
+	arch := getParam(query.Get(ArchParam), dtclient.ArchX86)
+	technologies := parseTechnologies(query.Get(TechnologiesParam))
+
+	if !dtcsi.IsValidFlavor(flavor) {
+		http.Error(w, fmt.Sprintf("invalid flavor '%s'", flavor), http.StatusBadRequest)
+		return
+	}
+	if arch != dtclient.ArchX86 && arch != dtclient.ArchARM {
+		http.Error(w, fmt.Sprintf("invalid arch '%s'", arch), http.StatusBadRequest)
+		return
+	}
+
+	path, release, err := cache.getPackage(flavor, arch, technologies)
+	if err != nil {
+		cache.logger.Error(err, "failed to provide OneAgent package", "flavor", flavor, "arch", arch, "technologies", technologies)
+		http.Error(w, "failed to download OneAgent package", http.StatusBadGateway)
+		return
+	}
+	defer release()
+
+	f, err := cache.fs.Open(path)
+	if err != nil {
+		cache.logger.Error(err, "failed to open OneAgent package", "path", path)
+		http.Error(w, "failed to open OneAgent package", http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "failed to open OneAgent package", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), f)
+}
+
+// getPackage returns the path of the requested package of the latest version, which is downloaded if it isn't cached yet.
+// Concurrent requests for the same package wait for the first download instead of downloading it again.
+// The version of the package isn't removed until the returned release function is called.
+func (cache *Cache) getPackage(flavor, arch string, technologies []string) (string, func(), error) {
+	version, err := cache.getLatestVersion()
+	if err != nil {
+		return "", nil, err
+	}
+
+	release := cache.acquireVersion(version)
+	path, err := cache.getPackageOfVersion(version, flavor, arch, technologies)
+	if err != nil {
+		release()
+		return "", nil, err
+	}
+	return path, release, nil
+}
+
+func (cache *Cache) getPackageOfVersion(version, flavor, arch string, technologies []string) (string, error) {
+	path := filepath.Join(cache.dir, version, packageName(flavor, arch, technologies))
+
+	lock := cache.lockPackage(path)
+	defer lock.Unlock()
+
+	if exists, err := afero.Exists(cache.fs, path); err != nil || exists {
+		return path, errors.WithStack(err)
+	}
+
+	if err := cache.download(path, flavor, arch, technologies); err != nil {
+		return "", err
+	}
+
+	cache.removeOutdatedVersions(version)
+	return path, nil
+}
+
+// acquireVersion keeps the packages of the version from being removed until the returned function is called.
+// Releasing the last request of an outdated version removes its packages.
+func (cache *Cache) acquireVersion(version string) func() {
+	cache.packagesMu.Lock()
+	cache.inUse[version]++
+	cache.packagesMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cache.packagesMu.Lock()
+			cache.inUse[version]--
+			unused := cache.inUse[version] == 0
+			if unused {
+				delete(cache.inUse, version)
+			}
+			cache.packagesMu.Unlock()
+
+			if latest := cache.getCachedVersion(); unused && latest != "" && latest != version {
+				cache.removeOutdatedVersions(latest)
+			}
+		})
+	}
+}
+
+func (cache *Cache) download(path string, flavor, arch string, technologies []string) error {
+	if err := cache.fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	tmp, err := afero.TempFile(cache.fs, filepath.Dir(path), "tmp.")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	cache.logger.Info("downloading OneAgent package", "path", path)
+	err = cache.dtc.GetLatestAgentForTechnologies(dtclient.OsUnix, dtclient.InstallerTypePaaS, flavor, arch, technologies, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	// Renaming makes sure that incomplete downloads are never served
+	if err == nil {
+		err = cache.fs.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = cache.fs.Remove(tmp.Name())
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// getLatestVersion returns the latest OneAgent version, which is queried again once it's older than the version TTL.
+// The package is downloaded as latest package, so a package released between both requests is stored under the previous
+// version until the version is queried again.
+func (cache *Cache) getLatestVersion() (string, error) {
+	cache.versionMu.Lock()
+	defer cache.versionMu.Unlock()
+
+	if cache.version != "" && cache.now().Sub(cache.versionFetched) < cache.versionTTL {
+		return cache.version, nil
+	}
+
+	version, err := cache.dtc.GetLatestAgentVersion(dtclient.OsUnix, dtclient.InstallerTypePaaS)
+	if err != nil {
+		if cache.version != "" {
+			cache.logger.Error(err, "failed to query latest OneAgent version, using cached version", "version", cache.version)
+			return cache.version, nil
+		}
+		return "", errors.WithStack(err)
+	}
+
+	cache.version = version
+	cache.versionFetched = cache.now()
+	return version, nil
+}
+
+func (cache *Cache) getCachedVersion() string {
+	cache.versionMu.Lock()
+	defer cache.versionMu.Unlock()
+	return cache.version
+}
+
+func (cache *Cache) lockPackage(path string) *sync.Mutex {
+	cache.packagesMu.Lock()
+	lock, ok := cache.packages[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		cache.packages[path] = lock
+	}
+	cache.packagesMu.Unlock()
+
+	lock.Lock()
+	return lock
+}
+
+// removeOutdatedVersions removes the packages of all versions but the latest one. Versions still used by a request are
+// skipped, they are removed once their last request is released.
+func (cache *Cache) removeOutdatedVersions(latest string) {
+	cache.packagesMu.Lock()
+	defer cache.packagesMu.Unlock()
+
+	versions, err := afero.ReadDir(cache.fs, cache.dir)
+	if err != nil {
+		cache.logger.Error(err, "failed to list cached versions")
+		return
+	}
+
+	for _, version := range versions {
+		if !version.IsDir() || version.Name() == latest || cache.inUse[version.Name()] > 0 {
+			continue
+		}
+
+		dir := filepath.Join(cache.dir, version.Name())
+		cache.logger.Info("removing outdated OneAgent packages", "version", version.Name())
+		if err := cache.fs.RemoveAll(dir); err != nil {
+			cache.logger.Error(err, "failed to remove outdated OneAgent packages", "version", version.Name())
+			continue
+		}
+		for path := range cache.packages {
+			if filepath.Dir(path) == dir {
+				delete(cache.packages, path)
+			}
+		}
+	}
+}
+
+func packageName(flavor, arch string, technologies []string) string {
+	name := fmt.Sprintf("%s-%s", flavor, arch)
+	if len(technologies) > 0 {
+		hash := sha256.Sum256([]byte(strings.Join(technologies, ",")))
+		name += fmt.Sprintf("-%x", hash[:8])
+	}
+	return name + ".zip"
+}
+
+// parseTechnologies returns the sorted list of technologies, an empty list stands for all technologies
+func parseTechnologies(value string) []string {
+	var technologies []string
+	for _, technology := range strings.Split(value, ",") {
+		if technology = strings.TrimSpace(technology); technology != "" && technology != "all" {
+			technologies = append(technologies, technology)
+		}
+	}
+	sort.Strings(technologies)
+	return technologies
+}
+
+func getParam(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,142 @@
+package downloadcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/Dynatrace/dynatrace-operator/logger"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testDir = "/cache"
+
+func buildTestCache(dtc dtclient.Client) *Cache {
+	return NewCache(afero.NewMemMapFs(), dtc, testDir, logger.NewDTLogger())
+}
+
+func mockDownload(dtc *dtclient.MockDynatraceClient, content string) *mock.Call {
+	return dtc.On("GetLatestAgentForTechnologies", dtclient.OsUnix, dtclient.InstallerTypePaaS, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			_, _ = args.Get(5).(io.Writer).Write([]byte(content))
+		}).
+		Return(nil)
+}
+
+func request(cache *Cache, url string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	cache.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+	return recorder
+}
+
+func TestCache(t *testing.T) {
+	t.Run(`download package only once`, func(t *testing.T) {
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("GetLatestAgentVersion", dtclient.OsUnix, dtclient.InstallerTypePaaS).Return("1.2.3", nil)
+		mockDownload(dtc, "package").Once()
+		cache := buildTestCache(dtc)
+
+		for i := 0; i < 3; i++ {
+			response := request(cache, PackagePath+"?flavor=musl&arch=x86&technologies=nginx,java")
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, "package", response.Body.String())
+		}
+
+		dtc.AssertNumberOfCalls(t, "GetLatestAgentForTechnologies", 1)
+		dtc.AssertCalled(t, "GetLatestAgentForTechnologies", dtclient.OsUnix, dtclient.InstallerTypePaaS,
+			dtclient.FlavorMUSL, dtclient.ArchX86, []string{"java", "nginx"}, mock.Anything)
+		dtc.AssertNumberOfCalls(t, "GetLatestAgentVersion", 1)
+	})
+	t.Run(`download packages separately`, func(t *testing.T) {
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("GetLatestAgentVersion", dtclient.OsUnix, dtclient.InstallerTypePaaS).Return("1.2.3", nil)
+		mockDownload(dtc, "package")
+		cache := buildTestCache(dtc)
+
+		assert.Equal(t, http.StatusOK, request(cache, PackagePath).Code)
+		assert.Equal(t, http.StatusOK, request(cache, PackagePath+"?technologies=all").Code)
+		assert.Equal(t, http.StatusOK, request(cache, PackagePath+"?technologies=java").Code)
+		assert.Equal(t, http.StatusOK, request(cache, PackagePath+"?flavor=default").Code)
+
+		dtc.AssertNumberOfCalls(t, "GetLatestAgentForTechnologies", 3)
+	})
+	t.Run(`remove outdated versions`, func(t *testing.T) {
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("GetLatestAgentVersion", dtclient.OsUnix, dtclient.InstallerTypePaaS).Return("1.2.3", nil).Once()
+		dtc.On("GetLatestAgentVersion", dtclient.OsUnix, dtclient.InstallerTypePaaS).Return("1.2.4", nil)
+		mockDownload(dtc, "package")
+		cache := buildTestCache(dtc)
+		now := time.Now()
+		cache.now = func() time.Time { return now }
+
+		assert.Equal(t, http.StatusOK, request(cache, PackagePath).Code)
+		now = now.Add(defaultVersionTTL)
+		assert.Equal(t, http.StatusOK, request(cache, PackagePath).Code)
+
+		exists, err := afero.DirExists(cache.fs, filepath.Join(testDir, "1.2.3"))
+		require.NoError(t, err)
+		assert.False(t, exists)
+		exists, err = afero.Exists(cache.fs, filepath.Join(testDir, "1.2.4", "multidistro-x86.zip"))
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+	t.Run(`keep outdated version while it is in use`, func(t *testing.T) {
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("GetLatestAgentVersion", dtclient.OsUnix, dtclient.InstallerTypePaaS).Return("1.2.3", nil).Once()
+		dtc.On("GetLatestAgentVersion", dtclient.OsUnix, dtclient.InstallerTypePaaS).Return("1.2.4", nil)
+		mockDownload(dtc, "package")
+		cache := buildTestCache(dtc)
+		now := time.Now()
+		cache.now = func() time.Time { return now }
+
+		outdatedPath, release, err := cache.getPackage(dtclient.FlavorMultidistro, dtclient.ArchX86, nil)
+		require.NoError(t, err)
+		now = now.Add(defaultVersionTTL)
+		assert.Equal(t, http.StatusOK, request(cache, PackagePath).Code)
+
+		exists, err := afero.Exists(cache.fs, outdatedPath)
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		release()
+
+		exists, err = afero.DirExists(cache.fs, filepath.Join(testDir, "1.2.3"))
+		require.NoError(t, err)
+		assert.False(t, exists)
+		exists, err = afero.Exists(cache.fs, filepath.Join(testDir, "1.2.4", "multidistro-x86.zip"))
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+	t.Run(`failed download isn't cached`, func(t *testing.T) {
+		dtc := &dtclient.MockDynatraceClient{}
+		dtc.On("GetLatestAgentVersion", dtclient.OsUnix, dtclient.InstallerTypePaaS).Return("1.2.3", nil)
+		dtc.On("GetLatestAgentForTechnologies", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(errors.New("unavailable")).Once()
+		mockDownload(dtc, "package")
+		cache := buildTestCache(dtc)
+
+		assert.Equal(t, http.StatusBadGateway, request(cache, PackagePath).Code)
+		response := request(cache, PackagePath)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "package", response.Body.String())
+	})
+	t.Run(`invalid parameters`, func(t *testing.T) {
+		cache := buildTestCache(&dtclient.MockDynatraceClient{})
+
+		assert.Equal(t, http.StatusBadRequest, request(cache, PackagePath+"?flavor=unknown").Code)
+		assert.Equal(t, http.StatusBadRequest, request(cache, PackagePath+"?arch=s390").Code)
+	})
+	t.Run(`health`, func(t *testing.T) {
+		cache := buildTestCache(&dtclient.MockDynatraceClient{})
+
+		assert.Equal(t, http.StatusOK, request(cache, HealthPath).Code)
+	})
+}
@@ -0,0 +1,113 @@
+package dtclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Known ActiveGate module types.
+const (
+	ActiveGateModuleKubernetes      = "KUBERNETES"
+	ActiveGateModuleOneAgentRouting = "ONE_AGENT_ROUTING"
+	ActiveGateModuleMetricAPI       = "METRIC_API"
+)
+
+// ActiveGate holds the information the tenant has about a registered ActiveGate
+type ActiveGate struct {
+	ID            string             `json:"id"`
+	Hostname      string             `json:"hostname"`
+	Version       string             `json:"version"`
+	NetworkZone   string             `json:"networkZone"`
+	Group         string             `json:"group"`
+	Containerized bool               `json:"containerized"`
+	OfflineSince  int64              `json:"offlineSince"`
+	Modules       []ActiveGateModule `json:"modules"`
+	IDSeed        *ActiveGateIDSeed  `json:"idSeed,omitempty"`
+}
+
+// ActiveGateIDSeed holds the seeds a containerized ActiveGate generated its id from. The seeds are not part of the
+// documented response of the ActiveGate API and are only used to tell ActiveGates apart if the tenant reports them.
+type ActiveGateIDSeed struct {
+	Namespace         string `json:"namespace"`
+	KubernetesCluster string `json:"k8sClusterId"`
+}
+
+type ActiveGateModule struct {
+	Type          string `json:"type"`
+	Enabled       bool   `json:"enabled"`
+	Misconfigured bool   `json:"misconfigured"`
+	Version       string `json:"version"`
+}
+
+// ActiveGateQuery restricts the ActiveGates returned by GetActiveGates, empty fields are ignored
+type ActiveGateQuery struct {
+	Hostname    string
+	NetworkZone string
+}
+
+// IsConnected returns true if the tenant currently has a connection to the ActiveGate
+func (activeGate *ActiveGate) IsConnected() bool {
+	return activeGate.OfflineSince == 0
+}
+
+// HasModule returns true if the module of the given type is enabled and configured correctly
+func (activeGate *ActiveGate) HasModule(moduleType string) bool {
+	for _, module := range activeGate.Modules {
+		if module.Type == moduleType {
+			return module.Enabled && !module.Misconfigured
+		}
+	}
+	return false
+}
+
+func (dtc *dynatraceClient) GetActiveGates(query ActiveGateQuery) ([]ActiveGate, error) {
+	response, err := dtc.makeRequest(dtc.buildActiveGatesURL(query), dynatraceApiToken)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		//Swallow error, nothing has to be done at this point
+		_ = response.Body.Close()
+	}()
+
+	data, err := dtc.getServerResponseData(response)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return dtc.readResponseForActiveGates(data)
+}
+
+func (dtc *dynatraceClient) buildActiveGatesURL(query ActiveGateQuery) string {
+	params := url.Values{}
+	if query.Hostname != "" {
+		params.Add("hostname", query.Hostname)
+	}
+	if query.NetworkZone != "" {
+		params.Add("networkZone", query.NetworkZone)
+	}
+
+	activeGatesURL := fmt.Sprintf("%s/v2/activeGates", dtc.url)
+	if len(params) > 0 {
+		activeGatesURL += "?" + params.Encode()
+	}
+	return activeGatesURL
+}
+
+func (dtc *dynatraceClient) readResponseForActiveGates(response []byte) ([]ActiveGate, error) {
+	type jsonResponse struct {
+		ActiveGates []ActiveGate `json:"activeGates"`
+	}
+
+	jr := &jsonResponse{}
+	err := json.Unmarshal(response, jr)
+	if err != nil {
+		dtc.logger.Error(err, "error unmarshalling json response")
+		return nil, errors.WithStack(err)
+	}
+
+	return jr.ActiveGates, nil
+}
@@ -0,0 +1,100 @@
+package dtclient
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	activeGatesEndpoint = "/v2/activeGates"
+
+	activeGatesResponse = `{
+  "activeGates": [
+    {
+      "id": "-1234",
+      "hostname": "dynakube-routing-0",
+      "version": "1.213.0.20210324-052430",
+      "networkZone": "zone",
+      "group": "group",
+      "containerized": true,
+      "idSeed": {"namespace": "dynatrace", "k8sClusterId": "cluster-uid"},
+      "modules": [
+        {"type": "ONE_AGENT_ROUTING", "enabled": true, "misconfigured": false, "version": "1.213.0"},
+        {"type": "METRIC_API", "enabled": true, "misconfigured": true, "version": "1.213.0"},
+        {"type": "KUBERNETES", "enabled": false, "misconfigured": false, "version": "1.213.0"}
+      ]
+    },
+    {
+      "id": "-5678",
+      "hostname": "dynakube-routing-1",
+      "offlineSince": 1616581246345
+    }
+  ]
+}`
+)
+
+func TestGetActiveGates(t *testing.T) {
+	t.Run(`GetActiveGates`, func(t *testing.T) {
+		dynatraceServer, dynatraceClient := createTestDynatraceClient(t, activeGatesServerHandler())
+		defer dynatraceServer.Close()
+
+		activeGates, err := dynatraceClient.GetActiveGates(ActiveGateQuery{})
+		require.NoError(t, err)
+		require.Len(t, activeGates, 2)
+
+		activeGate := activeGates[0]
+		assert.Equal(t, "-1234", activeGate.ID)
+		assert.Equal(t, "dynakube-routing-0", activeGate.Hostname)
+		assert.Equal(t, "1.213.0.20210324-052430", activeGate.Version)
+		assert.Equal(t, "zone", activeGate.NetworkZone)
+		assert.Equal(t, "group", activeGate.Group)
+		assert.True(t, activeGate.Containerized)
+		assert.Equal(t, &ActiveGateIDSeed{Namespace: "dynatrace", KubernetesCluster: "cluster-uid"}, activeGate.IDSeed)
+		assert.Nil(t, activeGates[1].IDSeed)
+		assert.True(t, activeGate.IsConnected())
+		assert.True(t, activeGate.HasModule(ActiveGateModuleOneAgentRouting))
+		assert.False(t, activeGate.HasModule(ActiveGateModuleMetricAPI))
+		assert.False(t, activeGate.HasModule(ActiveGateModuleKubernetes))
+
+		assert.False(t, activeGates[1].IsConnected())
+	})
+	t.Run(`GetActiveGates passes query`, func(t *testing.T) {
+		dynatraceServer, dynatraceClient := createTestDynatraceClient(t, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if request.URL.Query().Get("hostname") != "dynakube-routing-1" || request.URL.Query().Get("networkZone") != "zone" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			activeGatesServerHandler()(writer, request)
+		}))
+		defer dynatraceServer.Close()
+
+		activeGates, err := dynatraceClient.GetActiveGates(ActiveGateQuery{Hostname: "dynakube-routing-1", NetworkZone: "zone"})
+		assert.NoError(t, err)
+		assert.Len(t, activeGates, 2)
+	})
+	t.Run(`GetActiveGates handle api error`, func(t *testing.T) {
+		dynatraceServer, dynatraceClient := createTestDynatraceClient(t, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.WriteHeader(http.StatusForbidden)
+			_, _ = writer.Write([]byte(`{"error": {"code": 403, "message": "Token is missing required scope"}}`))
+		}))
+		defer dynatraceServer.Close()
+
+		activeGates, err := dynatraceClient.GetActiveGates(ActiveGateQuery{})
+		assert.Error(t, err)
+		assert.Nil(t, activeGates)
+	})
+}
+
+func activeGatesServerHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == activeGatesEndpoint && request.Header.Get("Authorization") == "Api-Token "+apiToken {
+			writer.Header().Add("Content-Type", "application/json")
+			_, _ = writer.Write([]byte(activeGatesResponse))
+		} else {
+			writer.WriteHeader(http.StatusBadRequest)
+		}
+	}
+}
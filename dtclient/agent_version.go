@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	neturl "net/url"
 )
 
 // GetVersionForLatest gets the latest agent version for the given OS and installer type.
@@ -70,19 +72,96 @@ func (dtc *dynatraceClient) readResponseForLatestVersion(response []byte) (strin
 
 // GetVersionForLatest gets the latest agent package for the given OS and installer type.
 func (dtc *dynatraceClient) GetLatestAgent(os, installerType, flavor, arch string, writer io.Writer) error {
+	return dtc.GetLatestAgentForTechnologies(os, installerType, flavor, arch, nil, writer)
+}
+
+func (dtc *dynatraceClient) GetLatestAgentForTechnologies(os, installerType, flavor, arch string, technologies []string, writer io.Writer) error {
 	if len(os) == 0 || len(installerType) == 0 {
 		return errors.New("os or installerType is empty")
 	}
 
 	url := fmt.Sprintf("%s/v1/deployment/installer/agent/%s/%s/latest?bitness=64&flavor=%s&arch=%s",
 		dtc.url, os, installerType, flavor, arch)
+	for _, technology := range technologies {
+		url += "&include=" + neturl.QueryEscape(technology)
+	}
+
+	resp, err := dtc.makeRequest(url, dynatracePaaSToken)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		_, err = dtc.getServerResponseData(resp)
+		return err
+	}
 
+	_, err = io.Copy(writer, resp.Body)
+	return err
+}
+
+// GetAgent writes the agent package of the given version for the given OS and installer type to the writer.
+func (dtc *dynatraceClient) GetAgent(os, installerType, flavor, arch, version string, writer io.Writer) error {
+	if len(os) == 0 || len(installerType) == 0 || len(version) == 0 {
+		return errors.New("os, installerType or version is empty")
+	}
+
+	url := fmt.Sprintf("%s/v1/deployment/installer/agent/%s/%s/version/%s?bitness=64&flavor=%s&arch=%s",
+		dtc.url, os, installerType, neturl.PathEscape(version), flavor, arch)
 	resp, err := dtc.makeRequest(url, dynatracePaaSToken)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	if resp.StatusCode != http.StatusOK {
+		_, err = dtc.getServerResponseData(resp)
+		return err
+	}
+
 	_, err = io.Copy(writer, resp.Body)
 	return err
 }
+
+// GetAgentChecksum gets the SHA-256 checksum of the agent package of the given version, which is empty if the server
+// doesn't provide checksums.
+func (dtc *dynatraceClient) GetAgentChecksum(os, installerType, flavor, arch, version string) (string, error) {
+	if len(os) == 0 || len(installerType) == 0 || len(version) == 0 {
+		return "", errors.New("os, installerType or version is empty")
+	}
+
+	url := fmt.Sprintf("%s/v1/deployment/installer/agent/%s/%s/version/%s/checksum?bitness=64&flavor=%s&arch=%s",
+		dtc.url, os, installerType, neturl.PathEscape(version), flavor, arch)
+	resp, err := dtc.makeRequest(url, dynatracePaaSToken)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+
+	responseData, err := dtc.getServerResponseData(resp)
+	if err != nil {
+		return "", err
+	}
+
+	return dtc.readResponseForChecksum(responseData)
+}
+
+// readResponseForChecksum reads the checksum from the given server response
+func (dtc *dynatraceClient) readResponseForChecksum(response []byte) (string, error) {
+	type jsonResponse struct {
+		Sha256 string
+	}
+
+	jr := &jsonResponse{}
+	if err := json.Unmarshal(response, jr); err != nil {
+		dtc.logger.Error(err, "error unmarshalling json response")
+		return "", err
+	}
+
+	return jr.Sha256, nil
+}
@@ -1,9 +1,11 @@
 package dtclient
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -186,4 +188,107 @@ func handleLatestAgentVersion(request *http.Request, writer http.ResponseWriter)
 	}
 }
 
-//TODO test GetLatestAgent
+func TestGetLatestAgentForTechnologies(t *testing.T) {
+	t.Run(`download agent package with technologies`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			assert.Equal(t, "/v1/deployment/installer/agent/unix/paas/latest", request.URL.Path)
+			assert.Equal(t, []string{"java", "nginx"}, request.URL.Query()["include"])
+			assert.Equal(t, FlavorMUSL, request.URL.Query().Get("flavor"))
+			assert.Equal(t, "Api-Token "+paasToken, request.Header.Get("Authorization"))
+			_, _ = writer.Write([]byte("package"))
+		}))
+		defer server.Close()
+
+		dtc, err := NewClient(server.URL, "", paasToken)
+		require.NoError(t, err)
+
+		var buffer bytes.Buffer
+		err = dtc.GetLatestAgentForTechnologies(OsUnix, InstallerTypePaaS, FlavorMUSL, ArchX86, []string{"java", "nginx"}, &buffer)
+		assert.NoError(t, err)
+		assert.Equal(t, "package", buffer.String())
+	})
+	t.Run(`error response is not written`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			writeError(writer, http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		dtc, err := NewClient(server.URL, "", paasToken)
+		require.NoError(t, err)
+
+		var buffer bytes.Buffer
+		err = dtc.GetLatestAgent(OsUnix, InstallerTypePaaS, FlavorMultidistro, ArchX86, &buffer)
+		assert.Error(t, err)
+		assert.Empty(t, buffer.String())
+	})
+}
+
+func TestGetAgent(t *testing.T) {
+	t.Run(`download agent package of version`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			assert.Equal(t, "/v1/deployment/installer/agent/unix/paas/version/1.200.0", request.URL.Path)
+			assert.Equal(t, FlavorMUSL, request.URL.Query().Get("flavor"))
+			_, _ = writer.Write([]byte("package"))
+		}))
+		defer server.Close()
+
+		dtc, err := NewClient(server.URL, "", paasToken)
+		require.NoError(t, err)
+
+		var buffer bytes.Buffer
+		err = dtc.GetAgent(OsUnix, InstallerTypePaaS, FlavorMUSL, ArchX86, "1.200.0", &buffer)
+		assert.NoError(t, err)
+		assert.Equal(t, "package", buffer.String())
+	})
+	t.Run(`version is required`, func(t *testing.T) {
+		dtc, err := NewClient("https://test", "", paasToken)
+		require.NoError(t, err)
+
+		var buffer bytes.Buffer
+		assert.Error(t, dtc.GetAgent(OsUnix, InstallerTypePaaS, FlavorMUSL, ArchX86, "", &buffer))
+	})
+}
+
+func TestGetAgentChecksum(t *testing.T) {
+	t.Run(`read checksum`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			assert.Equal(t, "/v1/deployment/installer/agent/unix/paas/version/1.200.0/checksum", request.URL.Path)
+			assert.Equal(t, FlavorMUSL, request.URL.Query().Get("flavor"))
+			assert.Equal(t, ArchX86, request.URL.Query().Get("arch"))
+			_, _ = writer.Write([]byte(`{"sha256": "abc123"}`))
+		}))
+		defer server.Close()
+
+		dtc, err := NewClient(server.URL, "", paasToken)
+		require.NoError(t, err)
+
+		checksum, err := dtc.GetAgentChecksum(OsUnix, InstallerTypePaaS, FlavorMUSL, ArchX86, "1.200.0")
+		assert.NoError(t, err)
+		assert.Equal(t, "abc123", checksum)
+	})
+	t.Run(`no checksum provided`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			writeError(writer, http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		dtc, err := NewClient(server.URL, "", paasToken)
+		require.NoError(t, err)
+
+		checksum, err := dtc.GetAgentChecksum(OsUnix, InstallerTypePaaS, FlavorMultidistro, ArchX86, "1.200.0")
+		assert.NoError(t, err)
+		assert.Empty(t, checksum)
+	})
+	t.Run(`error response`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			writeError(writer, http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		dtc, err := NewClient(server.URL, "", paasToken)
+		require.NoError(t, err)
+
+		_, err = dtc.GetAgentChecksum(OsUnix, InstallerTypePaaS, FlavorMultidistro, ArchX86, "1.200.0")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,92 @@
+package dtclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Token is a token created through the API of the tenant
+type Token struct {
+	ID             string    `json:"id"`
+	Token          string    `json:"token"`
+	ExpirationDate time.Time `json:"expirationDate"`
+}
+
+func (dtc *dynatraceClient) CreateToken(name string, scopes []string, expiration time.Time) (*Token, error) {
+	model := struct {
+		Name           string   `json:"name"`
+		Scopes         []string `json:"scopes"`
+		ExpirationDate string   `json:"expirationDate"`
+	}{
+		Name:           name,
+		Scopes:         scopes,
+		ExpirationDate: expiration.UTC().Format(time.RFC3339),
+	}
+
+	jsonStr, err := json.Marshal(model)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v2/apiTokens", dtc.url), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return nil, fmt.Errorf("error initializing http request: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Api-Token %s", dtc.apiToken))
+
+	resp, err := dtc.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making post request to dynatrace api: %w", err)
+	}
+	defer func() {
+		//Swallow error, nothing has to be done at this point
+		_ = resp.Body.Close()
+	}()
+
+	data, err := dtc.getServerResponseData(resp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("error unmarshalling json response: %w", err)
+	}
+	if token.Token == "" {
+		return nil, errors.New("token missing in response")
+	}
+
+	return &token, nil
+}
+
+func (dtc *dynatraceClient) RevokeToken(id string) error {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/v2/apiTokens/%s", dtc.url, url.PathEscape(id)), nil)
+	if err != nil {
+		return fmt.Errorf("error initializing http request: %w", err)
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Api-Token %s", dtc.apiToken))
+
+	resp, err := dtc.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making delete request to dynatrace api: %w", err)
+	}
+	defer func() {
+		//Swallow error, nothing has to be done at this point
+		_ = resp.Body.Close()
+	}()
+
+	// Tokens which don't exist anymore don't have to be revoked
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	_, err = dtc.getServerResponseData(resp)
+	return errors.WithStack(err)
+}
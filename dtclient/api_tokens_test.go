@@ -0,0 +1,95 @@
+package dtclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateToken(t *testing.T) {
+	expiration := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run(`create token`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			assert.Equal(t, "POST", request.Method)
+			assert.Equal(t, "/v2/apiTokens", request.URL.Path)
+			assert.Equal(t, "Api-Token "+apiToken, request.Header.Get("Authorization"))
+
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(request.Body).Decode(&body))
+			assert.Equal(t, "installer", body["name"])
+			assert.Equal(t, []interface{}{TokenScopeInstallerDownload}, body["scopes"])
+			assert.Equal(t, "2021-06-01T12:00:00Z", body["expirationDate"])
+
+			writer.WriteHeader(http.StatusCreated)
+			_, _ = writer.Write([]byte(`{"id": "dt0c01.ABC", "token": "dt0c01.ABC.XYZ", "expirationDate": "2021-06-01T12:00:00.000Z"}`))
+		}))
+		defer server.Close()
+
+		dtc, err := NewClient(server.URL, apiToken, paasToken)
+		require.NoError(t, err)
+
+		token, err := dtc.CreateToken("installer", []string{TokenScopeInstallerDownload}, expiration)
+		require.NoError(t, err)
+		assert.Equal(t, "dt0c01.ABC", token.ID)
+		assert.Equal(t, "dt0c01.ABC.XYZ", token.Token)
+		assert.True(t, expiration.Equal(token.ExpirationDate))
+	})
+	t.Run(`missing scope`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			writeError(writer, http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		dtc, err := NewClient(server.URL, apiToken, paasToken)
+		require.NoError(t, err)
+
+		token, err := dtc.CreateToken("installer", []string{TokenScopeInstallerDownload}, expiration)
+		assert.Error(t, err)
+		assert.Nil(t, token)
+	})
+}
+
+func TestRevokeToken(t *testing.T) {
+	t.Run(`revoke token`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			assert.Equal(t, "DELETE", request.Method)
+			assert.Equal(t, "/v2/apiTokens/dt0c01.ABC", request.URL.Path)
+			assert.Equal(t, "Api-Token "+apiToken, request.Header.Get("Authorization"))
+			writer.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		dtc, err := NewClient(server.URL, apiToken, paasToken)
+		require.NoError(t, err)
+
+		assert.NoError(t, dtc.RevokeToken("dt0c01.ABC"))
+	})
+	t.Run(`ignore missing token`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			writeError(writer, http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		dtc, err := NewClient(server.URL, apiToken, paasToken)
+		require.NoError(t, err)
+
+		assert.NoError(t, dtc.RevokeToken("dt0c01.ABC"))
+	})
+	t.Run(`missing scope`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			writeError(writer, http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		dtc, err := NewClient(server.URL, apiToken, paasToken)
+		require.NoError(t, err)
+
+		assert.Error(t, dtc.RevokeToken("dt0c01.ABC"))
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -32,6 +33,17 @@ type Client interface {
 	// GetLatestAgent returns a reader with the contents of the download. Must be closed by caller.
 	GetLatestAgent(os, installerType, flavor, arch string, writer io.Writer) error
 
+	// GetLatestAgentForTechnologies writes the latest agent package, which only includes the code modules for the
+	// given technologies, to the writer. All technologies are included if none are given.
+	GetLatestAgentForTechnologies(os, installerType, flavor, arch string, technologies []string, writer io.Writer) error
+
+	// GetAgent writes the agent package of the given version to the writer.
+	GetAgent(os, installerType, flavor, arch, version string, writer io.Writer) error
+
+	// GetAgentChecksum returns the hex encoded SHA-256 checksum of the agent package of the given version for the given
+	// OS, installer type, flavor and architecture. Returns an empty checksum if the server doesn't provide one.
+	GetAgentChecksum(os, installerType, flavor, arch, version string) (string, error)
+
 	// GetCommunicationHosts returns, on success, the list of communication hosts used for available
 	// communication endpoints that the Dynatrace OneAgent can use to connect to.
 	//
@@ -54,6 +66,21 @@ type Client interface {
 
 	// GetTenantInfo returns TenantInfo that holds UUID, Tenant Token and Endpoints
 	GetTenantInfo() (*TenantInfo, error)
+
+	// GetActiveGates returns the ActiveGates registered at the tenant which match the given query.
+	//
+	// Returns an error if the API token lacks the activeGates.read scope or the request failed.
+	GetActiveGates(query ActiveGateQuery) ([]ActiveGate, error)
+
+	// CreateToken creates a token with the given scopes, which expires at the given time.
+	//
+	// Returns an error if the API token lacks the apiTokens.write scope or the request failed.
+	CreateToken(name string, scopes []string, expiration time.Time) (*Token, error)
+
+	// RevokeToken deletes the token with the given id, tokens which don't exist anymore are ignored.
+	//
+	// Returns an error if the API token lacks the apiTokens.write scope or the request failed.
+	RevokeToken(id string) error
 }
 
 // Known OS values.
@@ -2,6 +2,7 @@ package dtclient
 
 import (
 	"io"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -26,6 +27,21 @@ func (o *MockDynatraceClient) GetLatestAgent(os, installerType, flavor, arch str
 	return args.Error(0)
 }
 
+func (o *MockDynatraceClient) GetLatestAgentForTechnologies(os, installerType, flavor, arch string, technologies []string, writer io.Writer) error {
+	args := o.Called(os, installerType, flavor, arch, technologies, writer)
+	return args.Error(0)
+}
+
+func (o *MockDynatraceClient) GetAgent(os, installerType, flavor, arch, version string, writer io.Writer) error {
+	args := o.Called(os, installerType, flavor, arch, version, writer)
+	return args.Error(0)
+}
+
+func (o *MockDynatraceClient) GetAgentChecksum(os, installerType, flavor, arch, version string) (string, error) {
+	args := o.Called(os, installerType, flavor, arch, version)
+	return args.String(0), args.Error(1)
+}
+
 func (o *MockDynatraceClient) GetConnectionInfo() (ConnectionInfo, error) {
 	args := o.Called()
 	return args.Get(0).(ConnectionInfo), args.Error(1)
@@ -50,3 +66,18 @@ func (o *MockDynatraceClient) GetTokenScopes(token string) (TokenScopes, error)
 	args := o.Called(token)
 	return args.Get(0).(TokenScopes), args.Error(1)
 }
+
+func (o *MockDynatraceClient) GetActiveGates(query ActiveGateQuery) ([]ActiveGate, error) {
+	args := o.Called(query)
+	return args.Get(0).([]ActiveGate), args.Error(1)
+}
+
+func (o *MockDynatraceClient) CreateToken(name string, scopes []string, expiration time.Time) (*Token, error) {
+	args := o.Called(name, scopes, expiration)
+	return args.Get(0).(*Token), args.Error(1)
+}
+
+func (o *MockDynatraceClient) RevokeToken(id string) error {
+	args := o.Called(id)
+	return args.Error(0)
+}
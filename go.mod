@@ -13,6 +13,7 @@ require (
 	github.com/spf13/afero v1.6.0
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.6.1
+	go.etcd.io/bbolt v1.3.5
 	go.uber.org/zap v1.16.0
 	golang.org/x/sys v0.0.0-20200909081042-eff7692f9009
 	google.golang.org/grpc v1.28.1
@@ -0,0 +1,60 @@
+package installer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+const (
+	// ConfigFileName is the key of the install configuration in the config secret of each injected namespace
+	ConfigFileName = "config.json"
+	// TrustedCAsFileName is the key of the trusted certificates in the config secret of each injected namespace
+	TrustedCAsFileName = "ca.pem"
+
+	ConfigDir = "/mnt/config"
+	BinDir    = "/mnt/bin"
+	ShareDir  = "/mnt/share"
+
+	// TerminationLogPath is the file the termination message of the install container is read from by Kubernetes
+	TerminationLogPath = "/dev/termination-log"
+	// FailureMessagePrefix starts the termination message of install containers which failed to download or configure
+	// the OneAgent, even if the failure policy let the pod start
+	FailureMessagePrefix = "OneAgent installation failed: "
+)
+
+// Config holds the settings of the tenant, which are shared by all pods of a namespace
+type Config struct {
+	APIURL        string `json:"apiUrl"`
+	PaaSToken     string `json:"paasToken,omitempty"`
+	Proxy         string `json:"proxy,omitempty"`
+	SkipCertCheck bool   `json:"skipCertCheck,omitempty"`
+	TenantUUID    string `json:"tenantUUID"`
+	ClusterID     string `json:"clusterID"`
+
+	// HostTenants maps the names of nodes monitored by a host agent to the tenant of the host agent
+	HostTenants map[string]string `json:"hostTenants,omitempty"`
+}
+
+// ReadConfig reads the install configuration and the trusted certificates, if any, from the given directory
+func ReadConfig(fs afero.Fs, configDir string) (*Config, []byte, error) {
+	data, err := afero.ReadFile(fs, filepath.Join(configDir, ConfigFileName))
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse install configuration")
+	}
+
+	trustedCAs, err := afero.ReadFile(fs, filepath.Join(configDir, TrustedCAsFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	return &config, trustedCAs, nil
+}
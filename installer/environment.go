@@ -0,0 +1,94 @@
+package installer
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ModeInstaller   = "installer"
+	ModeProvisioned = "provisioned"
+
+	FailurePolicyFail = "fail"
+)
+
+type containerInfo struct {
+	name  string
+	image string
+}
+
+// environment holds the pod specific settings, which are passed as environment variables by the webhook
+type environment struct {
+	mode          string
+	flavor        string
+	technologies  []string
+	installPath   string
+	installerURL  string
+	failurePolicy string
+	containers    []containerInfo
+
+	podName     string
+	podUID      string
+	basePodName string
+	namespace   string
+	nodeName    string
+
+	// workloadKind and workloadName are only set if metadata enrichment is enabled
+	workloadKind string
+	workloadName string
+}
+
+func newEnvironment(getenv func(string) string) (*environment, error) {
+	env := &environment{
+		mode:          getenv("MODE"),
+		flavor:        getenv("FLAVOR"),
+		installPath:   getenv("INSTALLPATH"),
+		installerURL:  getenv("INSTALLER_URL"),
+		failurePolicy: getenv("FAILURE_POLICY"),
+		podName:       getenv("K8S_PODNAME"),
+		podUID:        getenv("K8S_PODUID"),
+		basePodName:   getenv("K8S_BASEPODNAME"),
+		namespace:     getenv("K8S_NAMESPACE"),
+		nodeName:      getenv("K8S_NODE_NAME"),
+		workloadKind:  getenv("K8S_WORKLOAD_KIND"),
+		workloadName:  getenv("K8S_WORKLOAD_NAME"),
+	}
+
+	if env.mode != ModeInstaller && env.mode != ModeProvisioned {
+		return nil, errors.Errorf("unknown mode '%s'", env.mode)
+	}
+	if env.installPath == "" {
+		return nil, errors.New("INSTALLPATH is not set")
+	}
+
+	technologies, err := url.QueryUnescape(getenv("TECHNOLOGIES"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid TECHNOLOGIES")
+	}
+	for _, technology := range strings.Split(technologies, ",") {
+		if technology = strings.TrimSpace(technology); technology != "" && technology != "all" {
+			env.technologies = append(env.technologies, technology)
+		}
+	}
+
+	count, err := strconv.Atoi(getenv("CONTAINERS_COUNT"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CONTAINERS_COUNT")
+	}
+	for i := 1; i <= count; i++ {
+		container := containerInfo{
+			name:  getenv(fmt.Sprintf("CONTAINER_%d_NAME", i)),
+			image: getenv(fmt.Sprintf("CONTAINER_%d_IMAGE", i)),
+		}
+		if container.name == "" {
+			return nil, errors.Errorf("CONTAINER_%d_NAME is not set", i)
+		}
+		env.containers = append(env.containers, container)
+	}
+
+	return env, nil
+}
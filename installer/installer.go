@@ -0,0 +1,260 @@
+package installer
+
+import (
+	"archive/zip"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+const hostAgentIDFile = "/var/lib/dynatrace/oneagent/agent/config/ruxithost.id"
+
+// Installer downloads the OneAgent package into the shared volume of a pod and writes the configuration files,
+// which are mounted into the injected containers
+type Installer struct {
+	fs           afero.Fs
+	logger       logr.Logger
+	getenv       func(string) string
+	dtcBuildFunc func(config *Config, trustedCAs []byte) (dtclient.Client, error)
+
+	configDir          string
+	binDir             string
+	shareDir           string
+	terminationLogPath string
+}
+
+func NewInstaller(logger logr.Logger) *Installer {
+	return &Installer{
+		fs:                 afero.NewOsFs(),
+		logger:             logger,
+		getenv:             os.Getenv,
+		dtcBuildFunc:       BuildDynatraceClient,
+		configDir:          ConfigDir,
+		binDir:             BinDir,
+		shareDir:           ShareDir,
+		terminationLogPath: TerminationLogPath,
+	}
+}
+
+// Run installs and configures the OneAgent.
+// Failures to download or unpack the package only fail the pod if the failure policy is set to fail. Failures are
+// reported in the termination message of the install container in any case.
+func (installer *Installer) Run() error {
+	err := installer.run()
+	if err != nil {
+		installer.reportFailure(err)
+	}
+	return err
+}
+
+func (installer *Installer) run() error {
+	env, err := newEnvironment(installer.getenv)
+	if err != nil {
+		return errors.Wrap(err, "invalid environment")
+	}
+
+	config, trustedCAs, err := ReadConfig(installer.fs, installer.configDir)
+	if err != nil {
+		return errors.Wrap(err, "failed to read install configuration")
+	}
+
+	if exists, _ := afero.Exists(installer.fs, hostAgentIDFile); exists {
+		installer.logger.Info("full-stack OneAgent has been injected to this container, app-only and full-stack injection can conflict with each other")
+	}
+
+	if env.mode == ModeInstaller {
+		if err := installer.installAgent(env, config, trustedCAs); err != nil {
+			if env.failurePolicy == FailurePolicyFail {
+				return err
+			}
+			installer.logger.Error(err, "failed to install OneAgent package, containers are not instrumented")
+			installer.reportFailure(err)
+			return nil
+		}
+	}
+
+	installer.logger.Info("configuring OneAgent", "mode", env.mode, "containers", len(env.containers))
+	if err := installer.writePreload(env); err != nil {
+		return errors.Wrap(err, "failed to write ld.so.preload")
+	}
+
+	for _, container := range env.containers {
+		if err := installer.writeContainerConf(env, config, container); err != nil {
+			return errors.Wrapf(err, "failed to write configuration of container %s", container.name)
+		}
+	}
+	return nil
+}
+
+func (installer *Installer) installAgent(env *environment, config *Config, trustedCAs []byte) error {
+	archive, err := afero.TempFile(installer.fs, installer.binDir, "tmp.")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file for download")
+	}
+	defer func() {
+		_ = archive.Close()
+		if err := installer.fs.Remove(archive.Name()); err != nil {
+			installer.logger.Error(err, "failed to delete downloaded file", "path", archive.Name())
+		}
+	}()
+
+	installer.logger.Info("downloading OneAgent package", "flavor", env.flavor, "technologies", env.technologies, "url", env.installerURL)
+	if env.installerURL != "" {
+		err = downloadFromURL(env.installerURL, config, trustedCAs, archive)
+	} else {
+		err = installer.downloadFromTenant(env, config, trustedCAs, archive)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to download the OneAgent package")
+	}
+
+	fileInfo, err := archive.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	zipr, err := zip.NewReader(archive, fileInfo.Size())
+	if err != nil {
+		return errors.Wrap(err, "failed to open the OneAgent package")
+	}
+
+	installer.logger.Info("unpacking OneAgent package", "size", fileInfo.Size())
+	if err := Unzip(installer.fs, zipr, installer.binDir, installer.logger); err != nil {
+		return errors.Wrap(err, "failed to unpack the OneAgent package")
+	}
+	return nil
+}
+
+func (installer *Installer) downloadFromTenant(env *environment, config *Config, trustedCAs []byte, writer io.Writer) error {
+	dtc, err := installer.dtcBuildFunc(config, trustedCAs)
+	if err != nil {
+		return err
+	}
+
+	arch := dtclient.ArchX86
+	if runtime.GOARCH == "arm64" {
+		arch = dtclient.ArchARM
+	}
+
+	return dtc.GetLatestAgentForTechnologies(dtclient.OsUnix, dtclient.InstallerTypePaaS, env.flavor, arch, env.technologies, writer)
+}
+
+func downloadFromURL(installerURL string, config *Config, trustedCAs []byte, writer io.Writer) error {
+	httpClient, err := buildHTTPClient(config, trustedCAs)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Get(installerURL)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected response from %s: %s", installerURL, resp.Status)
+	}
+
+	_, err = io.Copy(writer, resp.Body)
+	return errors.WithStack(err)
+}
+
+func buildHTTPClient(config *Config, trustedCAs []byte) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: config.SkipCertCheck}
+
+	if len(trustedCAs) > 0 {
+		rootCAs := x509.NewCertPool()
+		if ok := rootCAs.AppendCertsFromPEM(trustedCAs); !ok {
+			return nil, errors.New("failed to parse trusted certificates")
+		}
+		transport.TLSClientConfig.RootCAs = rootCAs
+	}
+
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid proxy")
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// BuildDynatraceClient creates a client for the tenant, which only uses the PaaS token of the install configuration
+func BuildDynatraceClient(config *Config, trustedCAs []byte) (dtclient.Client, error) {
+	opts := []dtclient.Option{dtclient.SkipCertificateValidation(config.SkipCertCheck)}
+	if config.Proxy != "" {
+		opts = append(opts, dtclient.Proxy(config.Proxy))
+	}
+	if len(trustedCAs) > 0 {
+		opts = append(opts, dtclient.Certs(trustedCAs))
+	}
+	return dtclient.NewClient(config.APIURL, "", config.PaaSToken, opts...)
+}
+
+// reportFailure writes the failure to the termination message of the install container, which is counted in the status
+// of the DynaKube by the Operator
+func (installer *Installer) reportFailure(err error) {
+	message := []byte(FailureMessagePrefix + err.Error())
+	if err := afero.WriteFile(installer.fs, installer.terminationLogPath, message, 0644); err != nil {
+		installer.logger.Error(err, "failed to write termination message")
+	}
+}
+
+func (installer *Installer) writePreload(env *environment) error {
+	return installer.appendToFile(filepath.Join(installer.shareDir, "ld.so.preload"),
+		env.installPath+"/agent/lib64/liboneagentproc.so")
+}
+
+func (installer *Installer) writeContainerConf(env *environment, config *Config, container containerInfo) error {
+	var sb strings.Builder
+	sb.WriteString("[container]\n")
+	sb.WriteString(fmt.Sprintf("containerName %s\n", container.name))
+	sb.WriteString(fmt.Sprintf("imageName %s\n", container.image))
+	sb.WriteString(fmt.Sprintf("k8s_fullpodname %s\n", env.podName))
+	sb.WriteString(fmt.Sprintf("k8s_poduid %s\n", env.podUID))
+	sb.WriteString(fmt.Sprintf("k8s_containername %s\n", container.name))
+	sb.WriteString(fmt.Sprintf("k8s_basepodname %s\n", env.basePodName))
+	sb.WriteString(fmt.Sprintf("k8s_namespace %s\n", env.namespace))
+	if env.workloadKind != "" {
+		sb.WriteString(fmt.Sprintf("k8s_workload_kind %s\n", env.workloadKind))
+		sb.WriteString(fmt.Sprintf("k8s_workload_name %s\n", env.workloadName))
+	}
+
+	if hostTenant := config.HostTenants[env.nodeName]; hostTenant != "" {
+		if hostTenant == config.TenantUUID {
+			sb.WriteString(fmt.Sprintf("k8s_node_name %s\n", env.nodeName))
+			sb.WriteString(fmt.Sprintf("k8s_cluster_id %s\n", config.ClusterID))
+		}
+		sb.WriteString(fmt.Sprintf("\n[host]\ntenant %s\n", hostTenant))
+	}
+
+	path := filepath.Join(installer.shareDir, fmt.Sprintf("container_%s.conf", container.name))
+	installer.logger.Info("writing container configuration", "path", path)
+	return installer.appendToFile(path, sb.String())
+}
+
+func (installer *Installer) appendToFile(path string, content string) error {
+	f, err := installer.fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	_, err = f.WriteString(content)
+	return errors.WithStack(err)
+}
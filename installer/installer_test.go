@@ -0,0 +1,238 @@
+package installer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/Dynatrace/dynatrace-operator/logger"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTenantUUID = "abc12345"
+	testNodeName   = "node1"
+	testLibrary    = "agent/lib64/liboneagentproc.so"
+)
+
+func buildTestEnv(mode string) map[string]string {
+	return map[string]string{
+		"MODE":              mode,
+		"FLAVOR":            dtclient.FlavorMultidistro,
+		"TECHNOLOGIES":      "java%2Cnginx",
+		"INSTALLPATH":       "/opt/dynatrace/oneagent-paas",
+		"FAILURE_POLICY":    "silent",
+		"CONTAINERS_COUNT":  "2",
+		"CONTAINER_1_NAME":  "app",
+		"CONTAINER_1_IMAGE": "alpine",
+		"CONTAINER_2_NAME":  "sidecar",
+		"CONTAINER_2_IMAGE": "nginx",
+		"K8S_PODNAME":       "app-1234-5678",
+		"K8S_PODUID":        "uid",
+		"K8S_BASEPODNAME":   "app-1234",
+		"K8S_NAMESPACE":     "test-namespace",
+		"K8S_NODE_NAME":     testNodeName,
+	}
+}
+
+func buildTestInstaller(t *testing.T, env map[string]string, config Config) (*Installer, *dtclient.MockDynatraceClient) {
+	fs := afero.NewMemMapFs()
+	data, err := json.Marshal(config)
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(ConfigDir, ConfigFileName), data, 0644))
+	require.NoError(t, fs.MkdirAll(BinDir, 0755))
+	require.NoError(t, fs.MkdirAll(ShareDir, 0755))
+
+	dtc := &dtclient.MockDynatraceClient{}
+	return &Installer{
+		fs:     fs,
+		logger: logger.NewDTLogger(),
+		getenv: func(key string) string { return env[key] },
+		dtcBuildFunc: func(*Config, []byte) (dtclient.Client, error) {
+			return dtc, nil
+		},
+		configDir:          ConfigDir,
+		binDir:             BinDir,
+		shareDir:           ShareDir,
+		terminationLogPath: TerminationLogPath,
+	}, dtc
+}
+
+func buildTestPackage(t *testing.T) []byte {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	f, err := writer.Create(testLibrary)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("library"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func readFile(t *testing.T, installer *Installer, path string) string {
+	data, err := afero.ReadFile(installer.fs, path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestInstaller_Provisioned(t *testing.T) {
+	t.Run(`write configuration`, func(t *testing.T) {
+		installer, dtc := buildTestInstaller(t, buildTestEnv(ModeProvisioned), Config{TenantUUID: testTenantUUID, ClusterID: "42"})
+
+		require.NoError(t, installer.Run())
+
+		dtc.AssertNotCalled(t, "GetLatestAgentForTechnologies")
+		assert.Equal(t, "/opt/dynatrace/oneagent-paas/"+testLibrary, readFile(t, installer, filepath.Join(ShareDir, "ld.so.preload")))
+		assert.Equal(t, `[container]
+containerName app
+imageName alpine
+k8s_fullpodname app-1234-5678
+k8s_poduid uid
+k8s_containername app
+k8s_basepodname app-1234
+k8s_namespace test-namespace
+`, readFile(t, installer, filepath.Join(ShareDir, "container_app.conf")))
+		assert.Contains(t, readFile(t, installer, filepath.Join(ShareDir, "container_sidecar.conf")), "containerName sidecar\nimageName nginx\n")
+	})
+	t.Run(`add host details of the same tenant`, func(t *testing.T) {
+		installer, _ := buildTestInstaller(t, buildTestEnv(ModeProvisioned), Config{
+			TenantUUID:  testTenantUUID,
+			ClusterID:   "42",
+			HostTenants: map[string]string{testNodeName: testTenantUUID},
+		})
+
+		require.NoError(t, installer.Run())
+
+		assert.Contains(t, readFile(t, installer, filepath.Join(ShareDir, "container_app.conf")),
+			"k8s_namespace test-namespace\nk8s_node_name node1\nk8s_cluster_id 42\n\n[host]\ntenant abc12345\n")
+	})
+	t.Run(`add workload of enriched pods`, func(t *testing.T) {
+		env := buildTestEnv(ModeProvisioned)
+		env["K8S_WORKLOAD_KIND"] = "Deployment"
+		env["K8S_WORKLOAD_NAME"] = "app"
+		installer, _ := buildTestInstaller(t, env, Config{TenantUUID: testTenantUUID})
+
+		require.NoError(t, installer.Run())
+
+		assert.Contains(t, readFile(t, installer, filepath.Join(ShareDir, "container_app.conf")),
+			"k8s_namespace test-namespace\nk8s_workload_kind Deployment\nk8s_workload_name app\n")
+	})
+	t.Run(`add host tenant of other tenant`, func(t *testing.T) {
+		installer, _ := buildTestInstaller(t, buildTestEnv(ModeProvisioned), Config{
+			TenantUUID:  testTenantUUID,
+			HostTenants: map[string]string{testNodeName: "other"},
+		})
+
+		require.NoError(t, installer.Run())
+
+		conf := readFile(t, installer, filepath.Join(ShareDir, "container_app.conf"))
+		assert.NotContains(t, conf, "k8s_node_name")
+		assert.Contains(t, conf, "k8s_namespace test-namespace\n\n[host]\ntenant other\n")
+	})
+	t.Run(`invalid environment`, func(t *testing.T) {
+		env := buildTestEnv(ModeProvisioned)
+		env["CONTAINERS_COUNT"] = "3"
+		installer, _ := buildTestInstaller(t, env, Config{})
+
+		assert.EqualError(t, installer.Run(), "invalid environment: CONTAINER_3_NAME is not set")
+	})
+}
+
+func TestInstaller_Installer(t *testing.T) {
+	t.Run(`download from tenant`, func(t *testing.T) {
+		installer, dtc := buildTestInstaller(t, buildTestEnv(ModeInstaller), Config{TenantUUID: testTenantUUID})
+		dtc.On("GetLatestAgentForTechnologies", dtclient.OsUnix, dtclient.InstallerTypePaaS, dtclient.FlavorMultidistro,
+			mock.AnythingOfType("string"), []string{"java", "nginx"}, mock.Anything).
+			Run(func(args mock.Arguments) {
+				_, err := args.Get(5).(io.Writer).Write(buildTestPackage(t))
+				require.NoError(t, err)
+			}).
+			Return(nil)
+
+		require.NoError(t, installer.Run())
+
+		assert.Equal(t, "library", readFile(t, installer, filepath.Join(BinDir, testLibrary)))
+		assert.NotEmpty(t, readFile(t, installer, filepath.Join(ShareDir, "container_app.conf")))
+
+		files, err := afero.ReadDir(installer.fs, BinDir)
+		require.NoError(t, err)
+		assert.Len(t, files, 1, "downloaded archive has been removed")
+	})
+	t.Run(`download from installer url`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			_, _ = writer.Write(buildTestPackage(t))
+		}))
+		defer server.Close()
+
+		env := buildTestEnv(ModeInstaller)
+		env["INSTALLER_URL"] = server.URL
+		installer, dtc := buildTestInstaller(t, env, Config{TenantUUID: testTenantUUID})
+
+		require.NoError(t, installer.Run())
+
+		dtc.AssertNotCalled(t, "GetLatestAgentForTechnologies")
+		assert.Equal(t, "library", readFile(t, installer, filepath.Join(BinDir, testLibrary)))
+	})
+	t.Run(`download failure with silent failure policy`, func(t *testing.T) {
+		installer, dtc := buildTestInstaller(t, buildTestEnv(ModeInstaller), Config{TenantUUID: testTenantUUID})
+		dtc.On("GetLatestAgentForTechnologies", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(fmt.Errorf("unauthorized"))
+
+		require.NoError(t, installer.Run())
+
+		exists, err := afero.Exists(installer.fs, filepath.Join(ShareDir, "ld.so.preload"))
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		assert.Equal(t, FailureMessagePrefix+"failed to download the OneAgent package: unauthorized", readFile(t, installer, TerminationLogPath))
+	})
+	t.Run(`download failure with fail failure policy`, func(t *testing.T) {
+		env := buildTestEnv(ModeInstaller)
+		env["FAILURE_POLICY"] = FailurePolicyFail
+		installer, dtc := buildTestInstaller(t, env, Config{TenantUUID: testTenantUUID})
+		dtc.On("GetLatestAgentForTechnologies", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(fmt.Errorf("unauthorized"))
+
+		assert.EqualError(t, installer.Run(), "failed to download the OneAgent package: unauthorized")
+		assert.Equal(t, FailureMessagePrefix+"failed to download the OneAgent package: unauthorized", readFile(t, installer, TerminationLogPath))
+	})
+	t.Run(`invalid package`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			_, _ = writer.Write([]byte("not a zip file"))
+		}))
+		defer server.Close()
+
+		env := buildTestEnv(ModeInstaller)
+		env["INSTALLER_URL"] = server.URL
+		env["FAILURE_POLICY"] = FailurePolicyFail
+		installer, _ := buildTestInstaller(t, env, Config{TenantUUID: testTenantUUID})
+
+		assert.EqualError(t, installer.Run(), "failed to open the OneAgent package: zip: not a valid zip file")
+	})
+}
+
+func TestNewEnvironment(t *testing.T) {
+	t.Run(`all technologies`, func(t *testing.T) {
+		values := buildTestEnv(ModeInstaller)
+		values["TECHNOLOGIES"] = "all"
+		env, err := newEnvironment(func(key string) string { return values[key] })
+		require.NoError(t, err)
+		assert.Empty(t, env.technologies)
+		assert.Equal(t, []containerInfo{{name: "app", image: "alpine"}, {name: "sidecar", image: "nginx"}}, env.containers)
+	})
+	t.Run(`unknown mode`, func(t *testing.T) {
+		values := buildTestEnv("unknown")
+		_, err := newEnvironment(func(key string) string { return values[key] })
+		assert.EqualError(t, err, "unknown mode 'unknown'")
+	})
+}
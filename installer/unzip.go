@@ -0,0 +1,80 @@
+package installer
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/afero"
+)
+
+// AgentConfPath is the directory of the OneAgent configuration within the package
+const AgentConfPath = "agent/conf/"
+
+// Unzip extracts the OneAgent package into the target directory.
+// Entries pointing outside of the target directory are rejected, files in agent/conf are made group-writable.
+func Unzip(fs afero.Fs, r *zip.Reader, targetDir string, logger logr.Logger) error {
+	_ = fs.MkdirAll(targetDir, 0755)
+
+	// Closure to address file descriptors issue with all the deferred .Close() methods
+	extract := func(zipf *zip.File) error {
+		rc, err := zipf.Open()
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := rc.Close(); err != nil {
+				logger.Error(err, "Failed to close ZIP entry file", "path", zipf.Name)
+			}
+		}()
+
+		path := filepath.Join(targetDir, zipf.Name)
+
+		// Check for ZipSlip (Directory traversal)
+		if !strings.HasPrefix(path, filepath.Clean(targetDir)+string(os.PathSeparator)) {
+			return fmt.Errorf("illegal file path: %s", path)
+		}
+
+		mode := zipf.Mode()
+
+		// Mark all files inside ./agent/conf as group-writable
+		if zipf.Name != AgentConfPath && strings.HasPrefix(zipf.Name, AgentConfPath) {
+			mode |= 020
+		}
+
+		if zipf.FileInfo().IsDir() {
+			return fs.MkdirAll(path, mode)
+		}
+
+		if err = fs.MkdirAll(filepath.Dir(path), mode); err != nil {
+			return err
+		}
+
+		f, err := fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := f.Close(); err != nil {
+				logger.Error(err, "Failed to close target file", "path", f.Name)
+			}
+		}()
+
+		_, err = io.Copy(f, rc)
+		return err
+	}
+
+	for _, f := range r.File {
+		if err := extract(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -4,13 +4,24 @@ const (
 	// LabelInstance can be set in a Namespace and indicates the corresponding DynaKube object assigned to it.
 	LabelInstance = "oneagent.dynatrace.com/instance"
 
+	// AnnotationManagedInstance is set to "true" by the Operator on Namespaces, whose LabelInstance has been set because
+	// they match the namespaceSelector of a DynaKube.
+	AnnotationManagedInstance = "oneagent.dynatrace.com/managed-instance"
+
+	// AnnotationConflict is set by the Operator on Namespaces which are matched by more than one DynaKube.
+	AnnotationConflict = "oneagent.dynatrace.com/conflict"
+
 	// AnnotationInject can be set at pod or namespace label to enable/disable injection, where at pod level has higher
 	// priority.
 	AnnotationInject = "oneagent.dynatrace.com/inject"
 
-	// AnnotationInjected is set to "true" by the webhook to Pods to indicate that it has been modified.
+	// AnnotationInjected is set to "true" by the webhook to Pods to indicate that it has been modified, or to "false" if
+	// none of its containers is selected for injection.
 	AnnotationInjected = "oneagent.dynatrace.com/injected"
 
+	// AnnotationDynaKube is set by the webhook to Pods to indicate which DynaKube has injected them.
+	AnnotationDynaKube = "oneagent.dynatrace.com/dynakube"
+
 	// AnnotationTechnologies can be set on a Pod to configure which code module technologies to download. It's set to
 	// "all" if not set.
 	AnnotationTechnologies = "oneagent.dynatrace.com/technologies"
@@ -27,6 +38,30 @@ const (
 	// "fail", the init container will exit with error code 1. Defaults to "silent".
 	AnnotationFailurePolicy = "oneagent.dynatrace.com/failure-policy"
 
+	// AnnotationExcludeContainers can be set on a Pod with a comma separated list of container names, which are not
+	// injected.
+	AnnotationExcludeContainers = "oneagent.dynatrace.com/exclude-containers"
+
+	// AnnotationIncludeContainers can be set on a Pod with a comma separated list of container names. If set, only these
+	// containers are injected and the excludeContainers patterns of the DynaKube are ignored.
+	AnnotationIncludeContainers = "oneagent.dynatrace.com/include-containers"
+
+	// AnnotationFlavor can be set on a Pod to select the flavor of the OneAgent package, i.e. default, musl or multidistro.
+	// Takes precedence over the flavor rules of the DynaKube.
+	AnnotationFlavor = "oneagent.dynatrace.com/flavor"
+
+	// AnnotationVersion can be set on a Pod or Namespace to select the version of the OneAgent package mounted by the CSI
+	// driver. The annotation of the Pod takes precedence over the one of the Namespace and the version of the DynaKube.
+	AnnotationVersion = "oneagent.dynatrace.com/version"
+
+	// AnnotationVolumeQuota can be set on a Pod to limit the size of what it writes to the volume mounted by the CSI
+	// driver, e.g. 100Mi. Takes precedence over the volume quota of the DynaKube, an empty value removes the limit.
+	AnnotationVolumeQuota = "oneagent.dynatrace.com/volume-quota"
+
+	// AnnotationReadOnlyVolume can be set to "true" on a Pod to mount the volume of the CSI driver read-only, for pods
+	// which don't need to write to the agent directory, e.g. to its logs or its conf directory.
+	AnnotationReadOnlyVolume = "oneagent.dynatrace.com/read-only-volume"
+
 	// DefaultInstallPath is the default directory to install the app-only OneAgent package.
 	DefaultInstallPath = "/opt/dynatrace/oneagent-paas"
 
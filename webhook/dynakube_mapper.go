@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ConflictError is returned if a Namespace is matched by more than one DynaKube
+type ConflictError struct {
+	Namespace string
+	DynaKubes []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("namespace '%s' is matched by multiple DynaKubes: %s", e.Namespace, strings.Join(e.DynaKubes, ", "))
+}
+
+// FindDynaKube returns the DynaKube which injects into the given Namespace, or nil if there is none.
+//
+// A Namespace is matched by the DynaKube its LabelInstance label refers to, unless the label has been set by the
+// Operator, and by all DynaKubes with enabled code modules whose namespaceSelector matches the Namespace.
+// Returns a ConflictError if more than one DynaKube matches.
+func FindDynaKube(ns *corev1.Namespace, dynakubes []dynatracev1alpha1.DynaKube) (*dynatracev1alpha1.DynaKube, error) {
+	var matches []*dynatracev1alpha1.DynaKube
+
+	assigned := ""
+	if ns.Annotations[AnnotationManagedInstance] != "true" {
+		assigned = ns.Labels[LabelInstance]
+	}
+
+	for i := range dynakubes {
+		dk := &dynakubes[i]
+		if dk.Name == assigned {
+			matches = append(matches, dk)
+			continue
+		}
+
+		selected, err := MatchesNamespaceSelector(dk, ns)
+		if err != nil {
+			return nil, err
+		}
+		if selected {
+			matches = append(matches, dk)
+		}
+	}
+
+	if assigned != "" && !containsDynaKube(matches, assigned) {
+		return nil, errors.Errorf("namespace '%s' is assigned to DynaKube instance '%s' but doesn't exist", ns.Name, assigned)
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return matches[0], nil
+	}
+
+	conflict := &ConflictError{Namespace: ns.Name}
+	for _, dk := range matches {
+		conflict.DynaKubes = append(conflict.DynaKubes, dk.Name)
+	}
+	sort.Strings(conflict.DynaKubes)
+	return nil, conflict
+}
+
+// MatchesNamespaceSelector returns true if the DynaKube injects into the Namespace because of its namespaceSelector
+func MatchesNamespaceSelector(dk *dynatracev1alpha1.DynaKube, ns *corev1.Namespace) (bool, error) {
+	if !dk.Spec.CodeModules.Enabled || dk.Spec.CodeModules.NamespaceSelector == nil {
+		return false, nil
+	}
+	return matchesSelector(dk.Spec.CodeModules.NamespaceSelector, ns.Labels)
+}
+
+// MatchesPodSelector returns true if the DynaKube has no podSelector or the selector matches the Pod
+func MatchesPodSelector(dk *dynatracev1alpha1.DynaKube, pod *corev1.Pod) (bool, error) {
+	if dk.Spec.CodeModules.PodSelector == nil {
+		return true, nil
+	}
+	return matchesSelector(dk.Spec.CodeModules.PodSelector, pod.Labels)
+}
+
+func matchesSelector(labelSelector *metav1.LabelSelector, objectLabels map[string]string) (bool, error) {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return selector.Matches(labels.Set(objectLabels)), nil
+}
+
+func containsDynaKube(dynakubes []*dynatracev1alpha1.DynaKube, name string) bool {
+	for _, dk := range dynakubes {
+		if dk.Name == name {
+			return true
+		}
+	}
+	return false
+}
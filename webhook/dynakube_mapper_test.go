@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testNamespace = "test-namespace"
+	testDynaKube  = "test-dynakube"
+	testOther     = "test-other"
+)
+
+func buildTestDynaKube(name string, namespaceSelector *metav1.LabelSelector) dynatracev1alpha1.DynaKube {
+	return dynatracev1alpha1.DynaKube{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: dynatracev1alpha1.DynaKubeSpec{
+			CodeModules: dynatracev1alpha1.CodeModulesSpec{
+				Enabled:           true,
+				NamespaceSelector: namespaceSelector,
+			},
+		},
+	}
+}
+
+func buildTestNamespace(labels map[string]string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        testNamespace,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+	}
+}
+
+func TestFindDynaKube(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"inject": "true"}}
+
+	t.Run(`no match`, func(t *testing.T) {
+		dk, err := FindDynaKube(buildTestNamespace(nil, nil), []dynatracev1alpha1.DynaKube{
+			buildTestDynaKube(testDynaKube, selector),
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, dk)
+	})
+	t.Run(`match by label`, func(t *testing.T) {
+		dk, err := FindDynaKube(buildTestNamespace(map[string]string{LabelInstance: testDynaKube}, nil), []dynatracev1alpha1.DynaKube{
+			buildTestDynaKube(testOther, nil),
+			buildTestDynaKube(testDynaKube, nil),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, testDynaKube, dk.Name)
+	})
+	t.Run(`label refers to missing DynaKube`, func(t *testing.T) {
+		dk, err := FindDynaKube(buildTestNamespace(map[string]string{LabelInstance: testDynaKube}, nil), nil)
+		assert.EqualError(t, err, "namespace 'test-namespace' is assigned to DynaKube instance 'test-dynakube' but doesn't exist")
+		assert.Nil(t, dk)
+	})
+	t.Run(`match by namespace selector`, func(t *testing.T) {
+		dk, err := FindDynaKube(buildTestNamespace(map[string]string{"inject": "true"}, nil), []dynatracev1alpha1.DynaKube{
+			buildTestDynaKube(testOther, nil),
+			buildTestDynaKube(testDynaKube, selector),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, testDynaKube, dk.Name)
+	})
+	t.Run(`namespace selector is ignored if code modules are disabled`, func(t *testing.T) {
+		disabled := buildTestDynaKube(testDynaKube, selector)
+		disabled.Spec.CodeModules.Enabled = false
+
+		dk, err := FindDynaKube(buildTestNamespace(map[string]string{"inject": "true"}, nil), []dynatracev1alpha1.DynaKube{disabled})
+		assert.NoError(t, err)
+		assert.Nil(t, dk)
+	})
+	t.Run(`label set by the operator is ignored`, func(t *testing.T) {
+		ns := buildTestNamespace(
+			map[string]string{LabelInstance: testOther},
+			map[string]string{AnnotationManagedInstance: "true"})
+
+		dk, err := FindDynaKube(ns, []dynatracev1alpha1.DynaKube{
+			buildTestDynaKube(testOther, selector),
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, dk)
+	})
+	t.Run(`conflict between label and namespace selector`, func(t *testing.T) {
+		ns := buildTestNamespace(map[string]string{LabelInstance: testOther, "inject": "true"}, nil)
+
+		dk, err := FindDynaKube(ns, []dynatracev1alpha1.DynaKube{
+			buildTestDynaKube(testOther, nil),
+			buildTestDynaKube(testDynaKube, selector),
+		})
+		assert.Nil(t, dk)
+
+		var conflict *ConflictError
+		require.True(t, errors.As(err, &conflict))
+		assert.Equal(t, testNamespace, conflict.Namespace)
+		assert.Equal(t, []string{testDynaKube, testOther}, conflict.DynaKubes)
+	})
+	t.Run(`invalid selector`, func(t *testing.T) {
+		invalid := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "inject", Operator: "invalid"}}}
+
+		_, err := FindDynaKube(buildTestNamespace(nil, nil), []dynatracev1alpha1.DynaKube{
+			buildTestDynaKube(testDynaKube, invalid),
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestMatchesPodSelector(t *testing.T) {
+	dk := buildTestDynaKube(testDynaKube, nil)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "test"}}}
+
+	selected, err := MatchesPodSelector(&dk, pod)
+	require.NoError(t, err)
+	assert.True(t, selected)
+
+	dk.Spec.CodeModules.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}}
+	selected, err = MatchesPodSelector(&dk, pod)
+	require.NoError(t, err)
+	assert.True(t, selected)
+
+	dk.Spec.CodeModules.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "other"}}
+	selected, err = MatchesPodSelector(&dk, pod)
+	require.NoError(t, err)
+	assert.False(t, selected)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	dtwebhook "github.com/Dynatrace/dynatrace-operator/webhook"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// containerSelector decides which containers of a Pod are injected, based on the annotations of the Pod and the
+// exclusion patterns of the DynaKube
+type containerSelector struct {
+	included map[string]bool
+	excluded map[string]bool
+	patterns []dynatracev1alpha1.ContainerPattern
+}
+
+func newContainerSelector(pod *corev1.Pod, dk *dynatracev1alpha1.DynaKube) *containerSelector {
+	selector := &containerSelector{
+		excluded: parseContainerNames(pod.Annotations[dtwebhook.AnnotationExcludeContainers]),
+		patterns: dk.Spec.CodeModules.ExcludeContainers,
+	}
+
+	if include, ok := pod.Annotations[dtwebhook.AnnotationIncludeContainers]; ok {
+		selector.included = parseContainerNames(include)
+	}
+
+	return selector
+}
+
+func (selector *containerSelector) isSelected(c *corev1.Container) bool {
+	if selector.excluded[c.Name] {
+		return false
+	}
+
+	if selector.included != nil {
+		return selector.included[c.Name]
+	}
+
+	for _, pattern := range selector.patterns {
+		if matchesContainerPattern(pattern, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesContainerPattern(pattern dynatracev1alpha1.ContainerPattern, c *corev1.Container) bool {
+	if pattern.Name == "" && pattern.Image == "" {
+		return false
+	}
+	return (pattern.Name == "" || matchesWildcard(pattern.Name, c.Name)) &&
+		(pattern.Image == "" || matchesWildcard(pattern.Image, c.Image))
+}
+
+// matchesWildcard matches the value against the pattern, where '*' matches any sequence of characters, including '/'
+func matchesWildcard(pattern string, value string) bool {
+	expression := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	matched, err := regexp.MatchString("^"+expression+"$", value)
+	return err == nil && matched
+}
+
+func parseContainerNames(value string) map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMatchesContainerPattern(t *testing.T) {
+	container := &corev1.Container{Name: "istio-proxy", Image: "docker.io/istio/proxyv2:1.9.0"}
+
+	t.Run(`match by name`, func(t *testing.T) {
+		assert.True(t, matchesContainerPattern(dynatracev1alpha1.ContainerPattern{Name: "istio-proxy"}, container))
+		assert.True(t, matchesContainerPattern(dynatracev1alpha1.ContainerPattern{Name: "istio-*"}, container))
+		assert.False(t, matchesContainerPattern(dynatracev1alpha1.ContainerPattern{Name: "istio"}, container))
+	})
+	t.Run(`match by image`, func(t *testing.T) {
+		assert.True(t, matchesContainerPattern(dynatracev1alpha1.ContainerPattern{Image: "*/istio/proxyv2:*"}, container))
+		assert.False(t, matchesContainerPattern(dynatracev1alpha1.ContainerPattern{Image: "istio/proxyv2"}, container))
+	})
+	t.Run(`name and image have to match`, func(t *testing.T) {
+		assert.True(t, matchesContainerPattern(dynatracev1alpha1.ContainerPattern{Name: "istio-*", Image: "*proxyv2*"}, container))
+		assert.False(t, matchesContainerPattern(dynatracev1alpha1.ContainerPattern{Name: "app", Image: "*proxyv2*"}, container))
+	})
+	t.Run(`empty pattern matches nothing`, func(t *testing.T) {
+		assert.False(t, matchesContainerPattern(dynatracev1alpha1.ContainerPattern{}, container))
+	})
+	t.Run(`special characters are matched literally`, func(t *testing.T) {
+		assert.False(t, matchesContainerPattern(dynatracev1alpha1.ContainerPattern{Image: "docker.io/istio/proxyv2:1.9.?"}, container))
+		assert.False(t, matchesContainerPattern(dynatracev1alpha1.ContainerPattern{Image: "docker-io/*"}, container))
+	})
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/downloadcache"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const labelArch = "kubernetes.io/arch"
+
+// buildDownloadCacheURL returns the URL of the OneAgent package for a Pod at the download cache of the DynaKube.
+// The architecture is taken from the node selector of the Pod, since the node isn't known yet, and defaults to x86.
+func buildDownloadCacheURL(pod *corev1.Pod, dk *dynatracev1alpha1.DynaKube, flavor string, technologies string) string {
+	arch := dtclient.ArchX86
+	if pod.Spec.NodeSelector[labelArch] == "arm64" {
+		arch = dtclient.ArchARM
+	}
+
+	query := url.Values{}
+	query.Set(downloadcache.FlavorParam, flavor)
+	query.Set(downloadcache.ArchParam, arch)
+	query.Set(downloadcache.TechnologiesParam, technologies)
+
+	return fmt.Sprintf("http://%s.%s.svc:%d%s?%s",
+		dk.DownloadCacheName(), dk.Namespace, downloadcache.Port, downloadcache.PackagePath, query.Encode())
+}
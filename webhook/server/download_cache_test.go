@@ -0,0 +1,25 @@
+package server
+
+import (
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildDownloadCacheURL(t *testing.T) {
+	dk := &dynatracev1alpha1.DynaKube{ObjectMeta: metav1.ObjectMeta{Name: "dynakube", Namespace: "dynatrace"}}
+
+	t.Run(`default architecture`, func(t *testing.T) {
+		url := buildDownloadCacheURL(&corev1.Pod{}, dk, dtclient.FlavorMultidistro, "all")
+		assert.Equal(t, "http://dynakube-download-cache.dynatrace.svc:8080/oneagent?arch=x86&flavor=multidistro&technologies=all", url)
+	})
+	t.Run(`architecture from node selector`, func(t *testing.T) {
+		pod := &corev1.Pod{Spec: corev1.PodSpec{NodeSelector: map[string]string{labelArch: "arm64"}}}
+		url := buildDownloadCacheURL(pod, dk, dtclient.FlavorDefault, "java,nodejs")
+		assert.Equal(t, "http://dynakube-download-cache.dynatrace.svc:8080/oneagent?arch=arm&flavor=default&technologies=java%2Cnodejs", url)
+	})
+}
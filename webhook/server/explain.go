@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/controllers/kubesystem"
+	"github.com/Dynatrace/dynatrace-operator/controllers/utils"
+	"github.com/pkg/errors"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// ExplainPath is the path of the endpoint explaining the injection decision for a pod manifest sent in the body
+	ExplainPath = "/explain"
+	// NamespaceParam is the query parameter of the explain endpoint with the Namespace of the pod, which defaults to the
+	// Namespace of the manifest
+	NamespaceParam = "namespace"
+
+	// WebhookDeploymentName is the name of the webhook Deployment, whose image is used for the install container
+	WebhookDeploymentName = "dynatrace-webhook"
+
+	// DecisionInject means that the OneAgent is injected into the pod
+	DecisionInject = "inject"
+	// DecisionUpdate means that containers missing in an already injected pod are instrumented
+	DecisionUpdate = "update"
+	// DecisionSkip means that the pod is admitted without injection
+	DecisionSkip = "skip"
+	// DecisionReject means that the webhook rejects the pod
+	DecisionReject = "reject"
+
+	maxManifestSize = 1 << 20
+)
+
+// injectionResult is the decision of the webhook for a pod, the pod has been modified if it needs to be patched
+type injectionResult struct {
+	decision string
+	reason   string
+	dynakube string
+	patched  bool
+
+	// failurePolicy is the failure policy of the webhook configured in the DynaKube
+	failurePolicy string
+
+	// code is the HTTP status code of rejected pods
+	code int32
+	err  error
+}
+
+func skip(reason string) injectionResult {
+	return injectionResult{decision: DecisionSkip, reason: reason}
+}
+
+func reject(code int32, err error) injectionResult {
+	return injectionResult{decision: DecisionReject, reason: err.Error(), code: code, err: err}
+}
+
+func (result injectionResult) withDynaKube(dk *dynatracev1alpha1.DynaKube) injectionResult {
+	result.dynakube = dk.Name
+	result.failurePolicy = dk.Spec.CodeModules.Webhook.FailurePolicy
+	return result
+}
+
+// ignoresFailure returns true if the pod is admitted without injection, since the failure policy of the webhook of the
+// responsible DynaKube is Ignore. Failures before the responsible DynaKube is known are always rejected.
+func (result injectionResult) ignoresFailure() bool {
+	return result.err != nil && result.failurePolicy == string(admissionregistrationv1.Ignore)
+}
+
+// Explanation describes what the webhook would do with a pod
+type Explanation struct {
+	Decision string `json:"decision"`
+	Reason   string `json:"reason"`
+	// DynaKube is the name of the DynaKube responsible for the Namespace of the pod, if any
+	DynaKube string `json:"dynakube,omitempty"`
+	// Patch is the JSON patch the webhook would apply to the pod
+	Patch json.RawMessage `json:"patch,omitempty"`
+}
+
+// explain decodes the pod manifest, either JSON or YAML, and explains the injection decision for it without admitting it
+func (m *podInjector) explain(ctx context.Context, manifest []byte, namespace string) (*Explanation, error) {
+	pod, namespace, err := decodePod(manifest, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return m.explainPod(ctx, pod, namespace)
+}
+
+// decodePod decodes the pod manifest, either JSON or YAML, and returns it with its Namespace, unless overridden
+func decodePod(manifest []byte, namespace string) (*corev1.Pod, string, error) {
+	var pod corev1.Pod
+	if err := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), len(manifest)).Decode(&pod); err != nil {
+		return nil, "", errors.Wrap(err, "failed to decode pod manifest")
+	}
+
+	if namespace == "" {
+		namespace = pod.Namespace
+	}
+	if namespace == "" {
+		return nil, "", errors.New("namespace of the pod is missing")
+	}
+	return &pod, namespace, nil
+}
+
+func (m *podInjector) explainPod(ctx context.Context, pod *corev1.Pod, namespace string) (*Explanation, error) {
+	original, err := json.Marshal(pod)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := m.injectPod(ctx, pod, namespace)
+	explanation := &Explanation{
+		Decision: result.decision,
+		Reason:   result.reason,
+		DynaKube: result.dynakube,
+	}
+	if result.ignoresFailure() {
+		explanation.Decision = DecisionSkip
+	}
+	if !result.patched {
+		return explanation, nil
+	}
+
+	mutated, err := json.Marshal(pod)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// The patch is built the same way as by getResponse for admission requests
+	resp := admission.PatchResponseFromRaw(original, mutated)
+	if !resp.Allowed {
+		return nil, errors.New(resp.Result.Message)
+	}
+	if explanation.Patch, err = json.Marshal(resp.Patches); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return explanation, nil
+}
+
+// explainHandler serves the explain endpoint of the webhook server. Callers have to send a bearer token which is allowed
+// to create the pod in its Namespace, so the endpoint doesn't reveal more than a server-side dry-run of the pod.
+type explainHandler struct {
+	injector *podInjector
+}
+
+func (h *explainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manifest, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxManifestSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read pod manifest: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	pod, namespace, err := decodePod(manifest, r.URL.Query().Get(NamespaceParam))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if code, err := h.authorize(r, namespace); err != nil {
+		logger.Info("rejected explain request", "namespace", namespace, "error", err.Error())
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	explanation, err := h.injector.explainPod(r.Context(), pod, namespace)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(explanation); err != nil {
+		logger.Error(err, "failed to write explanation")
+	}
+}
+
+// authorize reviews the bearer token of the request and checks whether its user is allowed to create pods in the
+// Namespace. Returns the HTTP status code to respond with if not.
+func (h *explainHandler) authorize(r *http.Request, namespace string) (int, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return http.StatusUnauthorized, errors.New("bearer token is missing")
+	}
+
+	tokenReview := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	if err := h.injector.client.Create(r.Context(), tokenReview); err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "failed to review token")
+	}
+	if !tokenReview.Status.Authenticated {
+		return http.StatusUnauthorized, errors.New("bearer token is invalid")
+	}
+
+	user := tokenReview.Status.User
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	accessReview := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		User:   user.Username,
+		UID:    user.UID,
+		Groups: user.Groups,
+		Extra:  extra,
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      "create",
+			Resource:  "pods",
+		},
+	}}
+	if err := h.injector.client.Create(r.Context(), accessReview); err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "failed to review access")
+	}
+	if !accessReview.Status.Allowed {
+		return http.StatusForbidden, errors.Errorf("user %s is not allowed to create pods in namespace %s", user.Username, namespace)
+	}
+	return http.StatusOK, nil
+}
+
+// ExplainInjection explains the injection decision for the pod manifest with the same settings as the webhook running
+// in the given Namespace, without going through the webhook server. The cluster is only read.
+func ExplainInjection(ctx context.Context, cfg *rest.Config, clt client.Client, ns string, manifest []byte, podNamespace string) (*Explanation, error) {
+	apmExists, err := utils.CheckIfOneAgentAPMExists(cfg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var deployment appsv1.Deployment
+	if err := clt.Get(ctx, client.ObjectKey{Name: WebhookDeploymentName, Namespace: ns}, &deployment); err != nil {
+		return nil, errors.Wrap(err, "failed to get webhook deployment")
+	}
+
+	uid, err := kubesystem.GetUID(clt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	injector := &podInjector{
+		client:    clt,
+		apiReader: clt,
+		namespace: ns,
+		image:     deployment.Spec.Template.Spec.Containers[0].Image,
+		apmExists: apmExists,
+		clusterID: string(uid),
+	}
+	return injector.explain(ctx, manifest, podNamespace)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dynatrace/dynatrace-operator/scheme"
+	dtwebhook "github.com/Dynatrace/dynatrace-operator/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const testPodManifest = `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: test-pod-12345
+  namespace: test-namespace
+spec:
+  containers:
+    - name: test-container
+      image: alpine
+`
+
+const testToken = "test-token"
+
+// reviewClient answers TokenReviews for testToken with the user "developer", who may create pods in allowedNamespace
+type reviewClient struct {
+	client.Client
+	allowedNamespace string
+}
+
+func (c *reviewClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	switch review := obj.(type) {
+	case *authenticationv1.TokenReview:
+		if review.Spec.Token == testToken {
+			review.Status.Authenticated = true
+			review.Status.User.Username = "developer"
+		}
+		return nil
+	case *authorizationv1.SubjectAccessReview:
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == "developer" && attributes.Verb == "create" &&
+			attributes.Resource == "pods" && attributes.Namespace == c.allowedNamespace
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestExplain(t *testing.T) {
+	decoder, err := admission.NewDecoder(scheme.Scheme)
+	require.NoError(t, err)
+
+	t.Run(`explain injection`, func(t *testing.T) {
+		inj, _ := createPodInjector(t, decoder)
+
+		explanation, err := inj.explain(context.TODO(), []byte(testPodManifest), "")
+		require.NoError(t, err)
+		assert.Equal(t, DecisionInject, explanation.Decision)
+		assert.Equal(t, "oneagent", explanation.DynaKube)
+
+		var patch []map[string]interface{}
+		require.NoError(t, json.Unmarshal(explanation.Patch, &patch))
+		assert.NotEmpty(t, patch)
+	})
+	t.Run(`explain disabled injection`, func(t *testing.T) {
+		inj, _ := createPodInjector(t, decoder)
+		manifest := strings.Replace(testPodManifest, "  namespace: test-namespace\n",
+			"  namespace: test-namespace\n  annotations:\n    "+dtwebhook.AnnotationInject+": \"false\"\n", 1)
+
+		explanation, err := inj.explain(context.TODO(), []byte(manifest), "")
+		require.NoError(t, err)
+		assert.Equal(t, DecisionSkip, explanation.Decision)
+		assert.Contains(t, explanation.Reason, "annotation of the pod")
+		assert.Empty(t, explanation.Patch)
+	})
+	t.Run(`explain disabled code modules`, func(t *testing.T) {
+		inj, instance := createPodInjector(t, decoder)
+		instance.Spec.CodeModules.Enabled = false
+		require.NoError(t, inj.client.Update(context.TODO(), instance))
+
+		explanation, err := inj.explain(context.TODO(), []byte(testPodManifest), "")
+		require.NoError(t, err)
+		assert.Equal(t, DecisionSkip, explanation.Decision)
+		assert.Equal(t, "code modules are disabled in DynaKube oneagent", explanation.Reason)
+	})
+	t.Run(`explain rejected pod`, func(t *testing.T) {
+		inj, _ := createPodInjector(t, decoder)
+		require.NoError(t, inj.client.Create(context.TODO(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other-namespace"}}))
+
+		explanation, err := inj.explain(context.TODO(), []byte(testPodManifest), "other-namespace")
+		require.NoError(t, err)
+		assert.Equal(t, DecisionReject, explanation.Decision)
+		assert.Equal(t, "no DynaKube instance set for namespace: other-namespace", explanation.Reason)
+	})
+	t.Run(`missing namespace`, func(t *testing.T) {
+		inj, _ := createPodInjector(t, decoder)
+		manifest := strings.Replace(testPodManifest, "  namespace: test-namespace\n", "", 1)
+
+		_, err := inj.explain(context.TODO(), []byte(manifest), "")
+		assert.Error(t, err)
+	})
+	t.Run(`serve explanation`, func(t *testing.T) {
+		inj, _ := createPodInjector(t, decoder)
+		inj.client = &reviewClient{Client: inj.client, allowedNamespace: "test-namespace"}
+		handler := &explainHandler{injector: inj}
+
+		serve := func(method string, namespace string, token string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, ExplainPath+"?"+NamespaceParam+"="+namespace, strings.NewReader(testPodManifest))
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+
+		rec := serve(http.MethodPost, "test-namespace", testToken)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var explanation Explanation
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &explanation))
+		assert.Equal(t, DecisionInject, explanation.Decision)
+
+		assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "test-namespace", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "test-namespace", "invalid").Code)
+		assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "other-namespace", testToken).Code)
+		assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodGet, "test-namespace", testToken).Code)
+	})
+}
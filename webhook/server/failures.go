@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// EventReasonInjectionFailed is the reason of the Events recorded on the owners of pods the webhook failed to inject
+	EventReasonInjectionFailed = "InjectionFailed"
+
+	failureQueueSize = 100
+)
+
+var injectionFailuresMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "dynatrace",
+	Subsystem: "webhook",
+	Name:      "injection_failures_total",
+	Help:      "Number of pods the webhook failed to inject",
+}, []string{"dynakube"})
+
+func init() {
+	metrics.Registry.MustRegister(injectionFailuresMetric)
+}
+
+// reportFailure records a pod the webhook failed to inject as Event on the owner of the pod, since the pod itself
+// may not be created, and counts it in the metrics and in the status of the DynaKube responsible for the pod, if known
+func (m *podInjector) reportFailure(pod *corev1.Pod, namespace string, result injectionResult) {
+	injectionFailuresMetric.WithLabelValues(result.dynakube).Inc()
+
+	if owner := metav1.GetControllerOf(pod); owner != nil && m.recorder != nil {
+		m.recorder.Eventf(&metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: owner.APIVersion, Kind: owner.Kind},
+			ObjectMeta: metav1.ObjectMeta{Name: owner.Name, Namespace: namespace, UID: owner.UID},
+		}, corev1.EventTypeWarning, EventReasonInjectionFailed, "Failed to inject OneAgent into pod: %s", result.reason)
+	}
+
+	if result.dynakube == "" || m.failures == nil {
+		return
+	}
+
+	podName := pod.Name
+	if podName == "" {
+		podName = pod.GenerateName
+	}
+
+	failure := dynatracev1alpha1.InjectionFailure{
+		Namespace: namespace,
+		Pod:       podName,
+		Message:   result.reason,
+		Time:      metav1.Now(),
+	}
+	m.failures.add(result.dynakube, failure)
+}
+
+// failureCounter counts injection failures in the status of the DynaKubes. The status is updated in the background, so
+// admission requests don't wait for it, and failures queued in the meantime are counted with a single update.
+type failureCounter struct {
+	client    client.Client
+	namespace string
+	failures  chan queuedFailure
+}
+
+type queuedFailure struct {
+	dynakube string
+	failure  dynatracev1alpha1.InjectionFailure
+}
+
+func newFailureCounter(clt client.Client, namespace string) *failureCounter {
+	return &failureCounter{
+		client:    clt,
+		namespace: namespace,
+		failures:  make(chan queuedFailure, failureQueueSize),
+	}
+}
+
+// add queues the failure for the DynaKube. If the queue is full, the failure is only counted in the metrics.
+func (c *failureCounter) add(dynakube string, failure dynatracev1alpha1.InjectionFailure) {
+	select {
+	case c.failures <- queuedFailure{dynakube: dynakube, failure: failure}:
+	default:
+		logger.Info("injection failure queue is full, failure isn't counted in status of DynaKube", "dynakube", dynakube)
+	}
+}
+
+// Start counts the queued failures until the context is done
+func (c *failureCounter) Start(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case first := <-c.failures:
+			c.count(ctx, first)
+		}
+	}
+}
+
+// NeedLeaderElection returns false, since every replica of the webhook counts its own failures
+func (c *failureCounter) NeedLeaderElection() bool {
+	return false
+}
+
+// count counts the given failure together with all failures queued so far
+func (c *failureCounter) count(ctx context.Context, first queuedFailure) {
+	failures := map[string][]dynatracev1alpha1.InjectionFailure{first.dynakube: {first.failure}}
+	for drained := false; !drained; {
+		select {
+		case queued := <-c.failures:
+			failures[queued.dynakube] = append(failures[queued.dynakube], queued.failure)
+		default:
+			drained = true
+		}
+	}
+
+	for dynakube, dynakubeFailures := range failures {
+		if err := c.countFailures(ctx, dynakube, dynakubeFailures); err != nil {
+			logger.Error(err, "failed to count injection failures in status of DynaKube", "dynakube", dynakube)
+		}
+	}
+}
+
+// countFailures increments the injection failures in the status of the DynaKube, concurrent updates by other replicas
+// are handled by retrying on conflicts
+func (c *failureCounter) countFailures(ctx context.Context, name string, failures []dynatracev1alpha1.InjectionFailure) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var dk dynatracev1alpha1.DynaKube
+		if err := c.client.Get(ctx, client.ObjectKey{Name: name, Namespace: c.namespace}, &dk); err != nil {
+			return errors.WithStack(err)
+		}
+
+		dk.Status.CodeModules.InjectionFailures += int64(len(failures))
+		dk.Status.CodeModules.LastInjectionFailure = &failures[len(failures)-1]
+		return c.client.Status().Update(ctx, &dk)
+	})
+}
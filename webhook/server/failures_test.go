@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/scheme"
+	dtwebhook "github.com/Dynatrace/dynatrace-operator/webhook"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestReportFailure(t *testing.T) {
+	decoder, err := admission.NewDecoder(scheme.Scheme)
+	require.NoError(t, err)
+
+	inj, _ := createPodInjector(t, decoder)
+	recorder := record.NewFakeRecorder(10)
+	inj.recorder = recorder
+	inj.failures = newFailureCounter(inj.client, "dynatrace")
+
+	controller := true
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "app-1234-",
+			Namespace:    "test-namespace",
+			Annotations:  map[string]string{dtwebhook.AnnotationFlavor: "invalid"},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-1234", Controller: &controller},
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "alpine"}},
+		},
+	}
+	podBytes, err := json.Marshal(&pod)
+	require.NoError(t, err)
+
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Object:    runtime.RawExtension{Raw: podBytes},
+			Namespace: "test-namespace",
+		},
+	}
+
+	before := testutil.ToFloat64(injectionFailuresMetric.WithLabelValues("oneagent"))
+
+	resp := inj.Handle(context.TODO(), req)
+	require.False(t, resp.Allowed)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(injectionFailuresMetric.WithLabelValues("oneagent")))
+
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, EventReasonInjectionFailed)
+
+	require.Len(t, inj.failures.failures, 1)
+	inj.failures.count(context.TODO(), <-inj.failures.failures)
+
+	var dk dynatracev1alpha1.DynaKube
+	require.NoError(t, inj.client.Get(context.TODO(), client.ObjectKey{Name: "oneagent", Namespace: "dynatrace"}, &dk))
+	assert.Equal(t, int64(1), dk.Status.CodeModules.InjectionFailures)
+	require.NotNil(t, dk.Status.CodeModules.LastInjectionFailure)
+	assert.Equal(t, "app-1234-", dk.Status.CodeModules.LastInjectionFailure.Pod)
+	assert.Equal(t, "test-namespace", dk.Status.CodeModules.LastInjectionFailure.Namespace)
+	assert.Equal(t, resp.Result.Message, dk.Status.CodeModules.LastInjectionFailure.Message)
+
+	// Failures are counted up, queued failures at once
+	resp = inj.Handle(context.TODO(), req)
+	require.False(t, resp.Allowed)
+	resp = inj.Handle(context.TODO(), req)
+	require.False(t, resp.Allowed)
+	inj.failures.count(context.TODO(), <-inj.failures.failures)
+
+	require.NoError(t, inj.client.Get(context.TODO(), client.ObjectKey{Name: "oneagent", Namespace: "dynatrace"}, &dk))
+	assert.Equal(t, int64(3), dk.Status.CodeModules.InjectionFailures)
+
+	// Dry-runs must not have side effects
+	dryRun := true
+	req.DryRun = &dryRun
+	resp = inj.Handle(context.TODO(), req)
+	require.False(t, resp.Allowed)
+
+	assert.Empty(t, inj.failures.failures)
+	assert.Equal(t, before+3, testutil.ToFloat64(injectionFailuresMetric.WithLabelValues("oneagent")))
+	assert.Len(t, recorder.Events, 2)
+}
+
+func TestHandle_IgnoreFailure(t *testing.T) {
+	decoder, err := admission.NewDecoder(scheme.Scheme)
+	require.NoError(t, err)
+
+	inj, instance := createPodInjector(t, decoder)
+	instance.Spec.CodeModules.Webhook.FailurePolicy = string(admissionregistrationv1.Ignore)
+	require.NoError(t, inj.client.Update(context.TODO(), instance))
+	inj.failures = newFailureCounter(inj.client, "dynatrace")
+
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pod",
+			Namespace:   "test-namespace",
+			Annotations: map[string]string{dtwebhook.AnnotationFlavor: "invalid"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "alpine"}},
+		},
+	}
+	podBytes, err := json.Marshal(&pod)
+	require.NoError(t, err)
+
+	resp := inj.Handle(context.TODO(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Object:    runtime.RawExtension{Raw: podBytes},
+			Namespace: "test-namespace",
+		},
+	})
+	require.True(t, resp.Allowed)
+	assert.Empty(t, resp.Patches)
+	require.Len(t, resp.Warnings, 1)
+	assert.Contains(t, resp.Warnings[0], "OneAgent hasn't been injected")
+
+	// The failure is still reported
+	assert.Len(t, inj.failures.failures, 1)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	dtwebhook "github.com/Dynatrace/dynatrace-operator/webhook"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// resolveFlavor returns the flavor of the OneAgent package for the injected containers of a Pod.
+// The annotation of the Pod takes precedence, otherwise the flavor rules of the DynaKube are applied to the images of the
+// containers. If the containers require different flavors, or one is not matched by any rule, multidistro is used.
+func resolveFlavor(pod *corev1.Pod, dk *dynatracev1alpha1.DynaKube, containers []*corev1.Container) (string, error) {
+	if flavor, ok := pod.Annotations[dtwebhook.AnnotationFlavor]; ok {
+		if !dtcsi.IsValidFlavor(flavor) {
+			return "", fmt.Errorf("invalid value '%s' for annotation %s", flavor, dtwebhook.AnnotationFlavor)
+		}
+		return flavor, nil
+	}
+
+	resolved := ""
+	for _, c := range containers {
+		flavor := matchFlavorRule(dk.Spec.CodeModules.FlavorRules, c.Image)
+		if flavor == "" || (resolved != "" && flavor != resolved) {
+			return dtclient.FlavorMultidistro, nil
+		}
+		resolved = flavor
+	}
+
+	if resolved == "" {
+		return dtclient.FlavorMultidistro, nil
+	}
+	return resolved, nil
+}
+
+func matchFlavorRule(rules []dynatracev1alpha1.FlavorRule, image string) string {
+	for _, rule := range rules {
+		if dtcsi.IsValidFlavor(rule.Flavor) && matchesWildcard(rule.Image, image) {
+			return rule.Flavor
+		}
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	"github.com/Dynatrace/dynatrace-operator/dtclient"
+	dtwebhook "github.com/Dynatrace/dynatrace-operator/webhook"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolveFlavor(t *testing.T) {
+	dk := &dynatracev1alpha1.DynaKube{
+		Spec: dynatracev1alpha1.DynaKubeSpec{
+			CodeModules: dynatracev1alpha1.CodeModulesSpec{
+				FlavorRules: []dynatracev1alpha1.FlavorRule{
+					{Image: "*alpine*", Flavor: dtclient.FlavorMUSL},
+					{Image: "registry.example.com/*", Flavor: dtclient.FlavorDefault},
+				},
+			},
+		},
+	}
+	alpine := &corev1.Container{Name: "alpine", Image: "docker.io/library/alpine:3.13"}
+	ubi := &corev1.Container{Name: "ubi", Image: "registry.example.com/ubi8:latest"}
+	other := &corev1.Container{Name: "other", Image: "busybox"}
+
+	resolve := func(annotations map[string]string, containers ...*corev1.Container) (string, error) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+		return resolveFlavor(pod, dk, containers)
+	}
+
+	t.Run(`annotation takes precedence`, func(t *testing.T) {
+		flavor, err := resolve(map[string]string{dtwebhook.AnnotationFlavor: dtclient.FlavorDefault}, alpine)
+		assert.NoError(t, err)
+		assert.Equal(t, dtclient.FlavorDefault, flavor)
+	})
+	t.Run(`invalid annotation`, func(t *testing.T) {
+		_, err := resolve(map[string]string{dtwebhook.AnnotationFlavor: "glibc"}, alpine)
+		assert.EqualError(t, err, "invalid value 'glibc' for annotation oneagent.dynatrace.com/flavor")
+	})
+	t.Run(`flavor of matching rule`, func(t *testing.T) {
+		flavor, err := resolve(nil, alpine)
+		assert.NoError(t, err)
+		assert.Equal(t, dtclient.FlavorMUSL, flavor)
+
+		flavor, err = resolve(nil, ubi)
+		assert.NoError(t, err)
+		assert.Equal(t, dtclient.FlavorDefault, flavor)
+	})
+	t.Run(`multidistro for different flavors`, func(t *testing.T) {
+		flavor, err := resolve(nil, alpine, ubi)
+		assert.NoError(t, err)
+		assert.Equal(t, dtclient.FlavorMultidistro, flavor)
+	})
+	t.Run(`multidistro for containers without matching rule`, func(t *testing.T) {
+		flavor, err := resolve(nil, alpine, other)
+		assert.NoError(t, err)
+		assert.Equal(t, dtclient.FlavorMultidistro, flavor)
+	})
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	dtwebhook "github.com/Dynatrace/dynatrace-operator/webhook"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// EnvTags and EnvCustomProperties are the environment variables of the injected containers holding the metadata of
+	// the pod, they are appended to values set by the containers themselves
+	EnvTags             = "DT_TAGS"
+	EnvCustomProperties = "DT_CUSTOM_PROP"
+
+	podLabelPrefix       = "k8s.pod.label."
+	podAnnotationPrefix  = "k8s.pod.annotation."
+	namespaceLabelPrefix = "k8s.namespace.label."
+	workloadKindKey      = "k8s.workload.kind"
+	workloadNameKey      = "k8s.workload.name"
+)
+
+// podMetadata holds the metadata propagated to the injected containers, if metadata enrichment is enabled
+type podMetadata struct {
+	workloadKind     string
+	workloadName     string
+	tags             []string
+	customProperties []string
+}
+
+// buildPodMetadata collects the pod labels, pod annotations and namespace labels allowed by the metadata enrichment of
+// the DynaKube and resolves the workload owning the pod. Returns nil if metadata enrichment is disabled.
+func buildPodMetadata(ctx context.Context, apiReader client.Reader, pod *corev1.Pod, ns *corev1.Namespace, dk *dynatracev1alpha1.DynaKube) (*podMetadata, error) {
+	spec := &dk.Spec.CodeModules.MetadataEnrichment
+	if !spec.Enabled {
+		return nil, nil
+	}
+
+	metadata := &podMetadata{}
+	metadata.tags = appendProperties(metadata.tags, podLabelPrefix, pod.Labels, spec.PodLabels)
+	metadata.tags = appendProperties(metadata.tags, namespaceLabelPrefix, ns.Labels, spec.NamespaceLabels)
+	metadata.customProperties = appendProperties(metadata.customProperties, podAnnotationPrefix, pod.Annotations, spec.PodAnnotations)
+
+	kind, name, err := dtwebhook.FindWorkload(ctx, apiReader, pod, ns.Name)
+	if err != nil {
+		return nil, err
+	}
+	if kind != "" {
+		metadata.workloadKind, metadata.workloadName = kind, name
+		metadata.customProperties = append(metadata.customProperties,
+			formatProperty(workloadKindKey, kind),
+			formatProperty(workloadNameKey, name))
+	}
+
+	return metadata, nil
+}
+
+// appendProperties appends the values of the allowed keys in the order of the allow-list
+func appendProperties(properties []string, prefix string, values map[string]string, allowed []string) []string {
+	for _, key := range allowed {
+		if value, ok := values[key]; ok {
+			properties = append(properties, formatProperty(prefix+key, value))
+		}
+	}
+	return properties
+}
+
+// formatProperty formats the key and value as key=value, whitespace is replaced since properties are separated by spaces
+func formatProperty(key string, value string) string {
+	return fmt.Sprintf("%s=%s", key, strings.Join(strings.Fields(value), "_"))
+}
+
+// addMetadataEnv appends the metadata to the DT_TAGS and DT_CUSTOM_PROP environment variables of the container
+func addMetadataEnv(c *corev1.Container, metadata *podMetadata) {
+	if metadata == nil {
+		return
+	}
+	appendEnvValue(c, EnvTags, strings.Join(metadata.tags, " "))
+	appendEnvValue(c, EnvCustomProperties, strings.Join(metadata.customProperties, " "))
+}
+
+func appendEnvValue(c *corev1.Container, name string, value string) {
+	if value == "" {
+		return
+	}
+
+	for i := range c.Env {
+		e := &c.Env[i]
+		if e.Name != name {
+			continue
+		}
+		// Values referenced from other sources can't be merged, a second variable would silently override them
+		if e.ValueFrom != nil {
+			logger.Info("not adding metadata to environment variable set from another source", "container", c.Name, "env", name)
+			return
+		}
+		if e.Value != "" {
+			value = e.Value + " " + value
+		}
+		e.Value = value
+		return
+	}
+	c.Env = append(c.Env, corev1.EnvVar{Name: name, Value: value})
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dynatrace/dynatrace-operator/scheme"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestMetadataEnrichment(t *testing.T) {
+	decoder, err := admission.NewDecoder(scheme.Scheme)
+	require.NoError(t, err)
+
+	controller := true
+	newPod := func() *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "app-1234-abcd",
+				Namespace:   "test-namespace",
+				Labels:      map[string]string{"app": "shop", "tier": "frontend", "ignored": "true"},
+				Annotations: map[string]string{"owner": "team checkout"},
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-1234", Controller: &controller},
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name:  "app",
+					Image: "alpine",
+					Env:   []corev1.EnvVar{{Name: EnvTags, Value: "existing=tag"}},
+				}},
+			},
+		}
+	}
+
+	t.Run(`enrich injected containers`, func(t *testing.T) {
+		inj, instance := createPodInjector(t, decoder)
+		instance.Spec.CodeModules.MetadataEnrichment.Enabled = true
+		instance.Spec.CodeModules.MetadataEnrichment.PodLabels = []string{"tier", "app", "missing"}
+		instance.Spec.CodeModules.MetadataEnrichment.PodAnnotations = []string{"owner"}
+		instance.Spec.CodeModules.MetadataEnrichment.NamespaceLabels = []string{"oneagent.dynatrace.com/instance"}
+		require.NoError(t, inj.client.Update(context.TODO(), instance))
+		require.NoError(t, inj.client.Create(context.TODO(), &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "app-1234",
+				Namespace: "test-namespace",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Controller: &controller},
+				},
+			},
+		}))
+
+		pod := newPod()
+		result := inj.injectPod(context.TODO(), pod, "test-namespace")
+		require.NoError(t, result.err)
+		require.Equal(t, DecisionInject, result.decision)
+
+		env := map[string]string{}
+		for _, e := range pod.Spec.Containers[0].Env {
+			env[e.Name] = e.Value
+		}
+		assert.Equal(t, "existing=tag k8s.pod.label.tier=frontend k8s.pod.label.app=shop k8s.namespace.label.oneagent.dynatrace.com/instance=oneagent", env[EnvTags])
+		assert.Equal(t, "k8s.pod.annotation.owner=team_checkout k8s.workload.kind=Deployment k8s.workload.name=app", env[EnvCustomProperties])
+
+		installEnv := map[string]string{}
+		for _, e := range pod.Spec.InitContainers[0].Env {
+			installEnv[e.Name] = e.Value
+		}
+		assert.Equal(t, "Deployment", installEnv["K8S_WORKLOAD_KIND"])
+		assert.Equal(t, "app", installEnv["K8S_WORKLOAD_NAME"])
+	})
+	t.Run(`fall back to owner if replicaset is missing`, func(t *testing.T) {
+		inj, instance := createPodInjector(t, decoder)
+		instance.Spec.CodeModules.MetadataEnrichment.Enabled = true
+		require.NoError(t, inj.client.Update(context.TODO(), instance))
+
+		pod := newPod()
+		var ns corev1.Namespace
+		require.NoError(t, inj.client.Get(context.TODO(), client.ObjectKey{Name: "test-namespace"}, &ns))
+
+		metadata, err := buildPodMetadata(context.TODO(), inj.apiReader, pod, &ns, instance)
+		require.NoError(t, err)
+		assert.Equal(t, "ReplicaSet", metadata.workloadKind)
+		assert.Equal(t, "app-1234", metadata.workloadName)
+		assert.Empty(t, metadata.tags)
+	})
+	t.Run(`no enrichment if disabled`, func(t *testing.T) {
+		inj, _ := createPodInjector(t, decoder)
+
+		pod := newPod()
+		result := inj.injectPod(context.TODO(), pod, "test-namespace")
+		require.NoError(t, result.err)
+
+		for _, e := range pod.Spec.Containers[0].Env {
+			assert.NotEqual(t, EnvCustomProperties, e.Name)
+			if e.Name == EnvTags {
+				assert.Equal(t, "existing=tag", e.Value)
+			}
+		}
+		for _, e := range pod.Spec.InitContainers[0].Env {
+			assert.NotEqual(t, "K8S_WORKLOAD_KIND", e.Name)
+		}
+	})
+	t.Run(`keep environment variables set from other sources`, func(t *testing.T) {
+		fromConfigMap := &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "tags"},
+			Key:                  "tags",
+		}}
+		c := &corev1.Container{Name: "app", Env: []corev1.EnvVar{{Name: EnvTags, ValueFrom: fromConfigMap}}}
+
+		addMetadataEnv(c, &podMetadata{
+			tags:             []string{"k8s.pod.label.app=shop"},
+			customProperties: []string{"k8s.workload.kind=Deployment"},
+		})
+
+		assert.Equal(t, []corev1.EnvVar{
+			{Name: EnvTags, ValueFrom: fromConfigMap},
+			{Name: EnvCustomProperties, Value: "k8s.workload.kind=Deployment"},
+		}, c.Env)
+	})
+}
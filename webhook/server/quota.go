@@ -0,0 +1,29 @@
+package server
+
+import (
+	"fmt"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	dtwebhook "github.com/Dynatrace/dynatrace-operator/webhook"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// resolveVolumeQuota returns the quota of the volume mounted by the CSI driver for a Pod. The annotation of the Pod
+// takes precedence over the volume quota of the DynaKube. An empty quota doesn't limit the volume.
+func resolveVolumeQuota(pod *corev1.Pod, dk *dynatracev1alpha1.DynaKube) (string, error) {
+	if quota, ok := pod.Annotations[dtwebhook.AnnotationVolumeQuota]; ok {
+		if quota == "" {
+			return "", nil
+		}
+		if q, err := resource.ParseQuantity(quota); err != nil || q.Sign() <= 0 {
+			return "", fmt.Errorf("invalid value '%s' for annotation %s of the pod", quota, dtwebhook.AnnotationVolumeQuota)
+		}
+		return quota, nil
+	}
+
+	if dk.Spec.CodeModules.VolumeQuota != nil && dk.Spec.CodeModules.VolumeQuota.Sign() > 0 {
+		return dk.Spec.CodeModules.VolumeQuota.String(), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	dtwebhook "github.com/Dynatrace/dynatrace-operator/webhook"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolveVolumeQuota(t *testing.T) {
+	quota := resource.MustParse("100Mi")
+	dk := &dynatracev1alpha1.DynaKube{
+		ObjectMeta: metav1.ObjectMeta{Name: "dynakube"},
+		Spec: dynatracev1alpha1.DynaKubeSpec{
+			CodeModules: dynatracev1alpha1.CodeModulesSpec{VolumeQuota: &quota},
+		},
+	}
+
+	resolve := func(podAnnotations map[string]string, dk *dynatracev1alpha1.DynaKube) (string, error) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations}}
+		return resolveVolumeQuota(pod, dk)
+	}
+
+	t.Run(`quota of DynaKube`, func(t *testing.T) {
+		quota, err := resolve(nil, dk)
+		assert.NoError(t, err)
+		assert.Equal(t, "100Mi", quota)
+	})
+	t.Run(`no quota`, func(t *testing.T) {
+		quota, err := resolve(nil, &dynatracev1alpha1.DynaKube{})
+		assert.NoError(t, err)
+		assert.Empty(t, quota)
+	})
+	t.Run(`pod annotation overrides DynaKube`, func(t *testing.T) {
+		quota, err := resolve(map[string]string{dtwebhook.AnnotationVolumeQuota: "1Gi"}, dk)
+		assert.NoError(t, err)
+		assert.Equal(t, "1Gi", quota)
+	})
+	t.Run(`empty annotation removes quota`, func(t *testing.T) {
+		quota, err := resolve(map[string]string{dtwebhook.AnnotationVolumeQuota: ""}, dk)
+		assert.NoError(t, err)
+		assert.Empty(t, quota)
+	})
+	t.Run(`invalid annotation`, func(t *testing.T) {
+		_, err := resolve(map[string]string{dtwebhook.AnnotationVolumeQuota: "lots"}, dk)
+		assert.EqualError(t, err, "invalid value 'lots' for annotation oneagent.dynatrace.com/volume-quota of the pod")
+	})
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -18,8 +19,8 @@ import (
 	"github.com/Dynatrace/dynatrace-operator/dtclient"
 	dtwebhook "github.com/Dynatrace/dynatrace-operator/webhook"
 	corev1 "k8s.io/api/core/v1"
-	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -37,10 +38,15 @@ func AddToManager(mgr manager.Manager, ns string) error {
 		logger.Info("No Pod name set for webhook container")
 	}
 
+	failures := newFailureCounter(mgr.GetClient(), ns)
+	if err := mgr.Add(failures); err != nil {
+		return err
+	}
+
 	if podName == "" && debug == "true" {
-		registerDebugInjectEndpoint(mgr, ns)
+		registerDebugInjectEndpoint(mgr, ns, failures)
 	} else {
-		if err := registerInjectEndpoint(mgr, ns, podName); err != nil {
+		if err := registerInjectEndpoint(mgr, ns, podName, failures); err != nil {
 			return err
 		}
 	}
@@ -57,13 +63,18 @@ func AddToManager(mgr manager.Manager, ns string) error {
 // to allow further debugging steps.
 //
 // This behavior must only occur if the DEBUG_OPERATOR flag is set to true
-func registerDebugInjectEndpoint(mgr manager.Manager, ns string) {
-	mgr.GetWebhookServer().Register("/inject", &webhook.Admission{Handler: &podInjector{
+func registerDebugInjectEndpoint(mgr manager.Manager, ns string, failures *failureCounter) {
+	injector := &podInjector{
+		apiReader: mgr.GetAPIReader(),
 		namespace: ns,
-	}})
+		recorder:  mgr.GetEventRecorderFor(dtwebhook.ServiceName),
+		failures:  failures,
+	}
+	mgr.GetWebhookServer().Register("/inject", &webhook.Admission{Handler: injector})
+	mgr.GetWebhookServer().Register(ExplainPath, &explainHandler{injector: injector})
 }
 
-func registerInjectEndpoint(mgr manager.Manager, ns string, podName string) error {
+func registerInjectEndpoint(mgr manager.Manager, ns string, podName string, failures *failureCounter) error {
 	// Don't use mgr.GetClient() on this function, or other cache-dependent functions from the manager. The cache may
 	// not be ready at this point, and queries for Kubernetes objects may fail. mgr.GetAPIReader() doesn't depend on the
 	// cache and is safe to use.
@@ -89,12 +100,18 @@ func registerInjectEndpoint(mgr manager.Manager, ns string, podName string) erro
 		return err
 	}
 
-	mgr.GetWebhookServer().Register("/inject", &webhook.Admission{Handler: &podInjector{
+	injector := &podInjector{
+		apiReader: mgr.GetAPIReader(),
 		namespace: ns,
+		recorder:  mgr.GetEventRecorderFor(dtwebhook.ServiceName),
 		image:     pod.Spec.Containers[0].Image,
 		apmExists: apmExists,
 		clusterID: string(UID),
-	}})
+		failures:  failures,
+	}
+	mgr.GetWebhookServer().Register("/inject", &webhook.Admission{Handler: injector})
+	// The client is injected into the podInjector by the admission webhook, which is registered first
+	mgr.GetWebhookServer().Register(ExplainPath, &explainHandler{injector: injector})
 	return nil
 }
 
@@ -107,19 +124,18 @@ func registerHealthzEndpoint(mgr manager.Manager) {
 // podAnnotator injects the OneAgent into Pods
 type podInjector struct {
 	client    client.Client
+	apiReader client.Reader
 	decoder   *admission.Decoder
+	recorder  record.EventRecorder
 	image     string
 	namespace string
 	apmExists bool
 	clusterID string
+	failures  *failureCounter
 }
 
 // podAnnotator adds an annotation to every incoming pods
 func (m *podInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
-	if m.apmExists {
-		return admission.Patched("")
-	}
-
 	pod := &corev1.Pod{}
 
 	err := m.decoder.Decode(req, pod)
@@ -127,35 +143,71 @@ func (m *podInjector) Handle(ctx context.Context, req admission.Request) admissi
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
-	logger.Info("injecting into Pod", "name", pod.Name, "generatedName", pod.GenerateName, "namespace", req.Namespace)
+	result := m.injectPod(ctx, pod, req.Namespace)
+	if result.err != nil {
+		// The webhook is declared to have no side effects on dry-runs
+		if req.DryRun == nil || !*req.DryRun {
+			m.reportFailure(pod, req.Namespace, result)
+		}
+		if result.ignoresFailure() {
+			return admission.Allowed(result.reason).WithWarnings(fmt.Sprintf("OneAgent hasn't been injected: %s", result.reason))
+		}
+		return admission.Errored(result.code, result.err)
+	} else if !result.patched {
+		return admission.Patched(result.reason)
+	}
+	return getResponse(pod, &req)
+}
+
+// injectPod decides whether the pod in the given Namespace is injected and modifies it accordingly. The cluster is only
+// read, so the decision can be explained without admitting the pod.
+func (m *podInjector) injectPod(ctx context.Context, pod *corev1.Pod, namespace string) injectionResult {
+	if m.apmExists {
+		return skip("OneAgentAPM object exists, no injection until the OneAgent Operator has been uninstalled")
+	}
+
+	logger.Info("injecting into Pod", "name", pod.Name, "generatedName", pod.GenerateName, "namespace", namespace)
 
 	var ns corev1.Namespace
-	if err := m.client.Get(ctx, client.ObjectKey{Name: req.Namespace}, &ns); err != nil {
-		return admission.Errored(http.StatusInternalServerError, err)
+	if err := m.client.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		return reject(http.StatusInternalServerError, err)
 	}
 
-	inject := utils.GetField(ns.Annotations, dtwebhook.AnnotationInject, "true")
-	inject = utils.GetField(pod.Annotations, dtwebhook.AnnotationInject, inject)
-	if inject == "false" {
-		return admission.Patched("")
+	// The annotation of the pod has higher priority than the one of the Namespace
+	if podInject := utils.GetField(pod.Annotations, dtwebhook.AnnotationInject, ""); podInject == "false" {
+		return skip(fmt.Sprintf("injection disabled by the %s annotation of the pod", dtwebhook.AnnotationInject))
+	} else if podInject == "" && ns.Annotations[dtwebhook.AnnotationInject] == "false" {
+		return skip(fmt.Sprintf("injection disabled by the %s annotation of the namespace", dtwebhook.AnnotationInject))
 	}
 
-	oaName := utils.GetField(ns.Labels, dtwebhook.LabelInstance, "")
-	if oaName == "" {
-		return admission.Errored(http.StatusBadRequest, fmt.Errorf("no DynaKube instance set for namespace: %s", req.Namespace))
+	var dynakubes dynatracev1alpha1.DynaKubeList
+	if err := m.client.List(ctx, &dynakubes, client.InNamespace(m.namespace)); err != nil {
+		return reject(http.StatusInternalServerError, err)
 	}
 
-	var oa dynatracev1alpha1.DynaKube
-	if err := m.client.Get(ctx, client.ObjectKey{Name: oaName, Namespace: m.namespace}, &oa); k8serrors.IsNotFound(err) {
-		return admission.Errored(http.StatusBadRequest, fmt.Errorf(
-			"namespace '%s' is assigned to DynaKube instance '%s' but doesn't exist", req.Namespace, oaName))
+	// Conflicts are reported on the DynaKubes, the pod is admitted without being injected
+	var conflict *dtwebhook.ConflictError
+	dk, err := dtwebhook.FindDynaKube(&ns, dynakubes.Items)
+	if errors.As(err, &conflict) {
+		logger.Info("namespace matched by multiple DynaKubes", "namespace", namespace, "dynakubes", conflict.DynaKubes)
+		return skip(conflict.Error())
 	} else if err != nil {
-		return admission.Errored(http.StatusInternalServerError, err)
+		return reject(http.StatusBadRequest, err)
+	} else if dk == nil {
+		return reject(http.StatusBadRequest, fmt.Errorf("no DynaKube instance set for namespace: %s", namespace))
 	}
+	oa := *dk
 
 	if !oa.Spec.CodeModules.Enabled {
 		logger.Info("injection disabled")
-		return admission.Patched("")
+		return skip(fmt.Sprintf("code modules are disabled in DynaKube %s", oa.Name)).withDynaKube(&oa)
+	}
+
+	if selected, err := dtwebhook.MatchesPodSelector(&oa, pod); err != nil {
+		return reject(http.StatusInternalServerError, err).withDynaKube(&oa)
+	} else if !selected {
+		logger.Info("pod not matched by podSelector of DynaKube", "dynakube", oa.Name)
+		return skip(fmt.Sprintf("pod not matched by the podSelector of DynaKube %s", oa.Name)).withDynaKube(&oa)
 	}
 
 	if pod.Annotations == nil {
@@ -164,10 +216,19 @@ func (m *podInjector) Handle(ctx context.Context, req admission.Request) admissi
 
 	if pod.Annotations[dtwebhook.AnnotationInjected] == "true" {
 		if oa.FeatureEnableWebhookReinvocationPolicy() {
+			metadata, err := buildPodMetadata(ctx, m.apiReader, pod, &ns, &oa)
+			if err != nil {
+				return reject(http.StatusInternalServerError, err).withDynaKube(&oa)
+			}
+
 			var needsUpdate = false
 			var installContainer *corev1.Container
+			selector := newContainerSelector(pod, &oa)
 			for i := range pod.Spec.Containers {
 				c := &pod.Spec.Containers[i]
+				if !selector.isSelected(c) {
+					continue
+				}
 
 				preloaded := false
 				for _, e := range c.Env {
@@ -182,7 +243,7 @@ func (m *podInjector) Handle(ctx context.Context, req admission.Request) admissi
 					logger.Info("instrumenting missing container", "name", c.Name)
 
 					deploymentMetadata := deploymentmetadata.NewDeploymentMetadata(m.clusterID)
-					updateContainer(c, &oa, pod, deploymentMetadata)
+					updateContainer(c, &oa, pod, deploymentMetadata, metadata)
 
 					if installContainer == nil {
 						for j := range pod.Spec.InitContainers {
@@ -194,7 +255,12 @@ func (m *podInjector) Handle(ctx context.Context, req admission.Request) admissi
 							}
 						}
 					}
-					updateInstallContainer(installContainer, i+1, c.Name, c.Image)
+					if installContainer == nil {
+						return reject(http.StatusInternalServerError,
+							fmt.Errorf("pod is annotated as injected but has no %s init container", dtwebhook.InstallContainerName)).withDynaKube(&oa)
+					}
+					updateInstallContainer(installContainer, countInstrumentedContainers(installContainer)+1, c.Name, c.Image)
+					updateContainersCount(installContainer)
 
 					needsUpdate = true
 				}
@@ -202,17 +268,51 @@ func (m *podInjector) Handle(ctx context.Context, req admission.Request) admissi
 
 			if needsUpdate {
 				logger.Info("updating pod with missing containers")
-				return getResponse(pod, &req)
+				return injectionResult{
+					decision: DecisionUpdate,
+					reason:   "pod already injected, missing containers are instrumented",
+					patched:  true,
+				}.withDynaKube(&oa)
 			}
 		}
 
-		return admission.Patched("")
+		return skip("pod already injected").withDynaKube(&oa)
+	}
+
+	selector := newContainerSelector(pod, &oa)
+	var selectedContainers []*corev1.Container
+	for i := range pod.Spec.Containers {
+		if c := &pod.Spec.Containers[i]; selector.isSelected(c) {
+			selectedContainers = append(selectedContainers, c)
+		}
+	}
+	if len(selectedContainers) == 0 {
+		logger.Info("no container of pod selected for injection", "name", pod.Name)
+		pod.Annotations[dtwebhook.AnnotationInjected] = "false"
+		result := skip("no container of the pod selected for injection").withDynaKube(&oa)
+		result.patched = true
+		return result
+	}
+
+	flavor, err := resolveFlavor(pod, &oa, selectedContainers)
+	if err != nil {
+		return reject(http.StatusBadRequest, err).withDynaKube(&oa)
 	}
+
+	metadata, err := buildPodMetadata(ctx, m.apiReader, pod, &ns, &oa)
+	if err != nil {
+		return reject(http.StatusInternalServerError, err).withDynaKube(&oa)
+	}
+
 	pod.Annotations[dtwebhook.AnnotationInjected] = "true"
+	pod.Annotations[dtwebhook.AnnotationDynaKube] = oa.Name
 
 	technologies := url.QueryEscape(utils.GetField(pod.Annotations, dtwebhook.AnnotationTechnologies, "all"))
 	installPath := utils.GetField(pod.Annotations, dtwebhook.AnnotationInstallPath, dtwebhook.DefaultInstallPath)
 	installerURL := utils.GetField(pod.Annotations, dtwebhook.AnnotationInstallerUrl, "")
+	if installerURL == "" && oa.NeedsDownloadCache() {
+		installerURL = buildDownloadCacheURL(pod, &oa, flavor, utils.GetField(pod.Annotations, dtwebhook.AnnotationTechnologies, "all"))
+	}
 	failurePolicy := utils.GetField(pod.Annotations, dtwebhook.AnnotationFailurePolicy, "silent")
 	image := m.image
 
@@ -223,6 +323,44 @@ func (m *podInjector) Handle(ctx context.Context, req admission.Request) admissi
 		}
 	}
 
+	if dkVol.CSI != nil {
+		version, err := resolveVersion(pod, &ns, &oa)
+		if err != nil {
+			return reject(http.StatusBadRequest, err).withDynaKube(&oa)
+		}
+
+		quota, err := resolveVolumeQuota(pod, &oa)
+		if err != nil {
+			return reject(http.StatusBadRequest, err).withDynaKube(&oa)
+		}
+
+		attributes := map[string]string{}
+		if quota != "" {
+			attributes[dtcsi.QuotaVolumeAttribute] = quota
+		}
+		if flavor != dtclient.FlavorMultidistro {
+			attributes[dtcsi.FlavorVolumeAttribute] = flavor
+		}
+		if version != "" {
+			attributes[dtcsi.VersionVolumeAttribute] = version
+		}
+
+		readOnly := pod.Annotations[dtwebhook.AnnotationReadOnlyVolume] == "true"
+
+		if len(attributes) > 0 || readOnly {
+			dkVol.CSI = dkVol.CSI.DeepCopy()
+			if dkVol.CSI.VolumeAttributes == nil {
+				dkVol.CSI.VolumeAttributes = map[string]string{}
+			}
+			for key, value := range attributes {
+				dkVol.CSI.VolumeAttributes[key] = value
+			}
+			if readOnly {
+				dkVol.CSI.ReadOnly = &readOnly
+			}
+		}
+	}
+
 	mode := "provisioned"
 	if dkVol.EmptyDir != nil {
 		mode = "installer"
@@ -270,15 +408,14 @@ func (m *podInjector) Handle(ctx context.Context, req admission.Request) admissi
 		Name:            dtwebhook.InstallContainerName,
 		Image:           image,
 		ImagePullPolicy: corev1.PullAlways,
-		Command:         []string{"/usr/bin/env"},
-		Args:            []string{"bash", "/mnt/config/init.sh"},
+		Args:            []string{"install-oneagent"},
 		Env: []corev1.EnvVar{
-			{Name: "FLAVOR", Value: dtclient.FlavorMultidistro},
+			{Name: "FLAVOR", Value: flavor},
 			{Name: "TECHNOLOGIES", Value: technologies},
 			{Name: "INSTALLPATH", Value: installPath},
 			{Name: "INSTALLER_URL", Value: installerURL},
 			{Name: "FAILURE_POLICY", Value: failurePolicy},
-			{Name: "CONTAINERS_COUNT", Value: strconv.Itoa(len(pod.Spec.Containers))},
+			{Name: "CONTAINERS_COUNT", Value: strconv.Itoa(len(selectedContainers))},
 			{Name: "MODE", Value: mode},
 			{Name: "K8S_PODNAME", ValueFrom: fieldEnvVar("metadata.name")},
 			{Name: "K8S_PODUID", ValueFrom: fieldEnvVar("metadata.uid")},
@@ -295,17 +432,25 @@ func (m *podInjector) Handle(ctx context.Context, req admission.Request) admissi
 		Resources: oa.Spec.CodeModules.Resources,
 	}
 
-	for i := range pod.Spec.Containers {
-		c := &pod.Spec.Containers[i]
+	if metadata != nil && metadata.workloadKind != "" {
+		ic.Env = append(ic.Env,
+			corev1.EnvVar{Name: "K8S_WORKLOAD_KIND", Value: metadata.workloadKind},
+			corev1.EnvVar{Name: "K8S_WORKLOAD_NAME", Value: metadata.workloadName})
+	}
 
+	for i, c := range selectedContainers {
 		updateInstallContainer(&ic, i+1, c.Name, c.Image)
 
-		updateContainer(c, &oa, pod, deploymentMetadata)
+		updateContainer(c, &oa, pod, deploymentMetadata, metadata)
 	}
 
 	pod.Spec.InitContainers = append(pod.Spec.InitContainers, ic)
 
-	return getResponse(pod, &req)
+	return injectionResult{
+		decision: DecisionInject,
+		reason:   fmt.Sprintf("%d container(s) selected for injection", len(selectedContainers)),
+		patched:  true,
+	}.withDynaKube(&oa)
 }
 
 // InjectClient injects the client
@@ -328,9 +473,30 @@ func updateInstallContainer(ic *corev1.Container, number int, name string, image
 		corev1.EnvVar{Name: fmt.Sprintf("CONTAINER_%d_IMAGE", number), Value: image})
 }
 
-// updateContainer sets missing preload Variables
+// countInstrumentedContainers returns the number of containers already listed in the Install Container
+func countInstrumentedContainers(ic *corev1.Container) int {
+	count := 0
+	for _, e := range ic.Env {
+		if strings.HasPrefix(e.Name, "CONTAINER_") && strings.HasSuffix(e.Name, "_NAME") {
+			count++
+		}
+	}
+	return count
+}
+
+// updateContainersCount sets CONTAINERS_COUNT of the Install Container to the number of listed containers, if present
+func updateContainersCount(ic *corev1.Container) {
+	for i := range ic.Env {
+		if ic.Env[i].Name == "CONTAINERS_COUNT" {
+			ic.Env[i].Value = strconv.Itoa(countInstrumentedContainers(ic))
+			return
+		}
+	}
+}
+
+// updateContainer sets missing preload Variables and the metadata of the pod, if enriched
 func updateContainer(c *corev1.Container, oa *dynatracev1alpha1.DynaKube,
-	pod *corev1.Pod, deploymentMetadata *deploymentmetadata.DeploymentMetadata) {
+	pod *corev1.Pod, deploymentMetadata *deploymentmetadata.DeploymentMetadata, metadata *podMetadata) {
 
 	logger.Info("updating container with missing preload variables", "containerName", c.Name)
 	installPath := utils.GetField(pod.Annotations, dtwebhook.AnnotationInstallPath, dtwebhook.DefaultInstallPath)
@@ -379,6 +545,8 @@ func updateContainer(c *corev1.Container, oa *dynatracev1alpha1.DynaKube,
 	if oa.Spec.NetworkZone != "" {
 		c.Env = append(c.Env, corev1.EnvVar{Name: "DT_NETWORK_ZONE", Value: oa.Spec.NetworkZone})
 	}
+
+	addMetadataEnv(c, metadata)
 }
 
 // getResponse tries to format pod as json
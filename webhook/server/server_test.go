@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"testing"
 
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
@@ -89,16 +91,19 @@ func createPodInjector(_ *testing.T, decoder *admission.Decoder) (*podInjector,
 		},
 	}
 
-	return &podInjector{
-		client: fake.NewClient(
-			dynakube,
-			&corev1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:   "test-namespace",
-					Labels: map[string]string{"oneagent.dynatrace.com/instance": "oneagent"},
-				},
+	clt := fake.NewClient(
+		dynakube,
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "test-namespace",
+				Labels: map[string]string{"oneagent.dynatrace.com/instance": "oneagent"},
 			},
-		),
+		},
+	)
+
+	return &podInjector{
+		client:    clt,
+		apiReader: clt,
 		decoder:   decoder,
 		image:     "test-api-url.com/linux/codemodule",
 		namespace: "dynatrace",
@@ -182,6 +187,161 @@ func TestPodInjection(t *testing.T) {
 	assert.Equal(t, expected, updPod)
 }
 
+func TestPodInjectionWithPodSelector(t *testing.T) {
+	decoder, err := admission.NewDecoder(scheme.Scheme)
+	require.NoError(t, err)
+
+	inj, instance := createPodInjector(t, decoder)
+	instance.Spec.CodeModules.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"inject": "true"}}
+	err = inj.client.Update(context.TODO(), instance)
+	require.NoError(t, err)
+
+	inject := func(podLabels map[string]string) admission.Response {
+		basePod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod-12345", Namespace: "test-namespace", Labels: podLabels},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name:  "test-container",
+					Image: "alpine",
+				}},
+			},
+		}
+		basePodBytes, err := json.Marshal(&basePod)
+		require.NoError(t, err)
+
+		req := admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Object:    runtime.RawExtension{Raw: basePodBytes},
+				Namespace: "test-namespace",
+			},
+		}
+		resp := inj.Handle(context.TODO(), req)
+		require.NoError(t, resp.Complete(req))
+		require.True(t, resp.Allowed)
+		return resp
+	}
+
+	assert.Empty(t, inject(nil).Patch)
+	assert.NotEmpty(t, inject(map[string]string{"inject": "true"}).Patch)
+}
+
+func TestPodInjectionWithExcludedContainers(t *testing.T) {
+	decoder, err := admission.NewDecoder(scheme.Scheme)
+	require.NoError(t, err)
+
+	inj, instance := createPodInjector(t, decoder)
+	instance.Spec.CodeModules.ExcludeContainers = []dynatracev1alpha1.ContainerPattern{{Image: "*/istio/proxyv2:*"}}
+	err = inj.client.Update(context.TODO(), instance)
+	require.NoError(t, err)
+
+	inject := func(annotations map[string]string) (corev1.Pod, admission.Response) {
+		basePod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod-12345", Namespace: "test-namespace", Annotations: annotations},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "app", Image: "alpine"},
+					{Name: "sidecar", Image: "docker.io/istio/proxyv2:1.9.0"},
+					{Name: "logger", Image: "fluentd"},
+				},
+			},
+		}
+		basePodBytes, err := json.Marshal(&basePod)
+		require.NoError(t, err)
+
+		req := admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Object:    runtime.RawExtension{Raw: basePodBytes},
+				Namespace: "test-namespace",
+			},
+		}
+		resp := inj.Handle(context.TODO(), req)
+		require.NoError(t, resp.Complete(req))
+		require.True(t, resp.Allowed)
+		if len(resp.Patch) == 0 {
+			return basePod, resp
+		}
+
+		patch, err := jsonpatch.DecodePatch(resp.Patch)
+		require.NoError(t, err)
+		updPodBytes, err := patch.Apply(basePodBytes)
+		require.NoError(t, err)
+
+		var updPod corev1.Pod
+		require.NoError(t, json.Unmarshal(updPodBytes, &updPod))
+		return updPod, resp
+	}
+
+	assertInjected := func(t *testing.T, pod corev1.Pod, expected ...string) {
+		ic := pod.Spec.InitContainers[0]
+		assert.Contains(t, ic.Env, corev1.EnvVar{Name: "CONTAINERS_COUNT", Value: strconv.Itoa(len(expected))})
+		for i, name := range expected {
+			assert.Contains(t, ic.Env, corev1.EnvVar{Name: fmt.Sprintf("CONTAINER_%d_NAME", i+1), Value: name})
+		}
+		for _, c := range pod.Spec.Containers {
+			injected := false
+			for _, e := range c.Env {
+				injected = injected || e.Name == "LD_PRELOAD"
+			}
+			assert.Equal(t, contains(expected, c.Name), injected, c.Name)
+		}
+	}
+
+	t.Run(`exclude containers matching a pattern`, func(t *testing.T) {
+		pod, _ := inject(nil)
+		assertInjected(t, pod, "app", "logger")
+	})
+	t.Run(`exclude containers by annotation`, func(t *testing.T) {
+		pod, _ := inject(map[string]string{dtwebhook.AnnotationExcludeContainers: "logger"})
+		assertInjected(t, pod, "app")
+	})
+	t.Run(`include containers by annotation`, func(t *testing.T) {
+		pod, _ := inject(map[string]string{dtwebhook.AnnotationIncludeContainers: "sidecar, logger"})
+		assertInjected(t, pod, "sidecar", "logger")
+	})
+	t.Run(`skip pod without selected containers`, func(t *testing.T) {
+		pod, _ := inject(map[string]string{dtwebhook.AnnotationIncludeContainers: "unknown"})
+		assert.Equal(t, "false", pod.Annotations[dtwebhook.AnnotationInjected])
+		assert.Empty(t, pod.Spec.InitContainers)
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPodInjectionWithConflictingDynaKubes(t *testing.T) {
+	decoder, err := admission.NewDecoder(scheme.Scheme)
+	require.NoError(t, err)
+
+	inj, instance := createPodInjector(t, decoder)
+	other := instance.DeepCopy()
+	other.Name = "other"
+	other.ResourceVersion = ""
+	other.Spec.CodeModules.NamespaceSelector = &metav1.LabelSelector{}
+	require.NoError(t, inj.client.Create(context.TODO(), other))
+
+	basePod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod-123456", Namespace: "test-namespace"}}
+	basePodBytes, err := json.Marshal(&basePod)
+	require.NoError(t, err)
+
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Object:    runtime.RawExtension{Raw: basePodBytes},
+			Namespace: "test-namespace",
+		},
+	}
+	resp := inj.Handle(context.TODO(), req)
+	require.NoError(t, resp.Complete(req))
+	require.True(t, resp.Allowed)
+	assert.Empty(t, resp.Patch)
+	assert.Equal(t, metav1.StatusReason("namespace 'test-namespace' is matched by multiple DynaKubes: oneagent, other"), resp.Result.Reason)
+}
+
 func TestPodInjectionWithCSI(t *testing.T) {
 	decoder, err := admission.NewDecoder(scheme.Scheme)
 	require.NoError(t, err)
@@ -881,6 +1041,7 @@ func buildResultPod(_ *testing.T) corev1.Pod {
 			Namespace: "test-namespace",
 			Annotations: map[string]string{
 				"oneagent.dynatrace.com/injected": "true",
+				"oneagent.dynatrace.com/dynakube": "oneagent",
 			},
 		},
 		Spec: corev1.PodSpec{
@@ -888,8 +1049,7 @@ func buildResultPod(_ *testing.T) corev1.Pod {
 				Name:            dtwebhook.InstallContainerName,
 				Image:           "test-image",
 				ImagePullPolicy: corev1.PullAlways,
-				Command:         []string{"/usr/bin/env"},
-				Args:            []string{"bash", "/mnt/config/init.sh"},
+				Args:            []string{"install-oneagent"},
 				Env: []corev1.EnvVar{
 					{Name: "FLAVOR", Value: dtclient.FlavorMultidistro},
 					{Name: "TECHNOLOGIES", Value: "all"},
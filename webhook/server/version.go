@@ -0,0 +1,28 @@
+package server
+
+import (
+	"fmt"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	dtcsi "github.com/Dynatrace/dynatrace-operator/controllers/csi"
+	dtwebhook "github.com/Dynatrace/dynatrace-operator/webhook"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// resolveVersion returns the version of the OneAgent package mounted by the CSI driver for a Pod. The annotation of the
+// Pod takes precedence over the one of the Namespace and the version of the DynaKube. An empty version selects the
+// version provisioned for the tenant.
+func resolveVersion(pod *corev1.Pod, ns *corev1.Namespace, dk *dynatracev1alpha1.DynaKube) (string, error) {
+	version, source := dk.Spec.CodeModules.Version, fmt.Sprintf("version of DynaKube %s", dk.Name)
+	if v, ok := ns.Annotations[dtwebhook.AnnotationVersion]; ok {
+		version, source = v, fmt.Sprintf("annotation %s of the namespace", dtwebhook.AnnotationVersion)
+	}
+	if v, ok := pod.Annotations[dtwebhook.AnnotationVersion]; ok {
+		version, source = v, fmt.Sprintf("annotation %s of the pod", dtwebhook.AnnotationVersion)
+	}
+
+	if version != "" && !dtcsi.IsValidVersion(version) {
+		return "", fmt.Errorf("invalid value '%s' for %s", version, source)
+	}
+	return version, nil
+}
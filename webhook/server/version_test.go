@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-operator/api/v1alpha1"
+	dtwebhook "github.com/Dynatrace/dynatrace-operator/webhook"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolveVersion(t *testing.T) {
+	dk := &dynatracev1alpha1.DynaKube{
+		ObjectMeta: metav1.ObjectMeta{Name: "dynakube"},
+		Spec: dynatracev1alpha1.DynaKubeSpec{
+			CodeModules: dynatracev1alpha1.CodeModulesSpec{Version: "1.200.0"},
+		},
+	}
+
+	resolve := func(podAnnotations map[string]string, nsAnnotations map[string]string) (string, error) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: podAnnotations}}
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Annotations: nsAnnotations}}
+		return resolveVersion(pod, ns, dk)
+	}
+
+	t.Run(`version of DynaKube`, func(t *testing.T) {
+		version, err := resolve(nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "1.200.0", version)
+	})
+	t.Run(`namespace annotation overrides DynaKube`, func(t *testing.T) {
+		version, err := resolve(nil, map[string]string{dtwebhook.AnnotationVersion: "1.201.0"})
+		assert.NoError(t, err)
+		assert.Equal(t, "1.201.0", version)
+	})
+	t.Run(`pod annotation overrides namespace`, func(t *testing.T) {
+		version, err := resolve(
+			map[string]string{dtwebhook.AnnotationVersion: "1.203.0.20201029-151112"},
+			map[string]string{dtwebhook.AnnotationVersion: "1.201.0"})
+		assert.NoError(t, err)
+		assert.Equal(t, "1.203.0.20201029-151112", version)
+	})
+	t.Run(`empty annotation selects provisioned version`, func(t *testing.T) {
+		version, err := resolve(map[string]string{dtwebhook.AnnotationVersion: ""}, nil)
+		assert.NoError(t, err)
+		assert.Empty(t, version)
+	})
+	t.Run(`invalid annotation`, func(t *testing.T) {
+		_, err := resolve(map[string]string{dtwebhook.AnnotationVersion: "../latest"}, nil)
+		assert.EqualError(t, err, "invalid value '../latest' for annotation oneagent.dynatrace.com/version of the pod")
+	})
+}
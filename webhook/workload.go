@@ -0,0 +1,36 @@
+package webhook
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// FindWorkload returns the kind and name of the controller owning the pod in the given Namespace, pods of ReplicaSets
+// are resolved to their Deployment. Returns an empty kind for pods without controller.
+func FindWorkload(ctx context.Context, apiReader client.Reader, pod *corev1.Pod, namespace string) (string, string, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", "", nil
+	}
+	if owner.Kind != "ReplicaSet" {
+		return owner.Kind, owner.Name, nil
+	}
+
+	var rs appsv1.ReplicaSet
+	if err := apiReader.Get(ctx, client.ObjectKey{Name: owner.Name, Namespace: namespace}, &rs); k8serrors.IsNotFound(err) {
+		return owner.Kind, owner.Name, nil
+	} else if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	if rsOwner := metav1.GetControllerOf(&rs); rsOwner != nil && rsOwner.Kind == "Deployment" {
+		return rsOwner.Kind, rsOwner.Name, nil
+	}
+	return owner.Kind, owner.Name, nil
+}
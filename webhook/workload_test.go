@@ -0,0 +1,47 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dynatrace/dynatrace-operator/scheme/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFindWorkload(t *testing.T) {
+	controller := true
+	buildPod := func(kind string, name string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "test-namespace"}}
+		if kind != "" {
+			pod.OwnerReferences = []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+		}
+		return pod
+	}
+	clt := fake.NewClient(
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name:            "app-1234",
+			Namespace:       "test-namespace",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "app", Controller: &controller}},
+		}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "standalone", Namespace: "test-namespace"}})
+
+	for _, test := range []struct {
+		pod        *corev1.Pod
+		kind, name string
+	}{
+		{pod: buildPod("", "")},
+		{pod: buildPod("StatefulSet", "db"), kind: "StatefulSet", name: "db"},
+		{pod: buildPod("ReplicaSet", "app-1234"), kind: "Deployment", name: "app"},
+		{pod: buildPod("ReplicaSet", "standalone"), kind: "ReplicaSet", name: "standalone"},
+		{pod: buildPod("ReplicaSet", "deleted"), kind: "ReplicaSet", name: "deleted"},
+	} {
+		kind, name, err := FindWorkload(context.TODO(), clt, test.pod, "test-namespace")
+		require.NoError(t, err)
+		assert.Equal(t, test.kind, kind)
+		assert.Equal(t, test.name, name)
+	}
+}